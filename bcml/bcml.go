@@ -0,0 +1,184 @@
+// Package bcml writes mods in the layout BCML (Breath of the Wild Cross-
+// platform Mod Loader) installs: a folder or a .bnp zip with info.json,
+// options.json, the changed game files under content and the resource sizes
+// of those files in logs/rstb.json.
+//
+// Source:
+// https://github.com/NiceneNerd/BCML/blob/master/docs/README.md
+package bcml
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	PlatformWiiU   = "wiiu"
+	PlatformSwitch = "switch"
+)
+
+// info.json
+type Info struct {
+	Name        string                 `json:"name"`
+	Image       string                 `json:"image"`
+	URL         string                 `json:"url"`
+	Desc        string                 `json:"desc"`
+	Version     string                 `json:"version"`
+	Options     map[string]interface{} `json:"options"`
+	Depends     []string               `json:"depends"`
+	ShowCompare bool                   `json:"showCompare"`
+	ShowConvert bool                   `json:"showConvert"`
+	Platform    string                 `json:"platform"`
+	Priority    int                    `json:"priority"`
+	ID          string                 `json:"id"`
+}
+
+// options.json
+type Options struct {
+	Disable []string               `json:"disable"`
+	Options map[string]interface{} `json:"options"`
+}
+
+type Mod struct {
+	Info      Info
+	Thumbnail []byte            // optional png, written as thumbnail.png
+	Files     map[string][]byte // game files by their content path, e.g. "Font/Font_EU.sbfarc"
+	RSTB      map[string]uint32 // resource sizes by canonical path, e.g. "Font/Font_EU.bfarc"
+}
+
+// NewMod returns an empty mod. The id BCML uses to tell mods apart is
+// derived from the name and version.
+func NewMod(name string, desc string, version string, platform string, priority int) *Mod {
+	return &Mod{
+		Info: Info{
+			Name:     name,
+			Desc:     desc,
+			Version:  version,
+			Options:  map[string]interface{}{},
+			Depends:  []string{},
+			Platform: platform,
+			Priority: priority,
+			ID:       base64.StdEncoding.EncodeToString([]byte(name + "==" + version)),
+		},
+		Files: map[string][]byte{},
+		RSTB:  map[string]uint32{},
+	}
+}
+
+// CanonicalPath is how the resource size table names a file: Yaz0
+// compressed files lose the s in front of their extension.
+func CanonicalPath(contentPath string) string {
+	ext := path.Ext(contentPath)
+	if strings.HasPrefix(ext, ".s") {
+		return strings.TrimSuffix(contentPath, ext) + "." + ext[2:]
+	}
+	return contentPath
+}
+
+// AddFile adds a game file and its resource size.
+func (m *Mod) AddFile(contentPath string, data []byte, resourceSize uint32) {
+	m.Files[contentPath] = data
+	m.RSTB[CanonicalPath(contentPath)] = resourceSize
+}
+
+// Folder the game files go in
+func (m *Mod) contentDir() string {
+	if m.Info.Platform == PlatformSwitch {
+		return "01007EF00011E000/romfs"
+	}
+	return "content"
+}
+
+// Every file of the mod by its path in the mod folder
+func (m *Mod) files() (map[string][]byte, error) {
+	files := map[string][]byte{}
+
+	info, err := json.MarshalIndent(m.Info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	files["info.json"] = info
+
+	options, err := json.MarshalIndent(Options{Disable: []string{}, Options: map[string]interface{}{}}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	files["options.json"] = options
+
+	rstb, err := json.MarshalIndent(m.RSTB, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	files["logs/rstb.json"] = rstb
+
+	if m.Thumbnail != nil {
+		files["thumbnail.png"] = m.Thumbnail
+	}
+
+	for contentPath, data := range m.Files {
+		files[path.Join(m.contentDir(), contentPath)] = data
+	}
+
+	return files, nil
+}
+
+func sortedPaths(files map[string][]byte) []string {
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// WriteDir writes the mod folder, the layout BCML installs from.
+func (m *Mod) WriteDir(dir string) error {
+	files, err := m.files()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range sortedPaths(files) {
+		filename := filepath.Join(dir, filepath.FromSlash(p))
+		err = os.MkdirAll(filepath.Dir(filename), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filename, files[p], 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteBNP writes the mod folder as a zip, which BCML installs as a .bnp.
+func (m *Mod) WriteBNP(w io.Writer) error {
+	files, err := m.files()
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	for _, p := range sortedPaths(files) {
+		fw, err := zw.Create(p)
+		if err != nil {
+			return fmt.Errorf("bnp %s: %w", p, err)
+		}
+		_, err = fw.Write(files[p])
+		if err != nil {
+			return fmt.Errorf("bnp %s: %w", p, err)
+		}
+	}
+
+	return zw.Close()
+}
@@ -0,0 +1,86 @@
+package bcml
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonicalPath(t *testing.T) {
+	assert.Equal(t, "Font/Font_EU.bfarc", CanonicalPath("Font/Font_EU.sbfarc"))
+	assert.Equal(t, "Pack/Bootup.pack", CanonicalPath("Pack/Bootup.pack"))
+}
+
+// The layout has to match the HD_Fonts mod that was packaged by hand, with
+// the id BCML would give the new version.
+func TestWriteBNP(t *testing.T) {
+	archiveRaw, err := ioutil.ReadFile("../HD_Fonts/content/Font/Font_US.sbfarc")
+	assert.NoError(t, err)
+
+	mod := NewMod("HD_Fonts", "HD fonts that resemble the original ones", "0.1.0", PlatformWiiU, 103)
+	mod.AddFile("Font/Font_EU.sbfarc", archiveRaw, 10715748)
+	mod.AddFile("Font/Font_US.sbfarc", archiveRaw, 10715748)
+
+	var bnp bytes.Buffer
+	assert.NoError(t, mod.WriteBNP(&bnp))
+
+	zr, err := zip.NewReader(bytes.NewReader(bnp.Bytes()), int64(bnp.Len()))
+	assert.NoError(t, err)
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		assert.NoError(t, err)
+		files[f.Name], err = ioutil.ReadAll(r)
+		assert.NoError(t, err)
+		r.Close()
+	}
+
+	assert.ElementsMatch(t, []string{
+		"info.json",
+		"options.json",
+		"logs/rstb.json",
+		"content/Font/Font_EU.sbfarc",
+		"content/Font/Font_US.sbfarc",
+	}, sortedPaths(files))
+	assert.True(t, bytes.Equal(archiveRaw, files["content/Font/Font_US.sbfarc"]))
+
+	var info, expectedInfo Info
+	assert.NoError(t, json.Unmarshal(files["info.json"], &info))
+	expectedRaw, err := ioutil.ReadFile("../HD_Fonts/info.json")
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(expectedRaw, &expectedInfo))
+	expectedInfo.Version = "0.1.0" // the id was made for 0.1.0
+	assert.Equal(t, expectedInfo, info)
+
+	var rstb, expectedRSTB map[string]uint32
+	assert.NoError(t, json.Unmarshal(files["logs/rstb.json"], &rstb))
+	expectedRaw, err = ioutil.ReadFile("../HD_Fonts/logs/rstb.json")
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(expectedRaw, &expectedRSTB))
+	assert.Equal(t, expectedRSTB, rstb)
+
+	var options Options
+	assert.NoError(t, json.Unmarshal(files["options.json"], &options))
+	assert.Equal(t, Options{Disable: []string{}, Options: map[string]interface{}{}}, options)
+}
+
+func TestWriteDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bcml")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	mod := NewMod("HD_Fonts", "", "0.2.0", PlatformSwitch, 100)
+	mod.AddFile("Font/Font_EU.sbfarc", []byte("Yaz0"), 0x100)
+	assert.NoError(t, mod.WriteDir(dir))
+
+	for _, p := range []string{"info.json", "options.json", "logs/rstb.json", "01007EF00011E000/romfs/Font/Font_EU.sbfarc"} {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(p)))
+		assert.NoError(t, err, p)
+	}
+}
@@ -0,0 +1,169 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/disintegration/imaging"
+)
+
+// Draws the characters a font does not have from the font file of a profile
+// and adds them with the widths and kerning of the font file. Scale is what
+// the font was upscaled by, 1 for an original font. Returns the added
+// characters, and the ones that were skipped because the font file does not
+// have them or a bffnt can not map them.
+func addGlyphs(f *Font, profile *FontProfile, scale float64, chars []rune) (added []rune, skipped []rune, err error) {
+	drawer, err := newGlyphDrawer(profile, scale)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tglp := &f.BFFNT.TGLP
+	cellWidth, cellHeight := int(tglp.CellWidth), int(tglp.CellHeight)
+	// the cell and its 1 px padding, drawn like a cell at the top left of a sheet
+	realBaseline := int(tglp.BaselinePosition) + int(scale) + 1
+	cellRect := image.Rect(1, 1, cellWidth+1, cellHeight+1)
+
+	for _, r := range uniqueRunes(chars) {
+		if _, ok := f.Glyph(r); ok {
+			continue
+		}
+		if r > math.MaxUint16 || unicode.IsControl(r) || !drawer.hasGlyph(uint16(r)) {
+			skipped = append(skipped, r)
+			continue
+		}
+
+		dst := image.NewAlpha(image.Rect(0, 0, cellWidth+1, cellHeight+1))
+		m, err := drawer.drawGlyph(dst, 0, realBaseline, uint16(r))
+		if err != nil {
+			return added, skipped, err
+		}
+
+		err = f.AddGlyph(Glyph{
+			Rune:       r,
+			LeftWidth:  int8(clampInt(m.LeftWidth, math.MinInt8, math.MaxInt8)),
+			GlyphWidth: uint8(clampInt(m.GlyphWidth, 0, cellWidth)),
+			CharWidth:  uint8(clampInt(m.CharWidth, 0, math.MaxUint8)),
+			Image:      imaging.Crop(dst, cellRect),
+		})
+		if err != nil {
+			return added, skipped, err
+		}
+		added = append(added, r)
+	}
+
+	return added, skipped, addKerning(f, drawer, added)
+}
+
+// Adds the kerning of the font file for every pair with an added character
+func addKerning(f *Font, drawer *glyphDrawer, added []rune) error {
+	isAdded := make(map[rune]bool, len(added))
+	for _, r := range added {
+		isAdded[r] = true
+	}
+
+	kerning := make(map[rune]map[rune]int16)
+	for _, first := range f.Runes() {
+		for _, second := range added {
+			setKerning(kerning, drawer, first, second)
+			if !isAdded[first] {
+				setKerning(kerning, drawer, second, first)
+			}
+		}
+	}
+
+	for first, pairs := range kerning {
+		glyph, _ := f.Glyph(first)
+		if glyph.Kerning == nil {
+			glyph.Kerning = make(map[rune]int16, len(pairs))
+		}
+		for second, value := range pairs {
+			glyph.Kerning[second] = value
+		}
+		err := f.ReplaceGlyph(glyph)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setKerning(kerning map[rune]map[rune]int16, drawer *glyphDrawer, first rune, second rune) {
+	value := drawer.kern(uint16(first), uint16(second))
+	if value == 0 {
+		return
+	}
+	if kerning[first] == nil {
+		kerning[first] = make(map[rune]int16)
+	}
+	kerning[first][second] = int16(clampInt(value, math.MinInt16, math.MaxInt16))
+}
+
+// Sorted characters without duplicates
+func uniqueRunes(chars []rune) []rune {
+	seen := make(map[rune]bool, len(chars))
+	var res []rune
+	for _, r := range chars {
+		if !seen[r] {
+			seen[r] = true
+			res = append(res, r)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i] < res[j]
+	})
+	return res
+}
+
+// Characters of a comma separated list of hex codes and ranges, like
+// "U+0100-U+017F,1EA0"
+func parseCharList(list string) ([]rune, error) {
+	var chars []rune
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		first, last := item, item
+		if i := strings.Index(item, "-"); i != -1 {
+			first, last = item[:i], item[i+1:]
+		}
+		firstCode, err := parseCharCode(first)
+		if err != nil {
+			return nil, err
+		}
+		lastCode, err := parseCharCode(last)
+		if err != nil {
+			return nil, err
+		}
+		if lastCode < firstCode {
+			return nil, fmt.Errorf("%w: %s goes from %U down to %U", ErrInvalidRange, item, firstCode, lastCode)
+		}
+
+		for code := firstCode; code <= lastCode; code++ {
+			chars = append(chars, code)
+		}
+	}
+
+	return chars, nil
+}
+
+func parseCharCode(code string) (rune, error) {
+	trimmed := strings.TrimSpace(code)
+	for _, prefix := range []string{"U+", "u+", "0x", "0X"} {
+		trimmed = strings.TrimPrefix(trimmed, prefix)
+	}
+
+	value, err := strconv.ParseUint(trimmed, 16, 32)
+	if err != nil || value > unicode.MaxRune {
+		return 0, fmt.Errorf("%q is not a hex character code", code)
+	}
+	return rune(value), nil
+}
@@ -0,0 +1,681 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+)
+
+// BC4 stores a single channel in 4x4 pixel blocks of 8 bytes. The first two
+// bytes are endpoints, the other 6 bytes hold a 3 bit palette index for every
+// pixel of the block. If the first endpoint is bigger than the second the
+// palette has 6 values interpolated between them, otherwise it has 4 and the
+// last two palette entries are 0 and 255.
+func decodeBC4(data []byte, width int, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	blocksWide := (width + 3) / 4
+	blocksHigh := (height + 3) / 4
+
+	for by := 0; by < blocksHigh; by++ {
+		for bx := 0; bx < blocksWide; bx++ {
+			blockStart := (by*blocksWide + bx) * 8
+			values := decodeBC4Block(data[blockStart : blockStart+8])
+
+			for i, value := range values {
+				x := bx*4 + i%4
+				y := by*4 + i/4
+				if x >= width || y >= height {
+					continue
+				}
+				pos := y*img.Stride + 4*x
+				img.Pix[pos+0] = 255
+				img.Pix[pos+1] = 255
+				img.Pix[pos+2] = 255
+				img.Pix[pos+3] = value
+			}
+		}
+	}
+
+	return img
+}
+
+func encodeBC4(img *image.NRGBA, quality EncodeQuality) []byte {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	blocksWide := (width + 3) / 4
+	blocksHigh := (height + 3) / 4
+	data := make([]byte, 0, blocksWide*blocksHigh*8)
+
+	for by := 0; by < blocksHigh; by++ {
+		for bx := 0; bx < blocksWide; bx++ {
+			var values [16]uint8
+			for i := range values {
+				// pixels outside the image repeat the closest edge pixel
+				x := minInt(bx*4+i%4, width-1)
+				y := minInt(by*4+i/4, height-1)
+				values[i] = img.Pix[y*img.Stride+4*x+3]
+			}
+
+			block := encodeBC4Block(values, quality)
+			data = append(data, block[:]...)
+		}
+	}
+
+	return data
+}
+
+func bc4Palette(a0 uint8, a1 uint8) [8]uint8 {
+	var palette [8]uint8
+	palette[0] = a0
+	palette[1] = a1
+
+	e0, e1 := int(a0), int(a1)
+	if a0 > a1 {
+		for i := 1; i < 7; i++ {
+			palette[i+1] = uint8(((7-i)*e0 + i*e1 + 3) / 7)
+		}
+	} else {
+		for i := 1; i < 5; i++ {
+			palette[i+1] = uint8(((5-i)*e0 + i*e1 + 2) / 5)
+		}
+		palette[6] = 0
+		palette[7] = 255
+	}
+
+	return palette
+}
+
+func decodeBC4Block(block []byte) [16]uint8 {
+	var values [16]uint8
+	palette := bc4Palette(block[0], block[1])
+
+	var indexBits uint64
+	for i := 0; i < 6; i++ {
+		indexBits |= uint64(block[2+i]) << (8 * uint(i))
+	}
+
+	for i := range values {
+		values[i] = palette[(indexBits>>(3*uint(i)))&7]
+	}
+
+	return values
+}
+
+// Tries both palette modes and keeps the one with the smallest error. Font
+// sheets are mostly fully transparent or fully opaque pixels, which the 4
+// value mode can store exactly next to the anti aliased edge values.
+// QualityLow only tries the minimum and maximum values as endpoints.
+func encodeBC4Block(values [16]uint8, quality EncodeQuality) [8]byte {
+	minValue, maxValue := uint8(255), uint8(0)
+	minInner, maxInner := uint8(255), uint8(0)
+	for _, v := range values {
+		if v < minValue {
+			minValue = v
+		}
+		if v > maxValue {
+			maxValue = v
+		}
+		if v != 0 && v != 255 {
+			if v < minInner {
+				minInner = v
+			}
+			if v > maxInner {
+				maxInner = v
+			}
+		}
+	}
+	if minInner > maxInner {
+		// only 0 and 255 in this block
+		minInner, maxInner = 0, 0
+	}
+
+	// 6 interpolated values, then 4 interpolated values plus 0 and 255 with
+	// the endpoints also stretched to 0 and 255 as the fully transparent and
+	// opaque pixels can use either the endpoints or the extra palette entries
+	candidates := [][2]uint8{
+		{maxValue, minValue},
+		{minInner, maxInner},
+		{0, maxInner},
+		{minInner, 255},
+		{0, 255},
+	}
+
+	if quality == QualityLow {
+		candidates = candidates[:2]
+	}
+
+	bestBlock, bestError := [8]byte{}, 1<<30
+	for _, endpoints := range candidates {
+		var block [8]byte
+		var blockError int
+		if quality == QualityLow {
+			block, blockError = bc4FitBlock(values, endpoints[0], endpoints[1])
+		} else {
+			block, blockError = bc4RefineBlock(values, endpoints[0], endpoints[1])
+		}
+		if blockError < bestError {
+			bestBlock, bestError = block, blockError
+		}
+		if bestError == 0 {
+			break
+		}
+	}
+
+	return bestBlock
+}
+
+// Fits the block with the given endpoints and then moves the endpoints to the
+// least squares solution for the chosen palette indexes a few times. Keeps
+// the best result.
+func bc4RefineBlock(values [16]uint8, a0 uint8, a1 uint8) ([8]byte, int) {
+	bestBlock, bestError := bc4FitBlock(values, a0, a1)
+	sixValueMode := a0 > a1
+
+	block := bestBlock
+	for iteration := 0; iteration < 4 && bestError > 0; iteration++ {
+		indexes := bc4BlockIndexes(block)
+
+		// value = a0*(1-t) + a1*t for every interpolated palette index
+		var aa, ab, bb, av, bv float64
+		for i, v := range values {
+			t, interpolated := bc4IndexWeight(indexes[i], sixValueMode)
+			if !interpolated {
+				continue
+			}
+			aa += (1 - t) * (1 - t)
+			ab += (1 - t) * t
+			bb += t * t
+			av += (1 - t) * float64(v)
+			bv += t * float64(v)
+		}
+
+		det := aa*bb - ab*ab
+		if det == 0 {
+			break
+		}
+		newA0 := clampToUint8((av*bb - bv*ab) / det)
+		newA1 := clampToUint8((bv*aa - av*ab) / det)
+
+		// the endpoint order decides the palette mode, it has to stay the same
+		if sixValueMode && newA0 <= newA1 {
+			break
+		}
+		if !sixValueMode && newA0 > newA1 {
+			break
+		}
+
+		var blockError int
+		block, blockError = bc4FitBlock(values, newA0, newA1)
+		if blockError < bestError {
+			bestBlock, bestError = block, blockError
+		}
+	}
+
+	return bestBlock, bestError
+}
+
+// Position t between the endpoints of a palette index. The 0 and 255 entries
+// of the 4 value mode are not interpolated.
+func bc4IndexWeight(index int, sixValueMode bool) (float64, bool) {
+	switch {
+	case index == 0:
+		return 0, true
+	case index == 1:
+		return 1, true
+	case sixValueMode:
+		return float64(index-1) / 7, true
+	case index < 6:
+		return float64(index-1) / 5, true
+	default:
+		return 0, false
+	}
+}
+
+func bc4BlockIndexes(block [8]byte) [16]int {
+	var indexes [16]int
+	var indexBits uint64
+	for i := 0; i < 6; i++ {
+		indexBits |= uint64(block[2+i]) << (8 * uint(i))
+	}
+	for i := range indexes {
+		indexes[i] = int((indexBits >> (3 * uint(i))) & 7)
+	}
+
+	return indexes
+}
+
+func bc4FitBlock(values [16]uint8, a0 uint8, a1 uint8) ([8]byte, int) {
+	var block [8]byte
+	block[0] = a0
+	block[1] = a1
+	palette := bc4Palette(a0, a1)
+
+	var indexBits uint64
+	totalError := 0
+	for i, v := range values {
+		bestIndex, bestError := 0, 1<<30
+		for j, p := range palette {
+			diff := int(v) - int(p)
+			if diff*diff < bestError {
+				bestIndex, bestError = j, diff*diff
+			}
+		}
+		totalError += bestError
+		indexBits |= uint64(bestIndex) << (3 * uint(i))
+	}
+
+	for i := 0; i < 6; i++ {
+		block[2+i] = byte(indexBits >> (8 * uint(i)))
+	}
+
+	return block, totalError
+}
+
+// BC1 stores the colors of 4x4 pixel blocks in 8 bytes: two RGB565
+// endpoints, the red channel in the high bits, and a 2 bit palette index for
+// every pixel. If the first endpoint is bigger than the second the palette
+// has 2 colors interpolated between them, otherwise it has 1 and the last
+// palette entry is transparent black. BC2 and BC3 put 8 bytes of alpha in
+// front of a BC1 block that always has 4 colors, 4 bit alpha values for BC2
+// and a BC4 block for BC3. BC5 is two BC4 blocks, read as luminance and alpha
+// like the LA8 sheets.
+//
+// Source:
+// https://learn.microsoft.com/en-us/windows/win32/direct3d10/d3d10-graphics-programming-guide-resources-block-compression
+
+func decodeBC1(data []byte, width int, height int) *image.NRGBA {
+	return decodeBlocks(data, width, height, 8, func(block []byte) [16]color.NRGBA {
+		return decodeBC1Block(block, false)
+	})
+}
+
+func decodeBC2(data []byte, width int, height int) *image.NRGBA {
+	return decodeBlocks(data, width, height, 16, func(block []byte) [16]color.NRGBA {
+		pixels := decodeBC1Block(block[8:16], true)
+		alphas := binary.LittleEndian.Uint64(block[0:8])
+		for i := range pixels {
+			pixels[i].A = expand(uint8(alphas>>(4*uint(i))&0xF), 4)
+		}
+		return pixels
+	})
+}
+
+func decodeBC3(data []byte, width int, height int) *image.NRGBA {
+	return decodeBlocks(data, width, height, 16, func(block []byte) [16]color.NRGBA {
+		pixels := decodeBC1Block(block[8:16], true)
+		for i, a := range decodeBC4Block(block[0:8]) {
+			pixels[i].A = a
+		}
+		return pixels
+	})
+}
+
+func decodeBC5(data []byte, width int, height int) *image.NRGBA {
+	return decodeBlocks(data, width, height, 16, func(block []byte) [16]color.NRGBA {
+		var pixels [16]color.NRGBA
+		luminances, alphas := decodeBC4Block(block[0:8]), decodeBC4Block(block[8:16])
+		for i := range pixels {
+			l := luminances[i]
+			pixels[i] = color.NRGBA{l, l, l, alphas[i]}
+		}
+		return pixels
+	})
+}
+
+func encodeBC1(img *image.NRGBA, quality EncodeQuality) []byte {
+	return encodeBlocks(img, func(pixels [16]color.NRGBA) []byte {
+		block := encodeBC1Block(pixels, true, quality)
+		return block[:]
+	})
+}
+
+func encodeBC2(img *image.NRGBA, quality EncodeQuality) []byte {
+	return encodeBlocks(img, func(pixels [16]color.NRGBA) []byte {
+		var alphas uint64
+		for i, c := range pixels {
+			alphas |= uint64(quantize(c.A, 4)) << (4 * uint(i))
+		}
+		block := make([]byte, 8, 16)
+		binary.LittleEndian.PutUint64(block, alphas)
+		colors := encodeBC1Block(pixels, false, quality)
+		return append(block, colors[:]...)
+	})
+}
+
+func encodeBC3(img *image.NRGBA, quality EncodeQuality) []byte {
+	return encodeBlocks(img, func(pixels [16]color.NRGBA) []byte {
+		var alphas [16]uint8
+		for i, c := range pixels {
+			alphas[i] = c.A
+		}
+		alphaBlock := encodeBC4Block(alphas, quality)
+		colors := encodeBC1Block(pixels, false, quality)
+		return append(alphaBlock[:], colors[:]...)
+	})
+}
+
+func encodeBC5(img *image.NRGBA, quality EncodeQuality) []byte {
+	return encodeBlocks(img, func(pixels [16]color.NRGBA) []byte {
+		var luminances, alphas [16]uint8
+		for i, c := range pixels {
+			luminances[i], alphas[i] = luma(c), c.A
+		}
+		luminanceBlock := encodeBC4Block(luminances, quality)
+		alphaBlock := encodeBC4Block(alphas, quality)
+		return append(luminanceBlock[:], alphaBlock[:]...)
+	})
+}
+
+// Decodes 4x4 pixel blocks of blockBytes bytes, stored row by row
+func decodeBlocks(data []byte, width int, height int, blockBytes int, decodeBlock func(block []byte) [16]color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	blocksWide := (width + 3) / 4
+	blocksHigh := (height + 3) / 4
+
+	for by := 0; by < blocksHigh; by++ {
+		for bx := 0; bx < blocksWide; bx++ {
+			blockStart := (by*blocksWide + bx) * blockBytes
+			pixels := decodeBlock(data[blockStart : blockStart+blockBytes])
+
+			for i, c := range pixels {
+				x := bx*4 + i%4
+				y := by*4 + i/4
+				if x >= width || y >= height {
+					continue
+				}
+				pos := y*img.Stride + 4*x
+				img.Pix[pos+0] = c.R
+				img.Pix[pos+1] = c.G
+				img.Pix[pos+2] = c.B
+				img.Pix[pos+3] = c.A
+			}
+		}
+	}
+
+	return img
+}
+
+func encodeBlocks(img *image.NRGBA, encodeBlock func(pixels [16]color.NRGBA) []byte) []byte {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	blocksWide := (width + 3) / 4
+	blocksHigh := (height + 3) / 4
+	var data []byte
+
+	for by := 0; by < blocksHigh; by++ {
+		for bx := 0; bx < blocksWide; bx++ {
+			var pixels [16]color.NRGBA
+			for i := range pixels {
+				// pixels outside the image repeat the closest edge pixel
+				x := minInt(bx*4+i%4, width-1)
+				y := minInt(by*4+i/4, height-1)
+				pixels[i] = nrgbaAt(img, x, y)
+			}
+			data = append(data, encodeBlock(pixels)...)
+		}
+	}
+
+	return data
+}
+
+func decodeRGB565Endpoint(v uint16) [3]int {
+	return [3]int{
+		int(expand(uint8(v>>11&0x1F), 5)),
+		int(expand(uint8(v>>5&0x3F), 6)),
+		int(expand(uint8(v&0x1F), 5)),
+	}
+}
+
+func encodeRGB565Endpoint(c [3]float64) uint16 {
+	return uint16(quantize(clampToUint8(c[0]), 5))<<11 | uint16(quantize(clampToUint8(c[1]), 6))<<5 | uint16(quantize(clampToUint8(c[2]), 5))
+}
+
+// The colors of the palette indexes. The fourth color of the 3 color mode is
+// transparent black.
+func bc1Palette(c0 uint16, c1 uint16, fourColors bool) [4]color.NRGBA {
+	e0, e1 := decodeRGB565Endpoint(c0), decodeRGB565Endpoint(c1)
+	var palette [4]color.NRGBA
+	for i := range palette {
+		var c [3]int
+		for channel := range c {
+			switch {
+			case i < 2:
+				c[channel] = [2][3]int{e0, e1}[i][channel]
+			case fourColors:
+				// 1/3 and 2/3 of the way from the first endpoint
+				c[channel] = ((4-i)*e0[channel] + (i-1)*e1[channel] + 1) / 3
+			default:
+				c[channel] = (e0[channel] + e1[channel]) / 2
+			}
+		}
+		palette[i] = color.NRGBA{uint8(c[0]), uint8(c[1]), uint8(c[2]), 255}
+	}
+	if !fourColors {
+		palette[3] = color.NRGBA{}
+	}
+
+	return palette
+}
+
+func decodeBC1Block(block []byte, alwaysFourColors bool) [16]color.NRGBA {
+	c0 := binary.LittleEndian.Uint16(block[0:2])
+	c1 := binary.LittleEndian.Uint16(block[2:4])
+	palette := bc1Palette(c0, c1, alwaysFourColors || c0 > c1)
+	indexBits := binary.LittleEndian.Uint32(block[4:8])
+
+	var pixels [16]color.NRGBA
+	for i := range pixels {
+		pixels[i] = palette[indexBits>>(2*uint(i))&3]
+	}
+
+	return pixels
+}
+
+// Fits the endpoints to the range of the colors along their principal axis
+// and then, above QualityLow, moves them to the least squares solution for
+// the chosen palette indexes, a few times more for QualityHigh. Blocks with
+// transparent pixels use the 3 color mode when transparency is allowed.
+func encodeBC1Block(pixels [16]color.NRGBA, allowTransparency bool, quality EncodeQuality) [8]byte {
+	threeColors := false
+	var colors [][3]float64
+	for _, c := range pixels {
+		if allowTransparency && c.A < 128 {
+			threeColors = true
+			continue
+		}
+		colors = append(colors, [3]float64{float64(c.R), float64(c.G), float64(c.B)})
+	}
+	if len(colors) == 0 {
+		// only transparent pixels
+		block, _ := bc1FitBlock(pixels, 0, 0, true)
+		return block
+	}
+
+	e0, e1 := bc1RangeFit(colors)
+	bestBlock, bestError := bc1FitBlock(pixels, encodeRGB565Endpoint(e0), encodeRGB565Endpoint(e1), threeColors)
+
+	iterations := 2
+	switch quality {
+	case QualityLow:
+		iterations = 0
+	case QualityHigh:
+		iterations = 8
+	}
+
+	block := bestBlock
+	for iteration := 0; iteration < iterations && bestError > 0; iteration++ {
+		c0 := binary.LittleEndian.Uint16(block[0:2])
+		c1 := binary.LittleEndian.Uint16(block[2:4])
+		fourColors := c0 > c1
+		indexBits := binary.LittleEndian.Uint32(block[4:8])
+
+		// color = e0*(1-t) + e1*t for every interpolated palette index
+		var aa, ab, bb float64
+		var av, bv [3]float64
+		for i, c := range pixels {
+			t, interpolated := bc1IndexWeight(int(indexBits>>(2*uint(i))&3), fourColors)
+			if !interpolated || (threeColors && c.A < 128) {
+				continue
+			}
+			aa += (1 - t) * (1 - t)
+			ab += (1 - t) * t
+			bb += t * t
+			for channel, v := range [3]uint8{c.R, c.G, c.B} {
+				av[channel] += (1 - t) * float64(v)
+				bv[channel] += t * float64(v)
+			}
+		}
+
+		det := aa*bb - ab*ab
+		if det == 0 {
+			break
+		}
+		for channel := range e0 {
+			e0[channel] = (av[channel]*bb - bv[channel]*ab) / det
+			e1[channel] = (bv[channel]*aa - av[channel]*ab) / det
+		}
+
+		var blockError int
+		block, blockError = bc1FitBlock(pixels, encodeRGB565Endpoint(e0), encodeRGB565Endpoint(e1), threeColors)
+		if blockError < bestError {
+			bestBlock, bestError = block, blockError
+		}
+	}
+
+	return bestBlock
+}
+
+// The colors furthest apart along the principal axis of the colors, found
+// with a few power iterations on their covariance
+func bc1RangeFit(colors [][3]float64) ([3]float64, [3]float64) {
+	var mean [3]float64
+	for _, c := range colors {
+		for channel := range mean {
+			mean[channel] += c[channel] / float64(len(colors))
+		}
+	}
+
+	var covariance [3][3]float64
+	for _, c := range colors {
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				covariance[i][j] += (c[i] - mean[i]) * (c[j] - mean[j])
+			}
+		}
+	}
+
+	axis := [3]float64{1, 1, 1}
+	for iteration := 0; iteration < 8; iteration++ {
+		var next [3]float64
+		length := 0.0
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				next[i] += covariance[i][j] * axis[j]
+			}
+			length += next[i] * next[i]
+		}
+		if length == 0 {
+			break
+		}
+		for i := range next {
+			axis[i] = next[i] / math.Sqrt(length)
+		}
+	}
+
+	minProjection, maxProjection := math.Inf(1), math.Inf(-1)
+	var minColor, maxColor [3]float64
+	for _, c := range colors {
+		projection := 0.0
+		for channel := range c {
+			projection += (c[channel] - mean[channel]) * axis[channel]
+		}
+		if projection < minProjection {
+			minProjection, minColor = projection, c
+		}
+		if projection > maxProjection {
+			maxProjection, maxColor = projection, c
+		}
+	}
+
+	return maxColor, minColor
+}
+
+// Position t between the endpoints of a palette index. The transparent entry
+// of the 3 color mode is not interpolated.
+func bc1IndexWeight(index int, fourColors bool) (float64, bool) {
+	switch {
+	case index == 0:
+		return 0, true
+	case index == 1:
+		return 1, true
+	case fourColors:
+		return float64(index-1) / 3, true
+	case index == 2:
+		return 0.5, true
+	default:
+		return 0, false
+	}
+}
+
+// Orders the endpoints for the palette mode and picks the closest palette
+// color for every pixel. Transparent pixels of the 3 color mode take the
+// transparent entry and add no error.
+func bc1FitBlock(pixels [16]color.NRGBA, c0 uint16, c1 uint16, threeColors bool) ([8]byte, int) {
+	if threeColors == (c0 > c1) {
+		c0, c1 = c1, c0
+	}
+	palette := bc1Palette(c0, c1, !threeColors)
+
+	var block [8]byte
+	binary.LittleEndian.PutUint16(block[0:2], c0)
+	binary.LittleEndian.PutUint16(block[2:4], c1)
+
+	var indexBits uint32
+	totalError := 0
+	for i, c := range pixels {
+		if threeColors && c.A < 128 {
+			indexBits |= 3 << (2 * uint(i))
+			continue
+		}
+
+		bestIndex, bestError := 0, 1<<30
+		for j, p := range palette {
+			// with equal endpoints BC1 decodes the 3 color mode
+			if j == 3 && (threeColors || c0 == c1) {
+				break
+			}
+			dr, dg, db := int(c.R)-int(p.R), int(c.G)-int(p.G), int(c.B)-int(p.B)
+			if e := dr*dr + dg*dg + db*db; e < bestError {
+				bestIndex, bestError = j, e
+			}
+		}
+		totalError += bestError
+		indexBits |= uint32(bestIndex) << (2 * uint(i))
+	}
+	binary.LittleEndian.PutUint32(block[4:8], indexBits)
+
+	return block, totalError
+}
+
+func clampToUint8(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -1,17 +1,17 @@
 package bffnt_headers
 
 import (
-	"flag"
 	"fmt"
 	"image"
 	"image/color"
-	"image/png"
-	"io/ioutil"
+	"image/draw"
 	"os"
 	"sort"
 
+	"github.com/disintegration/imaging"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
 	"golang.org/x/image/math/fixed"
 )
 
@@ -30,45 +30,106 @@ type BFFNT struct {
 	CMAPs []CMAP
 	KRNG  KRNG
 
-	// Map of rune to it's index. Used to find a glyph's CWDH faster
+	// Map of rune to it's glyph index. ResolveGlyphIndex finds the CWDH of
+	// the glyph index.
 	CWDHIndexMap map[rune]int
 }
 
 var bffntRaw []byte
 var err error
 
-func (b *BFFNT) Decode(bffntRaw []byte) {
-	b.FFNT.Decode(bffntRaw)
-	b.FINF.Decode(bffntRaw)
-	b.TGLP.Decode(bffntRaw)
-	b.CWDHs = DecodeCWDHs(bffntRaw, b.FINF.CWDHOffset)
-	b.CMAPs = DecodeCMAPs(bffntRaw, b.FINF.CMAPOffset)
-	b.KRNG.Decode(bffntRaw)
+// Decode reads every section of a bffnt file. A malformed or truncated file
+// returns a *SectionError saying which section failed and where.
+func (b *BFFNT) Decode(bffntRaw []byte) error {
+	err := b.FFNT.Decode(bffntRaw)
+	if err != nil {
+		return err
+	}
+
+	err = b.FINF.Decode(bffntRaw)
+	if err != nil {
+		return err
+	}
+
+	err = b.TGLP.Decode(bffntRaw)
+	if err != nil {
+		return err
+	}
+
+	b.CWDHs, err = DecodeCWDHs(bffntRaw, b.FINF.CWDHOffset)
+	if err != nil {
+		return err
+	}
+
+	b.CMAPs, err = DecodeCMAPs(bffntRaw, b.FINF.CMAPOffset)
+	if err != nil {
+		return err
+	}
+
+	b.KRNG = KRNG{}
+	err = b.KRNG.Decode(bffntRaw)
+	if err != nil {
+		return err
+	}
 
 	b.CWDHIndexMap = make(map[rune]int, 0)
-	for i, glyph := range b.GlyphIndexes() {
-		b.CWDHIndexMap[rune(glyph.CharAscii)] = i
+	for _, glyph := range b.GlyphIndexes() {
+		b.CWDHIndexMap[rune(glyph.CharAscii)] = int(glyph.CharIndex)
 	}
+
+	return nil
 }
 
-func (b *BFFNT) Encode() []byte {
-	tglpOffset := FFNT_HEADER_SIZE + FINF_HEADER_SIZE + 8
-	tglpRaw := b.TGLP.Encode()
+func (b *BFFNT) Encode() ([]byte, error) {
+	err := b.setFileFormat()
+	if err != nil {
+		return nil, err
+	}
+
+	headerSize := b.FINF.layout.fileHeaderSize()
+	tglpOffset := headerSize + FINF_HEADER_SIZE + 8
+	tglpRaw, err := b.TGLP.Encode()
+	if err != nil {
+		return nil, err
+	}
 
 	cwdhOffset := tglpOffset + len(tglpRaw)
-	cwdhsRaw := EncodeCWDHs(b.CWDHs, cwdhOffset)
+	cwdhsRaw, err := EncodeCWDHs(b.CWDHs, cwdhOffset)
+	if err != nil {
+		return nil, err
+	}
 
 	cmapOffset := cwdhOffset + len(cwdhsRaw)
-	cmapsRaw := EncodeCMAPs(b.CMAPs, cmapOffset)
+	cmapsRaw, err := EncodeCMAPs(b.CMAPs, cmapOffset)
+	if err != nil {
+		return nil, err
+	}
 
-	finfRaw := b.FINF.Encode(tglpOffset, cwdhOffset, cmapOffset)
+	finfRaw, err := b.FINF.Encode(tglpOffset, cwdhOffset, cmapOffset)
+	if err != nil {
+		return nil, err
+	}
 
 	krngOffset := cmapOffset + len(cmapsRaw)
-	krngRaw := b.KRNG.Encode(uint32(krngOffset))
+	krngRaw, err := b.KRNG.Encode(uint32(krngOffset))
+	if err != nil {
+		return nil, err
+	}
+
+	// The RFNT header counts the sections instead
+	if b.FINF.layout == layoutRFNT {
+		b.FFNT.BlockReadNum = uint32(2 + len(b.CWDHs) + len(b.CMAPs))
+		if len(krngRaw) > 0 {
+			b.FFNT.BlockReadNum++
+		}
+	}
 
 	// TODO: calculate an appriopriate blockreadnum based on sheetsize?
-	fileSize := uint32(FFNT_HEADER_SIZE + len(finfRaw) + len(tglpRaw) + len(cwdhsRaw) + len(cmapsRaw) + len(krngRaw))
-	ffntRaw := b.FFNT.Encode(fileSize)
+	fileSize := uint32(headerSize + len(finfRaw) + len(tglpRaw) + len(cwdhsRaw) + len(cmapsRaw) + len(krngRaw))
+	ffntRaw, err := b.FFNT.Encode(fileSize)
+	if err != nil {
+		return nil, err
+	}
 
 	res := make([]byte, 0)
 	res = append(res, ffntRaw...)
@@ -78,7 +139,57 @@ func (b *BFFNT) Encode() []byte {
 	res = append(res, cmapsRaw...)
 	res = append(res, krngRaw...)
 
-	return res
+	return res, nil
+}
+
+// Every section is written in the byte order of the FFNT section, with the
+// sheets of the platform of its magic header and the header layout of its
+// version. Sections added by editing a font do not know them.
+func (b *BFFNT) setFileFormat() error {
+	order := b.FFNT.sectionOrder
+	platform := filePlatform(b.FFNT.MagicHeader, order.order())
+	layout, err := versionLayout(b.FFNT.MagicHeader, b.FFNT.Version)
+	if err != nil {
+		return err
+	}
+
+	b.FINF.sectionOrder = order
+	b.FINF.Platform = platform
+	b.FINF.layout = layout
+	b.TGLP.sectionOrder = order
+	b.TGLP.Platform = platform
+	b.TGLP.layout = layout
+	for i := range b.CWDHs {
+		b.CWDHs[i].sectionOrder = order
+	}
+	for i := range b.CMAPs {
+		b.CMAPs[i].sectionOrder = order
+		b.CMAPs[i].Platform = platform
+	}
+	b.KRNG.sectionOrder = order
+
+	return nil
+}
+
+// ResolveGlyphIndex finds the CWDH whose StartIndex to EndIndex covers a
+// glyph index. Returns the position of the CWDH in b.CWDHs and of the glyph
+// in its Glyphs. Glyphs no CWDH covers use the FINF default widths.
+func (b *BFFNT) ResolveGlyphIndex(index uint16) (cwdhIndex int, offset int, ok bool) {
+	for i, cwdh := range b.CWDHs {
+		if index >= cwdh.StartIndex && index <= cwdh.EndIndex && int(index-cwdh.StartIndex) < len(cwdh.Glyphs) {
+			return i, int(index - cwdh.StartIndex), true
+		}
+	}
+	return 0, 0, false
+}
+
+// The CWDH entry of a glyph index, nil when no CWDH covers it
+func (b *BFFNT) glyphWidths(index uint16) *glyphInfo {
+	cwdhIndex, offset, ok := b.ResolveGlyphIndex(index)
+	if !ok {
+		return nil
+	}
+	return &b.CWDHs[cwdhIndex].Glyphs[offset]
 }
 
 // Read all valid glyphs and indexes from the CMAPs and sort them
@@ -105,7 +216,8 @@ func (b *BFFNT) GlyphIndexes() []AsciiIndexPair {
 
 // This is to be used to upscale the resolution of the a texture. It will make
 // the appropriate calculations based on the amount of scaling specified
-// It will be up to the user to provide the upscaled images in a png format
+// It will be up to the user to provide the upscaled sheet images in
+// TGLP.SheetData, otherwise blank sheets are encoded
 func (b *BFFNT) Upscale(scale float64) {
 	b.FINF.Upscale(scale)
 	b.TGLP.Upscale(scale)
@@ -117,176 +229,66 @@ func (b *BFFNT) Upscale(scale float64) {
 	b.KRNG.Upscale(scale)
 }
 
-func Run() {
-	flag.BoolVar(&Debug, "d", false, "enable debug output")
-	flag.Parse()
-
-	initializeGlyphMaps()
-
-	// scale 1 for 1280×720 (original)
-	// scale 2 for 2560 × 1440
-	// scale 3 for 3840 x 2160
-	scale := 2.0
-	scale = scale
-
-	// upscaleBffnt("Ancient", "./nintendo_system_ui/botw-sheikah.ttf", scale)
-	// upscaleBffnt("Caption", "./nintendo_system_ui/DSi-Wii-3DS-Wii_U/FOT-RodinBokutoh-Pro-M.otf", scale)
-	// upscaleBffnt("Normal", "./nintendo_system_ui/DSi-Wii-3DS-Wii_U/FOT-RodinBokutoh-Pro-B.otf", scale)
-	// upscaleBffnt("NormalS", "./nintendo_system_ui/DSi-Wii-3DS-Wii_U/CafeStd.ttf", scale)
-	// upscaleBffnt("NormalS", "./nintendo_system_ui/DSi-Wii-3DS-Wii_U/FOT-RodinBokutoh-Pro-B.otf", scale)
-	upscaleBffnt("External", "./nintendo_system_ui/nintendo_ext_003.ttf", scale)
-
-	return
-}
-
-func upscaleBffnt(botwFontName string, fontFile string, scale float64) {
-	bffntFile := fmt.Sprintf("./WiiU_fonts/botw/%[1]s/%[1]s_00.bffnt", botwFontName)
-	fmt.Println("Reading bffnt file", bffntFile)
-	bffntRaw, err = ioutil.ReadFile(bffntFile)
-
+// Upscales a font and draws its sheet with the settings of a profile. With
+// fit the glyph widths are fitted to the font file instead of using the
+// width adjustments of the profile. With kerningFromFont the kerning table is
+// replaced with the kerning of the font file instead of being scaled. Quality
+// is used for lossy sheet formats.
+// Returns the encoded font, the drawn sheet and the glyphs fitting could not
+// bring within tolerance.
+func upscaleBffnt(bffntRaw []byte, profile *FontProfile, scale float64, fit bool, kerningFromFont bool, quality EncodeQuality) ([]byte, *image.Alpha, []glyphFit, error) {
 	var bffnt BFFNT
-	handleErr(err)
-	bffnt.Decode(bffntRaw)
+	err := bffnt.Decode(bffntRaw)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
 	fmt.Println("upscaling image by factor of", scale)
 	bffnt.Upscale(scale)
-	if botwFontName == "NormalS" {
-		// bffnt.TGLP.BaselinePosition += 6
-	}
-
-	bffnt.generateTexture(botwFontName, fontFile, scale) // This edits the CWDH
-
-	bffnt.manuallyAdjustWidths(botwFontName, scale)
-
-	encodedRaw := bffnt.Encode()
-	fmt.Println("encoded bytes:", len(encodedRaw))
+	bffnt.TGLP.BaselinePosition = uint16(int(bffnt.TGLP.BaselinePosition) + profile.BaselineNudge)
 
-	outputBffntFile := fmt.Sprintf("%s_00_%.2fx_template.bffnt", botwFontName, scale)
-	err = os.WriteFile(outputBffntFile, encodedRaw, 0644)
-	handleErr(err)
+	sheet, metrics, err := bffnt.generateTexture(profile, scale)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-	// bffnt.Decode(encodedRaw)
-}
+	var outliers []glyphFit
+	if fit {
+		outliers = bffnt.fitWidths(metrics, profile.Fit, scale)
+	} else {
+		profile.adjustWidths(&bffnt, scale)
+	}
 
-func (b *BFFNT) manuallyAdjustWidths(fontName string, scale float64) {
-	if scale == float64(2) {
-		switch fontName {
-		case "Ancient":
-		case "Caption":
-			adjustBotwCaptionWidth(b)
-		case "Normal":
-		case "NormalS":
-		case "External":
-		default:
-			panic("unknown font")
+	if kerningFromFont {
+		drawer, err := newGlyphDrawer(profile, scale)
+		if err != nil {
+			return nil, nil, nil, err
 		}
+		bffnt.generateKerning(drawer)
+		fmt.Println("kerning pairs from the font file:", bffnt.KRNG.pairCount())
 	}
-}
 
-func adjustBotwCaptionWidth(b *BFFNT) {
-	glyphWidths := b.CWDHs[0].Glyphs
-	glyphWidths[b.CWDHIndexMap['!']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap['"']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['&']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['\'']].CharWidth -= 6
-	glyphWidths[b.CWDHIndexMap['(']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap[')']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap['+']].CharWidth -= 4
-	glyphWidths[b.CWDHIndexMap[',']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap['-']].CharWidth -= 1
-	glyphWidths[b.CWDHIndexMap['.']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap['/']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap['0']].CharWidth -= 6
-	glyphWidths[b.CWDHIndexMap['1']].CharWidth -= 10
-	glyphWidths[b.CWDHIndexMap['2']].CharWidth -= 6
-	glyphWidths[b.CWDHIndexMap['3']].CharWidth -= 6
-	glyphWidths[b.CWDHIndexMap['4']].CharWidth -= 7
-	glyphWidths[b.CWDHIndexMap['5']].CharWidth -= 6
-	glyphWidths[b.CWDHIndexMap['6']].CharWidth -= 6
-	glyphWidths[b.CWDHIndexMap['7']].CharWidth -= 6
-	glyphWidths[b.CWDHIndexMap['8']].CharWidth -= 6
-	glyphWidths[b.CWDHIndexMap['9']].CharWidth -= 6
-	glyphWidths[b.CWDHIndexMap[':']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap[';']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap['<']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap['>']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap['?']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap['A']].CharWidth -= 1
-	glyphWidths[b.CWDHIndexMap['B']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['C']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['D']].CharWidth -= 4
-	glyphWidths[b.CWDHIndexMap['E']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['F']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['G']].CharWidth -= 1
-	glyphWidths[b.CWDHIndexMap['H']].CharWidth -= 4
-	glyphWidths[b.CWDHIndexMap['I']].CharWidth -= 1
-	glyphWidths[b.CWDHIndexMap['J']].CharWidth -= 1
-	glyphWidths[b.CWDHIndexMap['K']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['L']].CharWidth -= 4
-	glyphWidths[b.CWDHIndexMap['M']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['N']].CharWidth -= 5
-	glyphWidths[b.CWDHIndexMap['O']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['P']].CharWidth -= 4
-	glyphWidths[b.CWDHIndexMap['Q']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['R']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['S']].CharWidth -= 1
-	glyphWidths[b.CWDHIndexMap['T']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['U']].CharWidth -= 5
-	glyphWidths[b.CWDHIndexMap['V']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['W']].CharWidth -= 4
-	glyphWidths[b.CWDHIndexMap['X']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap['Y']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['Z']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['[']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap[']']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap['_']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['a']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['b']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['c']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['d']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['e']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['f']].CharWidth -= 1
-	glyphWidths[b.CWDHIndexMap['g']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['h']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['i']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap['j']].CharWidth -= 1
-	glyphWidths[b.CWDHIndexMap['k']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['l']].CharWidth -= 0
-	glyphWidths[b.CWDHIndexMap['m']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['n']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['o']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['p']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['q']].CharWidth -= 1
-	glyphWidths[b.CWDHIndexMap['r']].CharWidth -= 1
-	glyphWidths[b.CWDHIndexMap['s']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['t']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['u']].CharWidth -= 3
-	glyphWidths[b.CWDHIndexMap['v']].CharWidth -= 1
-	glyphWidths[b.CWDHIndexMap['w']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['x']].CharWidth -= 1
-	glyphWidths[b.CWDHIndexMap['y']].CharWidth -= 2
-	glyphWidths[b.CWDHIndexMap['z']].CharWidth -= 4
-
-	glyphWidths[b.CWDHIndexMap['C']].LeftWidth -= 2
-	glyphWidths[b.CWDHIndexMap['a']].LeftWidth += 1
-	glyphWidths[b.CWDHIndexMap['e']].LeftWidth -= 2
-	glyphWidths[b.CWDHIndexMap['g']].LeftWidth -= 1
-	glyphWidths[b.CWDHIndexMap['1']].LeftWidth -= 3
-	glyphWidths[b.CWDHIndexMap['!']].LeftWidth -= 1
-	glyphWidths[b.CWDHIndexMap[':']].LeftWidth -= 0
+	bffnt.TGLP.SheetQuality = quality
+	encodedRaw, err := bffnt.Encode()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	fmt.Println("encoded bytes:", len(encodedRaw))
+
+	return encodedRaw, sheet, outliers, nil
 }
 
+// Draws the glyphs of a font file into TGLP.SheetData. Returns the drawn
+// sheets below each other, with the cell grid on them in debug mode, and the
+// widths the font file has for every glyph.
 // https://pkg.go.dev/golang.org/x/image/font/sfnt#Font
-func (b *BFFNT) generateTexture(fontName string, fontFile string, scale float64) {
+func (b *BFFNT) generateTexture(profile *FontProfile, scale float64) (*image.Alpha, []glyphMetrics, error) {
 	glyphIndexes := b.GlyphIndexes()
 
-	fontSize, outlineOffset := getBotwFontSettings(fontName, scale)
-
 	var (
-		filename    = fmt.Sprintf("%s_00_%.2fx.png", fontName, scale)
 		cellWidth   = int(b.TGLP.CellWidth)
 		cellHeight  = int(b.TGLP.CellHeight)
-		columnCount = int(b.TGLP.NumOfColumns)
+		sheetCount  = int(b.TGLP.NumOfSheets)
 		baseline    = int(b.TGLP.BaselinePosition) + int(scale)
 		sheetHeight = int(b.TGLP.SheetHeight)
 		sheetWidth  = int(b.TGLP.SheetWidth)
@@ -297,274 +299,174 @@ func (b *BFFNT) generateTexture(fontName string, fontFile string, scale float64)
 		realCellHeight = cellHeight + 1
 	)
 
-	fmt.Println("Reading font file", fontFile)
-	dat, err := os.ReadFile(fontFile)
-	handleErr(err)
-
-	f, err := opentype.Parse(dat)
-	handleErr(err)
-
-	face, err := opentype.NewFace(f, &opentype.FaceOptions{
-		Size:    fontSize,
-		DPI:     144,
-		Hinting: font.HintingFull,
-	})
-	handleErr(err)
+	drawer, err := newGlyphDrawer(profile, scale)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// drawer.MeasureString can be used to modify kerning table
 	fmt.Println(sheetWidth, sheetHeight)
-	dst := image.NewAlpha(image.Rect(0, 0, sheetWidth, sheetHeight))
-	glyphDrawer := font.Drawer{
-		Dst:  dst,
-		Src:  image.White,
-		Face: face,
-		Dot:  fixed.P(0, 0),
-	}
-
-	var charIndex, x, y int
-	for rowIndex := 0; ; rowIndex++ {
-		y = realCellHeight*rowIndex + realBaseline
-		for columnIndex := 0; columnIndex < columnCount; columnIndex++ {
-			x = realCellWidth * columnIndex
-			glyphDrawer.Dot = fixed.P(x, y)
-			// fmt.Printf("The dot is at %v\n", glyphDrawer.Dot)
-
-			ascii := glyphIndexes[charIndex].CharAscii
-			glyph := string(rune(asciiToGlyph(fontName, ascii)))
-			// fmt.Println(charIndex, ascii, glyph)
-
-			glyphBoundAtDot, _ := glyphDrawer.BoundString(glyph)
-			// fmt.Println(x, glyphBoundAtDot.Min.X, glyphBoundAtDot.Min.Y, glyphBoundAtDot.Max.X, glyphBoundAtDot.Max.Y)
-
-			// TODO: make this work with multiple CWDHs
-			// calculate glyph x offset in it's cell so that there is only 1
-			// pixel length between the cell and the left most pixel of the
-			// glyph we are abount to draw. Generally the characters are draw
-			// to the right of the Dot but its possible for this to be
-			// negative. e.x. character j's left most pixel falls to the left
-			// of the dot.
-			leftAlignOffset := int(glyphBoundAtDot.Min.X/64) - x
-
-			// Drawing new glyphs means we should update the CWDH. If a glyph's
-			// recorded width is smaller than the one drawn it will get cut off
-			// when rendering in the game.
-			newGlyphWidth := int(glyphBoundAtDot.Max.X/64) - int(glyphBoundAtDot.Min.X/64) + 1
-			newGlyphWidth += 2 * outlineOffset // usually 0 except for botw NormalS, because the font has an outline
-			if newGlyphWidth > 255 {           // MaxUint8
-				panic("BFFNT's maximum glyph width is 255 (MaxUint8)")
-			}
-
-			// Measure how far the dot would travel if a character is printed
-			// we can use this to dial in the character width.
-			newCharWidth := int(glyphDrawer.MeasureString(glyph) / 64)
-			if newCharWidth > 255 { // MaxUint8
-				panic("BFFNT's maximum char width is 255 (MaxUint8)")
-			}
-
-			glyphCWDH := b.CWDHs[0].Glyphs[charIndex]
-			// It looks like that nintendo might have custom spacing, if the
-			// difference is too big do not update CWDH
-			// if math.Abs(float64(leftAlignOffset-int(glyphCWDH.LeftWidth))) <= float64(scale+1) {
-			// 	fmt.Println("left ", glyph, leftAlignOffset, glyphCWDH.LeftWidth)
-			// 	glyphCWDH.LeftWidth = int8(leftAlignOffset)
-			// }
-			// if math.Abs(float64(newCharWidth-int(glyphCWDH.CharWidth))) <= float64(scale+1) {
-			// 	fmt.Println("char ", glyph, newCharWidth, glyphCWDH.CharWidth)
-			// 	glyphCWDH.CharWidth = uint8(newCharWidth)
-			// }
-			// fmt.Println("glyph", glyph, newGlyphWidth, glyphCWDH.GlyphWidth)
-			glyphCWDH.GlyphWidth = uint8(newGlyphWidth)
-
-			y_nintendo := y - int(scale) // manual adjust to compensate y difference between nintendo font generator and mine.
-			glyphDrawer.Dot = fixed.P(x-leftAlignOffset+(outlineOffset)+1, y_nintendo)
-			glyphDrawer.DrawString(glyph)
-
-			charIndex++
-
-			// Exit when no more characters
-			// if charIndex == 95 {
-			if charIndex == len(glyphIndexes) {
-				goto writePng
-			}
+	dst := image.NewAlpha(image.Rect(0, 0, sheetWidth, sheetHeight*sheetCount))
+
+	var metrics []glyphMetrics
+	// Every glyph index has its own cell, counted row by row. Characters
+	// that share a glyph are drawn once.
+	drawn := make(map[uint16]bool, len(glyphIndexes))
+	for _, pair := range glyphIndexes {
+		if drawn[pair.CharIndex] {
+			continue
 		}
+		drawn[pair.CharIndex] = true
+
+		sheet, row, column := b.TGLP.cellPosition(int(pair.CharIndex))
+		x := realCellWidth * column
+		y := sheetHeight*sheet + realCellHeight*row + realBaseline
+
+		// What the font file wants for the glyph, Nintendo's spacing is
+		// different for some glyphs so the CWDH is only changed by
+		// fitWidths.
+		m, err := drawer.drawGlyph(dst, x, y, pair.CharAscii)
+		if err != nil {
+			return nil, nil, err
+		}
+		m.Index = pair.CharIndex
+		metrics = append(metrics, m)
+	}
+
+	// The generated sheets are what gets encoded into the bffnt
+	b.TGLP.SheetData = make([]image.NRGBA, sheetCount)
+	for i := range b.TGLP.SheetData {
+		b.TGLP.SheetData[i] = *imaging.Crop(dst, image.Rect(0, sheetHeight*i, sheetWidth, sheetHeight*(i+1)))
 	}
 
-writePng:
 	if Debug {
 		// draw grid lines. Good for debugging.
-		for x := 0; x < int(b.TGLP.SheetWidth); x += realCellWidth {
-			drawVerticalLine(dst, x, 0, int(b.TGLP.SheetHeight)) // draw columns
+		for x := 0; x < sheetWidth; x += realCellWidth {
+			drawVerticalLine(dst, x, 0, sheetHeight*sheetCount) // draw columns
 		}
-		for y := 0; y < int(b.TGLP.SheetHeight); y += realCellHeight {
-			drawHorizontalLine(dst, 0, y, int(b.TGLP.SheetWidth)) // draw rows
-		}
-		for y := int(b.TGLP.BaselinePosition) + 1; y < int(b.TGLP.SheetHeight); y += realCellHeight {
-			drawHorizontalLine(dst, 0, y, int(b.TGLP.SheetWidth)) // draw baseline
+		for top := 0; top < sheetHeight*sheetCount; top += sheetHeight {
+			for y := top; y < top+sheetHeight; y += realCellHeight {
+				drawHorizontalLine(dst, 0, y, sheetWidth) // draw rows
+			}
+			for y := top + int(b.TGLP.BaselinePosition) + 1; y < top+sheetHeight; y += realCellHeight {
+				drawHorizontalLine(dst, 0, y, sheetWidth) // draw baseline
+			}
 		}
 	}
 
-	_ = os.Remove(filename)
-
-	fmt.Println("wrote glyphs to", filename)
-	textureFile, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR, 0644)
-	handleErr(err)
-	err = png.Encode(textureFile, dst)
-	handleErr(err)
+	return dst, metrics, nil
 }
 
-// Manual adjustments for each font to closely resemble the original
-func getBotwFontSettings(fontName string, scale float64) (fontSize float64, outlineOffset int) {
-	switch fontName {
-	case "Ancient":
-		fontSize = 5.5 * scale
-
-	case "Caption":
-		fontSize = 8 * scale
-
-	case "Normal":
-		fontSize = 15 * scale // 2k
-
-	case "NormalS":
-		// This is what should be the proper setting for botw NormalS. However,
-		// there is a bug that stretches the words on the mini map if the
-		// textures are not the same width as the original.
-		fontSize = 10 * scale
-		outlineOffset = 3 // NormalS Characters have a 2px wide outline with 25% opacaity. I use GIMP.
-
-		// Boost the font size and minimize the opacity outline to let
-		// the character fill out the bounds of the texture as much as
-		// possible.
-		// fontSize = 11 * scale
-		// outlineOffset = 1
-		// the baseline will be manually adjusted in tglp
+// Draws glyphs of the font file of a profile the way generateTexture does
+type glyphDrawer struct {
+	profile *FontProfile
+	scale   float64
+	font    *opentype.Font
+	drawer  font.Drawer
+	kerning *fontKerning
+}
 
-	case "External":
-		fontSize = 15 * scale
+func newGlyphDrawer(profile *FontProfile, scale float64) (*glyphDrawer, error) {
+	fontFile := profile.fontFilePath()
+	fmt.Println("Reading font file", fontFile)
+	dat, err := os.ReadFile(fontFile)
+	if err != nil {
+		return nil, err
+	}
 
-	default:
-		panic("file texture generation settings unknown")
+	f, err := opentype.Parse(dat)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fontFile, err)
 	}
 
-	return
-}
+	size := profile.PointSize * scale
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     profile.dpi(),
+		Hinting: profile.hinting(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fontFile, err)
+	}
 
-// In most cases the ascii code maps to the correct glyph in the font file. For
-// some glyphs, the ascii does not match the glyph in the font file (because we
-// don't have the exact font file nintendo used). If the font file still
-// contains the correct glyph at a different index we can create a manual
-// mapping here.  No manual mapping means the ascii maps to the correct index
-// in the font file.
-var ancientMap map[uint16]uint16
-var externalMap map[uint16]uint16
-
-func initializeGlyphMaps() {
-	ancientMap = getBotwAncientMapping()
-	externalMap = getBotwExternalMapping()
+	return &glyphDrawer{
+		profile: profile,
+		scale:   scale,
+		font:    f,
+		drawer: font.Drawer{
+			Src:  image.White,
+			Face: face,
+			Dot:  fixed.P(0, 0),
+		},
+		kerning: newFontKerning(f, dat, size, profile.dpi(), profile.hinting()),
+	}, nil
 }
 
-func asciiToGlyph(fontName string, ascii uint16) uint16 {
-	var asciiToGlyphMap map[uint16]uint16
-	switch fontName {
-	case "Ancient":
-		asciiToGlyphMap = ancientMap
-	case "Caption":
-	case "Normal":
-	case "NormalS":
-	case "External":
-		asciiToGlyphMap = externalMap
-	default:
-		panic("unknown font mapping")
+// Draws the glyph for a character code into the cell whose left edge is x
+// and whose baseline is y, both including the 1 px cell padding. Returns the
+// widths the font file has for the glyph.
+func (d *glyphDrawer) drawGlyph(dst draw.Image, x int, y int, code uint16) (glyphMetrics, error) {
+	outlineOffset := d.profile.Outline
+	glyph := string(rune(d.profile.glyphCode(code)))
+
+	d.drawer.Dst = dst
+	d.drawer.Dot = fixed.P(x, y)
+	glyphBoundAtDot, _ := d.drawer.BoundString(glyph)
+	// fmt.Println(x, glyphBoundAtDot.Min.X, glyphBoundAtDot.Min.Y, glyphBoundAtDot.Max.X, glyphBoundAtDot.Max.Y)
+
+	// calculate glyph x offset in it's cell so that there is only 1
+	// pixel length between the cell and the left most pixel of the
+	// glyph we are abount to draw. Generally the characters are draw
+	// to the right of the Dot but its possible for this to be
+	// negative. e.x. character j's left most pixel falls to the left
+	// of the dot.
+	leftAlignOffset := int(glyphBoundAtDot.Min.X/64) - x
+
+	// Drawing new glyphs means we should update the CWDH. If a glyph's
+	// recorded width is smaller than the one drawn it will get cut off
+	// when rendering in the game.
+	newGlyphWidth := int(glyphBoundAtDot.Max.X/64) - int(glyphBoundAtDot.Min.X/64) + 1
+	newGlyphWidth += 2 * outlineOffset // usually 0 except for fonts with an outline, like botw NormalS
+	if newGlyphWidth > 255 {           // MaxUint8
+		return glyphMetrics{}, fmt.Errorf("glyph %q is %d px wide, a BFFNT's maximum glyph width is 255 (MaxUint8)", glyph, newGlyphWidth)
 	}
 
-	glyphIndex, manualMappingExists := asciiToGlyphMap[ascii]
-	if manualMappingExists {
-		return glyphIndex
+	// Measure how far the dot would travel if a character is printed
+	// we can use this to dial in the character width.
+	newCharWidth := int(d.drawer.MeasureString(glyph) / 64)
+	if newCharWidth > 255 { // MaxUint8
+		return glyphMetrics{}, fmt.Errorf("glyph %q advances %d px, a BFFNT's maximum char width is 255 (MaxUint8)", glyph, newCharWidth)
 	}
 
-	return ascii
-}
-
-// mapping botw external font character indexes to nintendo_ext_003.ttf
-func getBotwAncientMapping() map[uint16]uint16 {
-	botwAncientMapping := make(map[uint16]uint16, 0)
+	y_nintendo := y - int(d.scale) // manual adjust to compensate y difference between nintendo font generator and mine.
+	d.drawer.Dot = fixed.P(x-leftAlignOffset+(outlineOffset)+1, y_nintendo)
+	d.drawer.DrawString(glyph)
 
-	// map indexes with no glyphs to empty
-	for i := uint16(34); i <= uint16(44); i++ {
-		botwAncientMapping[i] = 32
-	}
-	for i := uint16(47); i <= uint16(62); i++ {
-		botwAncientMapping[i] = 32
-	}
-	botwAncientMapping[64] = 32
-	for i := uint16(91); i <= uint16(96); i++ {
-		botwAncientMapping[i] = 32
-	}
-	botwAncientMapping[123] = 32
+	return glyphMetrics{
+		Code:       code,
+		LeftWidth:  leftAlignOffset - outlineOffset,
+		GlyphWidth: newGlyphWidth,
+		CharWidth:  newCharWidth,
+	}, nil
+}
 
-	// remap all capital letters to lowercase ones.
-	for i := uint16(65); i <= uint16(90); i++ {
-		botwAncientMapping[i] = i + 32
+// Glyph of the font file for a character code, 0 when it does not have one
+func (d *glyphDrawer) glyphIndex(code uint16) sfnt.GlyphIndex {
+	var buf sfnt.Buffer
+	index, err := d.font.GlyphIndex(&buf, rune(d.profile.glyphCode(code)))
+	if err != nil {
+		return 0
 	}
+	return index
+}
 
-	return botwAncientMapping
+// Whether the font file has a glyph for a character code
+func (d *glyphDrawer) hasGlyph(code uint16) bool {
+	return d.glyphIndex(code) != 0
 }
 
-// mapping botw external font character indexes to nintendo_ext_003.ttf
-func getBotwExternalMapping() map[uint16]uint16 {
-	botwExternalMapping := make(map[uint16]uint16, 0)
-
-	botwExternalMapping[57408] = 57568 // A
-	botwExternalMapping[57409] = 57569 // B
-	botwExternalMapping[57410] = 57570 // X
-	botwExternalMapping[57411] = 57571 // Y
-	botwExternalMapping[57412] = 57572 // L
-	botwExternalMapping[57413] = 57573 // R
-	botwExternalMapping[57414] = 57574 // ZL
-	botwExternalMapping[57415] = 57575 // ZR
-	botwExternalMapping[57416] = 57587 // Power
-	botwExternalMapping[57417] = 57616 // D-pad
-	botwExternalMapping[57418] = 57588 // Home
-	botwExternalMapping[57419] = 57583 // +
-	botwExternalMapping[57420] = 57584 // -
-
-	botwExternalMapping[57424] = 57473 // Ljoy down
-	botwExternalMapping[57425] = 57474 // Rjoy down
-	botwExternalMapping[57426] = 57473 // Ljoy up
-	botwExternalMapping[57427] = 57474 // Rjoy up
-	botwExternalMapping[57428] = 57473 // Ljoy left-right
-	botwExternalMapping[57429] = 57474 // Rjoy left-right
-	botwExternalMapping[57430] = 57473 // Ljoy press-down
-	botwExternalMapping[57431] = 57474 // Rjoy press-down
-	botwExternalMapping[57432] = 57473 // Ljoy right
-	botwExternalMapping[57433] = 57474 // Rjoy right
-	botwExternalMapping[57434] = 57473 // Ljoy left
-	botwExternalMapping[57435] = 57473 // Rjoy left
-	botwExternalMapping[57437] = 57473 // Rjoy up-down
-	botwExternalMapping[57438] = 57473 // Ljoy
-	botwExternalMapping[57439] = 57473 // Rjoy
-	botwExternalMapping[57440] = 0     // D-pad up
-	botwExternalMapping[57441] = 0     // D-pad down
-	botwExternalMapping[57442] = 0     // D-pad left
-	botwExternalMapping[57443] = 0     // D-pad right
-	botwExternalMapping[57444] = 0     // D-pad up-down
-	botwExternalMapping[57445] = 0     // D-pad left-right
-	// (34, 57446)
-	// (35, 57447)
-	// (36, 57475)
-	// (37, 57476)
-	// (38, 57477)
-	// (39, 57478)
-	// (40, 57479)
-	// (41, 57480)
-	// (42, 57481)
-	// (43, 57482)
-	// (44, 57483)
-	// (45, 57484)
-	// (46, 57485)
-	// (47, 57486)
-	// (48, 57487)
-
-	return botwExternalMapping
+// Kerning of the font file between two character codes, in pixels
+func (d *glyphDrawer) kern(first uint16, second uint16) int {
+	return d.kerning.pair(d.glyphIndex(first), d.glyphIndex(second))
 }
 
 func drawHorizontalLine(img *image.Alpha, x1, y, x2 int) {
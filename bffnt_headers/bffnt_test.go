@@ -1,17 +1,28 @@
 package bffnt_headers
 
 import (
+	"bytes"
 	"crypto/md5"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
 	"io"
 	"io/ioutil"
 	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
+	"unicode"
 
+	"github.com/disintegration/imaging"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -35,6 +46,7 @@ func TestBFFNT(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
+		tc := tc
 		fmt.Println(fmt.Sprintf("Testing bffnt file %s", tc.filename))
 		t.Run(tc.filename, func(t *testing.T) {
 			t.Parallel()
@@ -55,41 +67,49 @@ func testCase(t *testing.T, bffntFile string, expectedFileHash string) {
 	assert.Equal(t, expectedFileHash, hash, "md5 hash of bffnt file mismatch. test is invalid.")
 
 	var ffnt FFNT
-	ffnt.Decode(bffntRaw)
-	encodedFFNT := ffnt.Encode(ffnt.TotalFileSize)
+	assertNoErr(t, ffnt.Decode(bffntRaw))
+	encodedFFNT, err := ffnt.Encode(ffnt.TotalFileSize)
+	assertNoErr(t, err)
 	expectedFFNT := bffntRaw[:FFNT_HEADER_SIZE]
 	assert.Equal(t, expectedFFNT, encodedFFNT, "FFNT encoding did not produce the correct results")
 
 	var finf FINF
-	finf.Decode(bffntRaw)
-	encodedFINF := finf.Encode(int(finf.TGLPOffset), int(finf.CWDHOffset), int(finf.CMAPOffset))
+	assertNoErr(t, finf.Decode(bffntRaw))
+	encodedFINF, err := finf.Encode(int(finf.TGLPOffset), int(finf.CWDHOffset), int(finf.CMAPOffset))
+	assertNoErr(t, err)
 	expectedFINF := bffntRaw[FFNT_HEADER_SIZE : FFNT_HEADER_SIZE+FINF_HEADER_SIZE]
 	assert.Equal(t, expectedFINF, encodedFINF, "FINF encoding did not produce the correct results")
 
 	var tglp TGLP
 	tglpHeaderStart := FFNT_HEADER_SIZE + FINF_HEADER_SIZE
 	tglpHeaderEnd := tglpHeaderStart + TGLP_HEADER_SIZE
-	tglp.DecodeHeader(bffntRaw[tglpHeaderStart:tglpHeaderEnd])
-	encodedTGLPHeader := tglp.EncodeHeader()
+	assertNoErr(t, tglp.DecodeHeader(bffntRaw[tglpHeaderStart:tglpHeaderEnd]))
+	encodedTGLPHeader, err := tglp.EncodeHeader()
+	assertNoErr(t, err)
 	expectedTGLPHeader := bffntRaw[tglpHeaderStart:tglpHeaderEnd]
 	assert.Equal(t, expectedTGLPHeader, encodedTGLPHeader, "TGLP Header encoding did not produce the correct results")
-	encodedTGLP := tglp.Encode()
+	encodedTGLP, err := tglp.Encode()
+	assertNoErr(t, err)
 	// check data length is correct at least
 	tglpDataEnd := tglpHeaderStart + int(tglp.SectionSize)
 	expectedTGLP := bffntRaw[tglpHeaderStart:tglpDataEnd]
 	assert.Equal(t, len(expectedTGLP), len(encodedTGLP), "TGLP encoding did not produce the correct amount of bytes")
 
 	var cwdhList []CWDH
-	cwdhList = DecodeCWDHs(bffntRaw, finf.CWDHOffset)
-	encodedCWDHs := EncodeCWDHs(cwdhList, int(finf.CWDHOffset))
+	cwdhList, err = DecodeCWDHs(bffntRaw, finf.CWDHOffset)
+	assertNoErr(t, err)
+	encodedCWDHs, err := EncodeCWDHs(cwdhList, int(finf.CWDHOffset))
+	assertNoErr(t, err)
 	cwdhStart := finf.CWDHOffset - 8
 	cwdhEnd := int(cwdhStart) + totalCwdhSectionSize(cwdhList)
 	expectedCWDHs := bffntRaw[cwdhStart:cwdhEnd]
 	assert.Equal(t, expectedCWDHs, encodedCWDHs, "CWDH encoding did not produce the correct results")
 
 	var cmapList []CMAP
-	cmapList = DecodeCMAPs(bffntRaw, finf.CMAPOffset)
-	encodedCMAPs := EncodeCMAPs(cmapList, int(finf.CMAPOffset))
+	cmapList, err = DecodeCMAPs(bffntRaw, finf.CMAPOffset)
+	assertNoErr(t, err)
+	encodedCMAPs, err := EncodeCMAPs(cmapList, int(finf.CMAPOffset))
+	assertNoErr(t, err)
 	cmapStart := finf.CMAPOffset - 8
 	cmapEnd := int(cmapStart) + totalCmapSectionSize(cmapList)
 	expectedCMAPs := bffntRaw[cmapStart:cmapEnd]
@@ -98,9 +118,10 @@ func testCase(t *testing.T, bffntFile string, expectedFileHash string) {
 	var encodedKRNG []byte
 	if strings.Index(string(bffntRaw), KRNG_MAGIC_HEADER) != -1 {
 		var krng KRNG
-		krng.Decode(bffntRaw)
+		assertNoErr(t, krng.Decode(bffntRaw))
 		krngStart := uint32(strings.Index(string(bffntRaw), KRNG_MAGIC_HEADER))
-		encodedKRNG = krng.Encode(krngStart)
+		encodedKRNG, err = krng.Encode(krngStart)
+		assertNoErr(t, err)
 		krngEnd := krngStart + krng.SectionSize
 		expectedKRNG := bffntRaw[krngStart:krngEnd]
 		assert.Equal(t, expectedKRNG, encodedKRNG, "KRNG encoding did not produce the correct results")
@@ -112,24 +133,28 @@ func testCase(t *testing.T, bffntFile string, expectedFileHash string) {
 
 	// verifyUpscale(t, bffntRaw)
 	var bffnt BFFNT
-	bffnt.Decode(bffntRaw)
+	assertNoErr(t, bffnt.Decode(bffntRaw))
 	bffnt.Upscale(1)
-	encodedUpscaled := bffnt.Encode()
+	encodedUpscaled, err := bffnt.Encode()
+	assertNoErr(t, err)
 	verifyBffnt(t, encodedUpscaled)
 
-	bffnt.Decode(bffntRaw)
+	assertNoErr(t, bffnt.Decode(bffntRaw))
 	bffnt.Upscale(2)
-	encodedUpscaled = bffnt.Encode()
+	encodedUpscaled, err = bffnt.Encode()
+	assertNoErr(t, err)
 	verifyBffnt(t, encodedUpscaled)
 
-	bffnt.Decode(bffntRaw)
+	assertNoErr(t, bffnt.Decode(bffntRaw))
 	bffnt.Upscale(1.1)
-	encodedUpscaled = bffnt.Encode()
+	encodedUpscaled, err = bffnt.Encode()
+	assertNoErr(t, err)
 	verifyBffnt(t, encodedUpscaled)
 
-	bffnt.Decode(bffntRaw)
+	assertNoErr(t, bffnt.Decode(bffntRaw))
 	bffnt.Upscale(1.2)
-	encodedUpscaled = bffnt.Encode()
+	encodedUpscaled, err = bffnt.Encode()
+	assertNoErr(t, err)
 	verifyBffnt(t, encodedUpscaled)
 
 }
@@ -157,12 +182,15 @@ func verifyBffnt(t *testing.T, bffntRaw []byte) {
 	var cmapList []CMAP
 	var krng KRNG
 
-	ffnt.Decode(bffntRaw)
-	finf.Decode(bffntRaw)
-	tglp.Decode(bffntRaw)
-	cwdhList = DecodeCWDHs(bffntRaw, finf.CWDHOffset)
-	cmapList = DecodeCMAPs(bffntRaw, finf.CMAPOffset)
-	krng.Decode(bffntRaw)
+	var err error
+	assertNoErr(t, ffnt.Decode(bffntRaw))
+	assertNoErr(t, finf.Decode(bffntRaw))
+	assertNoErr(t, tglp.Decode(bffntRaw))
+	cwdhList, err = DecodeCWDHs(bffntRaw, finf.CWDHOffset)
+	assertNoErr(t, err)
+	cmapList, err = DecodeCMAPs(bffntRaw, finf.CMAPOffset)
+	assertNoErr(t, err)
+	assertNoErr(t, krng.Decode(bffntRaw))
 
 	assertFail(t, 0, ffntStart, "ffnt should start at the byte 0")
 	assertFail(t, FFNT_MAGIC_HEADER, ffnt.MagicHeader, `ffnt magic header should be "FFNT"`)
@@ -189,16 +217,11 @@ func verifyBffnt(t *testing.T, bffntRaw []byte) {
 	assertFail(t, cwdhStart-tglpStart, int(tglp.SectionSize), "tglp.SectionSize should match cwdhStart-tglpStart")
 	assertFail(t, int(tglp.SectionSize), TGLP_HEADER_SIZE+tglpPaddingSize+tglpDataSize, "all tglp sections added together should equal the Section size")
 	assertFail(t, tglpDataSize, int(tglp.SheetSize)*int(tglp.NumOfSheets), "tglp.SheetSize and NumOfSheets should be the same as data size")
-	switch tglp.SheetImageFormat {
-	case 12:
-		// There seems to be a minimum of 65536 (Uint16Max). Ancient_00 observes this.
-		sheetArea := math.Max(math.Ceil(float64(tglp.SheetWidth)*float64(tglp.SheetHeight)/float64(2)), 65536)
-		assertFail(t, int(tglp.SheetSize), int(sheetArea), "SheetWidth*SheetHeight == SheetSize/2 when ImageFormat is 12 (ETC1)")
-	case 8:
-		assertFail(t, int(tglp.SheetSize), int(tglp.SheetWidth)*int(tglp.SheetHeight), "SheetWidth*SheetHeight == SheetSize when ImageFormat is 8 (A8)")
-	default:
-		panic(fmt.Sprintf("SheetWidth, SheetHeight, SheetSize ratio for image format %d not yet coded.", tglp.SheetImageFormat))
-	}
+	// Sheets are swizzled into whole GX2 tiles. The padding is why small
+	// sheets like Ancient_00's have a minimum size of 65536.
+	sheetSurface, _, err := tglp.sheetSurface(0)
+	assertNoErr(t, err)
+	assertFail(t, sheetSurface.Size(), int(tglp.SheetSize), "SheetSize should be the size of the swizzled sheet surface")
 	assertFail(t, int(52+tglp.SectionSize), cwdhStart, "cwdh should start whend tglp ends")
 
 	// verify cwdh
@@ -328,6 +351,761 @@ func allZero(s []byte) bool {
 	return true
 }
 
+// Decoding sheets and encoding them again has to produce the same swizzled
+// sheet data. A8 is lossless so it must match byte for byte, BC4 is
+// compared after decoding it again.
+func TestSheetRoundTrip(t *testing.T) {
+	testCases := []struct {
+		filename string
+		lossless bool
+	}{
+		{"../WiiU_fonts/botw/NormalS/NormalS_00.bffnt", true},
+		{"../WiiU_fonts/botw/Caption/Caption_00.bffnt", false},
+		{"../WiiU_fonts/botw/Ancient/Ancient_00.bffnt", false},
+		{"../WiiU_fonts/botw/Normal/Normal_00.bffnt", false},
+	}
+
+	for _, tc := range testCases {
+		bffntRaw, err := ioutil.ReadFile(tc.filename)
+		handleErr(err)
+
+		var bffnt BFFNT
+		assertNoErr(t, bffnt.Decode(bffntRaw))
+		encoded, err := bffnt.Encode()
+		assertNoErr(t, err)
+		verifyBffnt(t, encoded)
+
+		if tc.lossless {
+			assertFail(t, bffntRaw, encoded, tc.filename+" did not encode to the same bytes")
+			continue
+		}
+
+		var reencoded BFFNT
+		assertNoErr(t, reencoded.Decode(encoded))
+		assertFail(t, len(bffnt.TGLP.SheetData), len(reencoded.TGLP.SheetData), tc.filename+" sheet count changed after encoding")
+		for sheetIndex, original := range bffnt.TGLP.SheetData {
+			roundTrip := reencoded.TGLP.SheetData[sheetIndex]
+			// BC4 is lossy, blocks whose original endpoints can not be found
+			// again change a little but most of the sheet has to stay the same
+			maxDiff, totalDiff := 0, 0
+			for i := 3; i < len(original.Pix); i += 4 {
+				diff := int(original.Pix[i]) - int(roundTrip.Pix[i])
+				if diff < 0 {
+					diff = -diff
+				}
+				if diff > maxDiff {
+					maxDiff = diff
+				}
+				totalDiff += diff
+			}
+			meanDiff := float64(totalDiff) / float64(len(original.Pix)/4)
+			assertFail(t, true, maxDiff <= 32, fmt.Sprintf("%s sheet %d changed by up to %d after encoding", tc.filename, sheetIndex, maxDiff))
+			assertFail(t, true, meanDiff < 0.05, fmt.Sprintf("%s sheet %d changed by %.3f on average after encoding", tc.filename, sheetIndex, meanDiff))
+		}
+	}
+
+	// Upscaled fonts need sheet images of the new size
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	assertNoErr(t, bffnt.Decode(bffntRaw))
+	bffnt.Upscale(1.5)
+	bffnt.TGLP.SheetData = []image.NRGBA{*image.NewNRGBA(image.Rect(0, 0, 10, 10))}
+	_, err = bffnt.Encode()
+	assertFail(t, true, errors.Is(err, ErrSizeMismatch), "encoding a sheet of the wrong size should fail")
+
+	sheet := image.NewNRGBA(image.Rect(0, 0, int(bffnt.TGLP.SheetWidth), int(bffnt.TGLP.SheetHeight)))
+	bffnt.TGLP.SheetData = []image.NRGBA{*sheet}
+	encoded, err := bffnt.Encode()
+	assertNoErr(t, err)
+	verifyBffnt(t, encoded)
+}
+
+// The verify command has to pass the original fonts and fail broken ones.
+func TestVerifyRoundTrip(t *testing.T) {
+	for _, filename := range []string{
+		"../WiiU_fonts/botw/NormalS/NormalS_00.bffnt",
+		"../WiiU_fonts/botw/Caption/Caption_00.bffnt",
+		"../WiiU_fonts/botw/Normal/Normal_00.bffnt",
+	} {
+		bffntRaw, err := ioutil.ReadFile(filename)
+		handleErr(err)
+		assertNoErr(t, verifyRoundTrip(bffntRaw))
+	}
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	assertFail(t, true, verifyRoundTrip(bffntRaw[:len(bffntRaw)/2]) != nil, "a truncated font should not verify")
+}
+
+// The botw profiles have to keep the settings the fonts were upscaled with
+// before there were profiles.
+func TestFontProfiles(t *testing.T) {
+	profileFiles, err := filepath.Glob("../profiles/botw/*.json")
+	assertNoErr(t, err)
+	assertFail(t, 5, len(profileFiles), "botw profile count")
+
+	profiles := map[string]*FontProfile{}
+	for _, profileFile := range profileFiles {
+		profile, err := LoadFontProfile(profileFile)
+		assertNoErr(t, err)
+		_, err = os.Stat(profile.fontFilePath())
+		assertNoErr(t, err)
+		profiles[profile.Name] = profile
+	}
+
+	assert.Equal(t, 5.5, profiles["Ancient"].PointSize)
+	assert.Equal(t, 3, profiles["NormalS"].Outline)
+	assert.Equal(t, 144.0, profiles["Normal"].dpi())
+
+	ancient := profiles["Ancient"]
+	assert.Equal(t, uint16('a'), ancient.glyphCode('A'))
+	assert.Equal(t, uint16('z'), ancient.glyphCode('Z'))
+	assert.Equal(t, uint16(' '), ancient.glyphCode('#'))
+	assert.Equal(t, uint16('a'), ancient.glyphCode('a'))
+	external := profiles["External"]
+	assert.Equal(t, uint16(57568), external.glyphCode(57408))
+	assert.Equal(t, uint16(0), external.glyphCode(57440))
+	assert.Equal(t, uint16(57436), external.glyphCode(57436))
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	assertNoErr(t, bffnt.Decode(bffntRaw))
+	one := *bffnt.glyphWidths(uint16(bffnt.CWDHIndexMap['1']))
+
+	profiles["Caption"].adjustWidths(&bffnt, 1.5)
+	assert.Equal(t, one, *bffnt.glyphWidths(uint16(bffnt.CWDHIndexMap['1'])), "widths are only adjusted at their scale")
+	profiles["Caption"].adjustWidths(&bffnt, 2)
+	adjusted := *bffnt.glyphWidths(uint16(bffnt.CWDHIndexMap['1']))
+	assert.Equal(t, int(one.CharWidth)-10, int(adjusted.CharWidth))
+	assert.Equal(t, int(one.LeftWidth)-3, int(adjusted.LeftWidth))
+
+	glyph := uint16(32)
+	for _, invalid := range []FontProfile{
+		{PointSize: 10},
+		{Name: "Normal"},
+		{Name: "Normal", PointSize: 10, Hinting: "some"},
+		{Name: "Normal", PointSize: 10, Remap: []RemapRule{{First: 40, Last: 30, Glyph: &glyph}}},
+		{Name: "Normal", PointSize: 10, Remap: []RemapRule{{First: 40}}},
+		{Name: "Normal", PointSize: 10, Widths: map[string]map[string]WidthAdjustment{"2": {"ab": {}}}},
+	} {
+		assert.Error(t, invalid.validate(), fmt.Sprintf("%+v", invalid))
+	}
+}
+
+// Fitting moves the widths towards the font file by at most the max
+// correction and reports the glyphs it could not fit.
+func TestFitWidths(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	assertNoErr(t, bffnt.Decode(bffntRaw))
+	bffnt.Upscale(2)
+
+	a, b, c := uint16(bffnt.CWDHIndexMap['a']), uint16(bffnt.CWDHIndexMap['b']), uint16(bffnt.CWDHIndexMap['c'])
+	original := []glyphInfo{*bffnt.glyphWidths(a), *bffnt.glyphWidths(b), *bffnt.glyphWidths(c)}
+	metrics := []glyphMetrics{
+		// within tolerance of the original
+		{Code: 'a', Index: a, LeftWidth: int(original[0].LeftWidth) + 1, GlyphWidth: 10, CharWidth: int(original[0].CharWidth) - 2},
+		// can be fitted exactly
+		{Code: 'b', Index: b, LeftWidth: int(original[1].LeftWidth) - 6, GlyphWidth: 12, CharWidth: int(original[1].CharWidth) + 5},
+		// too far off, and wider than a cell
+		{Code: 'c', Index: c, LeftWidth: int(original[2].LeftWidth), GlyphWidth: 1000, CharWidth: int(original[2].CharWidth) + 20},
+	}
+
+	outliers := bffnt.fitWidths(metrics, WidthFit{}, 2)
+
+	assert.Equal(t, int(original[0].LeftWidth)+1, int(bffnt.glyphWidths(a).LeftWidth))
+	assert.Equal(t, int(original[0].CharWidth)-2, int(bffnt.glyphWidths(a).CharWidth))
+	assert.Equal(t, uint8(10), bffnt.glyphWidths(a).GlyphWidth)
+	assert.Equal(t, int(original[1].LeftWidth)-6, int(bffnt.glyphWidths(b).LeftWidth))
+	assert.Equal(t, int(original[1].CharWidth)+5, int(bffnt.glyphWidths(b).CharWidth))
+	assert.Equal(t, int(original[2].CharWidth)+6, int(bffnt.glyphWidths(c).CharWidth), "corrections are bounded by 3 pixels times the scale")
+	assert.Equal(t, bffnt.TGLP.CellWidth, bffnt.glyphWidths(c).GlyphWidth, "glyphs can not be wider than a cell")
+
+	assertFail(t, 1, len(outliers), "outlier count")
+	assert.Equal(t, uint16('c'), outliers[0].Code)
+	assert.Equal(t, int(original[2].CharWidth)+20, outliers[0].FontWidth)
+
+	var report bytes.Buffer
+	printWidthFitReport(&report, "Caption_00.bffnt", outliers)
+	assert.Contains(t, report.String(), "1 glyphs are still outside tolerance")
+	assert.Contains(t, report.String(), "'c'")
+}
+
+// None of the fonts have more than one CWDH, so Caption's is split in two to
+// check glyph indexes are looked up in the CWDH that covers them.
+func TestResolveGlyphIndex(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	var original BFFNT
+	assertNoErr(t, original.Decode(bffntRaw))
+	glyphs := original.CWDHs[0].Glyphs
+	last := original.CWDHs[0].EndIndex
+
+	var split BFFNT
+	assertNoErr(t, split.Decode(bffntRaw))
+	first, second := split.CWDHs[0], split.CWDHs[0]
+	first.EndIndex, first.Glyphs = 300, glyphs[:301]
+	second.StartIndex, second.Glyphs = 301, glyphs[301:]
+	split.CWDHs = []CWDH{first, second}
+	encoded, err := split.Encode()
+	assertNoErr(t, err)
+	assertNoErr(t, split.Decode(encoded))
+	assertFail(t, 2, len(split.CWDHs), "CWDH count")
+
+	for _, tc := range []struct {
+		index     uint16
+		cwdhIndex int
+		offset    int
+	}{
+		{0, 0, 0},
+		{300, 0, 300},
+		{301, 1, 0},
+		{last, 1, int(last) - 301},
+	} {
+		cwdhIndex, offset, ok := split.ResolveGlyphIndex(tc.index)
+		assert.True(t, ok, tc.index)
+		assert.Equal(t, tc.cwdhIndex, cwdhIndex, tc.index)
+		assert.Equal(t, tc.offset, offset, tc.index)
+		assert.Equal(t, glyphs[tc.index], *split.glyphWidths(tc.index), tc.index)
+	}
+	_, _, ok := split.ResolveGlyphIndex(last + 1)
+	assert.False(t, ok, "no CWDH covers the index after the last glyph")
+	assert.Nil(t, split.glyphWidths(last+1))
+
+	for char, index := range original.CWDHIndexMap {
+		assertFail(t, *original.glyphWidths(uint16(index)), *split.glyphWidths(uint16(split.CWDHIndexMap[char])), fmt.Sprintf("widths of %q", char))
+	}
+}
+
+// NormalS has A8 sheets, so cell images have to survive encoding unchanged.
+func TestFont(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+	var original BFFNT
+	assertNoErr(t, original.Decode(bffntRaw))
+	font, err := DecodeFont(bffntRaw)
+	assertNoErr(t, err)
+
+	a, ok := font.Glyph('A')
+	assert.True(t, ok)
+	index := original.CWDHIndexMap['A']
+	assertFail(t, *original.glyphWidths(uint16(index)), glyphInfo{a.LeftWidth, a.GlyphWidth, a.CharWidth}, "widths of A")
+	assert.Equal(t, index/int(original.TGLP.NumOfColumns), a.Row)
+	assert.Equal(t, index%int(original.TGLP.NumOfColumns), a.Column)
+	assert.Equal(t, original.KRNG.Kern('A', 'V'), a.Kerning['V'])
+	assert.NotEqual(t, 0, a.Kerning['V'], "A should kern with V")
+	assert.False(t, allZero(a.Image.Pix), "A should be drawn")
+
+	// edits are done on copies
+	a.Image.Pix[3] = 0xFF
+	a.Kerning['V'] = 0
+	unchanged, _ := font.Glyph('A')
+	assert.NotEqual(t, a.Image.Pix, unchanged.Image.Pix)
+	assert.Equal(t, original.KRNG.Kern('A', 'V'), unchanged.Kerning['V'])
+
+	added := unchanged
+	added.Rune = 0xE000
+	assertNoErr(t, font.AddGlyph(added))
+	assertNoErr(t, font.ReplaceGlyph(Glyph{Rune: 'B', CharWidth: 7}))
+	assertNoErr(t, font.RemoveGlyph('V'))
+	runes := font.Runes()
+
+	assert.True(t, errors.Is(font.AddGlyph(added), ErrGlyphExists))
+	assert.True(t, errors.Is(font.RemoveGlyph('V'), ErrGlyphNotFound))
+	assert.True(t, errors.Is(font.ReplaceGlyph(Glyph{Rune: 'V'}), ErrGlyphNotFound))
+	assert.True(t, errors.Is(font.RemoveGlyph(rune(original.GlyphIndexes()[original.FINF.AlterCharIndex].CharAscii)), ErrAlterChar))
+	assert.True(t, errors.Is(font.AddGlyph(Glyph{Rune: 0xE001, Image: image.NewNRGBA(image.Rect(0, 0, 1, 1))}), ErrSizeMismatch))
+	assert.True(t, errors.Is(font.AddGlyph(Glyph{Rune: 0x10000}), ErrValueOutOfRange))
+
+	encoded, err := font.Encode()
+	assertNoErr(t, err)
+	decoded, err := DecodeFont(encoded)
+	assertNoErr(t, err)
+	assertFail(t, runes, decoded.Runes(), "glyph order")
+
+	for _, r := range runes {
+		if r == 'B' {
+			continue
+		}
+		expected, _ := font.Glyph(r)
+		actual, ok := decoded.Glyph(r)
+		assert.True(t, ok, string(r))
+		assert.Equal(t, expected, actual, string(r))
+	}
+
+	b, _ := decoded.Glyph('B')
+	assert.Equal(t, uint8(7), b.CharWidth)
+	for i := 3; i < len(b.Image.Pix); i += 4 {
+		assertFail(t, uint8(0), b.Image.Pix[i], "B should be blank")
+	}
+	_, ok = decoded.Glyph('V')
+	assert.False(t, ok)
+	a, _ = decoded.Glyph('A')
+	_, ok = a.Kerning['V']
+	assert.False(t, ok, "kerning with a removed glyph should be removed")
+	pua, _ := decoded.Glyph(0xE000)
+	assert.Equal(t, unchanged.Image, pua.Image)
+	assert.Equal(t, a.Kerning, pua.Kerning)
+	assert.Equal(t, len(runes)-1, decoded.BFFNT.CWDHIndexMap[0xE000])
+}
+
+// NormalS has 50 free cells, adding more glyphs has to add a sheet.
+func TestAddGlyphs(t *testing.T) {
+	chars, err := parseCharList("U+4E00-U+4E7F, 0x100-17f,41")
+	assertNoErr(t, err)
+	assertFail(t, 128+128+1, len(chars), "character count")
+	assert.Equal(t, rune(0x4E00), chars[0])
+	assert.Equal(t, 'A', chars[len(chars)-1])
+	for _, invalid := range []string{"4E7F-4E00", "U+XYZ", "1-", "110000"} {
+		_, err := parseCharList(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+	font, err := DecodeFont(bffntRaw)
+	assertNoErr(t, err)
+	originalA, _ := font.Glyph('A')
+	profile, err := LoadFontProfile("../profiles/botw/NormalS.json")
+	assertNoErr(t, err)
+
+	added, skipped, err := addGlyphs(font, profile, 1, append(chars, '\n', 0x10000, 0x4E00))
+	assertNoErr(t, err)
+	assert.Greater(t, len(added), 50)
+	assert.NotContains(t, added, 'A', "characters the font has are not drawn again")
+	assert.Contains(t, skipped, '\n')
+	assert.Contains(t, skipped, rune(0x10000))
+	assert.Equal(t, len(uniqueRunes(added)), len(added))
+
+	encoded, err := font.Encode()
+	assertNoErr(t, err)
+	assertNoErr(t, verifyRoundTrip(encoded))
+	decoded, err := DecodeFont(encoded)
+	assertNoErr(t, err)
+	assert.Equal(t, uint8(2), decoded.BFFNT.TGLP.NumOfSheets)
+
+	a, _ := decoded.Glyph('A')
+	assert.Equal(t, originalA.Image, a.Image)
+	for second, value := range originalA.Kerning {
+		assert.Equal(t, value, a.Kerning[second], "kerning A"+string(second))
+	}
+	assert.NotZero(t, a.Kerning['Ť'], "characters the font has kern with added ones")
+	kerned := false
+	for _, r := range added {
+		glyph, ok := decoded.Glyph(r)
+		assertFail(t, true, ok, string(r))
+		assert.False(t, allZero(glyph.Image.Pix), string(r))
+		assert.LessOrEqual(t, int(glyph.GlyphWidth), int(decoded.BFFNT.TGLP.CellWidth), string(r))
+		kerned = kerned || len(glyph.Kerning) > 0
+	}
+	last, _ := decoded.Glyph(added[len(added)-1])
+	assert.Equal(t, 1, last.Sheet)
+	assert.True(t, kerned, "the font file kerns some of the added characters")
+}
+
+// CafeStd is what NormalS was made from, its kerning at scale 1 is the
+// kerning Nintendo put in the font.
+func TestGenerateKerning(t *testing.T) {
+	cafeStd, err := ioutil.ReadFile("../nintendo_system_ui/DSi-Wii-3DS-Wii_U/CafeStd.ttf")
+	handleErr(err)
+	assert.NotEmpty(t, readKernTable(cafeStd))
+	noKerning, err := ioutil.ReadFile("../nintendo_system_ui/nintendo_ext_003.ttf")
+	handleErr(err)
+	assert.Empty(t, readKernTable(noKerning))
+	assert.Empty(t, readKernTable([]byte("not a font")))
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+	var b BFFNT
+	assertNoErr(t, b.Decode(bffntRaw))
+	original := b.KRNG.KerningTable
+	profile, err := LoadFontProfile("../profiles/botw/NormalS.json")
+	assertNoErr(t, err)
+
+	drawer, err := newGlyphDrawer(profile, 1)
+	assertNoErr(t, err)
+	b.generateKerning(drawer)
+	assert.Equal(t, original, b.KRNG.KerningTable)
+
+	drawer, err = newGlyphDrawer(profile, 2)
+	assertNoErr(t, err)
+	b.generateKerning(drawer)
+	// rounded at the upscaled size instead of scaling the rounded kerning
+	for first, pairs := range original {
+		for _, pair := range pairs {
+			upscaled := b.KRNG.Kern(rune(first), rune(pair.SecondChar))
+			assert.InDelta(t, 2*pair.KerningValue, upscaled, 1, "%q%q", first, pair.SecondChar)
+		}
+	}
+	encoded, err := b.Encode()
+	assertNoErr(t, err)
+	assertNoErr(t, verifyRoundTrip(encoded))
+
+	// FOT-RodinBokutoh only has GPOS kerning
+	bffntRaw, err = ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	b = BFFNT{}
+	assertNoErr(t, b.Decode(bffntRaw))
+	profile, err = LoadFontProfile("../profiles/botw/Caption.json")
+	assertNoErr(t, err)
+	drawer, err = newGlyphDrawer(profile, 1)
+	assertNoErr(t, err)
+	b.generateKerning(drawer)
+	assert.Less(t, b.KRNG.Kern('A', 'V'), int16(0))
+	for first, pairs := range b.KRNG.KerningTable {
+		for _, pair := range pairs {
+			assert.NotZero(t, pair.KerningValue)
+			assert.Contains(t, b.CWDHIndexMap, rune(pair.SecondChar), "kerning of %q", first)
+		}
+	}
+}
+
+// Glyphs are placed by their widths and kerning, and missing characters use
+// the alter char.
+func TestLayout(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+	var b BFFNT
+	assertNoErr(t, b.Decode(bffntRaw))
+	widths := func(r rune) glyphInfo {
+		return b.widthsOrDefault(uint16(b.CWDHIndexMap[r]))
+	}
+	lineFeed := int(b.FINF.LineFeed)
+
+	layout := b.Layout("AV\nꙬV")
+	assertFail(t, 4, len(layout.Glyphs), "glyph count")
+	assert.Equal(t, 2, layout.Lines)
+	assert.Equal(t, []rune{'Ꙭ'}, layout.Missing)
+	a, v := layout.Glyphs[0], layout.Glyphs[1]
+	assert.Equal(t, uint16(b.CWDHIndexMap['V']), v.Index)
+	assert.Equal(t, int(widths('A').CharWidth)+int(b.KRNG.Kern('A', 'V'))+int(widths('V').LeftWidth), v.X-a.X+int(widths('A').LeftWidth))
+	assert.Equal(t, a.Y, v.Y)
+	missing := layout.Glyphs[2]
+	assert.True(t, missing.Missing)
+	assert.Equal(t, b.FINF.AlterCharIndex, missing.Index)
+	assert.Equal(t, 1, missing.Line)
+	assert.Equal(t, lineFeed, missing.Y-a.Y)
+	assert.Equal(t, lineFeed+int(b.FINF.Height), layout.Height)
+
+	// an upscaled font still falls back to the same alter char
+	var upscaled BFFNT
+	assertNoErr(t, upscaled.Decode(bffntRaw))
+	question := uint16(upscaled.CWDHIndexMap['?'])
+	upscaled.FINF.AlterCharIndex = question
+	upscaled.Upscale(2)
+	assert.Equal(t, question, upscaled.FINF.AlterCharIndex)
+	upscaledMissing := upscaled.Layout("AꙬ").Glyphs[1]
+	assert.True(t, upscaledMissing.Missing)
+	assert.Equal(t, question, upscaledMissing.Index)
+	assert.Equal(t, int(upscaled.widthsOrDefault(question).GlyphWidth), upscaledMissing.Width)
+
+	// the word that does not fit goes to the next line
+	text := "Hyrule Castle Town"
+	unwrapped := b.Layout(text)
+	assert.Equal(t, 1, unwrapped.Lines)
+	castleEnd := unwrapped.Glyphs[len("Hyrule Castle")-1]
+	wrapped := b.LayoutWrapped(text, castleEnd.X+castleEnd.Width+2)
+	assert.Equal(t, 2, wrapped.Lines)
+	assert.Equal(t, len(unwrapped.Glyphs), len(wrapped.Glyphs))
+	town := wrapped.Glyphs[len("Hyrule Castle ")]
+	assert.Equal(t, 'T', town.Rune)
+	assert.Equal(t, 1, town.Line)
+	assert.Equal(t, wrapped.Glyphs[0].X-int(widths('H').LeftWidth)+int(widths('T').LeftWidth), town.X)
+	assert.Less(t, wrapped.Width, unwrapped.Width)
+	// words longer than a line are broken anywhere
+	assert.Equal(t, len("Hyrule"), b.LayoutWrapped("Hyrule", 1).Lines)
+
+	img := b.DrawLayout(layout, color.Black)
+	assert.Equal(t, image.Rect(0, 0, layout.Width, layout.Height), img.Bounds())
+	sheet, row, column := b.TGLP.cellPosition(int(a.Index))
+	cell := b.TGLP.cellRect(row, column)
+	// white glyphs over black, up to where V is drawn over A
+	for y := 0; y < cell.Dy(); y++ {
+		for x := 0; x < minInt(a.Width, v.X-a.X); x++ {
+			expected := b.TGLP.SheetData[sheet].NRGBAAt(cell.Min.X+x, cell.Min.Y+y)
+			actual := img.NRGBAAt(a.X+x, a.Y+y)
+			if !assert.InDelta(t, expected.A, actual.R, 1, "A at %d,%d", x, y) {
+				t.FailNow()
+			}
+		}
+	}
+}
+
+// A font compared with itself has no drift, moved glyphs are found.
+func TestCompareFonts(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+	var original, changed BFFNT
+	assertNoErr(t, original.Decode(bffntRaw))
+	assertNoErr(t, changed.Decode(bffntRaw))
+
+	comparison, img := compareFonts(&original, &changed, defaultCompareText, 1, imaging.NearestNeighbor)
+	assert.Zero(t, comparison.MeanPixelDiff)
+	assert.Zero(t, comparison.TextSizeError)
+	assert.Len(t, comparison.Glyphs, len(uniqueRunes([]rune(strings.ReplaceAll(defaultCompareText, "\n", "")))))
+	for _, g := range comparison.Glyphs {
+		assert.Zero(t, g.boxDrift(), string(rune(g.Code)))
+		assert.Zero(t, g.AdvanceError, string(rune(g.Code)))
+	}
+	layout := original.Layout(defaultCompareText)
+	assert.Equal(t, image.Rect(0, 0, layout.Width, 3*layout.Height), img.Bounds())
+
+	changed.glyphWidths(uint16(changed.CWDHIndexMap['A'])).LeftWidth += 2
+	changed.glyphWidths(uint16(changed.CWDHIndexMap['B'])).CharWidth++
+	comparison, _ = compareFonts(&original, &changed, "ABC", 1, imaging.NearestNeighbor)
+	assert.NotZero(t, comparison.MeanPixelDiff)
+	assert.Equal(t, uint16('A'), comparison.Glyphs[0].Code, "the biggest drift comes first")
+	assert.Equal(t, glyphDrift{Code: 'A', HasInk: true, Left: 2, Right: 2, LeftWidthError: 2}, comparison.Glyphs[0])
+	assert.Equal(t, 1.0, comparison.Glyphs[1].AdvanceError)
+	var report bytes.Buffer
+	printCompareReport(&report, comparison, 1)
+	assert.Contains(t, report.String(), "3 glyphs")
+	assert.Contains(t, report.String(), "'A'")
+	assert.NotContains(t, report.String(), "'B'")
+	assert.NotContains(t, report.String(), "missing")
+
+	// characters a font does not have are reported
+	delete(changed.CWDHIndexMap, 'C')
+	comparison, _ = compareFonts(&original, &changed, "ABCꙬ", 1, imaging.NearestNeighbor)
+	assert.Equal(t, []rune{'Ꙭ'}, comparison.OriginalMissing)
+	assert.Equal(t, []rune{'C', 'Ꙭ'}, comparison.UpscaledMissing)
+	assert.Len(t, comparison.Glyphs, 2, "only glyphs both fonts have are compared")
+	report.Reset()
+	printCompareReport(&report, comparison, 1)
+	assert.Contains(t, report.String(), `missing in original font, drawn with its alter char: "Ꙭ"`)
+	assert.Contains(t, report.String(), `missing in upscaled font, drawn with its alter char: "CꙬ"`)
+
+	// an upscaled font is close to the original scaled up
+	profile, err := LoadFontProfile("../profiles/botw/NormalS.json")
+	assertNoErr(t, err)
+	upscaledRaw, _, _, err := upscaleBffnt(bffntRaw, profile, 2, false, false, QualityMedium)
+	assertNoErr(t, err)
+	var upscaled BFFNT
+	assertNoErr(t, upscaled.Decode(upscaledRaw))
+	comparison, _ = compareFonts(&original, &upscaled, defaultCompareText, 2, imaging.Lanczos)
+	assert.InDelta(t, 0, comparison.BaselineOffset, 2)
+	for _, g := range comparison.Glyphs {
+		assert.LessOrEqual(t, g.boxDrift(), 8.0, string(rune(g.Code)))
+	}
+
+	// a missing character is drawn with the same alter char in both fonts, so
+	// it adds no more to the difference than that glyph does
+	var alterChar BFFNT
+	assertNoErr(t, alterChar.Decode(bffntRaw))
+	alterChar.FINF.AlterCharIndex = uint16(alterChar.CWDHIndexMap['?'])
+	alterCharRaw, err := alterChar.Encode()
+	assertNoErr(t, err)
+	upscaledRaw, _, _, err = upscaleBffnt(alterCharRaw, profile, 2, false, false, QualityMedium)
+	assertNoErr(t, err)
+	assertNoErr(t, upscaled.Decode(upscaledRaw))
+	withAlterChar, _ := compareFonts(&alterChar, &upscaled, defaultCompareText+"?", 2, imaging.Lanczos)
+	withMissing, _ := compareFonts(&alterChar, &upscaled, defaultCompareText+"Ꙭ", 2, imaging.Lanczos)
+	assert.Equal(t, []rune{'Ꙭ'}, withMissing.OriginalMissing)
+	assert.Equal(t, []rune{'Ꙭ'}, withMissing.UpscaledMissing)
+	assert.Equal(t, withAlterChar.TextSizeError, withMissing.TextSizeError)
+	assert.Equal(t, withAlterChar.MeanPixelDiff, withMissing.MeanPixelDiff)
+	assert.Equal(t, withAlterChar.MeanPixelDiff, withMissing.MeanPixelDiff)
+}
+
+// A subset keeps the glyphs of the text as they were, on a smaller sheet.
+func TestSubsetFont(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+	font, err := DecodeFont(bffntRaw)
+	assertNoErr(t, err)
+	original, err := DecodeFont(bffntRaw)
+	assertNoErr(t, err)
+	alterChar := original.Runes()[original.BFFNT.FINF.AlterCharIndex]
+
+	removed, missing, err := subsetFont(font, []rune("AVAVA vs. Zelda\nꙬ"))
+	assertNoErr(t, err)
+	assert.Equal(t, []rune{'Ꙭ'}, missing)
+	assert.Equal(t, len(original.Runes()), len(removed)+len(font.Runes()))
+	assertNoErr(t, font.PackSheets())
+	assert.Less(t, font.BFFNT.TGLP.SheetHeight, original.BFFNT.TGLP.SheetHeight)
+
+	encoded, err := font.Encode()
+	assertNoErr(t, err)
+	assert.Less(t, len(encoded), len(bffntRaw)/4)
+	assertNoErr(t, verifyRoundTrip(encoded))
+	subset, err := DecodeFont(encoded)
+	assertNoErr(t, err)
+
+	kept := []rune{' ', '.', 'A', 'V', 'Z', 'a', 'd', 'e', 'l', 's', 'v'}
+	if !strings.ContainsRune(string(kept), alterChar) {
+		kept = append(kept, alterChar)
+	}
+	assert.ElementsMatch(t, kept, subset.Runes())
+	for _, r := range kept {
+		expected, _ := original.Glyph(r)
+		actual, _ := subset.Glyph(r)
+		assert.Equal(t, expected.Image, actual.Image, string(r))
+		assert.Equal(t, expected.CharWidth, actual.CharWidth, string(r))
+		for second := range actual.Kerning {
+			assert.Contains(t, kept, second, "kerning of %q", r)
+		}
+	}
+	a, _ := subset.Glyph('A')
+	assert.Equal(t, original.BFFNT.KRNG.Kern('A', 'V'), a.Kerning['V'])
+
+	// fonts that do not fit on one sheet keep their sheet size
+	font, err = DecodeFont(bffntRaw)
+	assertNoErr(t, err)
+	for i := 0; font.BFFNT.TGLP.NumOfRows*font.BFFNT.TGLP.NumOfColumns >= uint16(len(font.Runes())); i++ {
+		glyph, _ := font.Glyph('A')
+		glyph.Rune = rune(0xE000 + i)
+		assertNoErr(t, font.AddGlyph(glyph))
+	}
+	assertNoErr(t, font.PackSheets())
+	assert.Equal(t, original.BFFNT.TGLP.SheetHeight, font.BFFNT.TGLP.SheetHeight)
+}
+
+// Planned CMAPs have to map the same characters and be no bigger than the
+// ones Nintendo made, and as small as trying every split on small sets.
+func TestPlanCMAPs(t *testing.T) {
+	for _, fontName := range []string{"Ancient", "Caption", "External", "Normal", "NormalS", "Special"} {
+		bffntRaw, err := ioutil.ReadFile(fmt.Sprintf("../WiiU_fonts/botw/%s/%s_00.bffnt", fontName, fontName))
+		handleErr(err)
+		var b BFFNT
+		assertNoErr(t, b.Decode(bffntRaw))
+		pairs := b.GlyphIndexes()
+
+		cmaps, err := PlanCMAPs(pairs)
+		assertNoErr(t, err)
+		encoded, err := EncodeCMAPs(cmaps, 8)
+		assertNoErr(t, err)
+		assert.Equal(t, totalCmapSectionSize(cmaps), len(encoded), fontName+" planned section sizes")
+		assert.LessOrEqual(t, len(encoded), totalCmapSectionSize(b.CMAPs), fontName)
+
+		decoded, err := DecodeCMAPs(encoded, 8)
+		assertNoErr(t, err)
+		b.CMAPs = decoded
+		assert.Equal(t, pairs, b.GlyphIndexes(), fontName)
+	}
+
+	random := rand.New(rand.NewSource(1))
+	for n := 0; n < 500; n++ {
+		var pairs []AsciiIndexPair
+		code, index := random.Intn(4), random.Intn(4)
+		for i := random.Intn(9); i > 0; i-- {
+			pairs = append(pairs, AsciiIndexPair{CharAscii: uint16(code), CharIndex: uint16(index)})
+			code += 1 + random.Intn(3)*random.Intn(8)
+			index += 1 + random.Intn(2)*random.Intn(5)
+		}
+
+		cmaps, err := PlanCMAPs(pairs)
+		assertNoErr(t, err)
+		assertFail(t, smallestCMAPs(pairs), totalCmapSectionSize(cmaps), fmt.Sprint(pairs))
+	}
+
+	_, err := PlanCMAPs([]AsciiIndexPair{{'A', 0}, {'A', 1}})
+	assert.True(t, errors.Is(err, ErrDuplicateCode))
+	_, err = PlanCMAPs([]AsciiIndexPair{{'A', math.MaxUint16}})
+	assert.True(t, errors.Is(err, ErrValueOutOfRange))
+}
+
+// Size of the smallest CMAPs for pairs sorted by code, by trying every way
+// of splitting them
+func smallestCMAPs(pairs []AsciiIndexPair) int {
+	var try func(i int, scanCount int) int
+	try = func(i int, scanCount int) int {
+		if i == len(pairs) {
+			if scanCount == 0 {
+				return 0
+			}
+			return CMAP_HEADER_SIZE + 4 + 4*scanCount
+		}
+
+		best := try(i+1, scanCount+1)
+		direct := true
+		for j := i; j < len(pairs); j++ {
+			if j > i && (pairs[j].CharAscii != pairs[j-1].CharAscii+1 || pairs[j].CharIndex != pairs[j-1].CharIndex+1) {
+				direct = false
+			}
+			tableSize := 2 * int(pairs[j].CharAscii-pairs[i].CharAscii+1)
+			size := CMAP_HEADER_SIZE + tableSize + paddingToNext4ByteBoundary(tableSize)
+			if direct {
+				size = CMAP_HEADER_SIZE + 4
+			}
+			if rest := size + try(j+1, scanCount); rest < best {
+				best = rest
+			}
+		}
+		return best
+	}
+
+	return try(0, 0)
+}
+
+// Malformed input has to come back as a SectionError instead of a panic.
+func TestDecodeMalformed(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+
+	var bffnt BFFNT
+	var sectionErr *SectionError
+
+	// cut the file off in the middle of every section. The FFNT file size is
+	// patched when possible so the later sections are reached.
+	for _, size := range []int{0, 10, 40, 70, 8192, 270000, len(bffntRaw) - 100, len(bffntRaw) - 2} {
+		truncated := make([]byte, size)
+		copy(truncated, bffntRaw)
+		err = bffnt.Decode(truncated)
+		assertFail(t, true, errors.As(err, &sectionErr), fmt.Sprintf("truncated at %d bytes should return a SectionError, got %v", size, err))
+
+		if size >= FFNT_HEADER_SIZE {
+			binary.BigEndian.PutUint32(truncated[12:], uint32(size))
+			err = bffnt.Decode(truncated)
+			assertFail(t, true, errors.Is(err, ErrTruncated), fmt.Sprintf("truncated at %d bytes should return ErrTruncated, got %v", size, err))
+		}
+	}
+
+	corrupt := make([]byte, len(bffntRaw))
+
+	copy(corrupt, bffntRaw)
+	copy(corrupt[FFNT_HEADER_SIZE:], "XXXX")
+	err = bffnt.Decode(corrupt)
+	assertFail(t, true, errors.Is(err, ErrMagicHeader), "bad FINF magic")
+	assertFail(t, true, errors.As(err, &sectionErr), "bad FINF magic")
+	assertFail(t, FINF_MAGIC_HEADER, sectionErr.Section, "bad FINF magic should be reported for FINF")
+	assertFail(t, FFNT_HEADER_SIZE, sectionErr.Offset, "bad FINF magic should be reported at the FINF offset")
+
+	// point the first CMAP at itself
+	copy(corrupt, bffntRaw)
+	var finf FINF
+	assertNoErr(t, finf.Decode(bffntRaw))
+	binary.BigEndian.PutUint32(corrupt[finf.CMAPOffset-8+16:], finf.CMAPOffset)
+	err = bffnt.Decode(corrupt)
+	assertFail(t, true, errors.Is(err, ErrSectionOffsetNotAhead), "looping CMAP list")
+
+	// bad mapping method
+	copy(corrupt, bffntRaw)
+	binary.BigEndian.PutUint16(corrupt[finf.CMAPOffset-8+12:], 7)
+	err = bffnt.Decode(corrupt)
+	assertFail(t, true, errors.Is(err, ErrUnknownMappingMethod), "unknown mapping method")
+
+	// sheet section size that doesn't add up
+	copy(corrupt, bffntRaw)
+	binary.BigEndian.PutUint32(corrupt[FFNT_HEADER_SIZE+FINF_HEADER_SIZE+4:], 1234)
+	err = bffnt.Decode(corrupt)
+	assertFail(t, true, errors.As(err, &sectionErr), "bad TGLP section size")
+	assertFail(t, 1234, sectionErr.Expected, "TGLP section size expected value")
+
+	// no sheet columns or rows, the glyphs can not be placed
+	tglpStart := FFNT_HEADER_SIZE + FINF_HEADER_SIZE
+	for _, offset := range []int{20, 22} {
+		copy(corrupt, bffntRaw)
+		binary.BigEndian.PutUint16(corrupt[tglpStart+offset:], 0)
+		err = bffnt.Decode(corrupt)
+		assertFail(t, true, errors.Is(err, ErrValueOutOfRange), fmt.Sprintf("zero at TGLP+%d should be out of range, got %v", offset, err))
+		assertFail(t, true, errors.As(err, &sectionErr), "zero columns or rows")
+		assertFail(t, tglpStart+offset, sectionErr.Offset, "zero columns or rows should be reported at the field")
+	}
+}
+
 func TestMain(m *testing.M) {
 	code := m.Run()
 	os.Exit(code)
@@ -370,3 +1148,1268 @@ func assertFail(t *testing.T, expected interface{}, actual interface{}, errMsg s
 		t.FailNow()
 	}
 }
+
+func assertNoErr(t *testing.T, err error) {
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+}
+
+// Every sheet has to be decoded and the first one has to match the sheet
+// exported with switch toolbox next to each font. A8 sheets were exported as
+// gray values, BC4 sheets as white pixels with the value in the alpha channel.
+func TestDecodeSheets(t *testing.T) {
+	testCases := []struct {
+		filename string
+		channel  int // channel of Sheet_0.png holding the sheet values
+	}{
+		{"../WiiU_fonts/botw/Ancient/Ancient_00.bffnt", 3},
+		{"../WiiU_fonts/botw/Special/Special_00.bffnt", 3},
+		{"../WiiU_fonts/botw/Caption/Caption_00.bffnt", 3},
+		{"../WiiU_fonts/botw/Normal/Normal_00.bffnt", 3},
+		{"../WiiU_fonts/botw/NormalS/NormalS_00.bffnt", 0},
+		{"../WiiU_fonts/botw/External/External_00.bffnt", 3},
+
+		{"../WiiU_fonts/comicfont/Normal_00.bffnt", 3},
+		{"../WiiU_fonts/kirbysans/Normal_00.bffnt", 3},
+		{"../WiiU_fonts/kirbyscript/Normal_00.bffnt", 3},
+		{"../WiiU_fonts/popjoy_font/Normal_00.bffnt", 3},
+		{"../WiiU_fonts/turbofont/Normal_00.bffnt", 3},
+	}
+
+	for _, tc := range testCases {
+		bffntRaw, err := ioutil.ReadFile(tc.filename)
+		handleErr(err)
+
+		var bffnt BFFNT
+		assertNoErr(t, bffnt.Decode(bffntRaw))
+		tglp := bffnt.TGLP
+		assertFail(t, int(tglp.NumOfSheets), len(tglp.SheetData), tc.filename+" should decode every sheet")
+		for i, sheet := range tglp.SheetData {
+			assertFail(t, image.Rect(0, 0, int(tglp.SheetWidth), int(tglp.SheetHeight)), sheet.Rect, fmt.Sprintf("%s sheet %d has the wrong size", tc.filename, i))
+		}
+
+		referenceFile, err := os.Open(filepath.Join(filepath.Dir(tc.filename), "Sheet_0.png"))
+		handleErr(err)
+		reference, err := png.Decode(referenceFile)
+		handleErr(err)
+		referenceFile.Close()
+
+		sheet := tglp.SheetData[0]
+		referenceSheet := image.NewNRGBA(reference.Bounds())
+		draw.Draw(referenceSheet, referenceSheet.Rect, reference, reference.Bounds().Min, draw.Src)
+		assertFail(t, referenceSheet.Rect, sheet.Rect, tc.filename+" sheet 0 size does not match Sheet_0.png")
+
+		mismatches := 0
+		for i := 0; i < len(sheet.Pix); i += 4 {
+			if sheet.Pix[i+3] != referenceSheet.Pix[i+tc.channel] {
+				mismatches++
+			}
+		}
+		assertFail(t, 0, mismatches, tc.filename+" sheet 0 pixels do not match Sheet_0.png")
+	}
+}
+
+// Every sheet format has to produce the amount of bytes the sheet size is
+// computed from, and encoding a decoded sheet again must give the same bytes.
+func TestSheetFormats(t *testing.T) {
+	width, height := 24, 20
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 37)
+	}
+
+	platforms := map[string]map[uint16]sheetFormat{
+		"Wii U": cafeSheetFormats,
+		"3DS":   ctrSheetFormats,
+	}
+	for platform, formats := range platforms {
+		for number, format := range formats {
+			name := fmt.Sprintf("%s format %d (%s)", platform, number, format.Name)
+
+			encoded := format.Encode(img, QualityMedium)
+			assertFail(t, format.dataSize(width, height), len(encoded), name+" encoded to the wrong amount of bytes")
+
+			decoded := format.Decode(encoded, width, height)
+			assertFail(t, img.Rect, decoded.Rect, name+" decoded to the wrong size")
+			if format.BlockSize == 1 {
+				assertFail(t, encoded, format.Encode(decoded, QualityMedium), name+" changed after decoding and encoding again")
+			}
+		}
+	}
+
+	// Coverage masks keep their values in the alpha channel
+	assertFail(t, img.Pix[3], ctrSheetFormats[8].Decode(ctrSheetFormats[8].Encode(img, QualityMedium), width, height).Pix[3], "A8 should keep alpha")
+	assertFail(t, uint8(0xEE), ctrSheetFormats[11].Decode([]byte{0xFE}, 2, 1).Pix[3], "A4 should store the first pixel in the low nibble")
+}
+
+// Every Wii U format goes through the GX2 sheet pipeline. The channels of
+// the GX2 formats start at the lowest bits.
+func TestCafeSheetFormats(t *testing.T) {
+	for number := uint16(0); number < 14; number++ {
+		_, ok := cafeSheetFormats[number]
+		assertFail(t, true, ok, fmt.Sprintf("Wii U format %d should be supported", number))
+	}
+
+	pixel := func(format uint16, p ...byte) color.NRGBA {
+		return nrgbaAt(cafeSheetFormats[format].Decode(p, 1, 1), 0, 0)
+	}
+	assertFail(t, color.NRGBA{10, 20, 30, 255}, pixel(1, 10, 20, 30, 0), "RGB8 should be opaque")
+	assertFail(t, color.NRGBA{0, 0, 0, 255}, pixel(2, 0x00, 0x80), "RGB5A1 should have alpha in the top bit")
+	assertFail(t, color.NRGBA{255, 0, 0, 255}, pixel(3, 0x1F, 0x00), "RGB565 should have red in the low bits")
+	assertFail(t, color.NRGBA{255, 0, 0, 0}, pixel(4, 0x0F, 0x00), "RGBA4 should have red in the low nibble")
+	assertFail(t, color.NRGBA{0, 0, 0, 255}, pixel(6, 0xF0), "LA4 should have alpha in the high nibble")
+	assertFail(t, color.NRGBA{255, 255, 255, 255}, pixel(7, 0x0F), "A4 should have alpha in the low nibble")
+
+	// BC1 with red and blue endpoints, pixels 0 to 3 use palette index 0 to 3
+	block := []byte{0x00, 0xF8, 0x1F, 0x00, 0xE4, 0, 0, 0}
+	decoded := decodeBC1(block, 4, 4)
+	assertFail(t, color.NRGBA{255, 0, 0, 255}, nrgbaAt(decoded, 0, 0), "BC1 first endpoint")
+	assertFail(t, color.NRGBA{0, 0, 255, 255}, nrgbaAt(decoded, 1, 0), "BC1 second endpoint")
+	assertFail(t, color.NRGBA{170, 0, 85, 255}, nrgbaAt(decoded, 2, 0), "BC1 2/3 of the first endpoint")
+	assertFail(t, color.NRGBA{85, 0, 170, 255}, nrgbaAt(decoded, 3, 0), "BC1 1/3 of the first endpoint")
+	block[0], block[1], block[2], block[3] = block[2], block[3], block[0], block[1]
+	assertFail(t, color.NRGBA{}, nrgbaAt(decodeBC1(block, 4, 4), 3, 0), "BC1 with the smaller endpoint first has a transparent entry")
+	assertFail(t, color.NRGBA{170, 0, 85, 0}, nrgbaAt(decodeBC3(append(make([]byte, 8), block...), 4, 4), 3, 0), "BC3 always has 4 colors")
+
+	// A sheet with a color gradient and a real glyph coverage as alpha
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	assertNoErr(t, bffnt.Decode(bffntRaw))
+	sheet := bffnt.TGLP.SheetData[0]
+	img := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			v := sheet.Pix[y*sheet.Stride+4*x+3]
+			copy(img.Pix[y*img.Stride+4*x:], []uint8{v, 255 - v, uint8(2 * x), v})
+		}
+	}
+
+	for number, format := range cafeSheetFormats {
+		name := fmt.Sprintf("Wii U format %d (%s)", number, format.Name)
+		tglp := TGLP{
+			SheetImageFormat: number,
+			NumOfSheets:      1,
+			SheetWidth:       128,
+			SheetHeight:      128,
+			SheetData:        []image.NRGBA{*img},
+		}
+		surface, _, err := tglp.sheetSurface(0)
+		assertNoErr(t, err)
+		tglp.SheetSize = uint32(surface.Size())
+		tglp.AllSheetData, err = tglp.EncodeSheetData()
+		assertNoErr(t, err)
+		assertNoErr(t, tglp.DecodeSheets())
+
+		// the sheet decodes to what the format makes of the upside down image
+		expected := imaging.FlipV(format.Decode(format.Encode(imaging.FlipV(img), QualityMedium), 128, 128))
+		assertFail(t, expected.Pix, tglp.SheetData[0].Pix, name+" sheet did not survive the GX2 surface")
+
+		if format.BlockSize == 4 && number != 12 && number != 13 {
+			colorError := 0
+			for i := range img.Pix {
+				if i%4 < 3 && img.Pix[i-i%4+3] == 255 {
+					diff := int(img.Pix[i]) - int(expected.Pix[i])
+					colorError += diff * diff
+				}
+			}
+			assertFail(t, true, colorError < 300*128*128, fmt.Sprintf("%s has a squared color error of %d", name, colorError))
+		}
+	}
+}
+
+func TestETC1(t *testing.T) {
+	// Individual mode block with base colors of 8/15*255 for both subblocks
+	// and modifier table 0. Pixel 0 uses -large, every other pixel +small.
+	block := make([]byte, 8)
+	binary.LittleEndian.PutUint64(block, uint64(0x88888800)<<32|0x00010001)
+	decoded := decodeETC1(block, 4, 4)
+	assertFail(t, uint8(136-8), decoded.Pix[0], "pixel 0 should use the -large modifier")
+	assertFail(t, uint8(136+2), decoded.Pix[4], "pixel 1 should use the +small modifier")
+	assertFail(t, uint8(255), decoded.Pix[3], "ETC1 has no alpha")
+
+	// Part of a real sheet as a gray image with an alpha gradient
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	assertNoErr(t, bffnt.Decode(bffntRaw))
+	sheet := bffnt.TGLP.SheetData[0]
+	img := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			v := sheet.Pix[y*sheet.Stride+4*x+3]
+			copy(img.Pix[y*img.Stride+4*x:], []uint8{v, v, v, uint8(2 * x)})
+		}
+	}
+
+	squaredError := func(a *image.NRGBA, b *image.NRGBA, channels int) int {
+		total := 0
+		for i := range a.Pix {
+			if i%4 < channels {
+				diff := int(a.Pix[i]) - int(b.Pix[i])
+				total += diff * diff
+			}
+		}
+		return total
+	}
+
+	lastError := -1
+	for _, quality := range []EncodeQuality{QualityLow, QualityMedium, QualityHigh} {
+		encoded := encodeETC1A4(img, quality)
+		assertFail(t, ctrSheetFormats[13].dataSize(128, 128), len(encoded), "ETC1A4 encoded to the wrong amount of bytes")
+		decoded := decodeETC1A4(encoded, 128, 128)
+
+		colorError := squaredError(img, decoded, 3)
+		if lastError >= 0 {
+			assertFail(t, true, colorError <= lastError, fmt.Sprintf("quality %d should not be worse than the one below it", quality))
+		}
+		lastError = colorError
+
+		meanError := float64(colorError) / float64(128*128*3)
+		assertFail(t, true, meanError < 64, fmt.Sprintf("quality %d has a mean squared error of %.1f", quality, meanError))
+		for i := 3; i < len(img.Pix); i += 4 {
+			if decoded.Pix[i] != expand(quantize(img.Pix[i], 4), 4) {
+				t.Fatalf("ETC1A4 should keep 4 bits of alpha, got %d for %d", decoded.Pix[i], img.Pix[i])
+			}
+		}
+	}
+
+	// ETC1A4 sheets go through the 3DS sheet pipeline
+	tglp := TGLP{
+		Platform:         Platform3DS,
+		SheetImageFormat: 13,
+		NumOfSheets:      1,
+		SheetWidth:       128,
+		SheetHeight:      128,
+		SheetSize:        uint32(ctrSheetFormats[13].dataSize(128, 128)),
+		SheetData:        []image.NRGBA{*img},
+	}
+	tglp.AllSheetData, err = tglp.EncodeSheetData()
+	assertNoErr(t, err)
+	assertNoErr(t, tglp.DecodeSheets())
+	assertFail(t, true, squaredError(img, &tglp.SheetData[0], 4) < 64*128*128*4, "ETC1A4 sheet did not survive encoding")
+
+	// the qualities are ordered and the zero value is the default
+	assertFail(t, true, QualityLow < QualityMedium && QualityMedium < QualityHigh, "qualities should be ordered")
+	assertFail(t, QualityMedium, EncodeQuality(0), "the zero value should be QualityMedium")
+
+	// a 3DS font with ETC1A4 sheets survives being written, read and
+	// exported as png
+	var ctr BFFNT
+	assertNoErr(t, ctr.Decode(bffntRaw))
+	original := imaging.Clone(&ctr.TGLP.SheetData[0])
+	ctr.FFNT.MagicHeader = CFNT_MAGIC_HEADER
+	ctr.FFNT.Version = 0x03000000
+	ctr.FFNT.byteOrder = binary.LittleEndian
+	assertNoErr(t, ctr.setFileFormat())
+	ctr.TGLP.SheetImageFormat = 13
+	ctr.TGLP.SheetQuality = QualityHigh
+	surface, _, err := ctr.TGLP.sheetSurface(0)
+	assertNoErr(t, err)
+	ctr.TGLP.SheetSize = uint32(surface.Size())
+	ctr.TGLP.SectionSize = TGLP_HEADER_SIZE + uint32(ctr.TGLP.computePredataPadding()) + ctr.TGLP.SheetSize*uint32(ctr.TGLP.NumOfSheets)
+	ctrRaw, err := ctr.Encode()
+	assertNoErr(t, err)
+
+	var decodedFont BFFNT
+	assertNoErr(t, decodedFont.Decode(ctrRaw))
+	var pngFile bytes.Buffer
+	assertNoErr(t, png.Encode(&pngFile, &decodedFont.TGLP.SheetData[0]))
+	exported, err := png.Decode(&pngFile)
+	assertNoErr(t, err)
+	exportedSheet := imaging.Clone(exported)
+	for i := 3; i < len(original.Pix); i += 4 {
+		if exportedSheet.Pix[i] != expand(quantize(original.Pix[i], 4), 4) {
+			t.Fatalf("ETC1A4 font sheet pixel %d has alpha %d, expected 4 bits of %d", i/4, exportedSheet.Pix[i], original.Pix[i])
+		}
+	}
+}
+
+// There are no 3DS fonts in the repo, so a Wii U font is turned into a
+// little endian CFNT. A8 is lossless and numbered the same on both.
+func TestDecode3DS(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+
+	var original BFFNT
+	assertNoErr(t, original.Decode(bffntRaw))
+	var ctr BFFNT
+	assertNoErr(t, ctr.Decode(bffntRaw))
+
+	ctr.FFNT.MagicHeader = CFNT_MAGIC_HEADER
+	ctr.FFNT.byteOrder = binary.LittleEndian
+	assertNoErr(t, ctr.setFileFormat())
+	surface, _, err := ctr.TGLP.sheetSurface(0)
+	assertNoErr(t, err)
+	ctr.TGLP.SheetSize = uint32(surface.Size())
+	ctr.TGLP.SectionSize = TGLP_HEADER_SIZE + uint32(ctr.TGLP.computePredataPadding()) + ctr.TGLP.SheetSize*uint32(ctr.TGLP.NumOfSheets)
+
+	ctrRaw, err := ctr.Encode()
+	assertNoErr(t, err)
+
+	// little endian byte order mark, 1 byte line feed and baseline
+	assertFail(t, "CFNT", string(ctrRaw[0:4]), "magic header")
+	assertFail(t, []byte{0xFF, 0xFE}, ctrRaw[4:6], "byte order mark")
+	assertFail(t, uint32(len(ctrRaw)), binary.LittleEndian.Uint32(ctrRaw[12:16]), "little endian file size")
+	assertFail(t, uint8(original.FINF.LineFeed), ctrRaw[FFNT_HEADER_SIZE+9], "3DS FINF line feed")
+	assertFail(t, original.FINF.Ascent, ctrRaw[FFNT_HEADER_SIZE+30], "3DS FINF ascent")
+	tglpStart := FFNT_HEADER_SIZE + FINF_HEADER_SIZE
+	assertFail(t, uint8(original.TGLP.BaselinePosition), ctrRaw[tglpStart+10], "3DS TGLP baseline")
+	assertFail(t, uint16(original.TGLP.NumOfSheets), binary.LittleEndian.Uint16(ctrRaw[tglpStart+16:]), "3DS TGLP sheet count")
+
+	var decoded BFFNT
+	assertNoErr(t, decoded.Decode(ctrRaw))
+	assertFail(t, Platform3DS, decoded.TGLP.Platform, "CFNT should decode as a 3DS font")
+	assertFail(t, Platform3DS, decoded.FINF.Platform, "CFNT should decode as a 3DS font")
+	assertFail(t, original.FINF.LineFeed, decoded.FINF.LineFeed, "line feed")
+	assertFail(t, original.FINF.AlterCharIndex, decoded.FINF.AlterCharIndex, "alter char index")
+	assertFail(t, original.TGLP.BaselinePosition, decoded.TGLP.BaselinePosition, "baseline")
+	assertFail(t, original.GlyphIndexes(), decoded.GlyphIndexes(), "CMAPs should survive little endian")
+	assertFail(t, original.CWDHs[0].Glyphs, decoded.CWDHs[0].Glyphs, "CWDHs should survive little endian")
+	assertFail(t, original.KRNG.KerningTable, decoded.KRNG.KerningTable, "kerning should survive little endian")
+	for i := range original.TGLP.SheetData {
+		assertFail(t, true, bytes.Equal(original.TGLP.SheetData[i].Pix, decoded.TGLP.SheetData[i].Pix), fmt.Sprintf("sheet %d should survive the 3DS tiling", i))
+	}
+
+	reencoded, err := decoded.Encode()
+	assertNoErr(t, err)
+	assertFail(t, true, bytes.Equal(ctrRaw, reencoded), "3DS font should encode to the bytes it was decoded from")
+
+	// the 3DS fields are 1 byte
+	decoded.TGLP.BaselinePosition = 300
+	_, err = decoded.Encode()
+	assertFail(t, true, errors.Is(err, ErrValueOutOfRange), "baseline over 255 should not fit a 3DS TGLP")
+}
+
+// A 3DS font written field by field from 3dbrew's description of BCFNT, not
+// with Encode: 2 glyphs, A and B, on a 16x16 A8 sheet. The sheet is stored
+// upside down in 8x8 tiles with the pixels of a tile in Z order, the byte
+// offsets below are worked out by hand from that.
+// https://www.3dbrew.org/wiki/BCFNT
+func TestDecode3DSFixture(t *testing.T) {
+	order := binary.LittleEndian
+	raw := make([]byte, 0x1B0)
+
+	// CFNT header
+	copy(raw[0x00:], "CFNT")
+	copy(raw[0x04:], []byte{0xFF, 0xFE})
+	order.PutUint16(raw[0x06:], 0x14)       // header size
+	order.PutUint32(raw[0x08:], 0x03000000) // version
+	order.PutUint32(raw[0x0C:], 0x1B0)      // file size
+	order.PutUint32(raw[0x10:], 4)          // blocks
+
+	finf := raw[0x14:]
+	copy(finf[0x00:], "FINF")
+	order.PutUint32(finf[0x04:], 0x20)
+	finf[0x08] = 1                        // font type
+	finf[0x09] = 12                       // line feed
+	order.PutUint16(finf[0x0A:], 1)       // alternate character index
+	copy(finf[0x0C:], []byte{0, 6, 7})    // default left, glyph and char width
+	finf[0x0F] = 1                        // encoding, UTF-16
+	order.PutUint32(finf[0x10:], 0x34+8)  // TGLP data
+	order.PutUint32(finf[0x14:], 0x180+8) // CWDH data
+	order.PutUint32(finf[0x18:], 0x198+8) // CMAP data
+	copy(finf[0x1C:], []byte{10, 8, 9})   // height, width and ascent
+
+	tglp := raw[0x34:]
+	copy(tglp[0x00:], "TGLP")
+	order.PutUint32(tglp[0x04:], 0x180-0x34)
+	copy(tglp[0x08:], []byte{7, 7, 6, 7}) // cell width and height, baseline, max char width
+	order.PutUint32(tglp[0x0C:], 0x100)   // sheet size
+	order.PutUint16(tglp[0x10:], 1)       // sheets
+	order.PutUint16(tglp[0x12:], 8)       // A8
+	order.PutUint16(tglp[0x14:], 2)       // columns
+	order.PutUint16(tglp[0x16:], 2)       // rows
+	order.PutUint16(tglp[0x18:], 16)      // sheet width
+	order.PutUint16(tglp[0x1A:], 16)      // sheet height
+	order.PutUint32(tglp[0x1C:], 0x80)    // sheet data
+
+	// image pixel x, y and the byte it is in: the bottom row is stored
+	// first, then come the tiles to the right and the tile row above
+	sheet := raw[0x80:0x180]
+	pixels := []struct {
+		x, y, offset int
+		alpha        byte
+	}{
+		{0, 15, 0, 0x10},
+		{1, 15, 1, 0x20},
+		{0, 14, 2, 0x30},
+		{3, 12, 15, 0x40},
+		{5, 10, 51, 0x50},
+		{8, 15, 64, 0x60},
+		{0, 7, 128, 0x70},
+		{15, 0, 255, 0x80},
+	}
+	for _, p := range pixels {
+		sheet[p.offset] = p.alpha
+	}
+
+	cwdh := raw[0x180:]
+	copy(cwdh[0x00:], "CWDH")
+	order.PutUint32(cwdh[0x04:], 0x18)
+	order.PutUint16(cwdh[0x08:], 0) // start index
+	order.PutUint16(cwdh[0x0A:], 1) // end index
+	copy(cwdh[0x10:], []byte{0, 6, 7, 1, 5, 7})
+
+	cmap := raw[0x198:]
+	copy(cmap[0x00:], "CMAP")
+	order.PutUint32(cmap[0x04:], 0x18)
+	order.PutUint16(cmap[0x08:], 'A') // code begin
+	order.PutUint16(cmap[0x0A:], 'B') // code end
+	order.PutUint16(cmap[0x0C:], 0)   // direct
+	order.PutUint16(cmap[0x14:], 0)   // index of the first code
+
+	var font BFFNT
+	assertNoErr(t, font.Decode(raw))
+	assertFail(t, Platform3DS, font.TGLP.Platform, "platform")
+	assertFail(t, uint16(12), font.FINF.LineFeed, "line feed")
+	assertFail(t, uint16(1), font.FINF.AlterCharIndex, "alternate character index")
+	assertFail(t, uint8(6), font.FINF.DefaultGlyphWidth, "default glyph width")
+	assertFail(t, uint8(9), font.FINF.Ascent, "ascent")
+	assertFail(t, uint16(6), font.TGLP.BaselinePosition, "baseline")
+	assertFail(t, uint8(1), font.TGLP.NumOfSheets, "sheets")
+	assertFail(t, []AsciiIndexPair{{'A', 0}, {'B', 1}}, font.GlyphIndexes(), "character map")
+	assertFail(t, []glyphInfo{{0, 6, 7}, {1, 5, 7}}, font.CWDHs[0].Glyphs, "widths")
+
+	expected := image.NewAlpha(image.Rect(0, 0, 16, 16))
+	for _, p := range pixels {
+		expected.SetAlpha(p.x, p.y, color.Alpha{p.alpha})
+	}
+	decoded := &font.TGLP.SheetData[0]
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			assertFail(t, expected.AlphaAt(x, y).A, decoded.NRGBAAt(x, y).A, fmt.Sprintf("alpha of pixel %d,%d", x, y))
+		}
+	}
+
+	reencoded, err := font.Encode()
+	assertNoErr(t, err)
+	assertFail(t, raw, reencoded, "font should encode to the bytes written by hand")
+}
+
+// PICA200 textures are at most 1024x1024, an upscaled 3DS font spreads its
+// cells over more sheets instead of growing one sheet.
+func TestUpscale3DS(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+
+	var original BFFNT
+	assertNoErr(t, original.Decode(bffntRaw))
+	var ctr BFFNT
+	assertNoErr(t, ctr.Decode(bffntRaw))
+	ctr.FFNT.MagicHeader = CFNT_MAGIC_HEADER
+	ctr.FFNT.byteOrder = binary.LittleEndian
+	assertNoErr(t, ctr.setFileFormat())
+	surface, _, err := ctr.TGLP.sheetSurface(0)
+	assertNoErr(t, err)
+	ctr.TGLP.SheetSize = uint32(surface.Size())
+	ctr.TGLP.SectionSize = TGLP_HEADER_SIZE + uint32(ctr.TGLP.computePredataPadding()) + ctr.TGLP.SheetSize*uint32(ctr.TGLP.NumOfSheets)
+	ctrRaw, err := ctr.Encode()
+	assertNoErr(t, err)
+
+	profile, err := LoadFontProfile("../profiles/botw/NormalS.json")
+	assertNoErr(t, err)
+	upscaledRaw, sheet, _, err := upscaleBffnt(ctrRaw, profile, 2, false, false, QualityMedium)
+	assertNoErr(t, err)
+	var upscaled BFFNT
+	assertNoErr(t, upscaled.Decode(upscaledRaw))
+
+	// 512x1024 scaled by 2 is 1024x2048, 16 rows of 61 px fit a sheet
+	tglp := upscaled.TGLP
+	assertFail(t, Platform3DS, tglp.Platform, "upscaled font should still be a 3DS font")
+	assertFail(t, uint16(1024), tglp.SheetWidth, "sheet width")
+	assertFail(t, uint16(1024), tglp.SheetHeight, "sheet height")
+	assertFail(t, uint16(16), tglp.NumOfRows, "rows per sheet")
+	assertFail(t, original.TGLP.NumOfColumns, tglp.NumOfColumns, "columns")
+	assertFail(t, uint8(3), tglp.NumOfSheets, "sheets")
+	assertFail(t, int(tglp.NumOfSheets), len(tglp.SheetData), "every sheet should be decoded")
+	assertFail(t, image.Rect(0, 0, 1024, 3*1024), sheet.Rect, "the drawn sheets are returned below each other")
+
+	// the glyphs on the second sheet look like the ones of the same font
+	// upscaled on the Wii U, where they stay on one sheet
+	var text []rune
+	for _, pair := range original.GlyphIndexes() {
+		if int(pair.CharIndex) >= 16*20 && unicode.IsGraphic(rune(pair.CharAscii)) {
+			text = append(text, rune(pair.CharAscii))
+		}
+	}
+	assertFail(t, true, len(text) > 0, "the second sheet should have glyphs")
+	cafeRaw, _, _, err := upscaleBffnt(bffntRaw, profile, 2, false, false, QualityMedium)
+	assertNoErr(t, err)
+	var cafe BFFNT
+	assertNoErr(t, cafe.Decode(cafeRaw))
+	comparison, _ := compareFonts(&cafe, &upscaled, string(text), 1, imaging.NearestNeighbor)
+	assertFail(t, 0.0, comparison.MeanPixelDiff, "3DS glyphs differ from the Wii U ones")
+
+	// a sheet wider than the limit gets fewer columns
+	wide := TGLP{CellWidth: 24, CellHeight: 30, NumOfSheets: 1, NumOfColumns: 50, NumOfRows: 10, Platform: PlatformWii}
+	wide.splitSheets(1024, 1250, 310)
+	assertFail(t, uint16(40), wide.NumOfColumns, "columns of 25 px that fit 1024 px")
+	assertFail(t, uint16(13), wide.NumOfRows, "rows the cells need")
+	assertFail(t, uint16(1024), wide.SheetWidth, "sheet width")
+	assertFail(t, uint16(404), wide.SheetHeight, "13 rows of 31 px")
+	assertFail(t, uint8(1), wide.NumOfSheets, "13 rows fit one sheet")
+
+	// many sheets of a CJK font merged at 4x are taller than 16 bits
+	cjk := TGLP{CellWidth: 24, CellHeight: 24, NumOfSheets: 35, NumOfColumns: 20, NumOfRows: 20, SheetWidth: 512, SheetHeight: 512, Platform: PlatformWii}
+	cjk.Upscale(4)
+	assertFail(t, uint16(1024), cjk.SheetWidth, "sheet width")
+	assertFail(t, uint16(1024), cjk.SheetHeight, "sheet height")
+	assertFail(t, uint16(10), cjk.NumOfColumns, "columns of 97 px that fit 1024 px")
+	assertFail(t, uint16(10), cjk.NumOfRows, "rows of 97 px that fit 1024 px")
+	assertFail(t, uint8(140), cjk.NumOfSheets, "14000 cells on sheets of 100")
+}
+
+func TestUpscale3DSPowerOfTwo(t *testing.T) {
+	// the PICA200 only samples sheets of a power of two
+	small := TGLP{CellWidth: 24, CellHeight: 30, NumOfSheets: 1, NumOfColumns: 10, NumOfRows: 16, SheetWidth: 256, SheetHeight: 512, Platform: Platform3DS}
+	small.Upscale(1.5)
+	assertFail(t, uint16(512), small.SheetWidth, "384 px rounded up")
+	assertFail(t, uint16(1024), small.SheetHeight, "768 px rounded up")
+	assertFail(t, uint16(10), small.NumOfColumns, "columns")
+	assertFail(t, uint16(16), small.NumOfRows, "rows")
+	assertFail(t, uint8(1), small.NumOfSheets, "sheets")
+
+	// the Wii has no such limit
+	wii := TGLP{CellWidth: 24, CellHeight: 30, NumOfSheets: 1, NumOfColumns: 10, NumOfRows: 16, SheetWidth: 256, SheetHeight: 512, Platform: PlatformWii}
+	wii.Upscale(1.5)
+	assertFail(t, uint16(384), wii.SheetWidth, "sheet width")
+	assertFail(t, uint16(768), wii.SheetHeight, "sheet height")
+
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+	var ctr BFFNT
+	assertNoErr(t, ctr.Decode(bffntRaw))
+	ctr.FFNT.MagicHeader = CFNT_MAGIC_HEADER
+	ctr.FFNT.byteOrder = binary.LittleEndian
+	assertNoErr(t, ctr.setFileFormat())
+	surface, _, err := ctr.TGLP.sheetSurface(0)
+	assertNoErr(t, err)
+	ctr.TGLP.SheetSize = uint32(surface.Size())
+	ctr.TGLP.SectionSize = TGLP_HEADER_SIZE + uint32(ctr.TGLP.computePredataPadding()) + ctr.TGLP.SheetSize*uint32(ctr.TGLP.NumOfSheets)
+	ctrRaw, err := ctr.Encode()
+	assertNoErr(t, err)
+
+	profile, err := LoadFontProfile("../profiles/botw/NormalS.json")
+	assertNoErr(t, err)
+	upscaledRaw, _, _, err := upscaleBffnt(ctrRaw, profile, 1.5, false, false, QualityMedium)
+	assertNoErr(t, err)
+	var upscaled BFFNT
+	assertNoErr(t, upscaled.Decode(upscaledRaw))
+
+	// 512x1024 scaled by 1.5 is 768x1536
+	tglp := upscaled.TGLP
+	assertFail(t, uint16(1024), tglp.SheetWidth, "768 px rounded up")
+	assertFail(t, uint16(1024), tglp.SheetHeight, "sheet height")
+	assertFail(t, ctr.TGLP.NumOfColumns, tglp.NumOfColumns, "columns")
+	assertFail(t, uint8(2), tglp.NumOfSheets, "sheets")
+	assertFail(t, int(tglp.NumOfSheets), len(tglp.SheetData), "every sheet should be decoded")
+}
+
+func TestPicaLayout(t *testing.T) {
+	// 8x8 tiles row by row, pixels in Z order inside a tile
+	a8, err := newPicaLayout(16, 16, ctrSheetFormats[8])
+	assertNoErr(t, err)
+	for _, tc := range []struct{ x, y, index uint }{
+		{0, 0, 0}, {1, 0, 1}, {0, 1, 2}, {1, 1, 3}, {2, 0, 4}, {0, 2, 8}, {7, 7, 63}, {8, 0, 64}, {0, 8, 128}, {15, 15, 255},
+	} {
+		assertFail(t, tc.index, a8.elementIndex(tc.x, tc.y), fmt.Sprintf("A8 pixel %d,%d", tc.x, tc.y))
+	}
+
+	// ETC1 tiles are 2x2 blocks
+	etc1, err := newPicaLayout(16, 16, ctrSheetFormats[12])
+	assertNoErr(t, err)
+	assertFail(t, uint(3), etc1.elementIndex(1, 1), "ETC1 block 1,1")
+	assertFail(t, uint(4), etc1.elementIndex(2, 0), "ETC1 block 2,0")
+
+	// every format comes back the same, sizes that are not whole tiles are
+	// padded
+	for _, code := range []uint16{0, 1, 3, 8, 11, 12, 13} {
+		format := ctrSheetFormats[code]
+		layout, err := newPicaLayout(20, 12, format)
+		assertNoErr(t, err)
+		assertFail(t, format.dataSize(24, 16), layout.Size(), format.Name+" tiled size")
+
+		data := make([]byte, format.dataSize(20, 12))
+		rand.New(rand.NewSource(int64(code))).Read(data)
+		swizzled, err := layout.Swizzle(data)
+		assertNoErr(t, err)
+		deswizzled, err := layout.Deswizzle(swizzled)
+		assertNoErr(t, err)
+		assertFail(t, true, bytes.Equal(data, deswizzled), format.Name+" should deswizzle to what was swizzled")
+	}
+}
+
+// A BNTX with a single array texture of blank layers, laid out the way
+// Nintendo's tools write them: header, NX header, texture info, image data
+// and a relocation table with a section before and one for the data
+func testBNTX(width int, height int, layers int, format uint32) []byte {
+	order := binary.LittleEndian
+	_, elementsHigh := nxSheetFormats[format].elementSize(width, height)
+	blockHeight := blockHeightLog2(elementsHigh)
+	layout, err := newBlockLinearLayout(width, height, nxSheetFormats[format], blockHeight)
+	handleErr(err)
+	imageSize := layers * int(alignUp(uint(layout.Size()), 0x200))
+
+	const brtiStart, mipOffsets, brtdStart, dataStart = 0x60, 0x100, 0x1F0, 0x200
+	rltStart := dataStart + imageSize
+	fileSize := rltStart + rltHeaderSize + 2*rltSectionSize
+	raw := make([]byte, fileSize)
+
+	copy(raw[0x00:], BNTX_MAGIC_HEADER)
+	order.PutUint32(raw[0x08:], 0x00040000)
+	copy(raw[0x0C:], []byte{0xFF, 0xFE})
+	order.PutUint32(raw[0x18:], uint32(rltStart))
+	order.PutUint32(raw[0x1C:], uint32(fileSize))
+
+	copy(raw[0x20:], "NX  ")
+	order.PutUint32(raw[0x24:], 1)
+	order.PutUint64(raw[0x28:], 0x58)
+	order.PutUint64(raw[0x30:], brtdStart)
+	order.PutUint64(raw[0x58:], brtiStart)
+
+	brti := raw[brtiStart:]
+	copy(brti, BRTI_MAGIC_HEADER)
+	order.PutUint16(brti[0x16:], 1)
+	order.PutUint32(brti[0x1C:], format)
+	order.PutUint32(brti[0x24:], uint32(width))
+	order.PutUint32(brti[0x28:], uint32(height))
+	order.PutUint32(brti[0x2C:], 1)
+	order.PutUint32(brti[0x30:], uint32(layers))
+	order.PutUint32(brti[0x34:], uint32(blockHeight))
+	order.PutUint32(brti[0x50:], uint32(imageSize))
+	order.PutUint32(brti[0x54:], 0x200)
+	order.PutUint64(brti[0x70:], mipOffsets)
+	order.PutUint64(raw[mipOffsets:], dataStart)
+
+	copy(raw[brtdStart:], BRTD_MAGIC_HEADER)
+	order.PutUint32(raw[brtdStart+0x08:], uint32(0x10+imageSize))
+
+	rlt := raw[rltStart:]
+	copy(rlt, RLT_MAGIC_HEADER)
+	order.PutUint32(rlt[0x04:], uint32(rltStart))
+	order.PutUint32(rlt[0x08:], 2)
+	order.PutUint32(rlt[rltHeaderSize+0x0C:], brtdStart)
+	order.PutUint32(rlt[rltHeaderSize+rltSectionSize+0x08:], brtdStart)
+	order.PutUint32(rlt[rltHeaderSize+rltSectionSize+0x0C:], uint32(0x10+imageSize))
+
+	return raw
+}
+
+// There are no Switch fonts in the repo, so a Wii U font is turned into a
+// little endian one with its sheets in a BNTX
+func TestDecodeSwitch(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+
+	var original BFFNT
+	assertNoErr(t, original.Decode(bffntRaw))
+	var nx BFFNT
+	assertNoErr(t, nx.Decode(bffntRaw))
+
+	nx.FFNT.byteOrder = binary.LittleEndian
+	assertNoErr(t, nx.setFileFormat())
+	assertFail(t, PlatformSwitch, nx.TGLP.Platform, "little endian FFNT should be a Switch font")
+	nx.TGLP.bntx, err = decodeBNTX(testBNTX(int(nx.TGLP.SheetWidth), int(nx.TGLP.SheetHeight), int(nx.TGLP.NumOfSheets), 0x0201), 0)
+	assertNoErr(t, err)
+
+	nxRaw, err := nx.Encode()
+	assertNoErr(t, err)
+	assertFail(t, "FFNT", string(nxRaw[0:4]), "magic header")
+	assertFail(t, []byte{0xFF, 0xFE}, nxRaw[4:6], "byte order mark")
+	assertFail(t, original.FINF.LineFeed, binary.LittleEndian.Uint16(nxRaw[FFNT_HEADER_SIZE+12:]), "little endian line feed")
+	bntxStart := int(nx.TGLP.SheetDataOffset)
+	assertFail(t, BNTX_MAGIC_HEADER, string(nxRaw[bntxStart:bntxStart+4]), "sheets should be in a BNTX")
+
+	var decoded BFFNT
+	assertNoErr(t, decoded.Decode(nxRaw))
+	assertFail(t, PlatformSwitch, decoded.TGLP.Platform, "little endian FFNT should decode as a Switch font")
+	assertFail(t, original.GlyphIndexes(), decoded.GlyphIndexes(), "CMAPs should survive little endian")
+	assertFail(t, original.KRNG.KerningTable, decoded.KRNG.KerningTable, "kerning should survive little endian")
+	assertFail(t, true, bytes.Equal(original.TGLP.SheetData[0].Pix, decoded.TGLP.SheetData[0].Pix), "sheet should survive the block linear swizzle")
+
+	reencoded, err := decoded.Encode()
+	assertNoErr(t, err)
+	assertFail(t, true, bytes.Equal(nxRaw, reencoded), "Switch font should encode to the bytes it was decoded from")
+
+	// a smaller sheet moves the relocation table and shrinks its data section
+	half := int(decoded.TGLP.SheetHeight) / 2
+	decoded.TGLP.SheetHeight = uint16(half)
+	decoded.TGLP.SheetData[0] = *imaging.Crop(&decoded.TGLP.SheetData[0], image.Rect(0, 0, int(decoded.TGLP.SheetWidth), half))
+	smallRaw, err := decoded.Encode()
+	assertNoErr(t, err)
+	assertFail(t, true, len(smallRaw) < len(nxRaw), "smaller sheet should make a smaller file")
+
+	var small BFFNT
+	assertNoErr(t, small.Decode(smallRaw))
+	assertFail(t, true, bytes.Equal(decoded.TGLP.SheetData[0].Pix, small.TGLP.SheetData[0].Pix), "smaller sheet should survive encoding")
+	bntx := smallRaw[bntxStart:]
+	rltStart := binary.LittleEndian.Uint32(bntx[0x18:])
+	assertFail(t, RLT_MAGIC_HEADER, string(bntx[rltStart:rltStart+4]), "relocation table offset should move with the data")
+	assertFail(t, rltStart, binary.LittleEndian.Uint32(bntx[rltStart+4:]), "relocation table position")
+	dataSection := bntx[rltStart+rltHeaderSize+rltSectionSize:]
+	assertFail(t, uint32(0x10+len(small.TGLP.bntx.Data)), binary.LittleEndian.Uint32(dataSection[0x0C:]), "relocation section of the data should shrink with it")
+	assertFail(t, binary.LittleEndian.Uint32(bntx[0x1C:]), uint32(len(small.TGLP.AllSheetData)), "BNTX file size")
+}
+
+// A Switch font written field by field from Switch Toolbox's reader and
+// AboodXD's BNTX-Extractor, not with Encode or testBNTX: 2 glyphs, A and B,
+// on a 128x16 R8 texture. The texture is block linear with blocks of 2 GOBs,
+// the byte addresses below are worked out by hand from the Tegra X1 GOB
+// layout.
+func TestDecodeSwitchFixture(t *testing.T) {
+	order := binary.LittleEndian
+	raw := make([]byte, 0xAC4)
+
+	// FFNT header
+	copy(raw[0x00:], "FFNT")
+	copy(raw[0x04:], []byte{0xFF, 0xFE})
+	order.PutUint16(raw[0x06:], 0x14)       // header size
+	order.PutUint32(raw[0x08:], 0x04010000) // version
+	order.PutUint32(raw[0x0C:], 0xAC4)      // file size
+	order.PutUint32(raw[0x10:], 4)          // blocks
+
+	finf := raw[0x14:]
+	copy(finf[0x00:], "FINF")
+	order.PutUint32(finf[0x04:], 0x20)
+	copy(finf[0x08:], []byte{1, 10, 8, 9}) // font type, height, width and ascent
+	order.PutUint16(finf[0x0C:], 12)       // line feed
+	order.PutUint16(finf[0x0E:], 1)        // alternate character index
+	copy(finf[0x10:], []byte{0, 6, 7, 1})  // default left, glyph and char width, UTF-16
+	order.PutUint32(finf[0x14:], 0x34+8)   // TGLP data
+	order.PutUint32(finf[0x18:], 0xA90+8)  // CWDH data
+	order.PutUint32(finf[0x1C:], 0xAA8+8)  // CMAP data
+
+	tglp := raw[0x34:]
+	copy(tglp[0x00:], "TGLP")
+	order.PutUint32(tglp[0x04:], 0xA90-0x34)
+	copy(tglp[0x08:], []byte{15, 7, 1, 15}) // cell width and height, sheets, max char width
+	order.PutUint32(tglp[0x0C:], 0x800)     // sheet size
+	order.PutUint16(tglp[0x10:], 6)         // baseline
+	order.PutUint16(tglp[0x12:], 8)         // A8
+	order.PutUint16(tglp[0x14:], 8)         // columns
+	order.PutUint16(tglp[0x16:], 2)         // rows
+	order.PutUint16(tglp[0x18:], 128)       // sheet width
+	order.PutUint16(tglp[0x1A:], 16)        // sheet height
+	order.PutUint32(tglp[0x1C:], 0x80)      // sheet data, the BNTX
+
+	bntx := raw[0x80:0xA90]
+	copy(bntx[0x00:], "BNTX")
+	order.PutUint32(bntx[0x08:], 0x00040000) // version
+	copy(bntx[0x0C:], []byte{0xFF, 0xFE})
+	bntx[0x0E] = 0x0C                   // alignment shift
+	bntx[0x0F] = 0x40                   // address size
+	order.PutUint32(bntx[0x18:], 0xA00) // relocation table
+	order.PutUint32(bntx[0x1C:], 0xA10) // file size
+
+	copy(bntx[0x20:], "NX  ")
+	order.PutUint32(bntx[0x24:], 1)     // textures
+	order.PutUint64(bntx[0x28:], 0x58)  // texture info pointers
+	order.PutUint64(bntx[0x30:], 0x1F0) // BRTD
+	order.PutUint64(bntx[0x58:], 0x60)  // the BRTI
+
+	brti := bntx[0x60:]
+	copy(brti[0x00:], "BRTI")
+	order.PutUint32(brti[0x08:], 0xA0)
+	brti[0x10] = 1                       // flags
+	brti[0x11] = 2                       // dimensions
+	order.PutUint16(brti[0x12:], 0)      // block linear
+	order.PutUint16(brti[0x16:], 1)      // mip levels
+	order.PutUint16(brti[0x18:], 1)      // samples
+	order.PutUint32(brti[0x1C:], 0x0201) // R8 UNORM
+	order.PutUint32(brti[0x24:], 128)    // width
+	order.PutUint32(brti[0x28:], 16)     // height
+	order.PutUint32(brti[0x2C:], 1)      // depth
+	order.PutUint32(brti[0x30:], 1)      // array length
+	order.PutUint32(brti[0x34:], 1)      // log2 of the GOBs per block
+	order.PutUint32(brti[0x50:], 0x800)  // image size
+	order.PutUint32(brti[0x54:], 0x200)  // alignment
+	order.PutUint64(brti[0x70:], 0x100)  // mip level pointers
+	order.PutUint64(bntx[0x100:], 0x200) // level 0
+
+	brtd := bntx[0x1F0:]
+	copy(brtd[0x00:], "BRTD")
+	order.PutUint32(brtd[0x08:], 0x810)
+
+	// a GOB is 64 bytes by 8 rows in 16x2 byte pieces, two GOBs are stacked
+	// into a block and the blocks go from left to right
+	sheet := bntx[0x200:0xA00]
+	pixels := []struct {
+		x, y, offset int
+		alpha        byte
+	}{
+		{0, 0, 0, 0x10},
+		{5, 1, 21, 0x20},
+		{20, 0, 36, 0x30},
+		{40, 3, 344, 0x40},
+		{0, 8, 512, 0x50},
+		{70, 2, 1094, 0x60},
+		{127, 15, 2047, 0x70},
+	}
+	for _, p := range pixels {
+		sheet[p.offset] = p.alpha
+	}
+
+	rlt := bntx[0xA00:]
+	copy(rlt[0x00:], "_RLT")
+	order.PutUint32(rlt[0x04:], 0xA00)
+
+	cwdh := raw[0xA90:]
+	copy(cwdh[0x00:], "CWDH")
+	order.PutUint32(cwdh[0x04:], 0x18)
+	order.PutUint16(cwdh[0x08:], 0) // start index
+	order.PutUint16(cwdh[0x0A:], 1) // end index
+	copy(cwdh[0x10:], []byte{0, 6, 7, 1, 5, 7})
+
+	// Switch character codes are 4 bytes
+	cmap := raw[0xAA8:]
+	copy(cmap[0x00:], "CMAP")
+	order.PutUint32(cmap[0x04:], 0x1C)
+	order.PutUint32(cmap[0x08:], 'A') // code begin
+	order.PutUint32(cmap[0x0C:], 'B') // code end
+	order.PutUint16(cmap[0x10:], 0)   // direct
+	order.PutUint16(cmap[0x18:], 0)   // index of the first code
+
+	var font BFFNT
+	assertNoErr(t, font.Decode(raw))
+	assertFail(t, PlatformSwitch, font.TGLP.Platform, "platform")
+	assertFail(t, uint16(12), font.FINF.LineFeed, "line feed")
+	assertFail(t, uint8(9), font.FINF.Ascent, "ascent")
+	assertFail(t, uint16(6), font.TGLP.BaselinePosition, "baseline")
+	assertFail(t, uint8(1), font.TGLP.NumOfSheets, "sheets")
+	assertFail(t, uint(1), font.TGLP.bntx.BlockHeightLog2, "block height")
+	assertFail(t, []AsciiIndexPair{{'A', 0}, {'B', 1}}, font.GlyphIndexes(), "character map")
+	assertFail(t, []glyphInfo{{0, 6, 7}, {1, 5, 7}}, font.CWDHs[0].Glyphs, "widths")
+
+	expected := image.NewAlpha(image.Rect(0, 0, 128, 16))
+	for _, p := range pixels {
+		expected.SetAlpha(p.x, p.y, color.Alpha{p.alpha})
+	}
+	decoded := &font.TGLP.SheetData[0]
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 128; x++ {
+			assertFail(t, expected.AlphaAt(x, y).A, decoded.NRGBAAt(x, y).A, fmt.Sprintf("alpha of pixel %d,%d", x, y))
+		}
+	}
+
+	reencoded, err := font.Encode()
+	assertNoErr(t, err)
+	assertFail(t, raw, reencoded, "font should encode to the bytes written by hand")
+
+	// scan maps have padding after the count and after every index
+	font.CMAPs[0] = CMAP{MagicHeader: CMAP_MAGIC_HEADER, CodeBegin: 'A', CodeEnd: 'B', MappingMethod: 2, CharacterCount: 2, CharAscii: []uint16{'A', 'B'}, CharIndex: []uint16{0, 1}}
+	scanRaw, err := font.Encode()
+	assertNoErr(t, err)
+	scan := scanRaw[0xAA8:]
+	assertFail(t, uint32(0x18+4+2*8), order.Uint32(scan[0x04:]), "scan map size")
+	assertFail(t, []byte{2, 0, 0, 0, 'A', 0, 0, 0, 0, 0, 0, 0, 'B', 0, 0, 0, 1, 0, 0, 0}, scan[0x18:0x2C], "scan map data")
+	assertNoErr(t, font.Decode(scanRaw))
+	assertFail(t, []AsciiIndexPair{{'A', 0}, {'B', 1}}, font.GlyphIndexes(), "scan map")
+
+	// characters above U+FFFF do not fit the glyph indexes
+	order.PutUint32(raw[0xAA8+0x0C:], 0x1F600)
+	err = font.Decode(raw)
+	assertFail(t, true, errors.Is(err, ErrValueOutOfRange), "code end above U+FFFF")
+}
+
+func TestBlockLinearLayout(t *testing.T) {
+	a8 := nxSheetFormats[0x0201]
+
+	// GOBs are 64 bytes by 8 rows of 16x2 byte pieces
+	layout, err := newBlockLinearLayout(128, 16, a8, 0)
+	assertNoErr(t, err)
+	for _, tc := range []struct{ x, y, address uint }{
+		{0, 0, 0}, {15, 0, 15}, {0, 1, 16}, {16, 0, 32}, {0, 2, 64}, {32, 0, 256}, {63, 7, 511}, {64, 0, 512}, {0, 8, 1024},
+	} {
+		assertFail(t, tc.address, layout.address(tc.x, tc.y), fmt.Sprintf("byte %d,%d with 1 GOB blocks", tc.x, tc.y))
+	}
+
+	// blocks of 2 GOBs are stacked before going right
+	layout, err = newBlockLinearLayout(128, 16, a8, 1)
+	assertNoErr(t, err)
+	assertFail(t, uint(512), layout.address(0, 8), "second GOB of a 2 GOB block")
+	assertFail(t, uint(1024), layout.address(64, 0), "second block of 2 GOB blocks")
+
+	assertFail(t, uint(0), blockHeightLog2(8), "block height of 8 rows")
+	assertFail(t, uint(1), blockHeightLog2(16), "block height of 16 rows")
+	assertFail(t, uint(2), blockHeightLog2(24), "block height of 24 rows")
+	assertFail(t, uint(4), blockHeightLog2(1024), "block height of 1024 rows")
+
+	// every format comes back the same, sizes that are not whole blocks are
+	// padded
+	for code, format := range nxSheetFormats {
+		layout, err := newBlockLinearLayout(20, 12, format, 1)
+		assertNoErr(t, err)
+		data := make([]byte, format.dataSize(20, 12))
+		rand.New(rand.NewSource(int64(code))).Read(data)
+		swizzled, err := layout.Swizzle(data)
+		assertNoErr(t, err)
+		assertFail(t, layout.Size(), len(swizzled), format.Name+" swizzled size")
+		deswizzled, err := layout.Deswizzle(swizzled)
+		assertNoErr(t, err)
+		assertFail(t, true, bytes.Equal(data, deswizzled), format.Name+" should deswizzle to what was swizzled")
+	}
+}
+
+// There are no Wii fonts in the repo, so a Wii U font is turned into an RFNT.
+// The A8 sheets become I8, which is read as alpha the same way.
+func TestDecodeWii(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+
+	var original BFFNT
+	assertNoErr(t, original.Decode(bffntRaw))
+	var rvl BFFNT
+	assertNoErr(t, rvl.Decode(bffntRaw))
+
+	rvl.FFNT.MagicHeader = RFNT_MAGIC_HEADER
+	rvl.FFNT.Version = 0x0104
+	rvl.FFNT.SectionSize = RFNT_HEADER_SIZE
+	assertNoErr(t, rvl.setFileFormat())
+	assertFail(t, PlatformWii, rvl.TGLP.Platform, "RFNT should be a Wii font")
+	rvl.TGLP.SheetImageFormat = 1
+	surface, _, err := rvl.TGLP.sheetSurface(0)
+	assertNoErr(t, err)
+	rvl.TGLP.SheetSize = uint32(surface.Size())
+	rvl.TGLP.SectionSize = TGLP_HEADER_SIZE + uint32(rvl.TGLP.computePredataPadding()) + rvl.TGLP.SheetSize*uint32(rvl.TGLP.NumOfSheets)
+
+	rvlRaw, err := rvl.Encode()
+	assertNoErr(t, err)
+
+	// 16 byte header with a 2 byte version and the section count
+	assertFail(t, "RFNT", string(rvlRaw[0:4]), "magic header")
+	assertFail(t, []byte{0xFE, 0xFF}, rvlRaw[4:6], "byte order mark")
+	assertFail(t, uint16(0x0104), binary.BigEndian.Uint16(rvlRaw[6:8]), "version")
+	assertFail(t, uint32(len(rvlRaw)), binary.BigEndian.Uint32(rvlRaw[8:12]), "file size")
+	assertFail(t, uint16(RFNT_HEADER_SIZE), binary.BigEndian.Uint16(rvlRaw[12:14]), "header size")
+	assertFail(t, uint16(3+len(original.CWDHs)+len(original.CMAPs)), binary.BigEndian.Uint16(rvlRaw[14:16]), "section count with FINF, TGLP and KRNG")
+	assertFail(t, FINF_MAGIC_HEADER, string(rvlRaw[RFNT_HEADER_SIZE:RFNT_HEADER_SIZE+4]), "FINF right after the header")
+	assertFail(t, uint8(original.FINF.LineFeed), rvlRaw[RFNT_HEADER_SIZE+9], "Wii FINF line feed")
+	tglpStart := RFNT_HEADER_SIZE + FINF_HEADER_SIZE
+	assertFail(t, TGLP_MAGIC_HEADER, string(rvlRaw[tglpStart:tglpStart+4]), "TGLP after the FINF")
+	assertFail(t, uint8(original.TGLP.BaselinePosition), rvlRaw[tglpStart+10], "Wii TGLP baseline")
+
+	var decoded BFFNT
+	assertNoErr(t, decoded.Decode(rvlRaw))
+	assertFail(t, PlatformWii, decoded.TGLP.Platform, "RFNT should decode as a Wii font")
+	assertFail(t, uint32(0x0104), decoded.FFNT.Version, "version")
+	assertFail(t, original.FINF.LineFeed, decoded.FINF.LineFeed, "line feed")
+	assertFail(t, original.TGLP.BaselinePosition, decoded.TGLP.BaselinePosition, "baseline")
+	assertFail(t, original.GlyphIndexes(), decoded.GlyphIndexes(), "CMAPs should survive the RFNT header")
+	assertFail(t, original.CWDHs[0].Glyphs, decoded.CWDHs[0].Glyphs, "CWDHs should survive the RFNT header")
+	for i := range original.TGLP.SheetData {
+		assertFail(t, true, bytes.Equal(original.TGLP.SheetData[i].Pix, decoded.TGLP.SheetData[i].Pix), fmt.Sprintf("sheet %d should survive the GX tiling", i))
+	}
+
+	reencoded, err := decoded.Encode()
+	assertNoErr(t, err)
+	assertFail(t, true, bytes.Equal(rvlRaw, reencoded), "Wii font should encode to the bytes it was decoded from")
+
+	// the header size is checked against the one of the platform
+	corrupt := append([]byte{}, rvlRaw...)
+	binary.BigEndian.PutUint16(corrupt[12:], FFNT_HEADER_SIZE)
+	err = decoded.Decode(corrupt)
+	var sectionErr *SectionError
+	assertFail(t, true, errors.As(err, &sectionErr), "wrong RFNT header size should be a SectionError")
+	assertFail(t, true, errors.Is(err, ErrSizeMismatch), "wrong RFNT header size")
+	assertFail(t, 12, sectionErr.Offset, "RFNT header size offset")
+}
+
+// A Wii font written field by field from the BRFNT page of the Custom Mario
+// Kart wiki, not with Encode: 2 glyphs, A and B, on a 16x8 I4 sheet. GX
+// sheets are not upside down, I4 tiles are 8x8 pixels of 4 bytes a row with
+// the left pixel in the high nibble, the bytes below are worked out by hand
+// from that.
+// http://wiki.tockdom.com/wiki/BRFNT_(File_Format)
+func TestDecodeWiiFixture(t *testing.T) {
+	order := binary.BigEndian
+	raw := make([]byte, 0xD0)
+
+	// RFNT header
+	copy(raw[0x00:], "RFNT")
+	copy(raw[0x04:], []byte{0xFE, 0xFF})
+	order.PutUint16(raw[0x06:], 0x0104) // version
+	order.PutUint32(raw[0x08:], 0xD0)   // file size
+	order.PutUint16(raw[0x0C:], 0x10)   // header size
+	order.PutUint16(raw[0x0E:], 4)      // sections
+
+	finf := raw[0x10:]
+	copy(finf[0x00:], "FINF")
+	order.PutUint32(finf[0x04:], 0x20)
+	finf[0x08] = 1                       // font type
+	finf[0x09] = 12                      // line feed
+	order.PutUint16(finf[0x0A:], 1)      // alternate character index
+	copy(finf[0x0C:], []byte{0, 6, 7})   // default left, glyph and char width
+	finf[0x0F] = 1                       // encoding, UTF-16
+	order.PutUint32(finf[0x10:], 0x30+8) // TGLP data
+	order.PutUint32(finf[0x14:], 0xA0+8) // CWDH data
+	order.PutUint32(finf[0x18:], 0xB8+8) // CMAP data
+	copy(finf[0x1C:], []byte{10, 8, 9})  // height, width and ascent
+
+	tglp := raw[0x30:]
+	copy(tglp[0x00:], "TGLP")
+	order.PutUint32(tglp[0x04:], 0xA0-0x30)
+	copy(tglp[0x08:], []byte{7, 7, 6, 7}) // cell width and height, baseline, max char width
+	order.PutUint32(tglp[0x0C:], 0x40)    // sheet size
+	order.PutUint16(tglp[0x10:], 1)       // sheets
+	order.PutUint16(tglp[0x12:], 0)       // I4
+	order.PutUint16(tglp[0x14:], 2)       // columns
+	order.PutUint16(tglp[0x16:], 1)       // rows
+	order.PutUint16(tglp[0x18:], 16)      // sheet width
+	order.PutUint16(tglp[0x1A:], 8)       // sheet height
+	order.PutUint32(tglp[0x1C:], 0x60)    // sheet data, 32 byte aligned
+
+	// pixel x, y and its 4 bit intensity, read as alpha
+	sheet := raw[0x60:0xA0]
+	sheet[0] = 0xF1  // 0,0 and 1,0
+	sheet[7] = 0x30  // 6,1
+	sheet[31] = 0x05 // 7,7
+	sheet[32] = 0x70 // 8,0 in the second tile
+	sheet[42] = 0x09 // 13,2
+	sheet[63] = 0x0B // 15,7
+	pixels := []struct {
+		x, y      int
+		intensity byte
+	}{
+		{0, 0, 0xF}, {1, 0, 0x1}, {6, 1, 0x3}, {7, 7, 0x5}, {8, 0, 0x7}, {13, 2, 0x9}, {15, 7, 0xB},
+	}
+
+	cwdh := raw[0xA0:]
+	copy(cwdh[0x00:], "CWDH")
+	order.PutUint32(cwdh[0x04:], 0x18)
+	order.PutUint16(cwdh[0x08:], 0) // start index
+	order.PutUint16(cwdh[0x0A:], 1) // end index
+	copy(cwdh[0x10:], []byte{0, 6, 7, 1, 5, 7})
+
+	cmap := raw[0xB8:]
+	copy(cmap[0x00:], "CMAP")
+	order.PutUint32(cmap[0x04:], 0x18)
+	order.PutUint16(cmap[0x08:], 'A') // code begin
+	order.PutUint16(cmap[0x0A:], 'B') // code end
+	order.PutUint16(cmap[0x0C:], 0)   // direct
+	order.PutUint16(cmap[0x14:], 0)   // index of the first code
+
+	var font BFFNT
+	assertNoErr(t, font.Decode(raw))
+	assertFail(t, PlatformWii, font.TGLP.Platform, "platform")
+	assertFail(t, uint32(0x0104), font.FFNT.Version, "version")
+	assertFail(t, uint16(12), font.FINF.LineFeed, "line feed")
+	assertFail(t, uint16(1), font.FINF.AlterCharIndex, "alternate character index")
+	assertFail(t, uint8(9), font.FINF.Ascent, "ascent")
+	assertFail(t, uint16(6), font.TGLP.BaselinePosition, "baseline")
+	assertFail(t, []AsciiIndexPair{{'A', 0}, {'B', 1}}, font.GlyphIndexes(), "character map")
+	assertFail(t, []glyphInfo{{0, 6, 7}, {1, 5, 7}}, font.CWDHs[0].Glyphs, "widths")
+
+	expected := image.NewAlpha(image.Rect(0, 0, 16, 8))
+	for _, p := range pixels {
+		expected.SetAlpha(p.x, p.y, color.Alpha{p.intensity * 17})
+	}
+	decoded := &font.TGLP.SheetData[0]
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 16; x++ {
+			assertFail(t, expected.AlphaAt(x, y).A, decoded.NRGBAAt(x, y).A, fmt.Sprintf("alpha of pixel %d,%d", x, y))
+		}
+	}
+
+	reencoded, err := font.Encode()
+	assertNoErr(t, err)
+	assertFail(t, raw, reencoded, "font should encode to the bytes written by hand")
+}
+
+func TestGXLayout(t *testing.T) {
+	// I4 tiles are 8x8 with the first pixel in the high nibble
+	i4, err := newGXLayout(16, 8, rvlSheetFormats[0])
+	assertNoErr(t, err)
+	for _, tc := range []struct{ x, y, index uint }{
+		{0, 0, 0}, {1, 0, 1}, {7, 0, 7}, {0, 1, 8}, {8, 0, 64}, {15, 7, 127},
+	} {
+		assertFail(t, tc.index, i4.pixelIndex(tc.x, tc.y), fmt.Sprintf("I4 pixel %d,%d", tc.x, tc.y))
+	}
+	linear := make([]byte, 16*8/2)
+	linear[0] = 0xBA // pixel 0 is A, pixel 1 is B
+	tiled, err := i4.Swizzle(linear)
+	assertNoErr(t, err)
+	assertFail(t, byte(0xAB), tiled[0], "I4 pixels in the high nibble first")
+
+	// I8 and IA4 tiles are 8x4, 16 bit tiles 4x4
+	i8, err := newGXLayout(16, 8, rvlSheetFormats[1])
+	assertNoErr(t, err)
+	assertFail(t, uint(32), i8.pixelIndex(8, 0), "second I8 tile")
+	assertFail(t, uint(64), i8.pixelIndex(0, 4), "I8 tile row below")
+	ia8, err := newGXLayout(16, 8, rvlSheetFormats[3])
+	assertNoErr(t, err)
+	assertFail(t, uint(16), ia8.pixelIndex(4, 0), "second IA8 tile")
+
+	// RGBA8 tiles have the alpha and red first, then green and blue
+	rgba8, err := newGXLayout(4, 4, rvlSheetFormats[6])
+	assertNoErr(t, err)
+	pixels := make([]byte, 4*4*4)
+	copy(pixels[4:8], []byte{1, 2, 3, 4})
+	tiled, err = rgba8.Swizzle(pixels)
+	assertNoErr(t, err)
+	assertFail(t, []byte{4, 1}, tiled[2:4], "alpha and red of the second pixel")
+	assertFail(t, []byte{2, 3}, tiled[34:36], "green and blue of the second pixel")
+
+	// every format comes back the same, sizes that are not whole tiles are
+	// padded
+	for code, format := range rvlSheetFormats {
+		layout, err := newGXLayout(20, 12, format)
+		assertNoErr(t, err)
+		data := make([]byte, format.dataSize(20, 12))
+		rand.New(rand.NewSource(int64(code))).Read(data)
+		swizzled, err := layout.Swizzle(data)
+		assertNoErr(t, err)
+		assertFail(t, layout.Size(), len(swizzled), format.Name+" swizzled size")
+		deswizzled, err := layout.Deswizzle(swizzled)
+		assertNoErr(t, err)
+		assertFail(t, true, bytes.Equal(data, deswizzled), format.Name+" should deswizzle to what was swizzled")
+	}
+
+	// RGB5A3 keeps opaque pixels as RGB555 and the others as ARGB3444
+	p := make([]byte, 2)
+	encodeRGB5A3(color.NRGBA{255, 0, 0, 255}, p)
+	assertFail(t, uint16(0xFC00), binary.BigEndian.Uint16(p), "opaque red")
+	encodeRGB5A3(color.NRGBA{0, 0, 255, 0}, p)
+	assertFail(t, uint16(0x000F), binary.BigEndian.Uint16(p), "transparent blue")
+	assertFail(t, color.NRGBA{0, 0, 255, 0}, decodeRGB5A3(p), "transparent blue")
+}
+
+// Every known version is written in its own header layout and decodes to the
+// same font. The Wii U font is turned into each of them.
+func TestFileVersions(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/NormalS/NormalS_00.bffnt")
+	handleErr(err)
+
+	var original BFFNT
+	assertNoErr(t, original.Decode(bffntRaw))
+
+	for _, v := range fileVersions {
+		name := fmt.Sprintf("%s version 0x%X", v.Magic, v.Version)
+
+		var font BFFNT
+		assertNoErr(t, font.Decode(bffntRaw))
+		font.FFNT.MagicHeader = v.Magic
+		font.FFNT.Version = v.Version
+		font.FFNT.SectionSize = uint16(v.Layout.fileHeaderSize())
+		if v.Layout == layoutCFNT {
+			font.FFNT.byteOrder = binary.LittleEndian
+		}
+		assertNoErr(t, font.setFileFormat())
+		if v.Layout == layoutRFNT {
+			font.TGLP.SheetImageFormat = 1 // I8
+		}
+		surface, _, err := font.TGLP.sheetSurface(0)
+		assertNoErr(t, err)
+		font.TGLP.SheetSize = uint32(surface.Size())
+		font.TGLP.SectionSize = TGLP_HEADER_SIZE + uint32(font.TGLP.computePredataPadding()) + font.TGLP.SheetSize*uint32(font.TGLP.NumOfSheets)
+
+		encoded, err := font.Encode()
+		assertNoErr(t, err)
+
+		var decoded BFFNT
+		assertNoErr(t, decoded.Decode(encoded))
+		assertFail(t, v.Version, decoded.FFNT.Version, name)
+		assertFail(t, v.Layout, decoded.FINF.layout, name+" FINF layout")
+		assertFail(t, v.Layout, decoded.TGLP.layout, name+" TGLP layout")
+		assertFail(t, original.FINF.LineFeed, decoded.FINF.LineFeed, name+" line feed")
+		assertFail(t, original.FINF.Ascent, decoded.FINF.Ascent, name+" ascent")
+		assertFail(t, original.TGLP.BaselinePosition, decoded.TGLP.BaselinePosition, name+" baseline")
+		assertFail(t, original.TGLP.NumOfSheets, decoded.TGLP.NumOfSheets, name+" sheet count")
+		assertFail(t, original.GlyphIndexes(), decoded.GlyphIndexes(), name+" CMAPs")
+
+		reencoded, err := decoded.Encode()
+		assertNoErr(t, err)
+		assertFail(t, true, bytes.Equal(encoded, reencoded), name+" should encode to the bytes it was decoded from")
+	}
+
+	// versions that are not known fail at the version instead of being read
+	// in the wrong layout
+	var sectionErr *SectionError
+	unknown := append([]byte{}, bffntRaw...)
+	binary.BigEndian.PutUint32(unknown[8:], 0x05000000)
+	var font BFFNT
+	err = font.Decode(unknown)
+	assertFail(t, true, errors.Is(err, ErrUnknownVersion), "unknown FFNT version")
+	assertFail(t, true, errors.As(err, &sectionErr), "unknown FFNT version should be a SectionError")
+	assertFail(t, 8, sectionErr.Offset, "unknown version offset")
+	var finf FINF
+	assertFail(t, true, errors.Is(finf.Decode(unknown), ErrUnknownVersion), "FINF of an unknown version")
+	var tglp TGLP
+	assertFail(t, true, errors.Is(tglp.Decode(unknown), ErrUnknownVersion), "TGLP of an unknown version")
+
+	// the version numbers are per magic header
+	copy(unknown, bffntRaw)
+	copy(unknown[0:4], RFNT_MAGIC_HEADER)
+	err = font.Decode(unknown)
+	assertFail(t, true, errors.Is(err, ErrUnknownVersion), "RFNT with a version of FFNT")
+	assertFail(t, true, errors.As(err, &sectionErr), "RFNT with a version of FFNT should be a SectionError")
+	assertFail(t, 6, sectionErr.Offset, "RFNT version offset")
+
+	assertNoErr(t, font.Decode(bffntRaw))
+	font.FFNT.Version = 0x02000000
+	_, err = font.Encode()
+	assertFail(t, true, errors.Is(err, ErrUnknownVersion), "encoding an unknown version")
+}
+
+// Every character of the font becomes a BMFont char at its cell, and both
+// formats describe the same font.
+func TestBMFont(t *testing.T) {
+	bffntRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Caption/Caption_00.bffnt")
+	handleErr(err)
+	var bffnt BFFNT
+	assertNoErr(t, bffnt.Decode(bffntRaw))
+
+	font := bffnt.BMFont("Caption_00", func(sheet int) string {
+		return fmt.Sprintf("Caption_00_%d.png", sheet)
+	})
+	assertFail(t, len(bffnt.GlyphIndexes()), len(font.Chars), "chars")
+	assertFail(t, bffnt.KRNG.pairCount(), len(font.Kernings), "kerning pairs")
+	assertFail(t, int(bffnt.TGLP.NumOfSheets), len(font.Pages), "pages")
+	assertFail(t, true, font.Unicode, "unicode")
+
+	index := uint16(bffnt.CWDHIndexMap['A'])
+	sheet, row, column := bffnt.TGLP.cellPosition(int(index))
+	cell := bffnt.TGLP.cellRect(row, column)
+	widths := bffnt.widthsOrDefault(index)
+	var a bmfontChar
+	for _, c := range font.Chars {
+		if c.ID == 'A' {
+			a = c
+		}
+	}
+	assertFail(t, bmfontChar{
+		ID:       'A',
+		X:        uint16(cell.Min.X),
+		Y:        uint16(cell.Min.Y),
+		Width:    uint16(widths.GlyphWidth),
+		Height:   uint16(cell.Dy()),
+		XOffset:  int16(widths.LeftWidth),
+		XAdvance: int16(widths.CharWidth),
+		Page:     uint8(sheet),
+		Channel:  bmfontAllChannels,
+	}, a, "char A")
+
+	var text bytes.Buffer
+	assertNoErr(t, font.WriteText(&text))
+	assert.Contains(t, text.String(), fmt.Sprintf("common lineHeight=%d base=%d ", bffnt.FINF.LineFeed, bffnt.TGLP.BaselinePosition))
+	assert.Contains(t, text.String(), fmt.Sprintf("chars count=%d\n", len(font.Chars)))
+	assert.Contains(t, text.String(), fmt.Sprintf("kernings count=%d\n", len(font.Kernings)))
+	assert.Contains(t, text.String(), "page id=0 file=\"Caption_00_0.png\"\n")
+
+	// the blocks of the binary format fill the file
+	binaryFont := font.EncodeBinary()
+	assertFail(t, "BMF\x03", string(binaryFont[:4]), "binary header")
+	blockSizes := map[byte]int{}
+	for offset := 4; offset < len(binaryFont); {
+		blockType := binaryFont[offset]
+		size := int(binary.LittleEndian.Uint32(binaryFont[offset+1:]))
+		blockSizes[blockType] = size
+		offset += 5 + size
+		assertFail(t, true, offset <= len(binaryFont), "block past the end of the file")
+	}
+	assertFail(t, 14+len("Caption_00")+1, blockSizes[bmfontBlockInfo], "info block size")
+	assertFail(t, 15, blockSizes[bmfontBlockCommon], "common block size")
+	assertFail(t, 20*len(font.Chars), blockSizes[bmfontBlockChars], "chars block size")
+	assertFail(t, 10*len(font.Kernings), blockSizes[bmfontBlockKerning], "kerning block size")
+}
@@ -0,0 +1,273 @@
+package bffnt_headers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// BMFont is the bitmap font format of AngelCode's Bitmap Font Generator,
+// read by most game engines. A .fnt file describes the characters, the
+// kerning pairs and the png pages the glyphs are on. It is either text, a
+// line of key=value pairs per entry, or binary, "BMF" and version 3 followed
+// by blocks of little endian structs.
+//
+// Source:
+// http://www.angelcode.com/products/bmfont/doc/file_format.html
+
+const bmfontBinaryVersion = 3
+
+// Block types of the binary format
+const (
+	bmfontBlockInfo = iota + 1
+	bmfontBlockCommon
+	bmfontBlockPages
+	bmfontBlockChars
+	bmfontBlockKerning
+)
+
+// What a channel of the pages holds
+const (
+	bmfontChannelGlyph = 0
+	bmfontChannelOne   = 4
+)
+
+// All channels of the page
+const bmfontAllChannels = 15
+
+// The charset of FINF.Encoding for fonts that are not Unicode, by name in
+// the text format and number in the binary format
+var bmfontCharsets = map[uint8]struct {
+	Name string
+	ID   uint8
+}{
+	2: {"SHIFTJIS", 128}, // Shift JIS
+	3: {"ANSI", 0},       // CP1252
+}
+
+type bmfont struct {
+	Face       string
+	Size       int16
+	Unicode    bool
+	Charset    string // when not Unicode
+	CharsetID  uint8
+	LineHeight uint16
+	Base       uint16
+	ScaleW     uint16
+	ScaleH     uint16
+	Pages      []string
+
+	// alpha, red, green and blue, bmfontChannelGlyph or bmfontChannelOne
+	Channels [4]uint8
+
+	Chars    []bmfontChar
+	Kernings []bmfontKerning
+}
+
+// The binary layout, 20 bytes
+type bmfontChar struct {
+	ID       uint32
+	X        uint16
+	Y        uint16
+	Width    uint16
+	Height   uint16
+	XOffset  int16
+	YOffset  int16
+	XAdvance int16
+	Page     uint8
+	Channel  uint8
+}
+
+// The binary layout, 10 bytes
+type bmfontKerning struct {
+	First  uint32
+	Second uint32
+	Amount int16
+}
+
+// Describes a font as a BMFont with one page per sheet, named by pageFile.
+// Every character of the CMAPs gets the rectangle of its cell, as wide as
+// its glyph, and its CWDH widths. The top of a cell is the top of the line,
+// so base is the baseline of the cells.
+func (b *BFFNT) BMFont(face string, pageFile func(sheet int) string) bmfont {
+	f := bmfont{
+		Face:       face,
+		Size:       int16(b.FINF.Height),
+		Unicode:    true,
+		LineHeight: b.FINF.LineFeed,
+		Base:       b.TGLP.BaselinePosition,
+		ScaleW:     b.TGLP.SheetWidth,
+		ScaleH:     b.TGLP.SheetHeight,
+	}
+	if charset, ok := bmfontCharsets[b.FINF.Encoding]; ok {
+		f.Unicode = false
+		f.Charset = charset.Name
+		f.CharsetID = charset.ID
+	}
+	for i := range b.TGLP.SheetData {
+		f.Pages = append(f.Pages, pageFile(i))
+	}
+
+	// Most sheets are white with the glyphs in the alpha channel
+	f.Channels = [4]uint8{bmfontChannelGlyph, bmfontChannelOne, bmfontChannelOne, bmfontChannelOne}
+	if !b.TGLP.whiteSheets() {
+		f.Channels = [4]uint8{}
+	}
+
+	for _, pair := range b.GlyphIndexes() {
+		sheet, row, column := b.TGLP.cellPosition(int(pair.CharIndex))
+		if sheet >= len(b.TGLP.SheetData) {
+			continue
+		}
+		cell := b.TGLP.cellRect(row, column)
+		widths := b.widthsOrDefault(pair.CharIndex)
+		f.Chars = append(f.Chars, bmfontChar{
+			ID:       uint32(pair.CharAscii),
+			X:        uint16(cell.Min.X),
+			Y:        uint16(cell.Min.Y),
+			Width:    uint16(minInt(int(widths.GlyphWidth), cell.Dx())),
+			Height:   uint16(cell.Dy()),
+			XOffset:  int16(widths.LeftWidth),
+			XAdvance: int16(widths.CharWidth),
+			Page:     uint8(sheet),
+			Channel:  bmfontAllChannels,
+		})
+	}
+	sort.Slice(f.Chars, func(i, j int) bool {
+		return f.Chars[i].ID < f.Chars[j].ID
+	})
+
+	for first, pairs := range b.KRNG.KerningTable {
+		for _, pair := range pairs {
+			f.Kernings = append(f.Kernings, bmfontKerning{
+				First:  uint32(first),
+				Second: uint32(pair.SecondChar),
+				Amount: pair.KerningValue,
+			})
+		}
+	}
+	sort.Slice(f.Kernings, func(i, j int) bool {
+		if f.Kernings[i].First != f.Kernings[j].First {
+			return f.Kernings[i].First < f.Kernings[j].First
+		}
+		return f.Kernings[i].Second < f.Kernings[j].Second
+	})
+
+	return f
+}
+
+// Whether every pixel of every sheet is white, so only the alpha channel
+// holds the glyphs
+func (tglp *TGLP) whiteSheets() bool {
+	for i := range tglp.SheetData {
+		pix := tglp.SheetData[i].Pix
+		for p := 0; p < len(pix); p += 4 {
+			if pix[p] != 255 || pix[p+1] != 255 || pix[p+2] != 255 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Writes the text format
+func (f bmfont) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "info face=%q size=%d bold=0 italic=0 charset=%q unicode=%d stretchH=100 smooth=1 aa=1 padding=0,0,0,0 spacing=1,1 outline=0\n",
+		f.Face, f.Size, f.Charset, boolToInt(f.Unicode))
+	fmt.Fprintf(bw, "common lineHeight=%d base=%d scaleW=%d scaleH=%d pages=%d packed=0 alphaChnl=%d redChnl=%d greenChnl=%d blueChnl=%d\n",
+		f.LineHeight, f.Base, f.ScaleW, f.ScaleH, len(f.Pages), f.Channels[0], f.Channels[1], f.Channels[2], f.Channels[3])
+	for i, page := range f.Pages {
+		fmt.Fprintf(bw, "page id=%d file=%q\n", i, page)
+	}
+
+	fmt.Fprintf(bw, "chars count=%d\n", len(f.Chars))
+	for _, c := range f.Chars {
+		fmt.Fprintf(bw, "char id=%d x=%d y=%d width=%d height=%d xoffset=%d yoffset=%d xadvance=%d page=%d chnl=%d\n",
+			c.ID, c.X, c.Y, c.Width, c.Height, c.XOffset, c.YOffset, c.XAdvance, c.Page, c.Channel)
+	}
+
+	if len(f.Kernings) > 0 {
+		fmt.Fprintf(bw, "kernings count=%d\n", len(f.Kernings))
+		for _, k := range f.Kernings {
+			fmt.Fprintf(bw, "kerning first=%d second=%d amount=%d\n", k.First, k.Second, k.Amount)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// Encodes the binary format
+func (f bmfont) EncodeBinary() []byte {
+	order := binary.LittleEndian
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+
+	block := func(blockType uint8, data []byte) {
+		binaryWrite(w, order, blockType)
+		binaryWrite(w, order, uint32(len(data)))
+		_, _ = w.Write(data)
+		w.Flush()
+	}
+
+	_, _ = w.Write([]byte("BMF"))
+	binaryWrite(w, order, uint8(bmfontBinaryVersion))
+
+	// bits from the top: smooth, unicode, italic, bold, fixed height
+	bitField := uint8(0x80)
+	if f.Unicode {
+		bitField |= 0x40
+	}
+	info := bmfontBlock(order,
+		f.Size, bitField, f.CharsetID,
+		uint16(100),          // stretchH
+		uint8(1),             // aa
+		[4]uint8{0, 0, 0, 0}, // padding up, right, down, left
+		[2]uint8{1, 1},       // spacing horizontal, vertical
+		uint8(0),             // outline
+	)
+	info = append(info, f.Face...)
+	info = append(info, 0)
+	block(bmfontBlockInfo, info)
+
+	block(bmfontBlockCommon, bmfontBlock(order,
+		f.LineHeight, f.Base, f.ScaleW, f.ScaleH, uint16(len(f.Pages)),
+		uint8(0), // not packed
+		f.Channels,
+	))
+
+	var pages []byte
+	for _, page := range f.Pages {
+		pages = append(pages, page...)
+		pages = append(pages, 0)
+	}
+	block(bmfontBlockPages, pages)
+
+	block(bmfontBlockChars, bmfontBlock(order, f.Chars))
+	if len(f.Kernings) > 0 {
+		block(bmfontBlockKerning, bmfontBlock(order, f.Kernings))
+	}
+
+	return buf.Bytes()
+}
+
+// The fields of a block one after the other
+func bmfontBlock(order binary.ByteOrder, fields ...interface{}) []byte {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	for _, field := range fields {
+		binaryWrite(w, order, field)
+	}
+	return buf.Bytes()
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,317 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// BNTX is the texture container of the Switch. A Switch font keeps its
+// sheets as the layers of one array texture, in a BNTX at
+// TGLP.SheetDataOffset. Only the parts of the BNTX a font needs are read:
+//
+// header   0x00  "BNTX", byte order mark at 0x0C, relocation table offset at
+//                0x18 and file size at 0x1C
+// NX       0x20  "NX  ", texture count, offset of the texture info pointers
+//                and of the BRTD block
+// BRTI           texture info: format, size, array length, block height and
+//                the offset of the image data
+// BRTD           the image data of every texture
+// _RLT           relocation table, the pointers of the file to fix up when
+//                it is loaded
+//
+// A BNTX has more than that (a string table, a dictionary of the texture
+// names, memory pool space). Encode writes the BNTX the font was decoded from
+// again with only the texture size and the image data replaced, so all of it
+// stays the way Nintendo's tools wrote it.
+//
+// Source: AboodXD's BNTX-Extractor and Switch Toolbox
+
+const (
+	BNTX_MAGIC_HEADER = "BNTX"
+	BRTI_MAGIC_HEADER = "BRTI"
+	BRTD_MAGIC_HEADER = "BRTD"
+	RLT_MAGIC_HEADER  = "_RLT"
+
+	bntxHeaderSize     = 0x20
+	bntxContainerEnd   = 0x58
+	brtiSize           = 0xA0
+	rltHeaderSize      = 0x10
+	rltSectionSize     = 0x18
+	bntxTextureLayout  = 0x34 // offset in the BRTI
+	bntxImageSize      = 0x50
+	bntxMipOffsetsAddr = 0x70
+)
+
+// The first texture of a BNTX
+type bntxTexture struct {
+	Format          uint32 // nxSheetFormats
+	TileMode        uint16 // 0 block linear, 1 pitch linear
+	MipCount        uint16
+	Width           uint32
+	Height          uint32
+	ArrayLength     uint32 // number of sheets
+	BlockHeightLog2 uint
+	Alignment       uint32 // every layer starts at a multiple of it
+	Data            []byte // swizzled layers, one after the other
+
+	raw       []byte // the whole BNTX the texture was decoded from
+	order     binary.ByteOrder
+	brtiStart int
+	brtdStart int
+	dataStart int
+}
+
+// Size of the BNTX at start from its header
+func bntxFileSize(raw []byte, start int) (int, error) {
+	header, err := sliceSection(raw, BNTX_MAGIC_HEADER, "header", start, start+bntxHeaderSize)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkMagicHeader(BNTX_MAGIC_HEADER, start, string(header[0:4]))
+	if err != nil {
+		return 0, err
+	}
+
+	return int(bntxByteOrder(header).Uint32(header[0x1C:0x20])), nil
+}
+
+// The byte order mark of a BNTX is at 0x0C
+func bntxByteOrder(header []byte) binary.ByteOrder {
+	if header[0x0C] == 0xFF && header[0x0D] == 0xFE {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// Decodes the BNTX in raw. Offset is where it is in the font file, errors
+// are reported from there.
+func decodeBNTX(raw []byte, offset int) (*bntxTexture, error) {
+	t := &bntxTexture{raw: raw}
+
+	fileSize, err := bntxFileSize(raw, 0)
+	if err != nil {
+		return nil, bntxError(err, offset)
+	}
+	if fileSize > len(raw) {
+		return nil, &SectionError{
+			Section:  BNTX_MAGIC_HEADER,
+			Offset:   offset + 0x1C,
+			Field:    "file size",
+			Expected: fileSize,
+			Actual:   len(raw),
+			Err:      ErrTruncated,
+		}
+	}
+	t.order = bntxByteOrder(raw)
+	order := t.order
+
+	container, err := sliceSection(raw, BNTX_MAGIC_HEADER, "NX header", bntxHeaderSize, bntxContainerEnd)
+	if err != nil {
+		return nil, bntxError(err, offset)
+	}
+	if string(container[0:4]) != "NX  " {
+		return nil, &SectionError{
+			Section: BNTX_MAGIC_HEADER,
+			Offset:  offset + bntxHeaderSize,
+			Field:   fmt.Sprintf("magic header %q", container[0:4]),
+			Err:     ErrMagicHeader,
+		}
+	}
+	textureCount := order.Uint32(container[0x04:0x08])
+	if textureCount == 0 {
+		return nil, &SectionError{
+			Section:  BNTX_MAGIC_HEADER,
+			Offset:   offset + bntxHeaderSize + 0x04,
+			Field:    "texture count",
+			Expected: 1,
+			Actual:   0,
+			Err:      ErrValueOutOfRange,
+		}
+	}
+	infoPointers := order.Uint64(container[0x08:0x10])
+	t.brtdStart = int(order.Uint64(container[0x10:0x18]))
+
+	brtiPointer, err := t.readPointer(int(infoPointers), "texture info pointer", offset)
+	if err != nil {
+		return nil, err
+	}
+	t.brtiStart = int(brtiPointer)
+	brti, err := sliceSection(raw, BRTI_MAGIC_HEADER, "texture info", t.brtiStart, t.brtiStart+brtiSize)
+	if err != nil {
+		return nil, bntxError(err, offset)
+	}
+	err = checkMagicHeader(BRTI_MAGIC_HEADER, offset+t.brtiStart, string(brti[0:4]))
+	if err != nil {
+		return nil, err
+	}
+
+	t.TileMode = order.Uint16(brti[0x12:0x14])
+	t.MipCount = order.Uint16(brti[0x16:0x18])
+	t.Format = order.Uint32(brti[0x1C:0x20])
+	t.Width = order.Uint32(brti[0x24:0x28])
+	t.Height = order.Uint32(brti[0x28:0x2C])
+	t.ArrayLength = order.Uint32(brti[0x30:0x34])
+	t.BlockHeightLog2 = uint(order.Uint32(brti[bntxTextureLayout:bntxTextureLayout+4]) & 7)
+	imageSize := int(order.Uint32(brti[bntxImageSize : bntxImageSize+4]))
+	t.Alignment = order.Uint32(brti[0x54:0x58])
+
+	// font sheets have no mipmaps and are block linear
+	if t.MipCount != 1 {
+		return nil, &SectionError{
+			Section: BRTI_MAGIC_HEADER,
+			Offset:  offset + t.brtiStart + 0x16,
+			Field:   fmt.Sprintf("%d mip levels", t.MipCount),
+			Err:     ErrUnsupportedFormat,
+		}
+	}
+	if t.TileMode == tegraTileModeLinear {
+		return nil, &SectionError{
+			Section: BRTI_MAGIC_HEADER,
+			Offset:  offset + t.brtiStart + 0x12,
+			Field:   "pitch linear texture",
+			Err:     ErrUnsupportedFormat,
+		}
+	}
+
+	brtd, err := sliceSection(raw, BRTD_MAGIC_HEADER, "header", t.brtdStart, t.brtdStart+0x10)
+	if err != nil {
+		return nil, bntxError(err, offset)
+	}
+	err = checkMagicHeader(BRTD_MAGIC_HEADER, offset+t.brtdStart, string(brtd[0:4]))
+	if err != nil {
+		return nil, err
+	}
+
+	mipOffsets := int(order.Uint64(brti[bntxMipOffsetsAddr : bntxMipOffsetsAddr+8]))
+	dataStart, err := t.readPointer(mipOffsets, "image data pointer", offset)
+	if err != nil {
+		return nil, err
+	}
+	t.dataStart = int(dataStart)
+	if t.dataStart < t.brtdStart+0x10 {
+		return nil, &SectionError{
+			Section:  BRTD_MAGIC_HEADER,
+			Offset:   offset + mipOffsets,
+			Field:    "image data pointer",
+			Expected: offset + t.brtdStart + 0x10,
+			Actual:   offset + t.dataStart,
+			Err:      ErrInvalidRange,
+		}
+	}
+	t.Data, err = sliceSection(raw, BRTD_MAGIC_HEADER, "image data", t.dataStart, t.dataStart+imageSize)
+	if err != nil {
+		return nil, bntxError(err, offset)
+	}
+
+	return t, nil
+}
+
+func (t *bntxTexture) readPointer(at int, field string, offset int) (uint64, error) {
+	pointer, err := sliceSection(t.raw, BNTX_MAGIC_HEADER, field, at, at+8)
+	if err != nil {
+		return 0, bntxError(err, offset)
+	}
+	return t.order.Uint64(pointer), nil
+}
+
+// Moves the offset of a SectionError from the start of the BNTX to the start
+// of the font file
+func bntxError(err error, offset int) error {
+	if sectionErr, ok := err.(*SectionError); ok {
+		sectionErr.Offset += offset
+	}
+	return err
+}
+
+// Bytes of one layer, the first layer is padded to the alignment of the
+// texture
+func (t *bntxTexture) layerSize(surfaceSize int) int {
+	if t.Alignment == 0 {
+		return surfaceSize
+	}
+	return int(alignUp(uint(surfaceSize), uint(t.Alignment)))
+}
+
+// Writes the BNTX the texture was decoded from with the new size and data.
+// Everything after the image data moves by the difference in size, the
+// offsets pointing there and the relocation table section holding the data
+// are moved along.
+func (t *bntxTexture) Encode() ([]byte, error) {
+	order := t.order
+	oldImageSize := int(order.Uint32(t.raw[t.brtiStart+bntxImageSize:]))
+	oldDataEnd := t.dataStart + oldImageSize
+	fileSize := int(order.Uint32(t.raw[0x1C:0x20]))
+	delta := len(t.Data) - oldImageSize
+
+	res := make([]byte, 0, fileSize+delta)
+	res = append(res, t.raw[:t.dataStart]...)
+	res = append(res, t.Data...)
+	res = append(res, t.raw[oldDataEnd:fileSize]...)
+
+	moved := func(at int) {
+		value := int(order.Uint32(res[at:]))
+		if value >= oldDataEnd {
+			order.PutUint32(res[at:], uint32(value+delta))
+		}
+	}
+
+	// header
+	order.PutUint32(res[0x1C:], uint32(fileSize+delta))
+	moved(0x10) // file name
+	moved(0x18) // relocation table
+
+	// texture info
+	brti := res[t.brtiStart:]
+	order.PutUint16(brti[0x16:], t.MipCount)
+	order.PutUint32(brti[0x1C:], t.Format)
+	order.PutUint32(brti[0x24:], t.Width)
+	order.PutUint32(brti[0x28:], t.Height)
+	order.PutUint32(brti[0x30:], t.ArrayLength)
+	textureLayout := order.Uint32(brti[bntxTextureLayout:])
+	order.PutUint32(brti[bntxTextureLayout:], textureLayout&^7|uint32(t.BlockHeightLog2))
+	order.PutUint32(brti[bntxImageSize:], uint32(len(t.Data)))
+
+	// the data block grows with the data, the offset of the next block is
+	// relative to the block
+	brtd := res[t.brtdStart:]
+	if next := order.Uint32(brtd[0x04:]); next != 0 {
+		order.PutUint32(brtd[0x04:], uint32(int(next)+delta))
+	}
+	order.PutUint32(brtd[0x08:], uint32(int(order.Uint32(brtd[0x08:]))+delta))
+
+	rltStart := int(order.Uint32(res[0x18:]))
+	if rltStart == 0 {
+		return res, nil
+	}
+	rlt, err := sliceSection(res, RLT_MAGIC_HEADER, "header", rltStart, rltStart+rltHeaderSize)
+	if err != nil {
+		return nil, err
+	}
+	err = checkMagicHeader(RLT_MAGIC_HEADER, rltStart, string(rlt[0:4]))
+	if err != nil {
+		return nil, err
+	}
+	order.PutUint32(rlt[0x04:], uint32(rltStart))
+
+	// Every section is a range of the file with pointers in it. The section
+	// holding the data grows, the sections after it move.
+	sectionCount := int(order.Uint32(rlt[0x08:]))
+	sectionsStart := rltStart + rltHeaderSize
+	sections, err := sliceSection(res, RLT_MAGIC_HEADER, "sections", sectionsStart, sectionsStart+sectionCount*rltSectionSize)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < sectionCount; i++ {
+		section := sections[i*rltSectionSize:]
+		position := int(order.Uint32(section[0x08:]))
+		size := int(order.Uint32(section[0x0C:]))
+		if position >= oldDataEnd {
+			order.PutUint32(section[0x08:], uint32(position+delta))
+		} else if position+size >= oldDataEnd {
+			order.PutUint32(section[0x0C:], uint32(size+delta))
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,893 @@
+package bffnt_headers
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"bffnt/bcml"
+	"bffnt/rstb"
+	"bffnt/sarc"
+	"bffnt/yaz0"
+
+	"github.com/disintegration/imaging"
+)
+
+const defaultFontArchive = "./WiiU_fonts/botw/Font_EU.sbfarc"
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"info", "print the sections of a bffnt file or of every font in an archive", runInfo},
+	{"extract", "write the fonts of an archive and the sheets of every font as png", runExtract},
+	{"decompress", "write the decompressed .sarc of a Yaz0 compressed archive", runDecompress},
+	{"upscale", "upscale a font and draw its glyphs from a font file", runUpscale},
+	{"add-glyphs", "draw the characters a font does not have from a font file and add them", runAddGlyphs},
+	{"subset", "remove every glyph a text does not use from the fonts", runSubset},
+	{"render", "draw a text with a font the way the game lays it out into a png", runRender},
+	{"compare", "measure how an upscaled font differs from the original scaled up", runCompare},
+	{"export", "write fonts in another format: export bmfont", runExport},
+	{"draw-sheet", "draw the upscaled sheet of a font into a png without encoding it", runDrawSheet},
+	{"pack", "write a BCML mod folder and .bnp with upscaled bffnt files", runPack},
+	{"verify", "decode and encode every font again and check nothing changed", runVerify},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: bffnt [-d] <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "run bffnt <command> -h for the flags of a command")
+}
+
+func Run() {
+	flag.BoolVar(&Debug, "d", false, "enable debug output")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	name := flag.Arg(0)
+	for _, c := range commands {
+		if c.name == name {
+			err := c.run(flag.Args()[1:])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "bffnt %s: %v\n", name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "unknown command", name)
+	usage()
+	os.Exit(2)
+}
+
+func newFlagSet(name string, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: bffnt %s %s\n", name, usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// Wii U .bffnt, 3DS .bcfnt or Wii .brfnt font file
+func isFontFile(filename string) bool {
+	ext := filepath.Ext(filename)
+	return strings.EqualFold(ext, ".bffnt") || strings.EqualFold(ext, ".bcfnt") || strings.EqualFold(ext, ".brfnt")
+}
+
+// The fonts in a bffnt file or an archive by name. Only the font called
+// fontName is returned when it is set.
+func readFonts(inputFile string, fontName string) (names []string, fonts map[string][]byte, err error) {
+	fonts = map[string][]byte{}
+
+	if isFontFile(inputFile) {
+		bffntRaw, err := ioutil.ReadFile(inputFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		name := filepath.Base(inputFile)
+		return []string{name}, map[string][]byte{name: bffntRaw}, nil
+	}
+
+	archive, err := readFontArchive(inputFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, file := range archive.Files {
+		if !isFontFile(file.Name) {
+			continue
+		}
+		if fontName != "" && file.Name != fontName+"_00.bffnt" {
+			continue
+		}
+		names = append(names, file.Name)
+		fonts[file.Name] = file.Data
+	}
+	if len(names) == 0 {
+		return nil, nil, fmt.Errorf("%w: no font %s in %s", sarc.ErrNotFound, fontName, inputFile)
+	}
+
+	return names, fonts, nil
+}
+
+func runInfo(args []string) error {
+	flags := newFlagSet("info", "-i <bffnt or archive> [-font <name>]")
+	inputFile := flags.String("i", defaultFontArchive, "bffnt file or font archive")
+	fontName := flags.String("font", "", "only this font of the archive, like Normal")
+	flags.Parse(args)
+
+	names, fonts, err := readFonts(*inputFile, *fontName)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		var bffnt BFFNT
+		err := bffnt.Decode(fonts[name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		printInfo(name, &bffnt)
+	}
+
+	return nil
+}
+
+func printInfo(name string, b *BFFNT) {
+	tglp := b.TGLP
+	format := "unknown"
+	if _, f, err := tglp.sheetSurface(0); err == nil {
+		format = f.Name
+	}
+
+	fmt.Println(name)
+	fmt.Printf("  file           %s (%s), %s\n", b.FFNT.MagicHeader, tglp.Platform, b.FFNT.order())
+	fmt.Printf("  version        0x%08X\n", b.FFNT.Version)
+	fmt.Printf("  font type      %d\n", b.FINF.FontType)
+	fmt.Printf("  size           %dx%d, ascent %d, line feed %d\n", b.FINF.Width, b.FINF.Height, b.FINF.Ascent, b.FINF.LineFeed)
+	fmt.Printf("  sheets         %d of %dx%d %s, %d bytes each\n", tglp.NumOfSheets, tglp.SheetWidth, tglp.SheetHeight, format, tglp.SheetSize)
+	fmt.Printf("  cells          %dx%d, %d columns, %d rows, baseline %d\n", tglp.CellWidth, tglp.CellHeight, tglp.NumOfColumns, tglp.NumOfRows, tglp.BaselinePosition)
+	fmt.Printf("  glyphs         %d\n", len(b.GlyphIndexes()))
+	fmt.Printf("  CWDH sections  %d\n", len(b.CWDHs))
+	fmt.Printf("  CMAP sections  %d\n", len(b.CMAPs))
+	fmt.Printf("  kerning        %d first characters\n", len(b.KRNG.KerningTable))
+}
+
+func runExtract(args []string) error {
+	flags := newFlagSet("extract", "-i <bffnt or archive> [-o <dir>] [-font <name>]")
+	inputFile := flags.String("i", defaultFontArchive, "bffnt file or font archive")
+	outputDir := flags.String("o", ".", "folder the files are written to")
+	fontName := flags.String("font", "", "only this font of the archive, like Normal")
+	flags.Parse(args)
+
+	err := os.MkdirAll(*outputDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	names, fonts, err := readFonts(*inputFile, *fontName)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !isFontFile(*inputFile) {
+			err := writeFile(filepath.Join(*outputDir, name), fonts[name])
+			if err != nil {
+				return err
+			}
+		}
+
+		var bffnt BFFNT
+		err := bffnt.Decode(fonts[name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for i := range bffnt.TGLP.SheetData {
+			sheetFile := fmt.Sprintf("%s_Sheet_%d.png", strings.TrimSuffix(name, filepath.Ext(name)), i)
+			err := writePng(filepath.Join(*outputDir, sheetFile), &bffnt.TGLP.SheetData[i])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func runDecompress(args []string) error {
+	flags := newFlagSet("decompress", "-i <archive>")
+	inputFile := flags.String("i", defaultFontArchive, "Yaz0 compressed archive, written next to it as .sarc")
+	flags.Parse(args)
+
+	return decompressArchive(*inputFile)
+}
+
+// Adds the flags that pick a font profile
+func profileFlags(flags *flag.FlagSet) (profileFile *string, fontName *string, fontFile *string) {
+	profileFile = flags.String("profile", "", "font profile file, "+defaultProfileDir+"/<font>.json by default")
+	fontName = flags.String("font", "", "font in the archive, like Normal. The name in the profile by default")
+	fontFile = flags.String("ttf", "", "ttf or otf file to draw the glyphs from instead of the one in the profile")
+	return
+}
+
+// The profile picked by -profile or -font, with the font file of -ttf
+func loadProfile(flags *flag.FlagSet, profileFile string, fontName string, fontFile string) (*FontProfile, error) {
+	if profileFile == "" && fontName == "" {
+		fmt.Fprintln(os.Stderr, "-profile or -font is required")
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var profile *FontProfile
+	var err error
+	if profileFile != "" {
+		profile, err = LoadFontProfile(profileFile)
+	} else {
+		profile, err = loadBotwFontProfile(fontName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if fontFile != "" {
+		profile.FontFile, err = filepath.Abs(fontFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fontName != "" {
+		profile.Name = fontName
+	}
+
+	return profile, nil
+}
+
+// Adds the flag that picks the quality of lossy sheet formats
+func qualityFlag(flags *flag.FlagSet) *string {
+	return flags.String("quality", "medium", "how closely sheets in lossy formats (BC1-BC5, ETC1) match the drawn glyphs: low, medium or high. Higher is slower")
+}
+
+func parseQuality(flags *flag.FlagSet, name string) EncodeQuality {
+	quality, ok := encodeQualities[name]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown -quality", name)
+		flags.Usage()
+		os.Exit(2)
+	}
+	return quality
+}
+
+func runUpscale(args []string) error {
+	flags := newFlagSet("upscale", "-font <name> | -profile <profile> [-ttf <font file>] [-scale 2] [-fit] [-kerning] [-quality medium] [-i <archive>] [-o <archive or bffnt>]")
+	inputFile := flags.String("i", defaultFontArchive, "bffnt file or font archive")
+	outputFile := flags.String("o", "./Font_EU.sbfarc", "upscaled .bffnt, or the archive with the font replaced (.sbfarc compressed, .sarc not)")
+	profileFile, fontName, fontFile := profileFlags(flags)
+	scale := flags.Float64("scale", 2, "1 for 1280x720 (original), 2 for 2560x1440, 3 for 3840x2160")
+	pngFile := flags.String("png", "", "also write the drawn sheet to this png")
+	fit := flags.Bool("fit", false, "fit the glyph widths to the font file instead of using the widths of the profile, and report the glyphs that are still off")
+	fontKerning := flags.Bool("kerning", false, "take the kerning from the kern and GPOS tables of the font file instead of scaling the font's kerning")
+	quality := qualityFlag(flags)
+	flags.Parse(args)
+	sheetQuality := parseQuality(flags, *quality)
+	profile, err := loadProfile(flags, *profileFile, *fontName, *fontFile)
+	if err != nil {
+		return err
+	}
+
+	names, fonts, err := readFonts(*inputFile, profile.Name)
+	if err != nil {
+		return err
+	}
+	upscaledRaw, sheet, outliers, err := upscaleBffnt(fonts[names[0]], profile, *scale, *fit, *fontKerning, sheetQuality)
+	if err != nil {
+		return fmt.Errorf("%s: %w", names[0], err)
+	}
+	if *pngFile != "" {
+		err := writePng(*pngFile, sheet)
+		if err != nil {
+			return err
+		}
+	}
+	if *fit {
+		printWidthFitReport(os.Stdout, names[0], outliers)
+	}
+
+	return writeFonts(*inputFile, *outputFile, map[string][]byte{names[0]: upscaledRaw})
+}
+
+func runAddGlyphs(args []string) error {
+	flags := newFlagSet("add-glyphs", "-font <name> | -profile <profile> [-ttf <font file>] -chars <codes> | -text <file> [-scale 1] [-quality medium] [-i <archive>] [-o <archive or bffnt>]")
+	inputFile := flags.String("i", defaultFontArchive, "bffnt file or font archive")
+	outputFile := flags.String("o", "./Font_EU.sbfarc", ".bffnt with the added glyphs, or the archive with the font replaced (.sbfarc compressed, .sarc not)")
+	profileFile, fontName, fontFile := profileFlags(flags)
+	chars := flags.String("chars", "", "hex character codes and ranges to add, like U+0100-U+017F,1EA0")
+	textFile := flags.String("text", "", "add every character of this UTF-8 text the font does not have")
+	scale := flags.Float64("scale", 1, "what the font was upscaled by, 1 for an original font")
+	quality := qualityFlag(flags)
+	flags.Parse(args)
+	sheetQuality := parseQuality(flags, *quality)
+
+	if *chars == "" && *textFile == "" {
+		fmt.Fprintln(os.Stderr, "-chars or -text is required")
+		flags.Usage()
+		os.Exit(2)
+	}
+	profile, err := loadProfile(flags, *profileFile, *fontName, *fontFile)
+	if err != nil {
+		return err
+	}
+	charList, err := parseCharList(*chars)
+	if err != nil {
+		return err
+	}
+	if *textFile != "" {
+		text, err := ioutil.ReadFile(*textFile)
+		if err != nil {
+			return err
+		}
+		charList = append(charList, []rune(string(text))...)
+	}
+
+	names, fonts, err := readFonts(*inputFile, profile.Name)
+	if err != nil {
+		return err
+	}
+	font, err := DecodeFont(fonts[names[0]])
+	if err != nil {
+		return fmt.Errorf("%s: %w", names[0], err)
+	}
+	added, skipped, err := addGlyphs(font, profile, *scale, charList)
+	if err != nil {
+		return err
+	}
+	fmt.Println("added", len(added), "glyphs:", string(added))
+	if len(skipped) > 0 {
+		fmt.Println("skipped", len(skipped), "characters the font file or a bffnt can not have:", string(skipped))
+	}
+
+	font.BFFNT.TGLP.SheetQuality = sheetQuality
+	bffntRaw, err := font.Encode()
+	if err != nil {
+		return err
+	}
+	fmt.Println("sheets:", font.BFFNT.TGLP.NumOfSheets)
+	return writeFonts(*inputFile, *outputFile, map[string][]byte{names[0]: bffntRaw})
+}
+
+func runSubset(args []string) error {
+	flags := newFlagSet("subset", "[-i <bffnt or archive>] [-font <name>] [-o <bffnt or archive>] [-chars <codes>] <text files>")
+	inputFile := flags.String("i", defaultFontArchive, "bffnt file or font archive")
+	outputFile := flags.String("o", "./Font_EU.sbfarc", "subset .bffnt, or the archive with the fonts replaced (.sbfarc compressed, .sarc not)")
+	fontName := flags.String("font", "", "only this font of the archive, like Normal. Every font by default")
+	chars := flags.String("chars", "", "hex character codes and ranges to keep as well, like U+0020-U+007E")
+	flags.Parse(args)
+
+	keep, err := parseCharList(*chars)
+	if err != nil {
+		return err
+	}
+	for _, textFile := range flags.Args() {
+		text, err := ioutil.ReadFile(textFile)
+		if err != nil {
+			return err
+		}
+		keep = append(keep, []rune(string(text))...)
+	}
+	if len(keep) == 0 {
+		fmt.Fprintln(os.Stderr, "no text files or -chars, every glyph would be removed")
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	names, fonts, err := readFonts(*inputFile, *fontName)
+	if err != nil {
+		return err
+	}
+	subsets := make(map[string][]byte, len(names))
+	for _, name := range names {
+		font, err := DecodeFont(fonts[name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		removed, missing, err := subsetFont(font, keep)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		err = font.PackSheets()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		subsets[name], err = font.Encode()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		fmt.Printf("%s: kept %d glyphs, removed %d, %d bytes saved (%d to %d)\n",
+			name, len(font.Runes()), len(removed), len(fonts[name])-len(subsets[name]), len(fonts[name]), len(subsets[name]))
+		if len(missing) > 0 {
+			fmt.Printf("%s does not have %d characters of the text: %s\n", name, len(missing), string(missing))
+		}
+	}
+
+	return writeFonts(*inputFile, *outputFile, subsets)
+}
+
+func runRender(args []string) error {
+	flags := newFlagSet("render", "[-i <bffnt or archive>] [-font <name>] [-width <pixels>] [-o <png>] <text> | -text <file>")
+	inputFile := flags.String("i", defaultFontArchive, "bffnt file or font archive")
+	fontName := flags.String("font", "Normal", "font of the archive to draw with")
+	outputFile := flags.String("o", "text.png", "png to write")
+	textFile := flags.String("text", "", "draw the UTF-8 text of this file instead of the arguments")
+	maxWidth := flags.Int("width", 0, "wrap lines longer than this many pixels, 0 to only break lines at newlines")
+	background := flags.String("background", "000000", "RRGGBB or RRGGBBAA color behind the text")
+	zoom := flags.Int("zoom", 1, "scale the png up by this factor")
+	flags.Parse(args)
+
+	// \n in the arguments breaks lines, shells make passing newlines awkward
+	text := strings.ReplaceAll(strings.Join(flags.Args(), " "), `\n`, "\n")
+	if *textFile != "" {
+		raw, err := ioutil.ReadFile(*textFile)
+		if err != nil {
+			return err
+		}
+		text = string(raw)
+	}
+	if text == "" || *zoom < 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	backgroundColor, err := parseHexColor(*background)
+	if err != nil {
+		return err
+	}
+
+	names, fonts, err := readFonts(*inputFile, *fontName)
+	if err != nil {
+		return err
+	}
+	var bffnt BFFNT
+	err = bffnt.Decode(fonts[names[0]])
+	if err != nil {
+		return fmt.Errorf("%s: %w", names[0], err)
+	}
+
+	layout := bffnt.LayoutWrapped(text, *maxWidth)
+	fmt.Printf("%s: %d lines, %dx%d pixels\n", names[0], layout.Lines, layout.Width, layout.Height)
+	if len(layout.Missing) > 0 {
+		fmt.Println("drawn with the alter char, the font does not have them:", string(layout.Missing))
+	}
+
+	img := bffnt.DrawLayout(layout, backgroundColor)
+	if *zoom > 1 {
+		img = imaging.Resize(img, img.Bounds().Dx()**zoom, img.Bounds().Dy()**zoom, imaging.NearestNeighbor)
+	}
+	return writePng(*outputFile, img)
+}
+
+func runCompare(args []string) error {
+	flags := newFlagSet("compare", "-upscaled <bffnt or archive> [-i <archive>] [-font <name>] [-scale 2] [-filter lanczos] [-o <png>] [text]")
+	inputFile := flags.String("i", defaultFontArchive, "original bffnt file or font archive")
+	upscaledFile := flags.String("upscaled", "", "upscaled bffnt file or font archive")
+	fontName := flags.String("font", "Normal", "font of the archives to compare")
+	scale := flags.Float64("scale", 2, "what the font was upscaled by")
+	filter := flags.String("filter", "lanczos", "filter scaling the original up: nearest, box, linear, catmullrom or lanczos")
+	outputFile := flags.String("o", "compare.png", "png with the original scaled up, the upscaled font and the difference (original red, upscaled green)")
+	textFile := flags.String("text", "", "compare the UTF-8 text of this file instead of the arguments or a sample text")
+	glyphCount := flags.Int("glyphs", 20, "how many of the glyphs that drifted the most to print")
+	flags.Parse(args)
+
+	resampleFilter, ok := compareFilters[*filter]
+	if *upscaledFile == "" || !ok || *scale <= 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	text := strings.ReplaceAll(strings.Join(flags.Args(), " "), `\n`, "\n")
+	if *textFile != "" {
+		raw, err := ioutil.ReadFile(*textFile)
+		if err != nil {
+			return err
+		}
+		text = string(raw)
+	}
+	if text == "" {
+		text = defaultCompareText
+	}
+
+	decode := func(inputFile string) (*BFFNT, error) {
+		names, fonts, err := readFonts(inputFile, *fontName)
+		if err != nil {
+			return nil, err
+		}
+		var b BFFNT
+		err = b.Decode(fonts[names[0]])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", names[0], err)
+		}
+		return &b, nil
+	}
+	original, err := decode(*inputFile)
+	if err != nil {
+		return err
+	}
+	upscaled, err := decode(*upscaledFile)
+	if err != nil {
+		return err
+	}
+
+	comparison, img := compareFonts(original, upscaled, text, *scale, resampleFilter)
+	printCompareReport(os.Stdout, comparison, *glyphCount)
+	return writePng(*outputFile, img)
+}
+
+func runExport(args []string) error {
+	if len(args) == 0 || args[0] != "bmfont" {
+		fmt.Fprintln(os.Stderr, "usage: bffnt export bmfont [flags]")
+		os.Exit(2)
+	}
+	return runExportBMFont(args[1:])
+}
+
+func runExportBMFont(args []string) error {
+	flags := newFlagSet("export bmfont", "-i <bffnt or archive> [-o <dir>] [-font <name>] [-binary]")
+	inputFile := flags.String("i", defaultFontArchive, "bffnt file or font archive")
+	outputDir := flags.String("o", ".", "folder the .fnt and png files are written to")
+	fontName := flags.String("font", "", "only this font of the archive, like Normal")
+	binaryFormat := flags.Bool("binary", false, "write binary .fnt files instead of text")
+	flags.Parse(args)
+
+	err := os.MkdirAll(*outputDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	names, fonts, err := readFonts(*inputFile, *fontName)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		var bffnt BFFNT
+		err := bffnt.Decode(fonts[name])
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		// the binary format wants page names of the same length
+		face := strings.TrimSuffix(name, filepath.Ext(name))
+		digits := len(strconv.Itoa(len(bffnt.TGLP.SheetData) - 1))
+		pageFile := func(sheet int) string {
+			return fmt.Sprintf("%s_%0*d.png", face, digits, sheet)
+		}
+		for i := range bffnt.TGLP.SheetData {
+			err := writePng(filepath.Join(*outputDir, pageFile(i)), &bffnt.TGLP.SheetData[i])
+			if err != nil {
+				return err
+			}
+		}
+
+		bmfont := bffnt.BMFont(face, pageFile)
+		fntFile := filepath.Join(*outputDir, face+".fnt")
+		if *binaryFormat {
+			err := writeFile(fntFile, bmfont.EncodeBinary())
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		var text bytes.Buffer
+		err = bmfont.WriteText(&text)
+		if err != nil {
+			return err
+		}
+		err = writeFile(fntFile, text.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runDrawSheet(args []string) error {
+	flags := newFlagSet("draw-sheet", "-font <name> | -profile <profile> [-ttf <font file>] [-scale 2] [-i <archive>] [-o <png>]")
+	inputFile := flags.String("i", defaultFontArchive, "bffnt file or font archive")
+	outputFile := flags.String("o", "", "png to write, <font>_00_<scale>x.png by default")
+	profileFile, fontName, fontFile := profileFlags(flags)
+	scale := flags.Float64("scale", 2, "1 for 1280x720 (original), 2 for 2560x1440, 3 for 3840x2160")
+	flags.Parse(args)
+	profile, err := loadProfile(flags, *profileFile, *fontName, *fontFile)
+	if err != nil {
+		return err
+	}
+
+	if *outputFile == "" {
+		*outputFile = fmt.Sprintf("%s_00_%.2fx.png", profile.Name, *scale)
+	}
+
+	names, fonts, err := readFonts(*inputFile, profile.Name)
+	if err != nil {
+		return err
+	}
+	var bffnt BFFNT
+	err = bffnt.Decode(fonts[names[0]])
+	if err != nil {
+		return fmt.Errorf("%s: %w", names[0], err)
+	}
+	bffnt.Upscale(*scale)
+	sheet, _, err := bffnt.generateTexture(profile, *scale)
+	if err != nil {
+		return err
+	}
+	return writePng(*outputFile, sheet)
+}
+
+func runPack(args []string) error {
+	settings := defaultModSettings
+	flags := newFlagSet("pack", "-o <mod folder> [flags] <bffnt files>")
+	modDir := flags.String("o", settings.Name, "mod folder to write, the .bnp is written next to it. Its name is the mod name")
+	flags.StringVar(&settings.Version, "version", settings.Version, "mod version")
+	flags.IntVar(&settings.Priority, "priority", settings.Priority, "BCML priority")
+	flags.StringVar(&settings.BaseArchive, "base", settings.BaseArchive, "original font archive the fonts are put into")
+	flags.StringVar(&settings.RSTBFile, "rstb", "", "ResourceSizeTable.product.srsizetable of the game to patch and put in the mod")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "no bffnt files to pack")
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	settings.Name = filepath.Base(filepath.Clean(*modDir))
+	return packageMod(*modDir, settings, flags.Args())
+}
+
+func runVerify(args []string) error {
+	flags := newFlagSet("verify", "-i <bffnt or archive> [-font <name>]")
+	inputFile := flags.String("i", defaultFontArchive, "bffnt file or font archive")
+	fontName := flags.String("font", "", "only this font of the archive, like Normal")
+	flags.Parse(args)
+
+	names, fonts, err := readFonts(*inputFile, *fontName)
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, name := range names {
+		err := verifyRoundTrip(fonts[name])
+		if err != nil {
+			fmt.Println(name, "FAIL:", err)
+			failed++
+			continue
+		}
+		fmt.Println(name, "OK")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d fonts changed", failed, len(names))
+	}
+	return nil
+}
+
+// Lossy sheet formats are not encoded to the same bytes again, so sheets may
+// change a little. The rest of the font has to stay the same.
+const (
+	verifyMaxSheetDiff  = 32
+	verifyMeanSheetDiff = 0.05
+)
+
+// Decodes a font, encodes it and decodes the result again. Returns what
+// changed on the way.
+func verifyRoundTrip(bffntRaw []byte) error {
+	var bffnt BFFNT
+	err := bffnt.Decode(bffntRaw)
+	if err != nil {
+		return err
+	}
+	encoded, err := bffnt.Encode()
+	if err != nil {
+		return err
+	}
+	var reencoded BFFNT
+	err = reencoded.Decode(encoded)
+	if err != nil {
+		return fmt.Errorf("encoded font does not decode: %w", err)
+	}
+
+	if len(encoded) != len(bffntRaw) {
+		return fmt.Errorf("%w: encoded to %d bytes, was %d", ErrSizeMismatch, len(encoded), len(bffntRaw))
+	}
+	if bffnt.FINF != reencoded.FINF {
+		return fmt.Errorf("FINF changed")
+	}
+	if len(bffnt.GlyphIndexes()) != len(reencoded.GlyphIndexes()) {
+		return fmt.Errorf("glyph count changed from %d to %d", len(bffnt.GlyphIndexes()), len(reencoded.GlyphIndexes()))
+	}
+	for i, cwdh := range bffnt.CWDHs {
+		for j, glyph := range cwdh.Glyphs {
+			if glyph != reencoded.CWDHs[i].Glyphs[j] {
+				return fmt.Errorf("width of glyph %d in CWDH %d changed", j, i)
+			}
+		}
+	}
+
+	for i := range bffnt.TGLP.SheetData {
+		maxDiff, meanDiff := sheetDiff(&bffnt.TGLP.SheetData[i], &reencoded.TGLP.SheetData[i])
+		if maxDiff > verifyMaxSheetDiff || meanDiff >= verifyMeanSheetDiff {
+			return fmt.Errorf("sheet %d changed by up to %d, %.3f on average", i, maxDiff, meanDiff)
+		}
+	}
+
+	return nil
+}
+
+// Biggest and average difference of any channel between two sheets
+func sheetDiff(a *image.NRGBA, b *image.NRGBA) (maxDiff int, meanDiff float64) {
+	totalDiff := 0
+	for i := range a.Pix {
+		diff := int(a.Pix[i]) - int(b.Pix[i])
+		if diff < 0 {
+			diff = -diff
+		}
+		if diff > maxDiff {
+			maxDiff = diff
+		}
+		totalDiff += diff
+	}
+	return maxDiff, float64(totalDiff) / float64(len(a.Pix))
+}
+
+func writeFile(filename string, data []byte) error {
+	err := os.WriteFile(filename, data, 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Println("wrote", filename, len(data), "bytes")
+	return nil
+}
+
+// Color of RRGGBB or RRGGBBAA hex
+func parseHexColor(hex string) (color.NRGBA, error) {
+	hex = strings.TrimPrefix(hex, "#")
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || (len(hex) != 6 && len(hex) != 8) {
+		return color.NRGBA{}, fmt.Errorf("%q is not a RRGGBB or RRGGBBAA color", hex)
+	}
+	if len(hex) == 6 {
+		value = value<<8 | 0xff
+	}
+	return color.NRGBA{R: uint8(value >> 24), G: uint8(value >> 16), B: uint8(value >> 8), A: uint8(value)}, nil
+}
+
+func writePng(filename string, img image.Image) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	err = png.Encode(f, img)
+	if err != nil {
+		return err
+	}
+	fmt.Println("wrote", filename)
+	return nil
+}
+
+// Writes a font to a .bffnt, .bcfnt or .brfnt file, or replaces fonts in the
+// archive they were read from and writes the archive
+func writeFonts(inputFile string, outputFile string, fonts map[string][]byte) error {
+	if isFontFile(outputFile) {
+		if len(fonts) != 1 {
+			return fmt.Errorf("-o has to be an archive for %d fonts, got %s", len(fonts), outputFile)
+		}
+		for _, bffntRaw := range fonts {
+			return writeFile(outputFile, bffntRaw)
+		}
+	}
+	if isFontFile(inputFile) {
+		return fmt.Errorf("-o has to be a .bffnt, .bcfnt or .brfnt file when -i is one, got %s", outputFile)
+	}
+
+	archive, err := readFontArchive(inputFile)
+	if err != nil {
+		return err
+	}
+	for name, bffntRaw := range fonts {
+		err := archive.Replace(name, bffntRaw)
+		if err != nil {
+			return err
+		}
+	}
+	return writeFontArchive(archive, outputFile)
+}
+
+// Reads a font archive like Font_EU.sbfarc, Yaz0 compressed or not
+func readFontArchive(archiveFile string) (*sarc.Archive, error) {
+	fmt.Println("Reading archive", archiveFile)
+	archiveRaw, err := ioutil.ReadFile(archiveFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if yaz0.IsCompressed(archiveRaw) {
+		archiveRaw, err = yaz0.Decompress(archiveRaw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", archiveFile, err)
+		}
+	}
+
+	archive, err := sarc.Decode(archiveRaw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", archiveFile, err)
+	}
+	fmt.Println("archive files:", strings.Join(archive.Names(), ", "))
+
+	return archive, nil
+}
+
+// Writes the archive Yaz0 compressed, or as it is for .sarc files
+func writeFontArchive(archive *sarc.Archive, archiveFile string) error {
+	sarcRaw, err := archive.Encode()
+	if err != nil {
+		return err
+	}
+
+	archiveRaw := sarcRaw
+	if !strings.EqualFold(filepath.Ext(archiveFile), ".sarc") {
+		archiveRaw = yaz0.Compress(sarcRaw, yaz0.DefaultCompression)
+	}
+	err = writeFile(archiveFile, archiveRaw)
+	if err != nil {
+		return err
+	}
+
+	// the game crashes when the archive outgrows its ResourceSizeTable entry
+	_, sizePlatform := archivePlatform(archive)
+	size, err := rstb.ResourceSize("Font/"+bcml.CanonicalPath(filepath.Base(archiveFile)), sarcRaw, sizePlatform)
+	if err == nil {
+		fmt.Println("resource size:", size)
+	}
+	return nil
+}
+
+// Writes the decompressed archive next to the compressed one, Font_EU.sbfarc
+// becomes Font_EU.sarc
+func decompressArchive(archiveFile string) error {
+	fmt.Println("Reading archive", archiveFile)
+	archiveRaw, err := ioutil.ReadFile(archiveFile)
+	if err != nil {
+		return err
+	}
+
+	if !yaz0.IsCompressed(archiveRaw) {
+		fmt.Println(archiveFile, "is not Yaz0 compressed")
+		return nil
+	}
+
+	sarcRaw, err := yaz0.Decompress(archiveRaw)
+	if err != nil {
+		return fmt.Errorf("%s: %w", archiveFile, err)
+	}
+	fmt.Println("decompressed", len(archiveRaw), "bytes to", len(sarcRaw))
+
+	outputSarcFile := strings.TrimSuffix(archiveFile, filepath.Ext(archiveFile)) + ".sarc"
+	return writeFile(outputSarcFile, sarcRaw)
+}
@@ -3,9 +3,9 @@ package bffnt_headers
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
-	"errors"
 	"fmt"
+	"math"
+	"sort"
 )
 
 // A single cmap contains information about a character's texture location in
@@ -26,6 +26,55 @@ type CMAP struct { //         Offset  Size  Description
 	// texture. Characters that have an index of MaxUint16 (65535) are to be ignored.
 	CharAscii []uint16
 	CharIndex []uint16
+
+	Platform Platform // from the file magic header. Switch CMAPs have 4 byte character codes.
+
+	sectionOrder
+}
+
+// Switch CMAPs have CodeBegin and CodeEnd as 4 bytes, so the header is 24
+// bytes. A scan map has 2 bytes of padding after its character count and
+// every pair is a 4 byte character code, the 2 byte index and 2 bytes of
+// padding. Characters above U+FFFF are not supported.
+// Source: Switch Toolbox
+func (cmap *CMAP) codeSize() int {
+	if cmap.Platform == PlatformSwitch {
+		return 4
+	}
+	return 2
+}
+
+func (cmap *CMAP) headerSize() int {
+	return CMAP_HEADER_SIZE - 4 + 2*cmap.codeSize()
+}
+
+// Reads a character code of codeSize bytes
+func (cmap *CMAP) readCode(raw []byte, offset int) (uint16, error) {
+	if cmap.codeSize() == 2 {
+		return cmap.order().Uint16(raw), nil
+	}
+
+	code := cmap.order().Uint32(raw)
+	if code > math.MaxUint16 {
+		return 0, &SectionError{
+			Section:  CMAP_MAGIC_HEADER,
+			Offset:   offset,
+			Field:    fmt.Sprintf("character code %#U", rune(code)),
+			Expected: math.MaxUint16,
+			Actual:   int(code),
+			Err:      ErrValueOutOfRange,
+		}
+	}
+	return uint16(code), nil
+}
+
+// Writes a character code of codeSize bytes
+func (cmap *CMAP) writeCode(w *bufio.Writer, code uint16) {
+	if cmap.codeSize() == 2 {
+		binaryWrite(w, cmap.order(), code)
+		return
+	}
+	binaryWrite(w, cmap.order(), uint32(code))
 }
 
 type AsciiIndexPair struct {
@@ -33,29 +82,86 @@ type AsciiIndexPair struct {
 	CharIndex uint16
 }
 
-func (cmap *CMAP) Decode(allRaw []byte, cmapOffset uint32) {
-	headerStart := int(cmapOffset) - 8
-	headerEnd := headerStart + CMAP_HEADER_SIZE
-	headerRaw := allRaw[headerStart:headerEnd]
+func (cmap *CMAP) Decode(allRaw []byte, cmapOffset uint32) error {
+	cmap.byteOrder = byteOrderMark(allRaw)
+	if len(allRaw) >= 4 {
+		cmap.Platform = filePlatform(string(allRaw[0:4]), cmap.byteOrder)
+	}
 
-	assertEqual(CMAP_HEADER_SIZE, len(headerRaw))
+	codeSize := cmap.codeSize()
+	headerStart := int(cmapOffset) - 8
+	headerEnd := headerStart + cmap.headerSize()
+	headerRaw, err := sliceSection(allRaw, CMAP_MAGIC_HEADER, "header", headerStart, headerEnd)
+	if err != nil {
+		return err
+	}
 
 	cmap.MagicHeader = string(headerRaw[0:4])
-	cmap.SectionSize = binary.BigEndian.Uint32(headerRaw[4:8])
-	cmap.CodeBegin = binary.BigEndian.Uint16(headerRaw[8:10])
-	cmap.CodeEnd = binary.BigEndian.Uint16(headerRaw[10:12])
-	cmap.MappingMethod = binary.BigEndian.Uint16(headerRaw[12:14])
-	cmap.Reserved = binary.BigEndian.Uint16(headerRaw[14:16])
-	cmap.NextCMAPOffset = binary.BigEndian.Uint32(headerRaw[16:CMAP_HEADER_SIZE])
+	cmap.SectionSize = cmap.order().Uint32(headerRaw[4:8])
+	cmap.CodeBegin, err = cmap.readCode(headerRaw[8:], headerStart+8)
+	if err != nil {
+		return err
+	}
+	cmap.CodeEnd, err = cmap.readCode(headerRaw[8+codeSize:], headerStart+8+codeSize)
+	if err != nil {
+		return err
+	}
+	fields := headerRaw[8+2*codeSize:]
+	cmap.MappingMethod = cmap.order().Uint16(fields[0:2])
+	cmap.Reserved = cmap.order().Uint16(fields[2:4])
+	cmap.NextCMAPOffset = cmap.order().Uint32(fields[4:8])
 
 	if Debug {
 		pprint(cmap)
 	}
 
+	err = checkMagicHeader(CMAP_MAGIC_HEADER, headerStart, cmap.MagicHeader)
+	if err != nil {
+		return err
+	}
+
 	dataEnd := headerStart + int(cmap.SectionSize)
-	data := allRaw[headerEnd:dataEnd]
+	data, err := sliceSection(allRaw, CMAP_MAGIC_HEADER, "data", headerEnd, dataEnd)
+	if err != nil {
+		return err
+	}
 	dataPos := 0
 
+	// Every read below needs this many bytes of data. Scan maps only know how
+	// many after the first uint16 is read.
+	needed := 0
+	switch cmap.MappingMethod {
+	case 0:
+		needed = 2
+	case 1:
+		needed = 2 * (int(cmap.CodeEnd) - int(cmap.CodeBegin) + 1)
+	case 2:
+		needed = 2
+		if codeSize == 4 {
+			needed = 4
+		}
+	}
+	if cmap.MappingMethod != 2 && cmap.CodeEnd < cmap.CodeBegin {
+		return &SectionError{
+			Section:  CMAP_MAGIC_HEADER,
+			Offset:   headerStart + 8 + codeSize,
+			Field:    "CodeEnd",
+			Expected: int(cmap.CodeBegin),
+			Actual:   int(cmap.CodeEnd),
+			Err:      ErrInvalidRange,
+		}
+	}
+	if needed > len(data) {
+		return &SectionError{
+			Section:  CMAP_MAGIC_HEADER,
+			Offset:   headerStart + 4,
+			Field:    "SectionSize is too small for the mapping",
+			Expected: cmap.headerSize() + needed,
+			Actual:   int(cmap.SectionSize),
+			Err:      ErrTruncated,
+		}
+	}
+
 	indexSlice := make([]uint16, 0)
 	asciiSlice := make([]uint16, 0)
 	// Direct mapping is the most space efficient of mapping type. It is used
@@ -69,11 +175,11 @@ func (cmap *CMAP) Decode(allRaw []byte, cmapOffset uint32) {
 	// CMAPs.
 	switch cmap.MappingMethod {
 	case 0:
-		cmap.CharacterOffset = binary.BigEndian.Uint16(data[dataPos : dataPos+2])
+		cmap.CharacterOffset = cmap.order().Uint16(data[dataPos : dataPos+2])
 		dataPos += 2
-		for i := cmap.CodeBegin; i <= cmap.CodeEnd; i++ {
-			charAsciiCode := i
-			charIndex := i - cmap.CodeBegin + cmap.CharacterOffset
+		for i := int(cmap.CodeBegin); i <= int(cmap.CodeEnd); i++ {
+			charAsciiCode := uint16(i)
+			charIndex := charAsciiCode - cmap.CodeBegin + cmap.CharacterOffset
 			asciiSlice = append(asciiSlice, charAsciiCode)
 			indexSlice = append(indexSlice, charIndex)
 
@@ -87,9 +193,9 @@ func (cmap *CMAP) Decode(allRaw []byte, cmapOffset uint32) {
 	// (CodeEnd - CodeStart + 1) amount of bytes after the header. Unused
 	// characters will have an index of MaxUint16 (65535).
 	case 1:
-		for i := cmap.CodeBegin; i <= cmap.CodeEnd; i++ {
-			charAsciiCode := i
-			charIndex := binary.BigEndian.Uint16(data[dataPos : dataPos+2])
+		for i := int(cmap.CodeBegin); i <= int(cmap.CodeEnd); i++ {
+			charAsciiCode := uint16(i)
+			charIndex := cmap.order().Uint16(data[dataPos : dataPos+2])
 			asciiSlice = append(asciiSlice, charAsciiCode)
 			indexSlice = append(indexSlice, charIndex)
 
@@ -107,31 +213,54 @@ func (cmap *CMAP) Decode(allRaw []byte, cmapOffset uint32) {
 	// read in uint16 pairs. Read a uint16 for the character ascii code and
 	// then another uint16 for the character index.
 	case 2:
-		cmap.CharacterCount = binary.BigEndian.Uint16(data[dataPos : dataPos+2])
-		dataPos += 2
+		cmap.CharacterCount = cmap.order().Uint16(data[dataPos : dataPos+2])
+		dataPos += needed // the count and the padding of Switch CMAPs
+
+		entrySize := 2 * codeSize
+		needed += entrySize * int(cmap.CharacterCount)
+		if needed > len(data) {
+			return &SectionError{
+				Section:  CMAP_MAGIC_HEADER,
+				Offset:   headerEnd,
+				Field:    "CharacterCount is too big for the SectionSize",
+				Expected: cmap.headerSize() + needed,
+				Actual:   int(cmap.SectionSize),
+				Err:      ErrTruncated,
+			}
+		}
 
 		for i := uint16(0); i < cmap.CharacterCount; i++ {
-			charAsciiCode := binary.BigEndian.Uint16(data[dataPos : dataPos+2])
-			charIndex := binary.BigEndian.Uint16(data[dataPos+2 : dataPos+4])
+			charAsciiCode, err := cmap.readCode(data[dataPos:], headerEnd+dataPos)
+			if err != nil {
+				return err
+			}
+			charIndex := cmap.order().Uint16(data[dataPos+codeSize : dataPos+codeSize+2])
 			asciiSlice = append(asciiSlice, charAsciiCode)
 			indexSlice = append(indexSlice, charIndex)
 
 			// fmt.Printf("individual %#U %d\n", rune(charAsciiCode), charIndex)
 
-			dataPos += 4
+			dataPos += entrySize
 		}
 
 		break
 
 	default:
-		handleErr(errors.New("unknown mapping method"))
+		return &SectionError{
+			Section: CMAP_MAGIC_HEADER,
+			Offset:  headerStart + 12,
+			Field:   fmt.Sprintf("MappingMethod %d", cmap.MappingMethod),
+			Err:     ErrUnknownMappingMethod,
+		}
 	}
 	cmap.CharAscii = asciiSlice
 	cmap.CharIndex = indexSlice
-	assertEqual(len(cmap.CharAscii), len(cmap.CharIndex))
 
 	leftoverData := data[dataPos:]
-	verifyLeftoverBytes(leftoverData)
+	err = verifyLeftoverBytes(CMAP_MAGIC_HEADER, headerEnd+dataPos, leftoverData)
+	if err != nil {
+		return err
+	}
 
 	if Debug {
 		dataPosEnd := headerEnd + dataPos
@@ -143,26 +272,48 @@ func (cmap *CMAP) Decode(allRaw []byte, cmapOffset uint32) {
 		fmt.Printf("leftover bytes   %-8d to  %d\n", dataPosEnd, dataPosEnd+len(leftoverData))
 		fmt.Println()
 	}
+
+	return nil
 }
 
-func DecodeCMAPs(allRaw []byte, startingOffset uint32) []CMAP {
+func DecodeCMAPs(allRaw []byte, startingOffset uint32) ([]CMAP, error) {
 	res := make([]CMAP, 0)
 
 	offset := startingOffset
 	for offset != 0 {
 		var currentCMAP CMAP
-		currentCMAP.Decode(allRaw, offset)
+		err := currentCMAP.Decode(allRaw, offset)
+		if err != nil {
+			return nil, err
+		}
 		res = append(res, currentCMAP)
 
+		// A list that points backwards would never terminate
+		if currentCMAP.NextCMAPOffset != 0 && currentCMAP.NextCMAPOffset <= offset {
+			return nil, &SectionError{
+				Section:  CMAP_MAGIC_HEADER,
+				Offset:   int(offset) - 8 + currentCMAP.headerSize() - 4,
+				Field:    "NextCMAPOffset",
+				Expected: int(offset) + 1,
+				Actual:   int(currentCMAP.NextCMAPOffset),
+				Err:      ErrSectionOffsetNotAhead,
+			}
+		}
 		offset = currentCMAP.NextCMAPOffset
 	}
 
-	return res
+	return res, nil
 }
 
 // Encodes a single cmap.
 // The start offset is either FINF.CMAPOffset or the last cmap's NextCMAPOffset
-func (cmap *CMAP) Encode(startOffset uint32, isLastCMAP bool) []byte {
+func (cmap *CMAP) Encode(startOffset uint32, isLastCMAP bool) ([]byte, error) {
+	err := checkEqual(CMAP_MAGIC_HEADER, int(startOffset)-8, "CharIndex count", ErrSizeMismatch, len(cmap.CharAscii), len(cmap.CharIndex))
+	if err != nil {
+		return nil, err
+	}
+
+	order := cmap.order()
 	var cmapDataBuf bytes.Buffer
 	dataWriter := bufio.NewWriter(&cmapDataBuf)
 
@@ -170,17 +321,31 @@ func (cmap *CMAP) Encode(startOffset uint32, isLastCMAP bool) []byte {
 	// know the section size
 	switch cmap.MappingMethod {
 	case 0:
-		binaryWrite(dataWriter, cmap.CharacterOffset)
+		binaryWrite(dataWriter, order, cmap.CharacterOffset)
 	case 1:
 		for i, _ := range cmap.CharIndex {
-			binaryWrite(dataWriter, cmap.CharIndex[i])
+			binaryWrite(dataWriter, order, cmap.CharIndex[i])
 		}
 	case 2:
 		// first uint16 is amount of (charAscii, charIndex) pairs
-		binaryWrite(dataWriter, cmap.CharacterCount)
+		binaryWrite(dataWriter, order, cmap.CharacterCount)
+		wide := cmap.codeSize() == 4
+		if wide {
+			binaryWrite(dataWriter, order, uint16(0))
+		}
 		for i, _ := range cmap.CharIndex {
-			binaryWrite(dataWriter, cmap.CharAscii[i])
-			binaryWrite(dataWriter, cmap.CharIndex[i])
+			cmap.writeCode(dataWriter, cmap.CharAscii[i])
+			binaryWrite(dataWriter, order, cmap.CharIndex[i])
+			if wide {
+				binaryWrite(dataWriter, order, uint16(0))
+			}
+		}
+	default:
+		return nil, &SectionError{
+			Section: CMAP_MAGIC_HEADER,
+			Offset:  int(startOffset) - 8 + 12,
+			Field:   fmt.Sprintf("MappingMethod %d", cmap.MappingMethod),
+			Err:     ErrUnknownMappingMethod,
 		}
 	}
 	dataWriter.Flush()
@@ -188,7 +353,7 @@ func (cmap *CMAP) Encode(startOffset uint32, isLastCMAP bool) []byte {
 
 	cmapData := cmapDataBuf.Bytes()
 	// Calculate and edit the header information
-	cmap.SectionSize = uint32(CMAP_HEADER_SIZE + len(cmapData))
+	cmap.SectionSize = uint32(cmap.headerSize() + len(cmapData))
 	// Assume the startOffset already had +8 added to it to skip the magic header
 	cmap.NextCMAPOffset = startOffset + cmap.SectionSize
 
@@ -202,21 +367,25 @@ func (cmap *CMAP) Encode(startOffset uint32, isLastCMAP bool) []byte {
 
 	// Write raw data of the header and data
 	_, _ = w.Write([]byte(cmap.MagicHeader))
-	binaryWrite(w, cmap.SectionSize)
-	binaryWrite(w, cmap.CodeBegin)
-	binaryWrite(w, cmap.CodeEnd)
-	binaryWrite(w, cmap.MappingMethod)
-	binaryWrite(w, cmap.Reserved)
-	binaryWrite(w, cmap.NextCMAPOffset)
+	binaryWrite(w, order, cmap.SectionSize)
+	cmap.writeCode(w, cmap.CodeBegin)
+	cmap.writeCode(w, cmap.CodeEnd)
+	binaryWrite(w, order, cmap.MappingMethod)
+	binaryWrite(w, order, cmap.Reserved)
+	binaryWrite(w, order, cmap.NextCMAPOffset)
 	_, _ = w.Write(cmapData)
 	w.Flush()
 
 	totalBytesWithPadding := int(startOffset) + len(buf.Bytes())
-	check4ByteBoundary(totalBytesWithPadding)
-	return buf.Bytes()
+	err = check4ByteBoundary(CMAP_MAGIC_HEADER, totalBytesWithPadding)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
 }
 
-func EncodeCMAPs(CMAPs []CMAP, finfCMAPOffset int) []byte {
+func EncodeCMAPs(CMAPs []CMAP, finfCMAPOffset int) ([]byte, error) {
 	res := make([]byte, 0)
 
 	offset := uint32(finfCMAPOffset)
@@ -226,14 +395,17 @@ func EncodeCMAPs(CMAPs []CMAP, finfCMAPOffset int) []byte {
 			isLast = true
 		}
 
-		cmapBytes := currentCMAP.Encode(offset, isLast)
+		cmapBytes, err := currentCMAP.Encode(offset, isLast)
+		if err != nil {
+			return nil, err
+		}
 
 		res = append(res, cmapBytes...)
 		offset = currentCMAP.NextCMAPOffset
 
 	}
 
-	return res
+	return res, nil
 }
 
 // takes a cmap list and adds the section size together.
@@ -246,3 +418,193 @@ func totalCmapSectionSize(cmapList []CMAP) (totalSectionSize int) {
 
 	return totalSectionSize
 }
+
+// Kinds of CMAP the planner puts a character in
+const (
+	planDirect    = iota
+	planTableEven // table with an even amount of codes so far
+	planTableOdd  // table with an odd amount, its data is padded by 2 bytes
+	planScan
+	planKinds
+)
+
+// Cost and choice of the planner after a character. Costs are the bytes of
+// every CMAP so far including headers and padding.
+type cmapPlanStep struct {
+	cost   int
+	prev   int  // state of the previous character, kind + planKinds*scanUsed
+	starts bool // the character starts a new direct map or table
+	ok     bool
+}
+
+// PlanCMAPs finds the smallest chain of CMAPs mapping every character to its
+// glyph index. Characters are split into ranges of consecutive codes: a
+// direct map for ranges whose glyph indexes are consecutive too, a table for
+// ranges with few missing codes, and a single scan map for the stragglers. The
+// scan map comes last. SectionSize and CharacterOffset are set, EncodeCMAPs
+// sets NextCMAPOffset when the chain is written. Sizes are counted with 2 byte
+// character codes, the wider Switch CMAPs are encoded from the same plan.
+func PlanCMAPs(pairs []AsciiIndexPair) ([]CMAP, error) {
+	sorted := append([]AsciiIndexPair(nil), pairs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].CharAscii < sorted[j].CharAscii
+	})
+	for i, pair := range sorted {
+		if pair.CharIndex == math.MaxUint16 {
+			return nil, fmt.Errorf("%w: %q can not have glyph index %d, it marks missing characters", ErrValueOutOfRange, rune(pair.CharAscii), pair.CharIndex)
+		}
+		if i > 0 && sorted[i-1].CharAscii == pair.CharAscii {
+			return nil, fmt.Errorf("%w: %q", ErrDuplicateCode, rune(pair.CharAscii))
+		}
+	}
+	if len(sorted) == 0 {
+		return nil, nil
+	}
+
+	// A new direct map or table is a 20 byte header and 4 bytes of data. A
+	// scan map is a header, its character count and 4 bytes per character,
+	// which always ends 2 bytes short of a 4 byte boundary.
+	const newSection = CMAP_HEADER_SIZE + 4
+	const scanEntry = 4
+
+	// steps[i][state] is the cheapest chain for the first i+1 characters
+	// with character i in state. The state is the kind of CMAP the character
+	// is in and whether the scan map is used yet.
+	steps := make([][2 * planKinds]cmapPlanStep, len(sorted))
+	relax := func(i, state, cost, prev int, starts bool) {
+		step := &steps[i][state]
+		if !step.ok || cost < step.cost {
+			*step = cmapPlanStep{cost: cost, prev: prev, starts: starts, ok: true}
+		}
+	}
+
+	relax(0, planDirect, newSection, -1, true)
+	relax(0, planTableOdd, newSection, -1, true)
+	relax(0, planScan+planKinds, newSection+scanEntry, -1, false)
+	for i := 1; i < len(sorted); i++ {
+		gap := int(sorted[i].CharAscii) - int(sorted[i-1].CharAscii)
+		consecutive := gap == 1 && sorted[i].CharIndex == sorted[i-1].CharIndex+1
+
+		for prev := 0; prev < 2*planKinds; prev++ {
+			step := steps[i-1][prev]
+			if !step.ok {
+				continue
+			}
+			kind, scanUsed := prev%planKinds, prev/planKinds
+
+			// start a new direct map or table
+			relax(i, planDirect+scanUsed*planKinds, step.cost+newSection, prev, true)
+			relax(i, planTableOdd+scanUsed*planKinds, step.cost+newSection, prev, true)
+
+			// move the character to the scan map
+			if scanUsed == 1 {
+				relax(i, planScan+planKinds, step.cost+scanEntry, prev, false)
+			} else {
+				relax(i, planScan+planKinds, step.cost+newSection+scanEntry, prev, false)
+			}
+
+			switch kind {
+			case planDirect:
+				if consecutive {
+					relax(i, prev, step.cost, prev, false)
+				}
+			case planTableEven, planTableOdd:
+				// the table grows by gap codes of 2 bytes and the
+				// padding follows the new amount of codes
+				odd := (kind == planTableOdd) != (gap%2 == 1)
+				cost := step.cost + 2*gap
+				if kind == planTableOdd {
+					cost -= 2
+				}
+				next := planTableEven
+				if odd {
+					cost += 2
+					next = planTableOdd
+				}
+				relax(i, next+scanUsed*planKinds, cost, prev, false)
+			}
+		}
+	}
+
+	best := -1
+	last := steps[len(sorted)-1]
+	for state := range last {
+		if last[state].ok && (best == -1 || last[state].cost < last[best].cost) {
+			best = state
+		}
+	}
+
+	// Walk the choices back to get the kind of CMAP of every character
+	kinds := make([]int, len(sorted))
+	startsSection := make([]bool, len(sorted))
+	for i, state := len(sorted)-1, best; i >= 0; i-- {
+		kinds[i] = state % planKinds
+		startsSection[i] = steps[i][state].starts
+		state = steps[i][state].prev
+	}
+
+	var cmaps []CMAP
+	scan := CMAP{
+		MagicHeader:   CMAP_MAGIC_HEADER,
+		CodeBegin:     0,
+		CodeEnd:       math.MaxUint16,
+		MappingMethod: 2,
+	}
+	for i, pair := range sorted {
+		if kinds[i] == planScan {
+			scan.CharAscii = append(scan.CharAscii, pair.CharAscii)
+			scan.CharIndex = append(scan.CharIndex, pair.CharIndex)
+			continue
+		}
+
+		if startsSection[i] {
+			cmap := CMAP{
+				MagicHeader:     CMAP_MAGIC_HEADER,
+				CodeBegin:       pair.CharAscii,
+				MappingMethod:   0,
+				CharacterOffset: pair.CharIndex,
+			}
+			if kinds[i] != planDirect {
+				cmap.MappingMethod = 1
+				cmap.CharacterOffset = 0
+			}
+			cmaps = append(cmaps, cmap)
+		}
+
+		// tables hold every code of their range, missing ones get MaxUint16
+		cmap := &cmaps[len(cmaps)-1]
+		if cmap.MappingMethod == 1 && len(cmap.CharAscii) > 0 {
+			for code := cmap.CodeEnd + 1; code < pair.CharAscii; code++ {
+				cmap.CharAscii = append(cmap.CharAscii, code)
+				cmap.CharIndex = append(cmap.CharIndex, math.MaxUint16)
+			}
+		}
+		cmap.CodeEnd = pair.CharAscii
+		cmap.CharAscii = append(cmap.CharAscii, pair.CharAscii)
+		cmap.CharIndex = append(cmap.CharIndex, pair.CharIndex)
+	}
+	if len(scan.CharAscii) > 0 {
+		scan.CharacterCount = uint16(len(scan.CharAscii))
+		cmaps = append(cmaps, scan)
+	}
+
+	for i := range cmaps {
+		cmaps[i].SectionSize = uint32(cmaps[i].plannedSize())
+	}
+
+	return cmaps, nil
+}
+
+// Bytes of the CMAP once encoded, with padding
+func (cmap *CMAP) plannedSize() int {
+	dataSize := 0
+	switch cmap.MappingMethod {
+	case 0:
+		dataSize = 2
+	case 1:
+		dataSize = 2 * len(cmap.CharIndex)
+	case 2:
+		dataSize = 2 + 4*len(cmap.CharIndex)
+	}
+	return CMAP_HEADER_SIZE + dataSize + paddingToNext4ByteBoundary(dataSize)
+}
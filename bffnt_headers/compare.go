@@ -0,0 +1,235 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const defaultCompareText = "The quick brown fox jumps over the lazy dog.\n" +
+	"THE QUICK BROWN FOX JUMPS OVER THE LAZY DOG!\n" +
+	"0123456789 ?&%/()[]-+=:;,'\""
+
+var compareFilters = map[string]imaging.ResampleFilter{
+	"nearest":    imaging.NearestNeighbor,
+	"box":        imaging.Box,
+	"linear":     imaging.Linear,
+	"catmullrom": imaging.CatmullRom,
+	"lanczos":    imaging.Lanczos,
+}
+
+// Alpha of at least this much is ink when finding the pixels of a glyph
+const compareInkThreshold = 128
+
+// How far a glyph of the upscaled font is from the original glyph times the
+// scale, in pixels of the upscaled font. Positive is to the right or down.
+type glyphDrift struct {
+	Code uint16
+
+	// Edges of the ink of the glyph, from the pen position and baseline.
+	// Glyphs without ink, like the space, have no box drift.
+	HasInk                   bool
+	Left, Top, Right, Bottom float64
+
+	LeftWidthError float64
+	AdvanceError   float64
+}
+
+// Biggest edge drift of the ink box
+func (d glyphDrift) boxDrift() float64 {
+	if !d.HasInk {
+		return 0
+	}
+	return math.Max(math.Max(math.Abs(d.Left), math.Abs(d.Right)), math.Max(math.Abs(d.Top), math.Abs(d.Bottom)))
+}
+
+// How an upscaled font differs from the original scaled up, for a text
+type fontComparison struct {
+	Scale          float64
+	BaselineOffset float64 // upscaled baseline minus the original baseline times the scale
+	LineFeedError  float64
+	TextSizeError  image.Point // size of the upscaled text minus the original text times the scale
+	MeanPixelDiff  float64     // average alpha difference of the drawn texts, 0 to 255
+	Glyphs         []glyphDrift
+
+	// Characters of the text a font does not have. They are drawn with the
+	// alter char of the font and add to the pixel diff and text size error.
+	OriginalMissing []rune
+	UpscaledMissing []rune
+}
+
+// Draws a text with the original font scaled up with a filter and with the
+// upscaled font, and measures the difference. Returns the comparison and an
+// image of the original, the upscaled font and their difference, the
+// original in red and the upscaled font in green.
+func compareFonts(original *BFFNT, upscaled *BFFNT, text string, scale float64, filter imaging.ResampleFilter) (fontComparison, *image.NRGBA) {
+	originalLayout := original.Layout(text)
+	upscaledLayout := upscaled.Layout(text)
+
+	originalImg := original.DrawLayout(originalLayout, color.Transparent)
+	scaledWidth := int(math.Round(float64(originalLayout.Width) * scale))
+	scaledHeight := int(math.Round(float64(originalLayout.Height) * scale))
+	if scaledWidth > 0 && scaledHeight > 0 {
+		originalImg = imaging.Resize(originalImg, scaledWidth, scaledHeight, filter)
+	}
+	upscaledImg := upscaled.DrawLayout(upscaledLayout, color.Transparent)
+
+	comparison := fontComparison{
+		Scale:          scale,
+		BaselineOffset: float64(upscaled.TGLP.BaselinePosition) - float64(original.TGLP.BaselinePosition)*scale,
+		LineFeedError:  float64(upscaled.FINF.LineFeed) - float64(original.FINF.LineFeed)*scale,
+		TextSizeError:  upscaledImg.Bounds().Size().Sub(originalImg.Bounds().Size()),
+
+		OriginalMissing: originalLayout.Missing,
+		UpscaledMissing: upscaledLayout.Missing,
+	}
+
+	width := maxInt(originalImg.Bounds().Dx(), upscaledImg.Bounds().Dx())
+	height := maxInt(originalImg.Bounds().Dy(), upscaledImg.Bounds().Dy())
+	diff := image.NewNRGBA(image.Rect(0, 0, width, height))
+	totalDiff := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			originalAlpha := alphaAt(originalImg, x, y)
+			upscaledAlpha := alphaAt(upscaledImg, x, y)
+			diff.SetNRGBA(x, y, color.NRGBA{R: originalAlpha, G: upscaledAlpha, A: 255})
+			totalDiff += absInt(int(originalAlpha) - int(upscaledAlpha))
+		}
+	}
+	if width*height > 0 {
+		comparison.MeanPixelDiff = float64(totalDiff) / float64(width*height)
+	}
+
+	for _, r := range uniqueRunes([]rune(text)) {
+		originalIndex, ok := original.CWDHIndexMap[r]
+		if !ok {
+			continue
+		}
+		upscaledIndex, ok := upscaled.CWDHIndexMap[r]
+		if !ok {
+			continue
+		}
+		comparison.Glyphs = append(comparison.Glyphs, compareGlyphs(original, uint16(originalIndex), upscaled, uint16(upscaledIndex), scale, uint16(r)))
+	}
+	sort.SliceStable(comparison.Glyphs, func(i, j int) bool {
+		return comparison.Glyphs[i].boxDrift() > comparison.Glyphs[j].boxDrift()
+	})
+
+	// original, upscaled font and difference below each other
+	panels := image.NewNRGBA(image.Rect(0, 0, width, 3*height))
+	draw.Draw(panels, panels.Bounds(), image.Black, image.Point{}, draw.Src)
+	draw.Draw(panels, originalImg.Bounds(), originalImg, image.Point{}, draw.Over)
+	draw.Draw(panels, upscaledImg.Bounds().Add(image.Pt(0, height)), upscaledImg, image.Point{}, draw.Over)
+	draw.Draw(panels, diff.Bounds().Add(image.Pt(0, 2*height)), diff, image.Point{}, draw.Src)
+
+	return comparison, panels
+}
+
+func compareGlyphs(original *BFFNT, originalIndex uint16, upscaled *BFFNT, upscaledIndex uint16, scale float64, code uint16) glyphDrift {
+	originalWidths := original.widthsOrDefault(originalIndex)
+	upscaledWidths := upscaled.widthsOrDefault(upscaledIndex)
+	drift := glyphDrift{
+		Code:           code,
+		LeftWidthError: float64(upscaledWidths.LeftWidth) - float64(originalWidths.LeftWidth)*scale,
+		AdvanceError:   float64(upscaledWidths.CharWidth) - float64(originalWidths.CharWidth)*scale,
+	}
+
+	originalBox, originalInk := original.inkBox(originalIndex)
+	upscaledBox, upscaledInk := upscaled.inkBox(upscaledIndex)
+	if !originalInk || !upscaledInk {
+		return drift
+	}
+
+	drift.HasInk = true
+	drift.Left = float64(upscaledBox.Min.X) - float64(originalBox.Min.X)*scale
+	drift.Top = float64(upscaledBox.Min.Y) - float64(originalBox.Min.Y)*scale
+	drift.Right = float64(upscaledBox.Max.X) - float64(originalBox.Max.X)*scale
+	drift.Bottom = float64(upscaledBox.Max.Y) - float64(originalBox.Max.Y)*scale
+	return drift
+}
+
+// Box around the ink of a glyph, from the pen position and the baseline.
+// Returns false when the glyph has no ink.
+func (b *BFFNT) inkBox(index uint16) (image.Rectangle, bool) {
+	sheet, row, column := b.TGLP.cellPosition(int(index))
+	if sheet >= len(b.TGLP.SheetData) {
+		return image.Rectangle{}, false
+	}
+	cell := b.TGLP.cellRect(row, column)
+	pixels := &b.TGLP.SheetData[sheet]
+
+	box := image.Rectangle{}
+	hasInk := false
+	for y := cell.Min.Y; y < cell.Max.Y; y++ {
+		for x := cell.Min.X; x < cell.Max.X; x++ {
+			if pixels.NRGBAAt(x, y).A < compareInkThreshold {
+				continue
+			}
+			pixel := image.Rect(x, y, x+1, y+1)
+			if !hasInk {
+				box = pixel
+				hasInk = true
+			}
+			box = box.Union(pixel)
+		}
+	}
+	if !hasInk {
+		return box, false
+	}
+
+	origin := image.Pt(cell.Min.X-int(b.widthsOrDefault(index).LeftWidth), cell.Min.Y+int(b.TGLP.BaselinePosition))
+	return box.Sub(origin), true
+}
+
+func alphaAt(img *image.NRGBA, x int, y int) uint8 {
+	if !image.Pt(x, y).In(img.Bounds()) {
+		return 0
+	}
+	return img.NRGBAAt(x, y).A
+}
+
+// Prints the font metrics and the glyphs that drifted the most, at most
+// glyphCount of them
+func printCompareReport(w io.Writer, c fontComparison, glyphCount int) {
+	fmt.Fprintf(w, "scale %.2f\n", c.Scale)
+	fmt.Fprintf(w, "  baseline offset   %+.2f px\n", c.BaselineOffset)
+	fmt.Fprintf(w, "  line feed error   %+.2f px\n", c.LineFeedError)
+	fmt.Fprintf(w, "  text size error   %+d x %+d px\n", c.TextSizeError.X, c.TextSizeError.Y)
+	fmt.Fprintf(w, "  mean pixel diff   %.2f of 255\n", c.MeanPixelDiff)
+	if len(c.OriginalMissing) > 0 {
+		fmt.Fprintf(w, "  missing in original font, drawn with its alter char: %q\n", string(c.OriginalMissing))
+	}
+	if len(c.UpscaledMissing) > 0 {
+		fmt.Fprintf(w, "  missing in upscaled font, drawn with its alter char: %q\n", string(c.UpscaledMissing))
+	}
+
+	if len(c.Glyphs) == 0 {
+		return
+	}
+	var totalDrift, totalAdvance float64
+	for _, g := range c.Glyphs {
+		totalDrift += g.boxDrift()
+		totalAdvance += math.Abs(g.AdvanceError)
+	}
+	count := float64(len(c.Glyphs))
+	fmt.Fprintf(w, "  %d glyphs, mean box drift %.2f px, mean advance error %.2f px\n", len(c.Glyphs), totalDrift/count, totalAdvance/count)
+
+	if glyphCount > len(c.Glyphs) {
+		glyphCount = len(c.Glyphs)
+	}
+	fmt.Fprintf(w, "  %-4s %6s   %9s %4s %5s %6s   %10s %8s\n", "char", "code", "box left", "top", "right", "bottom", "left width", "advance")
+	for _, g := range c.Glyphs[:glyphCount] {
+		box := strings.Repeat(" ", 27)
+		if g.HasInk {
+			box = fmt.Sprintf("%+9.1f %+4.1f %+5.1f %+6.1f", g.Left, g.Top, g.Right, g.Bottom)
+		}
+		fmt.Fprintf(w, "  %-4q %6d   %s   %+10.1f %+8.1f\n", rune(g.Code), g.Code, box, g.LeftWidthError, g.AdvanceError)
+	}
+}
@@ -3,7 +3,6 @@ package bffnt_headers
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"math"
 )
@@ -16,6 +15,8 @@ type CWDH struct { //        Offset  Size  Description
 	NextCWDHOffset uint32 // 0x0C    0x04  Next CWDH Offset
 	Glyphs         []glyphInfo
 
+	sectionOrder
+
 	// Data until the end of the section comes in tuples of 3 bytes
 	// LeftWidth   uint8  // 0x10    0x04  Char Widths (3 bytes: Left, Glyph Width, Char Width)
 	// GlyphWidth  uint8
@@ -36,18 +37,58 @@ func (cwdh *CWDH) Upscale(scale float64) {
 	}
 }
 
-func (cwdh *CWDH) Decode(raw []byte, cwdhOffset uint32) {
+func (cwdh *CWDH) Decode(raw []byte, cwdhOffset uint32) error {
 	headerStart := int(cwdhOffset) - 8
 	headerEnd := headerStart + CWDH_HEADER_SIZE
-	headerBytes := raw[headerStart:headerEnd]
-	cwdh.DecodeHeader(headerBytes)
+	headerBytes, err := sliceSection(raw, CWDH_MAGIC_HEADER, "header", headerStart, headerEnd)
+	if err != nil {
+		return err
+	}
+	cwdh.byteOrder = byteOrderMark(raw)
+
+	err = cwdh.DecodeHeader(headerBytes)
+	if err != nil {
+		return err
+	}
+
+	err = checkMagicHeader(CWDH_MAGIC_HEADER, headerStart, cwdh.MagicHeader)
+	if err != nil {
+		return err
+	}
+
+	if cwdh.EndIndex < cwdh.StartIndex {
+		return &SectionError{
+			Section:  CWDH_MAGIC_HEADER,
+			Offset:   headerStart + 10,
+			Field:    "EndIndex",
+			Expected: int(cwdh.StartIndex),
+			Actual:   int(cwdh.EndIndex),
+			Err:      ErrInvalidRange,
+		}
+	}
 
 	// Character width data is read in tuples of 3 bytes.  The glyph width info
 	// is ordered corresponding to a character index.
-	dataSize := int(cwdh.SectionSize - CWDH_HEADER_SIZE)
+	dataSize := int(cwdh.SectionSize) - CWDH_HEADER_SIZE
 	dataStart := int(headerEnd) // data starts when the header ends
 	dataEnd := dataStart + dataSize
-	data := raw[dataStart:dataEnd]
+	data, err := sliceSection(raw, CWDH_MAGIC_HEADER, "glyph widths", dataStart, dataEnd)
+	if err != nil {
+		return err
+	}
+
+	glyphCount := int(cwdh.EndIndex) - int(cwdh.StartIndex) + 1
+	if 3*glyphCount > len(data) {
+		return &SectionError{
+			Section:  CWDH_MAGIC_HEADER,
+			Offset:   headerStart + 4,
+			Field:    "SectionSize is too small for the glyph count",
+			Expected: CWDH_HEADER_SIZE + 3*glyphCount,
+			Actual:   int(cwdh.SectionSize),
+			Err:      ErrTruncated,
+		}
+	}
+
 	resultGlyphs := make([]glyphInfo, 0)
 
 	dataPos := 0
@@ -63,9 +104,10 @@ func (cwdh *CWDH) Decode(raw []byte, cwdhOffset uint32) {
 	cwdh.Glyphs = resultGlyphs
 
 	leftoverData := data[dataPos:]
-	verifyLeftoverBytes(leftoverData)
-
-	assertEqual(int(cwdh.EndIndex+1), len(cwdh.Glyphs))
+	err = verifyLeftoverBytes(CWDH_MAGIC_HEADER, dataStart+dataPos, leftoverData)
+	if err != nil {
+		return err
+	}
 
 	if Debug {
 		dataEnd := dataStart + dataPos
@@ -76,49 +118,87 @@ func (cwdh *CWDH) Decode(raw []byte, cwdhOffset uint32) {
 		fmt.Printf("leftover bytes   %-8d to  %d\n", dataEnd, dataEnd+len(leftoverData))
 		fmt.Println()
 	}
+
+	return nil
 }
 
-func (cwdh *CWDH) DecodeHeader(raw []byte) {
-	assertEqual(CWDH_HEADER_SIZE, len(raw))
+func (cwdh *CWDH) DecodeHeader(raw []byte) error {
+	if len(raw) != CWDH_HEADER_SIZE {
+		return &SectionError{
+			Section:  CWDH_MAGIC_HEADER,
+			Field:    "header",
+			Expected: CWDH_HEADER_SIZE,
+			Actual:   len(raw),
+			Err:      ErrTruncated,
+		}
+	}
 
 	cwdh.MagicHeader = string(raw[0:4])
-	cwdh.SectionSize = binary.BigEndian.Uint32(raw[4:8])
-	cwdh.StartIndex = binary.BigEndian.Uint16(raw[8:10])
-	cwdh.EndIndex = binary.BigEndian.Uint16(raw[10:12])
-	cwdh.NextCWDHOffset = binary.BigEndian.Uint32(raw[12:CWDH_HEADER_SIZE])
+	cwdh.SectionSize = cwdh.order().Uint32(raw[4:8])
+	cwdh.StartIndex = cwdh.order().Uint16(raw[8:10])
+	cwdh.EndIndex = cwdh.order().Uint16(raw[10:12])
+	cwdh.NextCWDHOffset = cwdh.order().Uint32(raw[12:CWDH_HEADER_SIZE])
 
 	if Debug {
 		pprint(cwdh)
 	}
+
+	return nil
 }
 
-func DecodeCWDHs(allRaw []byte, startingOffset uint32) []CWDH {
+func DecodeCWDHs(allRaw []byte, startingOffset uint32) ([]CWDH, error) {
 	res := make([]CWDH, 0)
 
 	offset := startingOffset
 	for offset != 0 {
 		var currentCWDH CWDH
-		currentCWDH.Decode(allRaw, offset)
+		err := currentCWDH.Decode(allRaw, offset)
+		if err != nil {
+			return nil, err
+		}
 		res = append(res, currentCWDH)
 
+		// A list that points backwards would never terminate
+		if currentCWDH.NextCWDHOffset != 0 && currentCWDH.NextCWDHOffset <= offset {
+			return nil, &SectionError{
+				Section:  CWDH_MAGIC_HEADER,
+				Offset:   int(offset) - 8 + 12,
+				Field:    "NextCWDHOffset",
+				Expected: int(offset) + 1,
+				Actual:   int(currentCWDH.NextCWDHOffset),
+				Err:      ErrSectionOffsetNotAhead,
+			}
+		}
 		offset = currentCWDH.NextCWDHOffset
 	}
 
-	return res
+	return res, nil
 }
 
 // Encodes a single cwdh.
 // The start offset passed is either the starting finf.cwdhOffset or the last cwdh's NextCWDHOffset
-func (cwdh *CWDH) Encode(startOffset uint32, isLastCWDH bool) []byte {
+func (cwdh *CWDH) Encode(startOffset uint32, isLastCWDH bool) ([]byte, error) {
+	if len(cwdh.Glyphs) == 0 || len(cwdh.Glyphs) > math.MaxUint16+1 {
+		return nil, &SectionError{
+			Section:  CWDH_MAGIC_HEADER,
+			Offset:   int(startOffset) - 8,
+			Field:    "glyph count",
+			Expected: math.MaxUint16 + 1,
+			Actual:   len(cwdh.Glyphs),
+			Err:      ErrValueOutOfRange,
+		}
+	}
+
+	order := cwdh.order()
 	var dataBuf bytes.Buffer
 	dataWriter := bufio.NewWriter(&dataBuf)
 
 	// encode cwdh data. We need to know the length of the raw glyph data to
 	// know the section size
 	for _, glyph := range cwdh.Glyphs {
-		binaryWrite(dataWriter, glyph.LeftWidth)
-		binaryWrite(dataWriter, glyph.GlyphWidth)
-		binaryWrite(dataWriter, glyph.CharWidth)
+		binaryWrite(dataWriter, order, glyph.LeftWidth)
+		binaryWrite(dataWriter, order, glyph.GlyphWidth)
+		binaryWrite(dataWriter, order, glyph.CharWidth)
 	}
 	dataWriter.Flush()
 
@@ -127,8 +207,19 @@ func (cwdh *CWDH) Encode(startOffset uint32, isLastCWDH bool) []byte {
 	glyphData := dataBuf.Bytes()
 	// Calculate and edit the header information
 	cwdh.SectionSize = uint32(CWDH_HEADER_SIZE + len(glyphData))
-	cwdh.StartIndex = uint16(0)
-	cwdh.EndIndex = uint16(len(cwdh.Glyphs) - 1)
+	// fonts can have several CWDHs covering different glyph index ranges
+	endIndex := int(cwdh.StartIndex) + len(cwdh.Glyphs) - 1
+	if endIndex > math.MaxUint16 {
+		return nil, &SectionError{
+			Section:  CWDH_MAGIC_HEADER,
+			Offset:   int(startOffset) - 8 + 10,
+			Field:    "EndIndex",
+			Expected: math.MaxUint16,
+			Actual:   endIndex,
+			Err:      ErrValueOutOfRange,
+		}
+	}
+	cwdh.EndIndex = uint16(endIndex)
 	if isLastCWDH {
 		cwdh.NextCWDHOffset = 0
 	} else {
@@ -142,17 +233,17 @@ func (cwdh *CWDH) Encode(startOffset uint32, isLastCWDH bool) []byte {
 
 	// Write raw data of the header and data
 	_, _ = w.Write([]byte(cwdh.MagicHeader))
-	binaryWrite(w, cwdh.SectionSize)
-	binaryWrite(w, cwdh.StartIndex)
-	binaryWrite(w, cwdh.EndIndex)
-	binaryWrite(w, cwdh.NextCWDHOffset)
+	binaryWrite(w, order, cwdh.SectionSize)
+	binaryWrite(w, order, cwdh.StartIndex)
+	binaryWrite(w, order, cwdh.EndIndex)
+	binaryWrite(w, order, cwdh.NextCWDHOffset)
 	_, _ = w.Write(glyphData)
 	w.Flush()
 
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
-func EncodeCWDHs(CWDHs []CWDH, finfCWDHOffset int) []byte {
+func EncodeCWDHs(CWDHs []CWDH, finfCWDHOffset int) ([]byte, error) {
 	res := make([]byte, 0)
 
 	offset := uint32(finfCWDHOffset)
@@ -162,13 +253,16 @@ func EncodeCWDHs(CWDHs []CWDH, finfCWDHOffset int) []byte {
 			isLast = true
 		}
 
-		cwdhBytes := currentCWDH.Encode(offset, isLast)
+		cwdhBytes, err := currentCWDH.Encode(offset, isLast)
+		if err != nil {
+			return nil, err
+		}
 
 		res = append(res, cwdhBytes...)
 		offset = currentCWDH.NextCWDHOffset
 	}
 
-	return res
+	return res, nil
 }
 
 // takes a cwdh list and adds the section size together.
@@ -0,0 +1,388 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+)
+
+// ETC1 stores RGB in 4x4 pixel blocks of 8 bytes. A block is split into two
+// 2x4 (or 4x2 when flipped) subblocks. Every subblock has a base color and a
+// modifier table, each pixel picks one of the 4 modifiers of the table and
+// adds it to all channels of the base color. The base colors are either two
+// RGB444 colors, or a RGB555 color and a RGB333 difference to it.
+//
+// The 3DS stores every block as a little endian uint64, the bytes are in the
+// opposite order of the ETC1 specification. ETC1A4 puts 8 bytes of 4 bit
+// alpha values in front of every block.
+//
+// Source:
+// https://www.khronos.org/registry/OpenGL/extensions/OES/OES_compressed_ETC1_RGB8_texture.txt
+
+var etc1Modifiers = [8][2]int{
+	{2, 8},
+	{5, 17},
+	{9, 29},
+	{13, 42},
+	{18, 60},
+	{24, 80},
+	{33, 106},
+	{47, 183},
+}
+
+// The 2 bit pixel index picks +small, +large, -small or -large
+func etc1Modifier(table uint32, index uint32) int {
+	modifier := etc1Modifiers[table][index&1]
+	if index&2 != 0 {
+		return -modifier
+	}
+	return modifier
+}
+
+func decodeETC1(data []byte, width int, height int) *image.NRGBA {
+	return decodeETC1Blocks(data, width, height, false)
+}
+
+func decodeETC1A4(data []byte, width int, height int) *image.NRGBA {
+	return decodeETC1Blocks(data, width, height, true)
+}
+
+func encodeETC1(img *image.NRGBA, quality EncodeQuality) []byte {
+	return encodeETC1Blocks(img, quality, false)
+}
+
+func encodeETC1A4(img *image.NRGBA, quality EncodeQuality) []byte {
+	return encodeETC1Blocks(img, quality, true)
+}
+
+func decodeETC1Blocks(data []byte, width int, height int, hasAlpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	blocksWide := (width + 3) / 4
+	blocksHigh := (height + 3) / 4
+	blockBytes := 8
+	if hasAlpha {
+		blockBytes = 16
+	}
+
+	for by := 0; by < blocksHigh; by++ {
+		for bx := 0; bx < blocksWide; bx++ {
+			blockStart := (by*blocksWide + bx) * blockBytes
+			block := data[blockStart : blockStart+blockBytes]
+
+			var alphas uint64
+			if hasAlpha {
+				alphas = binary.LittleEndian.Uint64(block[0:8])
+				block = block[8:16]
+			}
+			pixels := decodeETC1Block(binary.LittleEndian.Uint64(block))
+
+			for i, c := range pixels {
+				// pixels are numbered column by column
+				x := bx*4 + i/4
+				y := by*4 + i%4
+				if x >= width || y >= height {
+					continue
+				}
+				if hasAlpha {
+					c.A = expand(uint8(alphas>>(4*uint(i))&0xF), 4)
+				}
+				pos := y*img.Stride + 4*x
+				img.Pix[pos+0] = c.R
+				img.Pix[pos+1] = c.G
+				img.Pix[pos+2] = c.B
+				img.Pix[pos+3] = c.A
+			}
+		}
+	}
+
+	return img
+}
+
+func encodeETC1Blocks(img *image.NRGBA, quality EncodeQuality, hasAlpha bool) []byte {
+	width, height := img.Rect.Dx(), img.Rect.Dy()
+	blocksWide := (width + 3) / 4
+	blocksHigh := (height + 3) / 4
+	data := make([]byte, 0, blocksWide*blocksHigh*16)
+	var blockBytes [8]byte
+
+	for by := 0; by < blocksHigh; by++ {
+		for bx := 0; bx < blocksWide; bx++ {
+			var pixels [16]color.NRGBA
+			for i := range pixels {
+				// pixels outside the image repeat the closest edge pixel
+				x := minInt(bx*4+i/4, width-1)
+				y := minInt(by*4+i%4, height-1)
+				pixels[i] = nrgbaAt(img, x, y)
+			}
+
+			if hasAlpha {
+				var alphas uint64
+				for i, c := range pixels {
+					alphas |= uint64(quantize(c.A, 4)) << (4 * uint(i))
+				}
+				binary.LittleEndian.PutUint64(blockBytes[:], alphas)
+				data = append(data, blockBytes[:]...)
+			}
+			binary.LittleEndian.PutUint64(blockBytes[:], encodeETC1Block(pixels, quality))
+			data = append(data, blockBytes[:]...)
+		}
+	}
+
+	return data
+}
+
+// Pixel i of a block is at x = i/4, y = i%4. Returns the subblock of a pixel.
+func etc1Subblock(i int, flip bool) int {
+	if flip {
+		return (i % 4) / 2
+	}
+	return i / 8
+}
+
+func decodeETC1Block(block uint64) [16]color.NRGBA {
+	high := uint32(block >> 32)
+	low := uint32(block)
+
+	flip := high&1 != 0
+	differential := high&2 != 0
+	tables := [2]uint32{high >> 5 & 7, high >> 2 & 7}
+
+	var baseColors [2][3]int
+	if differential {
+		for channel := 0; channel < 3; channel++ {
+			shift := uint(27 - 8*channel)
+			base := int(high >> shift & 0x1F)
+			// 3 bit two's complement
+			delta := int(int32(high>>(shift-3)&7<<29) >> 29)
+			baseColors[0][channel] = int(expand(uint8(base), 5))
+			baseColors[1][channel] = int(expand(uint8(base+delta)&0x1F, 5))
+		}
+	} else {
+		for channel := 0; channel < 3; channel++ {
+			shift := uint(28 - 8*channel)
+			baseColors[0][channel] = int(expand(uint8(high>>shift&0xF), 4))
+			baseColors[1][channel] = int(expand(uint8(high>>(shift-4)&0xF), 4))
+		}
+	}
+
+	var pixels [16]color.NRGBA
+	for i := range pixels {
+		subblock := etc1Subblock(i, flip)
+		index := (low>>(16+uint(i))&1)<<1 | low>>uint(i)&1
+		modifier := etc1Modifier(tables[subblock], index)
+		base := baseColors[subblock]
+		pixels[i] = color.NRGBA{
+			uint8(clampInt(base[0]+modifier, 0, 255)),
+			uint8(clampInt(base[1]+modifier, 0, 255)),
+			uint8(clampInt(base[2]+modifier, 0, 255)),
+			255,
+		}
+	}
+
+	return pixels
+}
+
+// The best fit of one subblock for a single base color
+type etc1Fit struct {
+	color   [3]int // quantized base color
+	table   uint32
+	indexes uint32 // low word bits of the pixels in this subblock
+	err     int
+}
+
+// Tries both subblock orientations in both base color modes and keeps the
+// one with the smallest error.
+func encodeETC1Block(pixels [16]color.NRGBA, quality EncodeQuality) uint64 {
+	var best uint64
+	bestError := -1
+
+	for _, flip := range []bool{false, true} {
+		var subblocks [2][]int
+		for i := range pixels {
+			subblock := etc1Subblock(i, flip)
+			subblocks[subblock] = append(subblocks[subblock], i)
+		}
+
+		for _, differential := range []bool{false, true} {
+			bits := uint(4)
+			if differential {
+				bits = 5
+			}
+
+			var fits [2][]etc1Fit
+			for s, subblock := range subblocks {
+				for _, candidate := range etc1BaseColorCandidates(pixels, subblock, bits, quality) {
+					fits[s] = append(fits[s], etc1FitSubblock(pixels, subblock, candidate, bits))
+				}
+			}
+
+			for _, fit0 := range fits[0] {
+				for _, fit1 := range fits[1] {
+					if differential && !etc1DeltaFits(fit0.color, fit1.color) {
+						continue
+					}
+					totalError := fit0.err + fit1.err
+					if bestError >= 0 && totalError >= bestError {
+						continue
+					}
+					bestError = totalError
+					best = etc1PackBlock(fit0, fit1, flip, differential)
+				}
+			}
+
+			if bestError == 0 {
+				return best
+			}
+		}
+	}
+
+	return best
+}
+
+// The second differential base color has to be within -4..3 of the first
+func etc1DeltaFits(color0 [3]int, color1 [3]int) bool {
+	for channel := 0; channel < 3; channel++ {
+		delta := color1[channel] - color0[channel]
+		if delta < -4 || delta > 3 {
+			return false
+		}
+	}
+	return true
+}
+
+func etc1PackBlock(fit0 etc1Fit, fit1 etc1Fit, flip bool, differential bool) uint64 {
+	var high uint32
+	for channel := 0; channel < 3; channel++ {
+		if differential {
+			shift := uint(27 - 8*channel)
+			delta := fit1.color[channel] - fit0.color[channel]
+			high |= uint32(fit0.color[channel]) << shift
+			high |= uint32(delta&7) << (shift - 3)
+		} else {
+			shift := uint(28 - 8*channel)
+			high |= uint32(fit0.color[channel]) << shift
+			high |= uint32(fit1.color[channel]) << (shift - 4)
+		}
+	}
+	high |= fit0.table<<5 | fit1.table<<2
+	if differential {
+		high |= 2
+	}
+	if flip {
+		high |= 1
+	}
+
+	return uint64(high)<<32 | uint64(fit0.indexes|fit1.indexes)
+}
+
+// Quantized base colors worth trying for a subblock. QualityLow only uses the
+// average color. Higher qualities also move it along the gray axis, which
+// the modifiers can not do for some pixels without moving it for others, and
+// QualityHigh tries every neighbouring color of the average as well.
+func etc1BaseColorCandidates(pixels [16]color.NRGBA, subblock []int, bits uint, quality EncodeQuality) [][3]int {
+	var sum [3]int
+	for _, i := range subblock {
+		sum[0] += int(pixels[i].R)
+		sum[1] += int(pixels[i].G)
+		sum[2] += int(pixels[i].B)
+	}
+
+	var average [3]int
+	for channel := range sum {
+		mean := (sum[channel] + len(subblock)/2) / len(subblock)
+		average[channel] = int(quantize(uint8(mean), bits))
+	}
+
+	maxValue := 1<<bits - 1
+	var candidates [][3]int
+	add := func(offset [3]int) {
+		var candidate [3]int
+		for channel := range candidate {
+			candidate[channel] = average[channel] + offset[channel]
+			if candidate[channel] < 0 || candidate[channel] > maxValue {
+				return
+			}
+		}
+		for _, existing := range candidates {
+			if existing == candidate {
+				return
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	add([3]int{0, 0, 0})
+	if quality == QualityLow {
+		return candidates
+	}
+
+	grayRange := 2
+	if quality == QualityHigh {
+		grayRange = 3
+	}
+	for d := -grayRange; d <= grayRange; d++ {
+		add([3]int{d, d, d})
+	}
+
+	if quality == QualityHigh {
+		for r := -1; r <= 1; r++ {
+			for g := -1; g <= 1; g++ {
+				for b := -1; b <= 1; b++ {
+					add([3]int{r, g, b})
+				}
+			}
+		}
+	}
+
+	return candidates
+}
+
+// Picks the modifier table and the pixel indexes with the smallest error for
+// a quantized base color.
+func etc1FitSubblock(pixels [16]color.NRGBA, subblock []int, quantizedColor [3]int, bits uint) etc1Fit {
+	var base [3]int
+	for channel := range base {
+		base[channel] = int(expand(uint8(quantizedColor[channel]), bits))
+	}
+
+	best := etc1Fit{color: quantizedColor, err: -1}
+	for table := uint32(0); table < 8; table++ {
+		var indexes uint32
+		tableError := 0
+		for _, i := range subblock {
+			p := pixels[i]
+			bestIndex, bestPixelError := uint32(0), -1
+			for index := uint32(0); index < 4; index++ {
+				modifier := etc1Modifier(table, index)
+				pixelError := 0
+				for channel, v := range [3]uint8{p.R, p.G, p.B} {
+					diff := clampInt(base[channel]+modifier, 0, 255) - int(v)
+					pixelError += diff * diff
+				}
+				if bestPixelError < 0 || pixelError < bestPixelError {
+					bestIndex, bestPixelError = index, pixelError
+				}
+			}
+			tableError += bestPixelError
+			indexes |= (bestIndex>>1)<<(16+uint(i)) | (bestIndex&1)<<uint(i)
+		}
+
+		if best.err < 0 || tableError < best.err {
+			best.table = table
+			best.indexes = indexes
+			best.err = tableError
+		}
+	}
+
+	return best
+}
+
+func clampInt(v int, low int, high int) int {
+	if v < low {
+		return low
+	}
+	if v > high {
+		return high
+	}
+	return v
+}
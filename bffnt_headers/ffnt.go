@@ -3,15 +3,14 @@ package bffnt_headers
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
 	"fmt"
 )
 
 type FFNT struct { //       Offset  Size  Description
-	MagicHeader   string // 0x00    0x04  Magic Header (either ffnt or CFNU or FFNT)
-	Endianness    uint16 // 0x04    0x02  Endianness (0xFEFF = little, 0xFFFE = big)
+	MagicHeader   string // 0x00    0x04  Magic Header (FFNT on the Wii U and Switch, CFNT or CFNU on the 3DS, RFNT on the Wii)
+	Endianness    uint16 // 0x04    0x02  Byte order mark, 0xFEFF in the byte order of the file (bytes FE FF = big, FF FE = little)
 	SectionSize   uint16 // 0x06    0x02  Header Size
-	Version       uint32 // 0x08    0x04  Version (observed to be 0x03000000)
+	Version       uint32 // 0x08    0x04  Version (0x03000000 on the Wii U and 3DS), decides the header layout (fileVersions)
 	TotalFileSize uint32 // 0x0C    0x04  File size (the total)
 	BlockReadNum  uint32 // 0x10    0x04  Number of "blocks" to read
 
@@ -21,20 +20,85 @@ type FFNT struct { //       Offset  Size  Description
 	// This means that a small block read size might result in slower font
 	// being printed to the screen. Perhaps it is ok to change this number
 	// around. Change this bit and see if botw crashes.
+
+	sectionOrder
+
+	// The Wii (RFNT) header is 16 bytes. It has a 2 byte version and the
+	// number of sections in the file where the others have BlockReadNum:
+	// Version           0x06    0x02  Version (0x0104)
+	// TotalFileSize     0x08    0x04  File size (the total)
+	// SectionSize       0x0C    0x02  Header Size
+	// BlockReadNum      0x0E    0x02  Number of sections
 }
 
-func (ffnt *FFNT) Decode(raw []byte) {
+func (ffnt *FFNT) Decode(raw []byte) error {
 	headerStart := 0
-	headerEnd := headerStart + FFNT_HEADER_SIZE
-	headerRaw := raw[headerStart:headerEnd]
-	assertEqual(FFNT_HEADER_SIZE, len(headerRaw))
+	headerRaw, err := sliceSection(raw, FFNT_MAGIC_HEADER, "header", headerStart, headerStart+RFNT_HEADER_SIZE)
+	if err != nil {
+		return err
+	}
 
+	ffnt.byteOrder = byteOrderMark(raw)
 	ffnt.MagicHeader = string(headerRaw[0:4])
-	ffnt.Endianness = binary.BigEndian.Uint16(headerRaw[4:6])
-	ffnt.SectionSize = binary.BigEndian.Uint16(headerRaw[6:8])
-	ffnt.Version = binary.BigEndian.Uint32(headerRaw[8:12])
-	ffnt.TotalFileSize = binary.BigEndian.Uint32(headerRaw[12:16])
-	ffnt.BlockReadNum = binary.BigEndian.Uint32(headerRaw[16:FFNT_HEADER_SIZE])
+	switch ffnt.MagicHeader {
+	case FFNT_MAGIC_HEADER, CFNT_MAGIC_HEADER, CFNU_MAGIC_HEADER, RFNT_MAGIC_HEADER:
+	default:
+		return &SectionError{
+			Section: FFNT_MAGIC_HEADER,
+			Offset:  headerStart,
+			Field:   fmt.Sprintf("magic header %q", ffnt.MagicHeader),
+			Err:     ErrMagicHeader,
+		}
+	}
+
+	order := ffnt.order()
+	ffnt.Version = fileVersion(headerRaw, ffnt.MagicHeader, order)
+	layout, err := versionLayout(ffnt.MagicHeader, ffnt.Version)
+	if err != nil {
+		return err
+	}
+
+	headerSize := layout.fileHeaderSize()
+	headerEnd := headerStart + headerSize
+	headerRaw, err = sliceSection(raw, FFNT_MAGIC_HEADER, "header", headerStart, headerEnd)
+	if err != nil {
+		return err
+	}
+
+	ffnt.Endianness = order.Uint16(headerRaw[4:6])
+	sectionSizeOffset := 6
+	if layout == layoutRFNT {
+		ffnt.TotalFileSize = order.Uint32(headerRaw[8:12])
+		ffnt.SectionSize = order.Uint16(headerRaw[12:14])
+		ffnt.BlockReadNum = uint32(order.Uint16(headerRaw[14:RFNT_HEADER_SIZE]))
+		sectionSizeOffset = 12
+	} else {
+		ffnt.SectionSize = order.Uint16(headerRaw[6:8])
+		ffnt.TotalFileSize = order.Uint32(headerRaw[12:16])
+		ffnt.BlockReadNum = order.Uint32(headerRaw[16:FFNT_HEADER_SIZE])
+	}
+
+	err = checkEqual(FFNT_MAGIC_HEADER, headerStart+sectionSizeOffset, "SectionSize", ErrSizeMismatch, headerSize, int(ffnt.SectionSize))
+	if err != nil {
+		return err
+	}
+
+	// A file that is shorter than what the header says has been cut off.
+	// Longer is fine, archives can pad their files.
+	if int(ffnt.TotalFileSize) > len(raw) {
+		totalFileSizeOffset := 12
+		if layout == layoutRFNT {
+			totalFileSizeOffset = 8
+		}
+		return &SectionError{
+			Section:  FFNT_MAGIC_HEADER,
+			Offset:   headerStart + totalFileSizeOffset,
+			Field:    "TotalFileSize",
+			Expected: int(ffnt.TotalFileSize),
+			Actual:   len(raw),
+			Err:      ErrTruncated,
+		}
+	}
 
 	if Debug {
 		pprint(ffnt)
@@ -43,20 +107,39 @@ func (ffnt *FFNT) Decode(raw []byte) {
 		fmt.Printf("header %d(inclusive) to %d(exclusive)\n", headerStart, headerEnd)
 		fmt.Println()
 	}
+
+	return nil
 }
 
-func (ffnt *FFNT) Encode(totalFileSize uint32) []byte {
+func (ffnt *FFNT) Encode(totalFileSize uint32) ([]byte, error) {
+	layout, err := versionLayout(ffnt.MagicHeader, ffnt.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	order := ffnt.order()
 	var buf bytes.Buffer
 	w := bufio.NewWriter(&buf)
 
 	_, _ = w.Write([]byte(ffnt.MagicHeader))
-	binaryWrite(w, ffnt.Endianness)
-	binaryWrite(w, ffnt.SectionSize)
-	binaryWrite(w, ffnt.Version)
-	binaryWrite(w, totalFileSize)
-	binaryWrite(w, ffnt.BlockReadNum)
+	binaryWrite(w, order, ffnt.Endianness)
+	if layout == layoutRFNT {
+		binaryWrite(w, order, uint16(ffnt.Version))
+		binaryWrite(w, order, totalFileSize)
+		binaryWrite(w, order, ffnt.SectionSize)
+		binaryWrite(w, order, uint16(ffnt.BlockReadNum))
+	} else {
+		binaryWrite(w, order, ffnt.SectionSize)
+		binaryWrite(w, order, ffnt.Version)
+		binaryWrite(w, order, totalFileSize)
+		binaryWrite(w, order, ffnt.BlockReadNum)
+	}
 	w.Flush()
 
-	assertEqual(FFNT_HEADER_SIZE, len(buf.Bytes()))
-	return buf.Bytes()
+	err = checkEqual(FFNT_MAGIC_HEADER, 0, "encoded header", ErrSizeMismatch, layout.fileHeaderSize(), len(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
 }
@@ -3,7 +3,6 @@ package bffnt_headers
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"math"
 )
@@ -24,30 +23,79 @@ type FINF struct { //  Offset  Size  Description
 	TGLPOffset        uint32 // 0x14    0x04  TGLP Offset
 	CWDHOffset        uint32 // 0x18    0x04  CWDH Offset
 	CMAPOffset        uint32 // 0x1C    0x04  CMAP Offset
+
+	Platform Platform // from the file magic header
+	sectionOrder
+	layout headerLayout // from the file version. Decides the field order.
+
+	// The 3DS (CFNT version 3) and Wii (RFNT version 1) section has the same
+	// fields in another order, with a 1 byte line feed:
+	// FontType          0x08    0x01  Font Type
+	// LineFeed          0x09    0x01  Line Feed
+	// AlterCharIndex    0x0A    0x02  Alter Char Index
+	// Default Width     0x0C    0x03  Default Width (3 bytes: Left, Glyph Width, Char Width)
+	// Encoding          0x0F    0x01  Encoding
+	// TGLPOffset        0x10    0x04  TGLP Offset
+	// CWDHOffset        0x14    0x04  CWDH Offset
+	// CMAPOffset        0x18    0x04  CMAP Offset
+	// Height            0x1C    0x01  Height
+	// Width             0x1D    0x01  Width
+	// Ascent            0x1E    0x01  Ascent
+	// Reserved          0x1F    0x01  Reserved
 }
 
-// Version 4 (BFFNT)
-func (finf *FINF) Decode(raw []byte) {
-	headerStart := FFNT_HEADER_SIZE
+// Every version of fileVersions. The version in the file header decides the
+// field order.
+func (finf *FINF) Decode(raw []byte) error {
+	var err error
+	finf.byteOrder, finf.Platform, finf.layout, err = fileFormat(raw)
+	if err != nil {
+		return err
+	}
+	order := finf.order()
+
+	headerStart := finf.layout.fileHeaderSize()
 	headerEnd := headerStart + FINF_HEADER_SIZE
-	headerRaw := raw[headerStart:headerEnd]
-	assertEqual(FINF_HEADER_SIZE, len(headerRaw))
+	headerRaw, err := sliceSection(raw, FINF_MAGIC_HEADER, "header", headerStart, headerEnd)
+	if err != nil {
+		return err
+	}
 
 	finf.MagicHeader = string(headerRaw[0:4])
-	finf.SectionSize = binary.BigEndian.Uint32(headerRaw[4:8])
+	finf.SectionSize = order.Uint32(headerRaw[4:8])
 	finf.FontType = headerRaw[8] // byte == uint8
-	finf.Height = headerRaw[9]
-	finf.Width = headerRaw[10]
-	finf.Ascent = headerRaw[11]
-	finf.LineFeed = binary.BigEndian.Uint16(headerRaw[12:14])
-	finf.AlterCharIndex = binary.BigEndian.Uint16(headerRaw[14:16])
-	finf.DefaultLeftWidth = headerRaw[16]
-	finf.DefaultGlyphWidth = headerRaw[17]
-	finf.DefaultCharWidth = headerRaw[18]
-	finf.Encoding = headerRaw[19]
-	finf.TGLPOffset = binary.BigEndian.Uint32(headerRaw[20:24])
-	finf.CWDHOffset = binary.BigEndian.Uint32(headerRaw[24:28])
-	finf.CMAPOffset = binary.BigEndian.Uint32(headerRaw[28:FINF_HEADER_SIZE])
+	if finf.layout.legacyFields() {
+		finf.LineFeed = uint16(headerRaw[9])
+		finf.AlterCharIndex = order.Uint16(headerRaw[10:12])
+		finf.DefaultLeftWidth = headerRaw[12]
+		finf.DefaultGlyphWidth = headerRaw[13]
+		finf.DefaultCharWidth = headerRaw[14]
+		finf.Encoding = headerRaw[15]
+		finf.TGLPOffset = order.Uint32(headerRaw[16:20])
+		finf.CWDHOffset = order.Uint32(headerRaw[20:24])
+		finf.CMAPOffset = order.Uint32(headerRaw[24:28])
+		finf.Height = headerRaw[28]
+		finf.Width = headerRaw[29]
+		finf.Ascent = headerRaw[30]
+	} else {
+		finf.Height = headerRaw[9]
+		finf.Width = headerRaw[10]
+		finf.Ascent = headerRaw[11]
+		finf.LineFeed = order.Uint16(headerRaw[12:14])
+		finf.AlterCharIndex = order.Uint16(headerRaw[14:16])
+		finf.DefaultLeftWidth = headerRaw[16]
+		finf.DefaultGlyphWidth = headerRaw[17]
+		finf.DefaultCharWidth = headerRaw[18]
+		finf.Encoding = headerRaw[19]
+		finf.TGLPOffset = order.Uint32(headerRaw[20:24])
+		finf.CWDHOffset = order.Uint32(headerRaw[24:28])
+		finf.CMAPOffset = order.Uint32(headerRaw[28:FINF_HEADER_SIZE])
+	}
+
+	err = checkMagicHeader(FINF_MAGIC_HEADER, headerStart, finf.MagicHeader)
+	if err != nil {
+		return err
+	}
 
 	if Debug {
 		pprint(finf)
@@ -56,9 +104,12 @@ func (finf *FINF) Decode(raw []byte) {
 		fmt.Printf("header %d(inclusive) to %d(exclusive)\n", headerStart, headerEnd)
 		fmt.Println()
 	}
+
+	return nil
 }
 
-func (finf *FINF) Encode(tglpOffset int, cwdhOffset int, cmapOffset int) []byte {
+func (finf *FINF) Encode(tglpOffset int, cwdhOffset int, cmapOffset int) ([]byte, error) {
+	order := finf.order()
 	var buf bytes.Buffer
 	w := bufio.NewWriter(&buf)
 
@@ -67,34 +118,64 @@ func (finf *FINF) Encode(tglpOffset int, cwdhOffset int, cmapOffset int) []byte
 	finf.CMAPOffset = uint32(cmapOffset)
 
 	_, _ = w.Write([]byte(finf.MagicHeader))
-	binaryWrite(w, finf.SectionSize)
-	binaryWrite(w, finf.FontType)
-	binaryWrite(w, finf.Height)
-	binaryWrite(w, finf.Width)
-	binaryWrite(w, finf.Ascent)
-	binaryWrite(w, finf.LineFeed)
-	binaryWrite(w, finf.AlterCharIndex)
-	binaryWrite(w, finf.DefaultLeftWidth)
-	binaryWrite(w, finf.DefaultGlyphWidth)
-	binaryWrite(w, finf.DefaultCharWidth)
-	binaryWrite(w, finf.Encoding)
-	binaryWrite(w, finf.TGLPOffset)
-	binaryWrite(w, finf.CWDHOffset)
-	binaryWrite(w, finf.CMAPOffset)
+	binaryWrite(w, order, finf.SectionSize)
+	binaryWrite(w, order, finf.FontType)
+	if finf.layout.legacyFields() {
+		if finf.LineFeed > math.MaxUint8 {
+			return nil, &SectionError{
+				Section:  FINF_MAGIC_HEADER,
+				Offset:   finf.layout.fileHeaderSize() + 9,
+				Field:    "LineFeed",
+				Expected: math.MaxUint8,
+				Actual:   int(finf.LineFeed),
+				Err:      ErrValueOutOfRange,
+			}
+		}
+		binaryWrite(w, order, uint8(finf.LineFeed))
+		binaryWrite(w, order, finf.AlterCharIndex)
+		binaryWrite(w, order, finf.DefaultLeftWidth)
+		binaryWrite(w, order, finf.DefaultGlyphWidth)
+		binaryWrite(w, order, finf.DefaultCharWidth)
+		binaryWrite(w, order, finf.Encoding)
+		binaryWrite(w, order, finf.TGLPOffset)
+		binaryWrite(w, order, finf.CWDHOffset)
+		binaryWrite(w, order, finf.CMAPOffset)
+		binaryWrite(w, order, finf.Height)
+		binaryWrite(w, order, finf.Width)
+		binaryWrite(w, order, finf.Ascent)
+		binaryWrite(w, order, uint8(0)) // reserved
+	} else {
+		binaryWrite(w, order, finf.Height)
+		binaryWrite(w, order, finf.Width)
+		binaryWrite(w, order, finf.Ascent)
+		binaryWrite(w, order, finf.LineFeed)
+		binaryWrite(w, order, finf.AlterCharIndex)
+		binaryWrite(w, order, finf.DefaultLeftWidth)
+		binaryWrite(w, order, finf.DefaultGlyphWidth)
+		binaryWrite(w, order, finf.DefaultCharWidth)
+		binaryWrite(w, order, finf.Encoding)
+		binaryWrite(w, order, finf.TGLPOffset)
+		binaryWrite(w, order, finf.CWDHOffset)
+		binaryWrite(w, order, finf.CMAPOffset)
+	}
 	w.Flush()
 
-	assertEqual(FINF_HEADER_SIZE, len(buf.Bytes()))
-	return buf.Bytes()
+	err := checkEqual(FINF_MAGIC_HEADER, finf.layout.fileHeaderSize(), "encoded header", ErrSizeMismatch, FINF_HEADER_SIZE, len(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
 }
 
 // Characters have a theorical maximum size of 256 pixels becuase some
 // attributes are defined with a uint8. A uint8's maxmum size is 256.
+// AlterCharIndex is a glyph index and stays the same.
 func (finf *FINF) Upscale(scale float64) {
 	finf.Height = uint8(math.Ceil(float64(finf.Height) * scale))
 	finf.Width = uint8(math.Ceil(float64(finf.Width) * scale))
 	finf.Ascent = uint8(math.Ceil(float64(finf.Ascent) * scale))
 	finf.LineFeed = uint16(math.Ceil(float64(finf.LineFeed) * scale))
-	finf.AlterCharIndex = uint16(math.Ceil(float64(finf.AlterCharIndex) * scale))
 	finf.DefaultLeftWidth = uint8(math.Ceil(float64(finf.DefaultLeftWidth) * scale))
 	finf.DefaultGlyphWidth = uint8(math.Ceil(float64(finf.DefaultGlyphWidth) * scale))
 	finf.DefaultCharWidth = uint8(math.Ceil(float64(finf.DefaultCharWidth) * scale))
@@ -0,0 +1,117 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Widths of a glyph as drawn from the font file, in pixels of the upscaled
+// font
+type glyphMetrics struct {
+	Code       uint16 // character code
+	Index      uint16 // glyph index
+	LeftWidth  int    // left bearing, from the pen to the glyph image
+	GlyphWidth int    // width of the drawn glyph, outline included
+	CharWidth  int    // advance of the pen
+}
+
+// How far fitting may move the upscaled CWDH widths towards the font file,
+// and how far off a glyph may stay before it is reported. Both are in pixels
+// of the original font and multiplied by the scale.
+type WidthFit struct {
+	MaxCorrection float64 `json:"maxCorrection"`
+	Tolerance     float64 `json:"tolerance"`
+}
+
+var defaultWidthFit = WidthFit{
+	MaxCorrection: 3,
+	Tolerance:     1,
+}
+
+// A glyph after fitting. Original is the upscaled CWDH width, Font what the
+// font file wants and Fitted what the CWDH got.
+type glyphFit struct {
+	Code                                  uint16
+	OriginalLeft, FontLeft, FittedLeft    int
+	OriginalWidth, FontWidth, FittedWidth int
+}
+
+func (f WidthFit) withDefaults() WidthFit {
+	if f.MaxCorrection == 0 {
+		f.MaxCorrection = defaultWidthFit.MaxCorrection
+	}
+	if f.Tolerance == 0 {
+		f.Tolerance = defaultWidthFit.Tolerance
+	}
+	return f
+}
+
+// Moves the left and char width of every drawn glyph in a CWDH towards the font file
+// by at most MaxCorrection, so the spacing follows the new glyphs without
+// losing Nintendo's spacing where the font file is far off. The glyph width
+// becomes the width of the drawn glyph. Returns the glyphs that are still
+// more than Tolerance off, sorted by character code.
+func (b *BFFNT) fitWidths(metrics []glyphMetrics, fit WidthFit, scale float64) []glyphFit {
+	fit = fit.withDefaults()
+	maxCorrection := int(math.Round(fit.MaxCorrection * scale))
+	tolerance := int(math.Round(fit.Tolerance * scale))
+	cellWidth := int(b.TGLP.CellWidth)
+
+	var outliers []glyphFit
+	for _, m := range metrics {
+		glyph := b.glyphWidths(m.Index)
+		if glyph == nil {
+			continue
+		}
+
+		result := glyphFit{
+			Code:          m.Code,
+			OriginalLeft:  int(glyph.LeftWidth),
+			FontLeft:      m.LeftWidth,
+			OriginalWidth: int(glyph.CharWidth),
+			FontWidth:     m.CharWidth,
+		}
+		result.FittedLeft = clampInt(result.OriginalLeft+clampInt(m.LeftWidth-result.OriginalLeft, -maxCorrection, maxCorrection), math.MinInt8, math.MaxInt8)
+		result.FittedWidth = clampInt(result.OriginalWidth+clampInt(m.CharWidth-result.OriginalWidth, -maxCorrection, maxCorrection), 0, math.MaxUint8)
+
+		glyph.LeftWidth = int8(result.FittedLeft)
+		glyph.CharWidth = uint8(result.FittedWidth)
+		glyph.GlyphWidth = uint8(clampInt(m.GlyphWidth, 0, cellWidth))
+
+		if absInt(m.LeftWidth-result.FittedLeft) > tolerance || absInt(m.CharWidth-result.FittedWidth) > tolerance {
+			outliers = append(outliers, result)
+		}
+	}
+
+	sort.Slice(outliers, func(i, j int) bool {
+		return outliers[i].Code < outliers[j].Code
+	})
+
+	return outliers
+}
+
+// Writes the glyphs fitting could not bring within tolerance
+func printWidthFitReport(w io.Writer, fontName string, outliers []glyphFit) {
+	if len(outliers) == 0 {
+		fmt.Fprintln(w, fontName, "every glyph width is within tolerance")
+		return
+	}
+
+	fmt.Fprintln(w, fontName, len(outliers), "glyphs are still outside tolerance")
+	fmt.Fprintln(w, "  char   code   left: original font fitted   width: original font fitted")
+	for _, o := range outliers {
+		fmt.Fprintf(w, "  %-4q %6d         %8d %4d %6d          %8d %4d %6d\n",
+			rune(o.Code), o.Code,
+			o.OriginalLeft, o.FontLeft, o.FittedLeft,
+			o.OriginalWidth, o.FontWidth, o.FittedWidth)
+	}
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,315 @@
+package bffnt_headers
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"sort"
+
+	"github.com/disintegration/imaging"
+)
+
+// Font is a bffnt seen per character instead of per section. The data of a
+// character is spread over the CMAPs (its glyph index), the CWDHs (its
+// widths), a cell of the TGLP sheets (its image) and the KRNG (its kerning).
+// A Font keeps all of it together in a Glyph and lays the sections out again
+// on Encode.
+type Font struct {
+	// Header sections of the font. FINF and TGLP settings like the cell size
+	// are kept, the CWDHs, CMAPs, KRNG and sheets are rebuilt from the glyphs
+	// on Encode.
+	BFFNT *BFFNT
+
+	glyphs map[rune]*Glyph
+	// Runes in glyph index order. Glyph i is drawn in cell i of the sheets.
+	order []rune
+	// Character drawn for characters the font does not have. -1 when the
+	// decoded font has no character at FINF.AlterCharIndex.
+	alterChar rune
+}
+
+// Everything the font has for a single character
+type Glyph struct {
+	Rune       rune
+	LeftWidth  int8  // left spacing
+	GlyphWidth uint8 // width of the drawn glyph in the cell
+	CharWidth  uint8 // advance of the pen
+
+	// The cell of the glyph, TGLP.CellWidth by TGLP.CellHeight pixels
+	Image *image.NRGBA
+
+	// Kerning with the character that follows this one
+	Kerning map[rune]int16
+
+	// Where the cell is on the sheets. Filled by Font.Glyph, ignored by
+	// AddGlyph and ReplaceGlyph.
+	Sheet, Row, Column int
+}
+
+// DecodeFont decodes a bffnt file into a Font.
+func DecodeFont(bffntRaw []byte) (*Font, error) {
+	var b BFFNT
+	err := b.Decode(bffntRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFont(&b)
+}
+
+// NewFont collects the glyphs of a decoded bffnt. The sheets have to be
+// decoded. Characters that share a glyph index each get their own copy of
+// the glyph.
+func NewFont(b *BFFNT) (*Font, error) {
+	err := checkEqual(TGLP_MAGIC_HEADER, int(b.TGLP.SheetDataOffset), "NumOfSheets vs sheet images", ErrSizeMismatch, int(b.TGLP.NumOfSheets), len(b.TGLP.SheetData))
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Font{
+		BFFNT:     b,
+		glyphs:    make(map[rune]*Glyph),
+		alterChar: -1,
+	}
+
+	for _, pair := range b.GlyphIndexes() {
+		r := rune(pair.CharAscii)
+		if _, ok := f.glyphs[r]; ok {
+			continue
+		}
+
+		glyph := &Glyph{
+			Rune:       r,
+			LeftWidth:  int8(b.FINF.DefaultLeftWidth),
+			GlyphWidth: b.FINF.DefaultGlyphWidth,
+			CharWidth:  b.FINF.DefaultCharWidth,
+			Image:      f.cellImage(int(pair.CharIndex)),
+		}
+		if widths := b.glyphWidths(pair.CharIndex); widths != nil {
+			glyph.LeftWidth = widths.LeftWidth
+			glyph.GlyphWidth = widths.GlyphWidth
+			glyph.CharWidth = widths.CharWidth
+		}
+		for _, kerning := range b.KRNG.KerningTable[pair.CharAscii] {
+			if glyph.Kerning == nil {
+				glyph.Kerning = make(map[rune]int16)
+			}
+			glyph.Kerning[rune(kerning.SecondChar)] = kerning.KerningValue
+		}
+
+		if pair.CharIndex == b.FINF.AlterCharIndex && f.alterChar == -1 {
+			f.alterChar = r
+		}
+		f.glyphs[r] = glyph
+		f.order = append(f.order, r)
+	}
+
+	return f, nil
+}
+
+// Runes of every glyph in glyph index order
+func (f *Font) Runes() []rune {
+	return append([]rune(nil), f.order...)
+}
+
+// Glyph returns a copy of everything the font has for a character.
+func (f *Font) Glyph(r rune) (Glyph, bool) {
+	glyph, ok := f.glyphs[r]
+	if !ok {
+		return Glyph{}, false
+	}
+
+	res := glyph.clone()
+	for i, orderRune := range f.order {
+		if orderRune == r {
+			res.Sheet, res.Row, res.Column = f.BFFNT.TGLP.cellPosition(i)
+			break
+		}
+	}
+
+	return res, true
+}
+
+// AddGlyph adds a character the font does not have yet. It gets the cell
+// after the last glyph, a nil Image is a blank cell.
+func (f *Font) AddGlyph(glyph Glyph) error {
+	if _, ok := f.glyphs[glyph.Rune]; ok {
+		return fmt.Errorf("%w: %q", ErrGlyphExists, glyph.Rune)
+	}
+	if len(f.order) >= math.MaxUint16 {
+		return fmt.Errorf("%w: a font can have at most %d glyphs", ErrValueOutOfRange, math.MaxUint16)
+	}
+
+	err := f.checkGlyph(&glyph)
+	if err != nil {
+		return err
+	}
+
+	added := glyph.clone()
+	f.glyphs[glyph.Rune] = &added
+	f.order = append(f.order, glyph.Rune)
+
+	return nil
+}
+
+// RemoveGlyph removes a character and the kerning of every pair it is in.
+// The glyphs after it move up a cell. The alter char can not be removed.
+func (f *Font) RemoveGlyph(r rune) error {
+	if _, ok := f.glyphs[r]; !ok {
+		return fmt.Errorf("%w: %q", ErrGlyphNotFound, r)
+	}
+	if r == f.alterChar {
+		return fmt.Errorf("%w: %q", ErrAlterChar, r)
+	}
+
+	delete(f.glyphs, r)
+	for i, orderRune := range f.order {
+		if orderRune == r {
+			f.order = append(f.order[:i], f.order[i+1:]...)
+			break
+		}
+	}
+	for _, glyph := range f.glyphs {
+		delete(glyph.Kerning, r)
+	}
+
+	return nil
+}
+
+// ReplaceGlyph replaces the widths, image and kerning of a character the
+// font has. The glyph keeps its cell.
+func (f *Font) ReplaceGlyph(glyph Glyph) error {
+	if _, ok := f.glyphs[glyph.Rune]; !ok {
+		return fmt.Errorf("%w: %q", ErrGlyphNotFound, glyph.Rune)
+	}
+
+	err := f.checkGlyph(&glyph)
+	if err != nil {
+		return err
+	}
+
+	replaced := glyph.clone()
+	f.glyphs[glyph.Rune] = &replaced
+
+	return nil
+}
+
+// Encode lays the glyphs out in cells in glyph index order, rebuilds the
+// CWDHs, CMAPs, KRNG and sheets from them and encodes the font.
+func (f *Font) Encode() ([]byte, error) {
+	b := f.BFFNT
+
+	cellsPerSheet := int(b.TGLP.NumOfColumns) * int(b.TGLP.NumOfRows)
+	sheetCount := (len(f.order) + cellsPerSheet - 1) / cellsPerSheet
+	if sheetCount < 1 {
+		sheetCount = 1
+	}
+	if sheetCount > math.MaxUint8 {
+		return nil, &SectionError{
+			Section:  TGLP_MAGIC_HEADER,
+			Offset:   FFNT_HEADER_SIZE + FINF_HEADER_SIZE + 10,
+			Field:    "NumOfSheets",
+			Expected: math.MaxUint8,
+			Actual:   sheetCount,
+			Err:      ErrValueOutOfRange,
+		}
+	}
+
+	cwdh := CWDH{MagicHeader: CWDH_MAGIC_HEADER}
+	pairs := make([]AsciiIndexPair, 0, len(f.order))
+	kerningTable := make(map[uint16][]kerningPair)
+	b.TGLP.SheetData = make([]image.NRGBA, sheetCount)
+	for i := range b.TGLP.SheetData {
+		b.TGLP.SheetData[i] = *image.NewNRGBA(image.Rect(0, 0, int(b.TGLP.SheetWidth), int(b.TGLP.SheetHeight)))
+	}
+
+	for i, r := range f.order {
+		glyph := f.glyphs[r]
+
+		cwdh.Glyphs = append(cwdh.Glyphs, glyphInfo{
+			LeftWidth:  glyph.LeftWidth,
+			GlyphWidth: glyph.GlyphWidth,
+			CharWidth:  glyph.CharWidth,
+		})
+		pairs = append(pairs, AsciiIndexPair{CharAscii: uint16(r), CharIndex: uint16(i)})
+
+		for second, value := range glyph.Kerning {
+			kerningTable[uint16(r)] = append(kerningTable[uint16(r)], kerningPair{SecondChar: uint16(second), KerningValue: value})
+		}
+		kerningPairs := kerningTable[uint16(r)]
+		sort.Slice(kerningPairs, func(i, j int) bool {
+			return kerningPairs[i].SecondChar < kerningPairs[j].SecondChar
+		})
+
+		sheet, row, column := f.BFFNT.TGLP.cellPosition(i)
+		draw.Draw(&b.TGLP.SheetData[sheet], f.BFFNT.TGLP.cellRect(row, column), glyph.Image, image.Point{}, draw.Src)
+	}
+
+	b.TGLP.NumOfSheets = uint8(sheetCount)
+	b.TGLP.SectionSize = uint32(TGLP_HEADER_SIZE+b.TGLP.computePredataPadding()) + b.TGLP.SheetSize*uint32(sheetCount)
+	b.CWDHs = []CWDH{cwdh}
+	cmaps, err := PlanCMAPs(pairs)
+	if err != nil {
+		return nil, err
+	}
+	b.CMAPs = cmaps
+	b.KRNG.KerningTable = kerningTable
+
+	b.FINF.AlterCharIndex = 0
+	for i, r := range f.order {
+		if r == f.alterChar {
+			b.FINF.AlterCharIndex = uint16(i)
+			break
+		}
+	}
+
+	b.CWDHIndexMap = make(map[rune]int, len(pairs))
+	for _, pair := range pairs {
+		b.CWDHIndexMap[rune(pair.CharAscii)] = int(pair.CharIndex)
+	}
+
+	return b.Encode()
+}
+
+// Checks a glyph fits in the font, a nil image becomes a blank cell
+func (f *Font) checkGlyph(glyph *Glyph) error {
+	if glyph.Rune < 0 || glyph.Rune > math.MaxUint16 {
+		return fmt.Errorf("%w: %q is outside the character codes a CMAP can map", ErrValueOutOfRange, glyph.Rune)
+	}
+
+	cellWidth, cellHeight := int(f.BFFNT.TGLP.CellWidth), int(f.BFFNT.TGLP.CellHeight)
+	if glyph.Image == nil {
+		glyph.Image = image.NewNRGBA(image.Rect(0, 0, cellWidth, cellHeight))
+	}
+	size := glyph.Image.Rect.Size()
+	if size.X != cellWidth || size.Y != cellHeight {
+		return fmt.Errorf("%w: %q image is %dx%d, cells are %dx%d", ErrSizeMismatch, glyph.Rune, size.X, size.Y, cellWidth, cellHeight)
+	}
+
+	return nil
+}
+
+// Copy of the cell of a glyph index from the decoded sheets
+func (f *Font) cellImage(index int) *image.NRGBA {
+	sheet, row, column := f.BFFNT.TGLP.cellPosition(index)
+	if sheet >= len(f.BFFNT.TGLP.SheetData) {
+		cellWidth, cellHeight := int(f.BFFNT.TGLP.CellWidth), int(f.BFFNT.TGLP.CellHeight)
+		return image.NewNRGBA(image.Rect(0, 0, cellWidth, cellHeight))
+	}
+	return imaging.Crop(&f.BFFNT.TGLP.SheetData[sheet], f.BFFNT.TGLP.cellRect(row, column))
+}
+
+func (g *Glyph) clone() Glyph {
+	res := *g
+	if g.Image != nil {
+		res.Image = imaging.Clone(g.Image)
+	}
+	if g.Kerning != nil {
+		res.Kerning = make(map[rune]int16, len(g.Kerning))
+		for second, value := range g.Kerning {
+			res.Kerning[second] = value
+		}
+	}
+	return res
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 )
 
@@ -15,6 +16,7 @@ var (
 const (
 	// number of bytes for each header size
 	FFNT_HEADER_SIZE = 20
+	RFNT_HEADER_SIZE = 16
 	FINF_HEADER_SIZE = 32
 	TGLP_HEADER_SIZE = 32
 	CWDH_HEADER_SIZE = 16
@@ -22,6 +24,9 @@ const (
 	KRNG_HEADER_SIZE = 8
 
 	FFNT_MAGIC_HEADER = "FFNT"
+	CFNT_MAGIC_HEADER = "CFNT"
+	CFNU_MAGIC_HEADER = "CFNU"
+	RFNT_MAGIC_HEADER = "RFNT"
 	FINF_MAGIC_HEADER = "FINF"
 	TGLP_MAGIC_HEADER = "TGLP"
 	CWDH_MAGIC_HEADER = "CWDH"
@@ -29,11 +34,265 @@ const (
 	KRNG_MAGIC_HEADER = "KRNG"
 )
 
-func assertEqual(expected int, actual int) {
+// The console a font is made for. It decides how the sheet image formats are
+// numbered and how sheets are laid out.
+type Platform int
+
+const (
+	PlatformWiiU Platform = iota
+	Platform3DS
+	PlatformSwitch
+	PlatformWii
+)
+
+func (p Platform) String() string {
+	switch p {
+	case Platform3DS:
+		return "3DS"
+	case PlatformSwitch:
+		return "Switch"
+	case PlatformWii:
+		return "Wii"
+	default:
+		return "Wii U"
+	}
+}
+
+// Largest sheet width and height the GPU of the platform can sample, 0 when
+// it is large enough for any font. PICA200 and GX textures are at most
+// 1024x1024.
+func (p Platform) maxSheetSize() int {
+	switch p {
+	case Platform3DS, PlatformWii:
+		return 1024
+	default:
+		return 0
+	}
+}
+
+// Platform of a font file from its magic header and byte order. 3DS and Wii
+// fonts have their own magic, Switch fonts are FFNT like on the Wii U but
+// little endian.
+func filePlatform(magic string, order binary.ByteOrder) Platform {
+	switch {
+	case magic == CFNT_MAGIC_HEADER || magic == CFNU_MAGIC_HEADER:
+		return Platform3DS
+	case magic == RFNT_MAGIC_HEADER:
+		return PlatformWii
+	case order == binary.LittleEndian:
+		return PlatformSwitch
+	default:
+		return PlatformWiiU
+	}
+}
+
+// How the file header, FINF and TGLP are laid out. NintendoWare moved the
+// fields around between versions of the font format, the magic header and
+// version in the file header decide the layout of a file. The zero value is
+// the Wii U layout, so sections made from scratch write Wii U files.
+type headerLayout int
+
+const (
+	layoutFFNT headerLayout = iota // 20 byte file header, 2 byte line feed and baseline
+	layoutCFNT                     // 20 byte file header, 1 byte line feed and baseline and the FINF and TGLP fields in another order
+	layoutRFNT                     // 16 byte file header, FINF and TGLP like CFNT
+)
+
+// Every version that can be read and written
+var fileVersions = []struct {
+	Magic   string
+	Version uint32
+	Layout  headerLayout
+}{
+	{RFNT_MAGIC_HEADER, 0x0104, layoutRFNT},     // Wii
+	{CFNT_MAGIC_HEADER, 0x03000000, layoutCFNT}, // 3DS
+	{CFNU_MAGIC_HEADER, 0x03000000, layoutCFNT}, // 3DS
+	{FFNT_MAGIC_HEADER, 0x03000000, layoutFFNT}, // Wii U, every botw font
+	{FFNT_MAGIC_HEADER, 0x04000000, layoutFFNT}, // version 4 on 3dbrew
+	{FFNT_MAGIC_HEADER, 0x04010000, layoutFFNT}, // Switch
+}
+
+// Layout of a magic header and version. Returns an ErrUnknownVersion
+// SectionError for versions not in fileVersions.
+func versionLayout(magic string, version uint32) (headerLayout, error) {
+	for _, v := range fileVersions {
+		if v.Magic == magic && v.Version == version {
+			return v.Layout, nil
+		}
+	}
+
+	return layoutFFNT, &SectionError{
+		Section: FFNT_MAGIC_HEADER,
+		Offset:  versionOffset(magic),
+		Field:   fmt.Sprintf("%s version 0x%X", magic, version),
+		Err:     ErrUnknownVersion,
+	}
+}
+
+// The RFNT header has a 2 byte version at 0x06, the others a 4 byte version
+// at 0x08.
+func fileVersion(header []byte, magic string, order binary.ByteOrder) uint32 {
+	if magic == RFNT_MAGIC_HEADER {
+		return uint32(order.Uint16(header[6:8]))
+	}
+	return order.Uint32(header[8:12])
+}
+
+func versionOffset(magic string) int {
+	if magic == RFNT_MAGIC_HEADER {
+		return 6
+	}
+	return 8
+}
+
+func (l headerLayout) fileHeaderSize() int {
+	if l == layoutRFNT {
+		return RFNT_HEADER_SIZE
+	}
+	return FFNT_HEADER_SIZE
+}
+
+// The Wii and 3DS put the FINF and TGLP fields in another order, with a 1
+// byte line feed and baseline
+func (l headerLayout) legacyFields() bool {
+	return l == layoutCFNT || l == layoutRFNT
+}
+
+// Byte order, platform and header layout of a font file from its file header
+func fileFormat(raw []byte) (binary.ByteOrder, Platform, headerLayout, error) {
+	order := byteOrderMark(raw)
+	header, err := sliceSection(raw, FFNT_MAGIC_HEADER, "header", 0, RFNT_HEADER_SIZE)
+	if err != nil {
+		return order, PlatformWiiU, layoutFFNT, err
+	}
+
+	magic := string(header[0:4])
+	layout, err := versionLayout(magic, fileVersion(header, magic, order))
+	return order, filePlatform(magic, order), layout, err
+}
+
+// Byte order of a font file from the byte order mark in its header. The mark
+// is 0xFEFF written in the order of the file, so FE FF is big endian (Wii U
+// and Wii) and FF FE little endian (3DS and Switch).
+func byteOrderMark(raw []byte) binary.ByteOrder {
+	if len(raw) >= 6 && raw[4] == 0xFF && raw[5] == 0xFE {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// Byte order a section is read and written in. Decode takes it from the byte
+// order mark of the file and BFFNT.Encode from the FFNT section. The zero
+// value is big endian like the Wii U fonts, so sections made from scratch
+// write Wii U files.
+type sectionOrder struct {
+	byteOrder binary.ByteOrder
+}
+
+func (s sectionOrder) order() binary.ByteOrder {
+	if s.byteOrder == nil {
+		return binary.BigEndian
+	}
+	return s.byteOrder
+}
+
+var (
+	ErrTruncated             = errors.New("unexpected end of data")
+	ErrMagicHeader           = errors.New("unexpected magic header")
+	ErrSizeMismatch          = errors.New("size mismatch")
+	ErrOffsetMismatch        = errors.New("offset mismatch")
+	ErrLeftoverBytes         = errors.New("left over bytes are not zero'd")
+	ErrUnknownMappingMethod  = errors.New("unknown mapping method")
+	ErrUnknownVersion        = errors.New("unknown file format version")
+	ErrInvalidRange          = errors.New("invalid range")
+	ErrNotOn4ByteBoundary    = errors.New("not at 4 byte boundary")
+	ErrValueOutOfRange       = errors.New("value out of range")
+	ErrSectionOffsetNotAhead = errors.New("next section offset does not move forward")
+	ErrUnsupportedFormat     = errors.New("unsupported sheet image format")
+	ErrGlyphExists           = errors.New("font already has the glyph")
+	ErrGlyphNotFound         = errors.New("font does not have the glyph")
+	ErrAlterChar             = errors.New("alter char can not be removed")
+	ErrDuplicateCode         = errors.New("character code is mapped more than once")
+)
+
+// SectionError is returned by every Decode and Encode in this package. It
+// says which section failed, the byte offset into the bffnt file where the
+// problem was found, and the expected vs. actual value when there is one to
+// compare. Use errors.Is on the error to find out what kind of problem it was
+// (ErrTruncated, ErrMagicHeader, ...).
+type SectionError struct {
+	Section  string // magic header of the section, e.g. "TGLP"
+	Offset   int    // byte offset into the bffnt file
+	Field    string // the field or part of the section that failed
+	Expected int
+	Actual   int
+	Err      error
+}
+
+func (e *SectionError) Error() string {
+	msg := fmt.Sprintf("%s section at offset %d", e.Section, e.Offset)
+	if e.Field != "" {
+		msg += ": " + e.Field
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Expected != e.Actual {
+		msg += fmt.Sprintf(" (expected %d, actual %d)", e.Expected, e.Actual)
+	}
+
+	return msg
+}
+
+func (e *SectionError) Unwrap() error {
+	return e.Err
+}
+
+// Returns raw[start:end] or an ErrTruncated SectionError if the requested
+// range does not fit in raw. Expected is the end of the range and actual is
+// the amount of bytes available.
+func sliceSection(raw []byte, section string, field string, start int, end int) ([]byte, error) {
+	if start < 0 || end < start || end > len(raw) {
+		return nil, &SectionError{
+			Section:  section,
+			Offset:   start,
+			Field:    field,
+			Expected: end,
+			Actual:   len(raw),
+			Err:      ErrTruncated,
+		}
+	}
+
+	return raw[start:end], nil
+}
+
+func checkMagicHeader(section string, offset int, actual string) error {
+	if actual != section {
+		return &SectionError{
+			Section: section,
+			Offset:  offset,
+			Field:   fmt.Sprintf("magic header %q", actual),
+			Err:     ErrMagicHeader,
+		}
+	}
+
+	return nil
+}
+
+// Returns a SectionError when expected and actual are not the same.
+func checkEqual(section string, offset int, field string, err error, expected int, actual int) error {
 	if expected != actual {
-		err := fmt.Errorf("%d(actual) does not equal %d(expected)\n", actual, expected)
-		handleErr(err)
+		return &SectionError{
+			Section:  section,
+			Offset:   offset,
+			Field:    field,
+			Expected: expected,
+			Actual:   actual,
+			Err:      err,
+		}
 	}
+
+	return nil
 }
 
 func handleErr(err error) {
@@ -42,9 +301,11 @@ func handleErr(err error) {
 	}
 }
 
-// Just a wrapper around binary.Write
-func binaryWrite(w *bufio.Writer, data interface{}) {
-	err := binary.Write(w, binary.BigEndian, data)
+// Just a wrapper around binary.Write. Writing fixed size data into a
+// bytes.Buffer can not fail, an error here means a non fixed size value was
+// passed in which is a programming error so we still panic.
+func binaryWrite(w *bufio.Writer, order binary.ByteOrder, data interface{}) {
+	err := binary.Write(w, order, data)
 	handleErr(err)
 
 	// just call every time. its easy to forget and end up with missing bytes
@@ -62,20 +323,30 @@ func pprint(s interface{}) {
 // It looks like in some cases there can be left over bytes from a section
 // after decoding is done. Not a significant amount. Usually 2, 4, or 6 bytes.
 // If these bytes are really unused we should expect them to be zero'd out.
-func verifyLeftoverBytes(leftovers []byte) {
+func verifyLeftoverBytes(section string, offset int, leftovers []byte) error {
 	if len(leftovers) > 0 {
 		if Debug {
 			fmt.Printf("%d bytes left over\n", len(leftovers))
 		}
 
-		for _, singleByte := range leftovers {
+		for i, singleByte := range leftovers {
 			if singleByte != 0 {
-				fmt.Println("left over bytes:", leftovers)
-				err := fmt.Errorf("There are left over bytes that are not zero'd")
-				handleErr(err)
+				if Debug {
+					fmt.Println("left over bytes:", leftovers)
+				}
+				return &SectionError{
+					Section:  section,
+					Offset:   offset + i,
+					Field:    "padding",
+					Expected: 0,
+					Actual:   int(singleByte),
+					Err:      ErrLeftoverBytes,
+				}
 			}
 		}
 	}
+
+	return nil
 }
 
 // After every CWDH, CMAP, and KRNG section and its data is encoded. There is padding
@@ -91,10 +362,20 @@ func paddingToNext4ByteBoundary(offset int) int {
 	return 4 - remainder
 }
 
-func check4ByteBoundary(offset int) {
-	if paddingToNext4ByteBoundary(offset) != 0 {
-		panic(fmt.Sprintf("%d not at 4 byte boundary", offset))
+func check4ByteBoundary(section string, offset int) error {
+	padding := paddingToNext4ByteBoundary(offset)
+	if padding != 0 {
+		return &SectionError{
+			Section:  section,
+			Offset:   offset,
+			Field:    "section end",
+			Expected: offset + padding,
+			Actual:   offset,
+			Err:      ErrNotOn4ByteBoundary,
+		}
 	}
+
+	return nil
 }
 
 func padToNext4ByteBoundary(w *bufio.Writer, buf *bytes.Buffer, startOffset int) {
@@ -103,12 +384,7 @@ func padToNext4ByteBoundary(w *bufio.Writer, buf *bytes.Buffer, startOffset int)
 
 	paddingAmount := paddingToNext4ByteBoundary(totalBytesSoFar)
 	for i := 0; i < paddingAmount; i++ {
-		binaryWrite(w, byte(0))
+		_ = w.WriteByte(0)
 	}
 	w.Flush()
-
-	totalBytesWithPadding := totalBytesSoFar + paddingAmount
-	if paddingToNext4ByteBoundary(totalBytesWithPadding) != 0 {
-		panic(fmt.Sprintf("%d not at 4 byte boundary", totalBytesWithPadding))
-	}
 }
@@ -0,0 +1,160 @@
+package bffnt_headers
+
+import (
+	"fmt"
+)
+
+// The Wii GPU (GX) reads textures in tiles of 32 bytes, stored row by row.
+// A tile is 8x8 pixels of 4 bit formats, 8x4 of 8 bit formats and 4x4 of 16
+// bit formats, with the pixels of a tile in row order. 4 bit pixels have the
+// first pixel in the high nibble. RGBA8 tiles are 4x4 pixels in two 32 byte
+// halves, the alpha and red of every pixel first and then the green and blue.
+//
+// Source:
+// http://wiki.tockdom.com/wiki/Image_Formats
+// and Dolphin (TextureDecoder_Generic.cpp)
+
+const gxTileBytes = 32
+
+// A single Wii texture. Width and Height are counted in pixels, Pitch and
+// AlignedHeight are padded to whole tiles.
+type gxLayout struct {
+	Width         uint
+	Height        uint
+	Bpp           uint // bits per pixel
+	TileWidth     uint
+	TileHeight    uint
+	Pitch         uint
+	AlignedHeight uint
+}
+
+func newGXLayout(width int, height int, format sheetFormat) (gxLayout, error) {
+	l := gxLayout{
+		Width:  uint(width),
+		Height: uint(height),
+		Bpp:    format.Bpp,
+	}
+
+	if width <= 0 || height <= 0 {
+		return l, fmt.Errorf("wii texture can not be %dx%d", width, height)
+	}
+	if format.BlockSize != 1 {
+		return l, fmt.Errorf("unsupported wii texture block size: %d", format.BlockSize)
+	}
+
+	switch l.Bpp {
+	case 4:
+		l.TileWidth, l.TileHeight = 8, 8
+	case 8:
+		l.TileWidth, l.TileHeight = 8, 4
+	case 16, 32:
+		l.TileWidth, l.TileHeight = 4, 4
+	default:
+		return l, fmt.Errorf("unsupported wii texture bpp: %d", l.Bpp)
+	}
+
+	l.Pitch = alignUp(l.Width, l.TileWidth)
+	l.AlignedHeight = alignUp(l.Height, l.TileHeight)
+
+	return l, nil
+}
+
+// Size in bytes of the tiled texture including the tile padding.
+func (l gxLayout) Size() int {
+	return int(l.Pitch * l.AlignedHeight * l.Bpp / 8)
+}
+
+// Takes the tiled texture and returns the pixels in row order with no
+// padding.
+func (l gxLayout) Deswizzle(data []byte) ([]byte, error) {
+	return l.swizzleTexture(data, false)
+}
+
+// Takes the pixels in row order with no padding and returns the tiled
+// texture, Size() bytes.
+func (l gxLayout) Swizzle(data []byte) ([]byte, error) {
+	return l.swizzleTexture(data, true)
+}
+
+func (l gxLayout) swizzleTexture(data []byte, swizzle bool) ([]byte, error) {
+	linearSize := int((l.Width*l.Height*l.Bpp + 7) / 8)
+
+	var result []byte
+	if swizzle {
+		if len(data) != linearSize {
+			return nil, fmt.Errorf("swizzle expected %d bytes of image data, got %d", linearSize, len(data))
+		}
+		result = make([]byte, l.Size())
+	} else {
+		if len(data) < l.Size() {
+			return nil, fmt.Errorf("deswizzle expected %d bytes of texture data, got %d", l.Size(), len(data))
+		}
+		result = make([]byte, linearSize)
+	}
+
+	for y := uint(0); y < l.Height; y++ {
+		for x := uint(0); x < l.Width; x++ {
+			tiledIndex := l.pixelIndex(x, y)
+			linearIndex := y*l.Width + x
+
+			switch l.Bpp {
+			case 4:
+				// the row order data has the first pixel in the low nibble
+				if swizzle {
+					setNibble(result, tiledIndex, true, getNibble(data, linearIndex, false))
+				} else {
+					setNibble(result, linearIndex, false, getNibble(data, tiledIndex, true))
+				}
+			case 32:
+				for channel, at := range l.rgba8Bytes(tiledIndex) {
+					if swizzle {
+						result[at] = data[4*linearIndex+uint(channel)]
+					} else {
+						result[4*linearIndex+uint(channel)] = data[at]
+					}
+				}
+			default:
+				if swizzle {
+					copyElement(result, tiledIndex, data, linearIndex, l.Bpp)
+				} else {
+					copyElement(result, linearIndex, data, tiledIndex, l.Bpp)
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Position of the pixel at x, y in the tiled texture, counted in pixels
+func (l gxLayout) pixelIndex(x uint, y uint) uint {
+	tileX, tileY := x/l.TileWidth, y/l.TileHeight
+	tileIndex := tileY*(l.Pitch/l.TileWidth) + tileX
+	return tileIndex*l.TileWidth*l.TileHeight + (y%l.TileHeight)*l.TileWidth + x%l.TileWidth
+}
+
+// Where the red, green, blue and alpha byte of an RGBA8 pixel are in the
+// tiled texture
+func (l gxLayout) rgba8Bytes(pixelIndex uint) [4]uint {
+	pixelsPerTile := l.TileWidth * l.TileHeight
+	tileStart := pixelIndex / pixelsPerTile * 2 * gxTileBytes
+	ar := tileStart + 2*(pixelIndex%pixelsPerTile)
+	gb := ar + gxTileBytes
+	return [4]uint{ar + 1, gb, gb + 1, ar}
+}
+
+func getNibble(data []byte, index uint, highFirst bool) byte {
+	return (data[index/2] >> nibbleShift(index, highFirst)) & 0xF
+}
+
+func setNibble(data []byte, index uint, highFirst bool, value byte) {
+	shift := nibbleShift(index, highFirst)
+	data[index/2] = data[index/2]&^(0xF<<shift) | value<<shift
+}
+
+func nibbleShift(index uint, highFirst bool) uint {
+	if highFirst {
+		return 4 * (1 - index%2)
+	}
+	return 4 * (index % 2)
+}
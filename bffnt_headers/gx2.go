@@ -0,0 +1,502 @@
+package bffnt_headers
+
+import (
+	"fmt"
+)
+
+// The Wii U stores textures the way its GPU wants to read them. Pixels are
+// not stored row by row, instead they are "swizzled" into micro tiles (8x8
+// pixels) that are grouped into macro tiles and spread over memory banks and
+// pipes. This file is a port of the parts of AMD's addrlib that GX2 uses to
+// compute where a pixel ends up.
+//
+// Ported from KillzXGaming/Switch-Toolbox and AboodXD's addrlib.py
+
+type AddrTileMode uint
+
+const (
+	ADDR_TM_LINEAR_GENERAL AddrTileMode = iota
+	ADDR_TM_LINEAR_ALIGNED
+	ADDR_TM_1D_TILED_THIN1
+	ADDR_TM_1D_TILED_THICK
+	ADDR_TM_2D_TILED_THIN1
+	ADDR_TM_2D_TILED_THIN2
+	ADDR_TM_2D_TILED_THIN4
+	ADDR_TM_2D_TILED_THICK
+	ADDR_TM_2B_TILED_THIN1
+	ADDR_TM_2B_TILED_THIN2
+	ADDR_TM_2B_TILED_THIN4
+	ADDR_TM_2B_TILED_THICK
+	ADDR_TM_3D_TILED_THIN1
+	ADDR_TM_3D_TILED_THICK
+	ADDR_TM_3B_TILED_THIN1
+	ADDR_TM_3B_TILED_THICK
+	ADDR_TM_2D_TILED_XTHICK
+	ADDR_TM_3D_TILED_XTHICK
+	ADDR_TM_POWER_SAVE
+	ADDR_TM_COUNT
+)
+
+const (
+	// Every GX2 surface in a bffnt is tiled with this mode
+	GX2_DEFAULT_TILE_MODE = ADDR_TM_2D_TILED_THIN1
+
+	// r7xx memory layout the Wii U uses
+	gx2NumPipes           = 2
+	gx2NumBanks           = 4
+	gx2PipeInterleaveSize = 256
+	gx2SwapSize           = 256
+	gx2RowSize            = 2048
+)
+
+// A single GX2 surface. Width and Height are counted in elements. An element
+// is a pixel, or a 4x4 block of pixels for the block compressed (BCn)
+// formats. Pitch and AlignedHeight are the dimensions after they have been
+// padded to fit whole tiles.
+type gx2Surface struct {
+	Width           uint
+	Height          uint
+	Bpp             uint // bits per element
+	TileMode        AddrTileMode
+	PipeBankSwizzle uint // bit 8 is the pipe swizzle, bits 9-10 the bank swizzle
+	Pitch           uint
+	AlignedHeight   uint
+}
+
+func newGX2Surface(width uint, height uint, bpp uint, tileMode AddrTileMode, swizzle uint) (gx2Surface, error) {
+	s := gx2Surface{
+		Width:           width,
+		Height:          height,
+		Bpp:             bpp,
+		TileMode:        tileMode,
+		PipeBankSwizzle: swizzle,
+	}
+
+	if width == 0 || height == 0 {
+		return s, fmt.Errorf("gx2 surface can not be %dx%d", width, height)
+	}
+
+	switch bpp {
+	case 8, 16, 32, 64, 128:
+	default:
+		return s, fmt.Errorf("unsupported gx2 surface bpp: %d", bpp)
+	}
+
+	pitchAlign, heightAlign, err := computeSurfaceAlignments(tileMode, bpp)
+	if err != nil {
+		return s, err
+	}
+
+	s.Pitch = alignUp(width, pitchAlign)
+	s.AlignedHeight = alignUp(height, heightAlign)
+
+	return s, nil
+}
+
+// Every sheet in a bffnt is a slice of a GX2 2D array texture. The pipe and
+// bank of a slice is rotated by the slice index which works out to a bank
+// swizzle of sheetIndex % 4 for every sheet.
+func sheetSwizzle(sheetIndex int) uint {
+	return uint(sheetIndex%gx2NumBanks) << 9
+}
+
+// Size in bytes of the swizzled surface including the tile padding.
+func (s gx2Surface) Size() int {
+	thickness := computeSurfaceThickness(s.TileMode)
+	return int((s.Pitch*s.AlignedHeight*thickness*s.Bpp + 7) / 8)
+}
+
+// Takes the swizzled surface and returns the elements in row order with no
+// padding, width*height*bpp/8 bytes.
+func (s gx2Surface) Deswizzle(data []byte) ([]byte, error) {
+	return s.swizzleSurface(data, false)
+}
+
+// Takes the elements in row order with no padding and returns the swizzled
+// surface, Size() bytes.
+func (s gx2Surface) Swizzle(data []byte) ([]byte, error) {
+	return s.swizzleSurface(data, true)
+}
+
+func (s gx2Surface) swizzleSurface(data []byte, swizzle bool) ([]byte, error) {
+	bytesPerElement := s.Bpp / 8
+	linearSize := int(s.Width * s.Height * bytesPerElement)
+
+	var result []byte
+	if swizzle {
+		if len(data) != linearSize {
+			return nil, fmt.Errorf("swizzle expected %d bytes of image data, got %d", linearSize, len(data))
+		}
+		result = make([]byte, s.Size())
+	} else {
+		if len(data) < s.Size() {
+			return nil, fmt.Errorf("deswizzle expected %d bytes of surface data, got %d", s.Size(), len(data))
+		}
+		result = make([]byte, linearSize)
+	}
+
+	for y := uint(0); y < s.Height; y++ {
+		for x := uint(0); x < s.Width; x++ {
+			swizzledIndex := s.computeSurfaceAddrFromCoord(x, y)
+			linearIndex := (y*s.Width + x) * bytesPerElement
+
+			if swizzle {
+				copy(result[swizzledIndex:swizzledIndex+bytesPerElement], data[linearIndex:linearIndex+bytesPerElement])
+			} else {
+				copy(result[linearIndex:linearIndex+bytesPerElement], data[swizzledIndex:swizzledIndex+bytesPerElement])
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Byte offset of the element at x, y
+func (s gx2Surface) computeSurfaceAddrFromCoord(x uint, y uint) uint {
+	pipeSwizzle := (s.PipeBankSwizzle >> 8) & 1
+	bankSwizzle := (s.PipeBankSwizzle >> 9) & 3
+
+	switch s.TileMode {
+	case ADDR_TM_LINEAR_GENERAL, ADDR_TM_LINEAR_ALIGNED:
+		return computeSurfaceAddrFromCoordLinear(x, y, s.Bpp, s.Pitch)
+	case ADDR_TM_1D_TILED_THIN1, ADDR_TM_1D_TILED_THICK:
+		return computeSurfaceAddrFromCoordMicroTiled(x, y, s.Bpp, s.Pitch, s.TileMode)
+	default:
+		return computeSurfaceAddrFromCoordMacroTiled(x, y, s.Bpp, s.Pitch, s.TileMode, pipeSwizzle, bankSwizzle)
+	}
+}
+
+// Pitch and height alignment in elements for a tile mode. Single sample
+// color surfaces only, that is all a font sheet can be.
+func computeSurfaceAlignments(tileMode AddrTileMode, bpp uint) (pitchAlign uint, heightAlign uint, err error) {
+	thickness := computeSurfaceThickness(tileMode)
+
+	switch tileMode {
+	case ADDR_TM_LINEAR_GENERAL:
+		return 1, 1, nil
+
+	case ADDR_TM_LINEAR_ALIGNED:
+		pitchAlign = 64
+		if 8*gx2PipeInterleaveSize/bpp > pitchAlign {
+			pitchAlign = 8 * gx2PipeInterleaveSize / bpp
+		}
+		return pitchAlign, 1, nil
+
+	case ADDR_TM_1D_TILED_THIN1, ADDR_TM_1D_TILED_THICK:
+		pitchAlign = 8
+		if gx2PipeInterleaveSize/(bpp/8)/thickness > pitchAlign {
+			pitchAlign = gx2PipeInterleaveSize / (bpp / 8) / thickness
+		}
+		return pitchAlign, 8, nil
+
+	case ADDR_TM_2D_TILED_THIN1, ADDR_TM_2D_TILED_THIN2, ADDR_TM_2D_TILED_THIN4, ADDR_TM_2D_TILED_THICK,
+		ADDR_TM_2B_TILED_THIN1, ADDR_TM_2B_TILED_THIN2, ADDR_TM_2B_TILED_THIN4, ADDR_TM_2B_TILED_THICK,
+		ADDR_TM_3D_TILED_THIN1, ADDR_TM_3D_TILED_THICK, ADDR_TM_3B_TILED_THIN1, ADDR_TM_3B_TILED_THICK:
+		macroTileWidth, macroTileHeight := computeMacroPitchAndHeight(tileMode)
+		pitchAlign = macroTileWidth * (gx2PipeInterleaveSize / bpp / (8 * thickness))
+		if pitchAlign < macroTileWidth {
+			pitchAlign = macroTileWidth
+		}
+		return pitchAlign, macroTileHeight, nil
+
+	default:
+		return 0, 0, fmt.Errorf("unsupported gx2 tile mode: %d", tileMode)
+	}
+}
+
+func alignUp(value uint, alignment uint) uint {
+	return (value + alignment - 1) / alignment * alignment
+}
+
+func computeSurfaceAddrFromCoordLinear(x uint, y uint, bpp uint, pitch uint) uint {
+	return (y*pitch + x) * bpp / 8
+}
+
+func computeSurfaceAddrFromCoordMicroTiled(x uint, y uint, bpp uint, pitch uint, tileMode AddrTileMode) uint {
+	microTileThickness := computeSurfaceThickness(tileMode)
+	microTileBytes := (64*microTileThickness*bpp + 7) / 8
+	microTilesPerRow := pitch >> 3
+	microTileIndexX := x >> 3
+	microTileIndexY := y >> 3
+	microTileOffset := microTileBytes * (microTileIndexX + microTileIndexY*microTilesPerRow)
+
+	pixelIndex := computePixelIndexWithinMicroTile(x, y, 0, bpp, tileMode, false)
+	pixelOffset := (bpp * pixelIndex) >> 3
+
+	return pixelOffset + microTileOffset
+}
+
+// Single sample, slice 0 version of computeSurfaceAddrFromCoordMacroTiled
+func computeSurfaceAddrFromCoordMacroTiled(x uint, y uint, bpp uint, pitch uint, tileMode AddrTileMode, pipeSwizzle uint, bankSwizzle uint) uint {
+	microTileThickness := computeSurfaceThickness(tileMode)
+
+	pixelIndex := computePixelIndexWithinMicroTile(x, y, 0, bpp, tileMode, false)
+	elemOffset := (bpp*pixelIndex + 7) / 8
+
+	pipe := computePipeFromCoordWoRotation(x, y)
+	bank := computeBankFromCoordWoRotation(x, y)
+
+	bankPipe := pipe + 2*bank
+	swizzle_ := pipeSwizzle + 2*bankSwizzle
+	bankPipe ^= swizzle_
+	bankPipe %= 8
+
+	pipe = bankPipe % 2
+	bank = bankPipe / 2
+
+	macroTilePitch, macroTileHeight := computeMacroPitchAndHeight(tileMode)
+	macroTilesPerRow := pitch / macroTilePitch
+	macroTileBytes := (microTileThickness*bpp*macroTileHeight*macroTilePitch + 7) / 8
+	macroTileIndexX := x / macroTilePitch
+	macroTileIndexY := y / macroTileHeight
+	macroTileOffset := (macroTileIndexX + macroTilesPerRow*macroTileIndexY) * macroTileBytes
+
+	if isBankSwappedTileMode(tileMode) {
+		bankSwapOrder := []uint{0, 1, 3, 2}
+		bankSwapWidth := computeSurfaceBankSwappedWidth(tileMode, bpp, pitch)
+		swapIndex := macroTilePitch * macroTileIndexX / bankSwapWidth
+		bank ^= bankSwapOrder[swapIndex&3]
+	}
+
+	totalOffset := elemOffset + (macroTileOffset >> 3)
+	return bank<<9 | pipe<<8 | totalOffset&255 | (totalOffset&^255)<<3
+}
+
+func computePixelIndexWithinMicroTile(x uint, y uint, z uint, bpp uint, tileMode AddrTileMode, isDepth bool) uint {
+	var pixelBit0 uint = 0
+	var pixelBit1 uint = 0
+	var pixelBit2 uint = 0
+	var pixelBit3 uint = 0
+	var pixelBit4 uint = 0
+	var pixelBit5 uint = 0
+	var pixelBit6 uint = 0
+	var pixelBit7 uint = 0
+	var pixelBit8 uint = 0
+	var thickness uint = computeSurfaceThickness(tileMode)
+
+	if isDepth {
+		pixelBit0 = x & 1
+		pixelBit1 = y & 1
+		pixelBit2 = (x & 2) >> 1
+		pixelBit3 = (y & 2) >> 1
+		pixelBit4 = (x & 4) >> 2
+		pixelBit5 = (y & 4) >> 2
+	} else {
+		switch bpp {
+		case 8:
+			pixelBit0 = x & 1
+			pixelBit1 = (x & 2) >> 1
+			pixelBit2 = (x & 4) >> 2
+			pixelBit3 = (y & 2) >> 1
+			pixelBit4 = y & 1
+			pixelBit5 = (y & 4) >> 2
+			break
+		case 0x10:
+			pixelBit0 = x & 1
+			pixelBit1 = (x & 2) >> 1
+			pixelBit2 = (x & 4) >> 2
+			pixelBit3 = y & 1
+			pixelBit4 = (y & 2) >> 1
+			pixelBit5 = (y & 4) >> 2
+			break
+		case 0x20:
+			fallthrough
+		case 0x60:
+			pixelBit0 = x & 1
+			pixelBit1 = (x & 2) >> 1
+			pixelBit2 = y & 1
+			pixelBit3 = (x & 4) >> 2
+			pixelBit4 = (y & 2) >> 1
+			pixelBit5 = (y & 4) >> 2
+			break
+		case 0x40:
+			pixelBit0 = x & 1
+			pixelBit1 = y & 1
+			pixelBit2 = (x & 2) >> 1
+			pixelBit3 = (x & 4) >> 2
+			pixelBit4 = (y & 2) >> 1
+			pixelBit5 = (y & 4) >> 2
+			break
+		case 0x80:
+			pixelBit0 = y & 1
+			pixelBit1 = x & 1
+			pixelBit2 = (x & 2) >> 1
+			pixelBit3 = (x & 4) >> 2
+			pixelBit4 = (y & 2) >> 1
+			pixelBit5 = (y & 4) >> 2
+			break
+		default:
+			pixelBit0 = x & 1
+			pixelBit1 = (x & 2) >> 1
+			pixelBit2 = y & 1
+			pixelBit3 = (x & 4) >> 2
+			pixelBit4 = (y & 2) >> 1
+			pixelBit5 = (y & 4) >> 2
+			break
+		}
+	}
+
+	if thickness > 1 {
+		pixelBit6 = z & 1
+		pixelBit7 = (z & 2) >> 1
+	}
+
+	if thickness == 8 {
+		pixelBit8 = (z & 4) >> 2
+	}
+
+	return (pixelBit8 << 8) | (pixelBit7 << 7) | (pixelBit6 << 6) | 32*pixelBit5 | 16*pixelBit4 | 8*pixelBit3 | 4*pixelBit2 | pixelBit0 | 2*pixelBit1
+}
+
+func computeSurfaceThickness(tileMode AddrTileMode) uint {
+	switch tileMode {
+	case ADDR_TM_1D_TILED_THICK:
+		fallthrough
+	case ADDR_TM_2D_TILED_THICK:
+		fallthrough
+	case ADDR_TM_2B_TILED_THICK:
+		fallthrough
+	case ADDR_TM_3D_TILED_THICK:
+		fallthrough
+	case ADDR_TM_3B_TILED_THICK:
+		return 4
+	case ADDR_TM_2D_TILED_XTHICK:
+		fallthrough
+	case ADDR_TM_3D_TILED_XTHICK:
+		return 8
+	default:
+		return 1
+	}
+}
+
+func computePipeFromCoordWoRotation(x uint, y uint) uint {
+	return ((y >> 3) ^ (x >> 3)) & 1
+}
+
+func computeBankFromCoordWoRotation(x uint, y uint) uint {
+	return ((y>>5)^(x>>3))&1 | 2*(((y>>4)^(x>>4))&1)
+}
+
+func computeSurfaceRotationFromTileMode(tileMode AddrTileMode) uint {
+	switch tileMode {
+	case ADDR_TM_2D_TILED_THIN1:
+		fallthrough
+	case ADDR_TM_2D_TILED_THIN2:
+		fallthrough
+	case ADDR_TM_2D_TILED_THIN4:
+		fallthrough
+	case ADDR_TM_2D_TILED_THICK:
+		fallthrough
+	case ADDR_TM_2B_TILED_THIN1:
+		fallthrough
+	case ADDR_TM_2B_TILED_THIN2:
+		fallthrough
+	case ADDR_TM_2B_TILED_THIN4:
+		fallthrough
+	case ADDR_TM_2B_TILED_THICK:
+		return 2
+	case ADDR_TM_3D_TILED_THIN1:
+		fallthrough
+	case ADDR_TM_3D_TILED_THICK:
+		fallthrough
+	case ADDR_TM_3B_TILED_THIN1:
+		fallthrough
+	case ADDR_TM_3B_TILED_THICK:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func isThickMacroTiled(tileMode AddrTileMode) uint {
+	switch tileMode {
+	case ADDR_TM_2D_TILED_THICK:
+		fallthrough
+	case ADDR_TM_2B_TILED_THICK:
+		fallthrough
+	case ADDR_TM_3D_TILED_THICK:
+		fallthrough
+	case ADDR_TM_3B_TILED_THICK:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func computeMacroPitchAndHeight(tileMode AddrTileMode) (pitch uint, height uint) {
+	var macroTilePitch uint = 32
+	var macroTileHeight uint = 16
+
+	switch tileMode {
+	case ADDR_TM_2D_TILED_THIN2:
+		fallthrough
+	case ADDR_TM_2B_TILED_THIN2:
+		macroTilePitch = 16
+		macroTileHeight = 32
+		break
+	case ADDR_TM_2D_TILED_THIN4:
+		fallthrough
+	case ADDR_TM_2B_TILED_THIN4:
+		macroTilePitch = 8
+		macroTileHeight = 64
+		break
+	}
+
+	return macroTilePitch, macroTileHeight
+}
+
+func computeMacroTileAspectRatio(tileMode AddrTileMode) uint {
+	switch tileMode {
+	case ADDR_TM_2D_TILED_THIN2, ADDR_TM_2B_TILED_THIN2:
+		return 2
+	case ADDR_TM_2D_TILED_THIN4, ADDR_TM_2B_TILED_THIN4:
+		return 4
+	default:
+		return 1
+	}
+}
+
+func isBankSwappedTileMode(tileMode AddrTileMode) bool {
+	switch tileMode {
+	case ADDR_TM_2B_TILED_THIN1, ADDR_TM_2B_TILED_THIN2, ADDR_TM_2B_TILED_THIN4, ADDR_TM_2B_TILED_THICK,
+		ADDR_TM_3B_TILED_THIN1, ADDR_TM_3B_TILED_THICK:
+		return true
+	default:
+		return false
+	}
+}
+
+func computeSurfaceBankSwappedWidth(tileMode AddrTileMode, bpp uint, pitch uint) uint {
+	numSamples := uint(1)
+	bytesPerSample := 8 * bpp
+	slicesPerTile := uint(1)
+	if isThickMacroTiled(tileMode) != 0 {
+		numSamples = 4
+	}
+
+	bytesPerTileSlice := numSamples * bytesPerSample / slicesPerTile
+	factor := computeMacroTileAspectRatio(tileMode)
+	swapTiles := (gx2SwapSize >> 1) / bpp
+	if swapTiles < 1 {
+		swapTiles = 1
+	}
+
+	swapWidth := swapTiles * 8 * gx2NumBanks
+	heightBytes := numSamples * factor * gx2NumPipes * bpp / slicesPerTile
+	swapMax := gx2NumPipes * gx2NumBanks * gx2RowSize / heightBytes
+	swapMin := gx2PipeInterleaveSize * 8 * gx2NumBanks / bytesPerTileSlice
+
+	bankSwapWidth := swapWidth
+	if swapMin > bankSwapWidth {
+		bankSwapWidth = swapMin
+	}
+	if swapMax < bankSwapWidth {
+		bankSwapWidth = swapMax
+	}
+
+	for bankSwapWidth >= 2*pitch {
+		bankSwapWidth >>= 1
+	}
+
+	return bankSwapWidth
+}
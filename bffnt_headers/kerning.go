@@ -0,0 +1,204 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// Pair kerning of a font file at a pixel size. sfnt reads the GPOS PairPos
+// lookups of the kern feature, and only falls back to the kern table when a
+// font has no GPOS kerning at all. Pairs sfnt has no kerning for are looked up
+// in kernTable, so fonts with both get the pairs only the kern table has.
+//
+// opentype.Face.Kern is not used because it passes the units per em as the
+// ppem, which returns the kerning in font units instead of pixels.
+type fontKerning struct {
+	font       *sfnt.Font
+	buf        sfnt.Buffer
+	ppem       fixed.Int26_6
+	hinting    font.Hinting
+	unitsPerEm fixed.Int26_6
+
+	// Horizontal format 0 pairs of the kern table in font units. Key is the
+	// first glyph << 16 | the second glyph.
+	kernTable map[uint32]int16
+}
+
+// Font file data is needed to read the kern table, sfnt does not expose it.
+// Size is in points.
+func newFontKerning(f *sfnt.Font, fontData []byte, size float64, dpi float64, hinting font.Hinting) *fontKerning {
+	return &fontKerning{
+		font:       f,
+		ppem:       fixed.Int26_6(0.5 + (size * dpi * 64 / 72)),
+		hinting:    hinting,
+		unitsPerEm: fixed.Int26_6(f.UnitsPerEm()),
+		kernTable:  readKernTable(fontData),
+	}
+}
+
+// Kerning between two glyphs in pixels
+func (k *fontKerning) pair(first sfnt.GlyphIndex, second sfnt.GlyphIndex) int {
+	if first == 0 || second == 0 {
+		return 0
+	}
+
+	value, err := k.font.Kern(&k.buf, first, second, k.ppem, k.hinting)
+	if err != nil || value == 0 {
+		units, ok := k.kernTable[uint32(first)<<16|uint32(second)]
+		if !ok {
+			return 0
+		}
+		value = k.scale(units)
+	}
+
+	return int(math.Round(float64(value) / 64))
+}
+
+// Font units to pixels, rounded the way sfnt does
+func (k *fontKerning) scale(units int16) fixed.Int26_6 {
+	x := fixed.Int26_6(units) * k.ppem
+	if x >= 0 {
+		x += k.unitsPerEm / 2
+	} else {
+		x -= k.unitsPerEm / 2
+	}
+	x /= k.unitsPerEm
+	if k.hinting == font.HintingFull {
+		x = (x + 32) &^ 63
+	}
+	return x
+}
+
+// Reads the horizontal format 0 subtables of the OpenType (version 0) kern
+// table. Values of subtables for the same pair add up. Returns nil when the
+// font has no kern table or one this can not read.
+// https://docs.microsoft.com/en-us/typography/opentype/spec/kern
+func readKernTable(fontData []byte) map[uint32]int16 {
+	table := findFontTable(fontData, "kern")
+	if len(table) < 4 || binary.BigEndian.Uint16(table[0:2]) != 0 {
+		return nil
+	}
+
+	const subtableHeaderSize = 6
+	const format0HeaderSize = 8
+	const pairSize = 6
+
+	res := make(map[uint32]int16)
+	subtableCount := int(binary.BigEndian.Uint16(table[2:4]))
+	pos := 4
+	for i := 0; i < subtableCount; i++ {
+		if pos+subtableHeaderSize > len(table) {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(table[pos+2 : pos+4]))
+		coverage := binary.BigEndian.Uint16(table[pos+4 : pos+6])
+		format := coverage >> 8
+		horizontal := coverage&0x1 != 0
+		// minimum values and cross-stream kerning are not pair kerning
+		other := coverage&0x6 != 0
+
+		if format != 0 {
+			// the length of large format 0 subtables overflows, other
+			// formats are sized by it
+			if length < subtableHeaderSize {
+				break
+			}
+			pos += length
+			continue
+		}
+
+		dataStart := pos + subtableHeaderSize
+		if dataStart+format0HeaderSize > len(table) {
+			break
+		}
+		pairCount := int(binary.BigEndian.Uint16(table[dataStart : dataStart+2]))
+		pairsStart := dataStart + format0HeaderSize
+		pairsEnd := pairsStart + pairCount*pairSize
+		if pairsEnd > len(table) {
+			break
+		}
+
+		if horizontal && !other {
+			for p := pairsStart; p < pairsEnd; p += pairSize {
+				key := binary.BigEndian.Uint32(table[p : p+4])
+				value := int(res[key]) + int(int16(binary.BigEndian.Uint16(table[p+4:p+6])))
+				res[key] = int16(clampInt(value, math.MinInt16, math.MaxInt16))
+			}
+		}
+		pos = pairsEnd
+	}
+
+	return res
+}
+
+// Data of a table of a ttf or otf, nil when the font does not have it
+func findFontTable(fontData []byte, tag string) []byte {
+	const tableDirectoryStart = 12
+	const tableRecordSize = 16
+	if len(fontData) < tableDirectoryStart {
+		return nil
+	}
+
+	tableCount := int(binary.BigEndian.Uint16(fontData[4:6]))
+	for i := 0; i < tableCount; i++ {
+		record := tableDirectoryStart + i*tableRecordSize
+		if record+tableRecordSize > len(fontData) {
+			return nil
+		}
+		if string(fontData[record:record+4]) != tag {
+			continue
+		}
+
+		offset := int(binary.BigEndian.Uint32(fontData[record+8 : record+12]))
+		length := int(binary.BigEndian.Uint32(fontData[record+12 : record+16]))
+		if offset+length > len(fontData) || offset+length < offset {
+			return nil
+		}
+		return fontData[offset : offset+length]
+	}
+
+	return nil
+}
+
+// Replaces the kerning table with the pair kerning of the font file of a
+// drawer, for every pair of characters in the CMAPs. The kerning is in pixels
+// at the size of the drawer, so of the upscaled font when upscaling.
+func (b *BFFNT) generateKerning(drawer *glyphDrawer) {
+	codes := make([]uint16, 0)
+	for _, pair := range b.GlyphIndexes() {
+		codes = append(codes, pair.CharAscii)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i] < codes[j]
+	})
+
+	glyphs := make([]sfnt.GlyphIndex, len(codes))
+	for i, code := range codes {
+		glyphs[i] = drawer.glyphIndex(code)
+	}
+
+	kerningTable := make(map[uint16][]kerningPair)
+	for i, first := range codes {
+		if glyphs[i] == 0 {
+			continue
+		}
+		for j, second := range codes {
+			value := drawer.kerning.pair(glyphs[i], glyphs[j])
+			if value == 0 {
+				continue
+			}
+			kerningTable[first] = append(kerningTable[first], kerningPair{
+				SecondChar:   second,
+				KerningValue: int16(clampInt(value, math.MinInt16, math.MaxInt16)),
+			})
+		}
+	}
+
+	b.KRNG.MagicHeader = KRNG_MAGIC_HEADER
+	b.KRNG.KerningTable = kerningTable
+}
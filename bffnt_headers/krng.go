@@ -3,7 +3,6 @@ package bffnt_headers
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"math"
 	"sort"
@@ -43,24 +42,29 @@ type KRNG struct { // Offset  Size  Description
 	// [ A ] | [( V, -1 ), ( W, -1 ), ( Y, -1 )]
 	// [ L ] | [( V, -1 ), ( T, -1 ), ( W, -1 )]
 	// [ P ] | [( d, -2 ), ( g, -2 ), ( y, -1 )]
+
+	sectionOrder
 }
 
 // The kerning index table doesn't seem to be recorded in any headers. It is
 // most likely usually the last section.
-func (krng *KRNG) Decode(bffntRaw []byte) {
+func (krng *KRNG) Decode(bffntRaw []byte) error {
 	// Since the kerning offset is not recorded we need to find it first.
 	headerStart := strings.Index(string(bffntRaw), KRNG_MAGIC_HEADER)
 	if headerStart == -1 {
 		// fmt.Println("no kerning table")
-		return
+		return nil
 	}
 
 	headerEnd := headerStart + KRNG_HEADER_SIZE
-	headerRaw := bffntRaw[headerStart:headerEnd]
-	assertEqual(KRNG_HEADER_SIZE, len(headerRaw))
+	headerRaw, err := sliceSection(bffntRaw, KRNG_MAGIC_HEADER, "header", headerStart, headerEnd)
+	if err != nil {
+		return err
+	}
 
+	krng.byteOrder = byteOrderMark(bffntRaw)
 	krng.MagicHeader = string(headerRaw[0:4])
-	krng.SectionSize = binary.BigEndian.Uint32(headerRaw[4:8])
+	krng.SectionSize = krng.order().Uint32(headerRaw[4:8])
 
 	// if Debug {
 	// 	pprint(krng)
@@ -72,12 +76,35 @@ func (krng *KRNG) Decode(bffntRaw []byte) {
 	// fmt.Println(krng.SectionSize)
 
 	dataEnd := headerStart + int(krng.SectionSize)
-	data := bffntRaw[headerEnd:dataEnd]
+	data, err := sliceSection(bffntRaw, KRNG_MAGIC_HEADER, "data", headerEnd, dataEnd)
+	if err != nil {
+		return err
+	}
 
 	// fmt.Println(dataEnd - headerStart)
 
+	// Offsets inside the kerning data are relative to the end of the header.
+	// Every read is bounds checked against the section size.
+	readData := func(field string, start int, end int) ([]byte, error) {
+		if end > len(data) {
+			return nil, &SectionError{
+				Section:  KRNG_MAGIC_HEADER,
+				Offset:   headerEnd + start,
+				Field:    field,
+				Expected: KRNG_HEADER_SIZE + end,
+				Actual:   int(krng.SectionSize),
+				Err:      ErrTruncated,
+			}
+		}
+		return data[start:end], nil
+	}
+
 	// The first two bytes are the amount of firstChars
-	firstCharCount := binary.BigEndian.Uint16(data[0:2])
+	firstCharCountRaw, err := readData("first char count", 0, 2)
+	if err != nil {
+		return err
+	}
+	firstCharCount := krng.order().Uint16(firstCharCountRaw)
 	dataPos := 2
 	totalDataBytesRead += 2
 
@@ -86,8 +113,12 @@ func (krng *KRNG) Decode(bffntRaw []byte) {
 	kerningMap := make(map[uint16][]kerningPair, 0)
 	// loop through first chars and their offset to the array of kerning pairs
 	for i := 0; i < int(firstCharCount); i++ {
-		firstChar := binary.BigEndian.Uint16(data[dataPos : dataPos+2])
-		secondCharOffset := binary.BigEndian.Uint16(data[dataPos+2 : dataPos+4])
+		firstCharRaw, err := readData("first char table", dataPos, dataPos+4)
+		if err != nil {
+			return err
+		}
+		firstChar := krng.order().Uint16(firstCharRaw[0:2])
+		secondCharOffset := krng.order().Uint16(firstCharRaw[2:4])
 		dataPos += 4
 		totalDataBytesRead += 4
 
@@ -98,8 +129,12 @@ func (krng *KRNG) Decode(bffntRaw []byte) {
 		// The real offset must be multiplied by 2. This might be the case
 		// because a single uint16 might not be big enough for an offset if the
 		// kerning table is too large
-		realSecondCharOffset := secondCharOffset * 2
-		secondCharCount := binary.BigEndian.Uint16(data[realSecondCharOffset : realSecondCharOffset+2])
+		realSecondCharOffset := int(secondCharOffset) * 2
+		secondCharCountRaw, err := readData("second char count", realSecondCharOffset, realSecondCharOffset+2)
+		if err != nil {
+			return err
+		}
+		secondCharCount := int(krng.order().Uint16(secondCharCountRaw))
 		totalDataBytesRead += 2
 
 		// fmt.Println("real char offset:", realSecondCharOffset)
@@ -107,14 +142,17 @@ func (krng *KRNG) Decode(bffntRaw []byte) {
 
 		pairDataStart := realSecondCharOffset + 2
 		pairDataEnd := realSecondCharOffset + 2 + secondCharCount*4
-		pairData := data[pairDataStart:pairDataEnd]
+		pairData, err := readData("kerning pairs", pairDataStart, pairDataEnd)
+		if err != nil {
+			return err
+		}
 
 		// Go to offset and record kerning pairs for this char
 		pairPos := 0
 		kerningPairSlice := make([]kerningPair, 0)
 		for j := 0; j < int(secondCharCount); j++ {
-			secondChar := binary.BigEndian.Uint16(pairData[pairPos : pairPos+2])
-			kerningValue := int16(binary.BigEndian.Uint16(pairData[pairPos+2 : pairPos+4]))
+			secondChar := krng.order().Uint16(pairData[pairPos : pairPos+2])
+			kerningValue := int16(krng.order().Uint16(pairData[pairPos+2 : pairPos+4]))
 
 			// fmt.Printf("(%s, %d), ", string(secondChar), kerningValue)
 
@@ -129,8 +167,21 @@ func (krng *KRNG) Decode(bffntRaw []byte) {
 
 	krng.KerningTable = kerningMap
 
+	if totalDataBytesRead > len(data) {
+		return &SectionError{
+			Section:  KRNG_MAGIC_HEADER,
+			Offset:   headerStart + 4,
+			Field:    "SectionSize",
+			Expected: KRNG_HEADER_SIZE + totalDataBytesRead,
+			Actual:   int(krng.SectionSize),
+			Err:      ErrSizeMismatch,
+		}
+	}
 	padding := data[totalDataBytesRead:]
-	verifyLeftoverBytes(padding)
+	err = verifyLeftoverBytes(KRNG_MAGIC_HEADER, headerEnd+totalDataBytesRead, padding)
+	if err != nil {
+		return err
+	}
 
 	if Debug {
 		dataPosEnd := headerEnd + totalDataBytesRead
@@ -142,25 +193,37 @@ func (krng *KRNG) Decode(bffntRaw []byte) {
 		fmt.Println()
 	}
 
+	return nil
 }
 
-func (krng *KRNG) Encode(startOffset uint32) []byte {
+func (krng *KRNG) Encode(startOffset uint32) ([]byte, error) {
 	if len(krng.KerningTable) == 0 {
-		return []byte{}
+		return []byte{}, nil
 	}
 
+	order := krng.order()
 	var dataBuf bytes.Buffer
 	dataWriter := bufio.NewWriter(&dataBuf)
 
 	firstChars := getFirstCharsOrdered(krng.KerningTable)
 
 	// Write amount of first chars
-	binaryWrite(dataWriter, uint16(len(firstChars)))
+	binaryWrite(dataWriter, order, uint16(len(firstChars)))
 
 	secondCharDataOffset := len(firstChars)*4 + 2 // +2 for amount of first chars
 	for _, firstChar := range firstChars {
-		binaryWrite(dataWriter, firstChar)
-		binaryWrite(dataWriter, uint16(secondCharDataOffset/2))
+		if secondCharDataOffset/2 > math.MaxUint16 {
+			return nil, &SectionError{
+				Section:  KRNG_MAGIC_HEADER,
+				Offset:   int(startOffset),
+				Field:    "kerning table is too large for its offsets",
+				Expected: 2 * math.MaxUint16,
+				Actual:   secondCharDataOffset,
+				Err:      ErrValueOutOfRange,
+			}
+		}
+		binaryWrite(dataWriter, order, firstChar)
+		binaryWrite(dataWriter, order, uint16(secondCharDataOffset/2))
 		// Nintendo divides the actual second character data offset by 2 before
 		// recording it. This is because the kerning table consist of only uint16s
 		// and int16s which means bytes are written in pairs (2 bytes).  By
@@ -175,11 +238,11 @@ func (krng *KRNG) Encode(startOffset uint32) []byte {
 	// Write kerning Data
 	for _, firstChar := range firstChars {
 		secondCharCount := uint16(len(krng.KerningTable[firstChar]))
-		binaryWrite(dataWriter, secondCharCount)
+		binaryWrite(dataWriter, order, secondCharCount)
 
 		for _, kerningPair := range krng.KerningTable[firstChar] {
-			binaryWrite(dataWriter, kerningPair.SecondChar)
-			binaryWrite(dataWriter, kerningPair.KerningValue)
+			binaryWrite(dataWriter, order, kerningPair.SecondChar)
+			binaryWrite(dataWriter, order, kerningPair.KerningValue)
 		}
 	}
 	dataWriter.Flush()
@@ -194,12 +257,12 @@ func (krng *KRNG) Encode(startOffset uint32) []byte {
 	w := bufio.NewWriter(&buf)
 	// Write raw data of the header and data
 	_, _ = w.Write([]byte(KRNG_MAGIC_HEADER))
-	binaryWrite(w, krng.SectionSize)
+	binaryWrite(w, order, krng.SectionSize)
 	_, _ = w.Write(krngData)
 
 	w.Flush()
 
-	return buf.Bytes()
+	return buf.Bytes(), nil
 }
 
 // takes the kerning table and returns the inputs in order.  Not functionally
@@ -230,6 +293,14 @@ func (krng *KRNG) Upscale(scale float64) {
 	}
 }
 
+func (krng *KRNG) pairCount() int {
+	count := 0
+	for _, pairs := range krng.KerningTable {
+		count += len(pairs)
+	}
+	return count
+}
+
 func (krng *KRNG) Kern(r1 rune, r2 rune) int16 {
 	pairs, hasEntry := krng.KerningTable[uint16(r1)]
 	if hasEntry {
@@ -0,0 +1,186 @@
+package bffnt_headers
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"unicode"
+)
+
+// A glyph of a text placed by Layout
+type PlacedGlyph struct {
+	Rune rune
+	// Glyph index of the character, the alter char's when the font does not
+	// have it
+	Index   uint16
+	Missing bool
+	Line    int
+
+	// Top left of the glyph's cell in the text, and how much of the cell is
+	// drawn
+	X, Y  int
+	Width int
+}
+
+// Text laid out the way the game's text writer does it
+type TextLayout struct {
+	Glyphs []PlacedGlyph
+	Lines  int
+
+	// Size of the text, every glyph and the line feed of every line fit in
+	Width, Height int
+
+	// Characters of the text the font does not have, drawn with the alter
+	// char
+	Missing []rune
+}
+
+// Lays out a text on lines only broken at newlines
+func (b *BFFNT) Layout(text string) TextLayout {
+	return b.LayoutWrapped(text, 0)
+}
+
+// Lays out a text and wraps lines longer than maxWidth pixels at the last
+// space, or before the character that does not fit when the line has no
+// space. Lines are not wrapped when maxWidth is 0.
+//
+// Every character starts at the pen position of the line, moved by the
+// kerning with the character before it. Its cell is drawn LeftWidth to the
+// right of that, with the baseline of the cell on the line's ascent, and the
+// pen moves CharWidth further. Lines are LineFeed apart.
+func (b *BFFNT) LayoutWrapped(text string, maxWidth int) TextLayout {
+	var layout TextLayout
+	runes := []rune(text)
+	missing := make(map[rune]bool)
+
+	line := 0
+	penX := 0
+	var previous rune
+	// where the line can be broken, the character after the last space and
+	// how many glyphs were placed before it
+	breakRune, breakGlyph := -1, 0
+
+	newLine := func() {
+		line++
+		penX = 0
+		previous = 0
+		breakRune, breakGlyph = -1, 0
+	}
+
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '\n' {
+			newLine()
+			continue
+		}
+		if unicode.IsControl(r) {
+			continue
+		}
+
+		index, ok := b.CWDHIndexMap[r]
+		if !ok {
+			index = int(b.FINF.AlterCharIndex)
+		}
+		widths := b.widthsOrDefault(uint16(index))
+
+		x := penX
+		if previous != 0 {
+			x += int(b.KRNG.Kern(previous, r))
+		}
+
+		lineHasGlyphs := len(layout.Glyphs) > 0 && layout.Glyphs[len(layout.Glyphs)-1].Line == line
+		if maxWidth > 0 && r != ' ' && lineHasGlyphs && x+int(widths.CharWidth) > maxWidth {
+			if breakRune != -1 {
+				// the word goes to the next line
+				layout.Glyphs = layout.Glyphs[:breakGlyph]
+				i = breakRune - 1
+				newLine()
+				continue
+			}
+			newLine()
+			x = 0
+		}
+
+		if !ok && !missing[r] {
+			missing[r] = true
+			layout.Missing = append(layout.Missing, r)
+		}
+		layout.Glyphs = append(layout.Glyphs, PlacedGlyph{
+			Rune:    r,
+			Index:   uint16(index),
+			Missing: !ok,
+			Line:    line,
+			X:       x + int(widths.LeftWidth),
+			Y:       line*int(b.FINF.LineFeed) + int(b.FINF.Ascent) - int(b.TGLP.BaselinePosition),
+			Width:   int(widths.GlyphWidth),
+		})
+		penX = x + int(widths.CharWidth)
+		previous = r
+
+		if r == ' ' {
+			breakRune, breakGlyph = i+1, len(layout.Glyphs)
+		}
+	}
+	layout.Lines = line + 1
+
+	b.fitLayout(&layout)
+	return layout
+}
+
+// Widths of a glyph index, the FINF default widths when no CWDH covers it
+func (b *BFFNT) widthsOrDefault(index uint16) glyphInfo {
+	widths := b.glyphWidths(index)
+	if widths == nil {
+		return glyphInfo{
+			LeftWidth:  int8(b.FINF.DefaultLeftWidth),
+			GlyphWidth: b.FINF.DefaultGlyphWidth,
+			CharWidth:  b.FINF.DefaultCharWidth,
+		}
+	}
+	return *widths
+}
+
+// Sets the size of a layout and moves its glyphs so none of them sticks out
+// at the left or top, like glyphs with a negative LeftWidth at the start of a
+// line.
+func (b *BFFNT) fitLayout(layout *TextLayout) {
+	cellHeight := int(b.TGLP.CellHeight)
+	left, top, right := 0, 0, 0
+	bottom := (layout.Lines-1)*int(b.FINF.LineFeed) + int(b.FINF.Height)
+
+	for _, glyph := range layout.Glyphs {
+		widths := b.widthsOrDefault(glyph.Index)
+		penX := glyph.X - int(widths.LeftWidth) + int(widths.CharWidth)
+		left = minInt(left, glyph.X)
+		top = minInt(top, glyph.Y)
+		right = maxInt(right, maxInt(glyph.X+glyph.Width, penX))
+		bottom = maxInt(bottom, glyph.Y+cellHeight)
+	}
+
+	for i := range layout.Glyphs {
+		layout.Glyphs[i].X -= left
+		layout.Glyphs[i].Y -= top
+	}
+	layout.Width = right - left
+	layout.Height = bottom - top
+}
+
+// Draws a layout with the glyphs of the decoded sheets over a background
+func (b *BFFNT) DrawLayout(layout TextLayout, background color.Color) *image.NRGBA {
+	dst := image.NewNRGBA(image.Rect(0, 0, layout.Width, layout.Height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	cellHeight := int(b.TGLP.CellHeight)
+	for _, glyph := range layout.Glyphs {
+		sheet, row, column := b.TGLP.cellPosition(int(glyph.Index))
+		if sheet >= len(b.TGLP.SheetData) {
+			continue
+		}
+		cell := b.TGLP.cellRect(row, column)
+		width := minInt(glyph.Width, cell.Dx())
+		target := image.Rect(glyph.X, glyph.Y, glyph.X+width, glyph.Y+cellHeight)
+		draw.Draw(dst, target, &b.TGLP.SheetData[sheet], cell.Min, draw.Over)
+	}
+
+	return dst
+}
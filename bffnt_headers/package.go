@@ -0,0 +1,180 @@
+package bffnt_headers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"bffnt/bcml"
+	"bffnt/rstb"
+	"bffnt/sarc"
+	"bffnt/yaz0"
+)
+
+// Regions the game loads its own copy of the font archive for. They all hold
+// the same fonts.
+var fontArchiveRegions = []string{"EU", "US"}
+
+type ModSettings struct {
+	Name          string
+	Desc          string
+	Version       string
+	Priority      int
+	BaseArchive   string // the original Font_EU.sbfarc the fonts are put into
+	ThumbnailFile string // optional
+	RSTBFile      string // optional ResourceSizeTable.product.srsizetable of the game to patch
+}
+
+var defaultModSettings = ModSettings{
+	Name:          "HD_Fonts",
+	Desc:          "HD fonts that resemble the original ones",
+	Version:       "0.2.0",
+	Priority:      103,
+	BaseArchive:   "./WiiU_fonts/botw/Font_EU.sbfarc",
+	ThumbnailFile: "./HD_Fonts/thumbnail.png",
+}
+
+// Writes the BCML mod folder modDir and modDir.bnp next to it. Every bffnt
+// file replaces the archive font its name starts with, so
+// Normal_00_2.00x.bffnt replaces Normal_00.bffnt.
+func packageMod(modDir string, settings ModSettings, bffntFiles []string) error {
+	archive, err := readFontArchive(settings.BaseArchive)
+	if err != nil {
+		return err
+	}
+
+	for _, bffntFile := range bffntFiles {
+		name, err := archiveFontName(archive, bffntFile)
+		if err != nil {
+			return err
+		}
+
+		bffntRaw, err := ioutil.ReadFile(bffntFile)
+		if err != nil {
+			return err
+		}
+
+		// only put fonts the game can read in the archive
+		var bffnt BFFNT
+		err = bffnt.Decode(bffntRaw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", bffntFile, err)
+		}
+
+		err = archive.Replace(name, bffntRaw)
+		if err != nil {
+			return err
+		}
+		fmt.Println("packaging", bffntFile, "as", name)
+	}
+
+	sarcRaw, err := archive.Encode()
+	if err != nil {
+		return err
+	}
+	archiveRaw := yaz0.Compress(sarcRaw, yaz0.DefaultCompression)
+
+	modPlatform, sizePlatform := archivePlatform(archive)
+	mod := bcml.NewMod(settings.Name, settings.Desc, settings.Version, modPlatform, settings.Priority)
+	for _, region := range fontArchiveRegions {
+		contentPath := fmt.Sprintf("Font/Font_%s.sbfarc", region)
+		size, err := rstb.ResourceSize(bcml.CanonicalPath(contentPath), sarcRaw, sizePlatform)
+		if err != nil {
+			return err
+		}
+		mod.AddFile(contentPath, archiveRaw, size)
+	}
+
+	if settings.RSTBFile != "" {
+		mod.Files[rstb.TablePath], err = patchResourceSizeTable(settings.RSTBFile, mod.RSTB)
+		if err != nil {
+			return err
+		}
+	}
+
+	if settings.ThumbnailFile != "" {
+		mod.Thumbnail, err = ioutil.ReadFile(settings.ThumbnailFile)
+		if os.IsNotExist(err) {
+			fmt.Println("no thumbnail at", settings.ThumbnailFile)
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	err = mod.WriteDir(modDir)
+	if err != nil {
+		return err
+	}
+	fmt.Println("wrote", modDir)
+
+	var bnp bytes.Buffer
+	err = mod.WriteBNP(&bnp)
+	if err != nil {
+		return err
+	}
+	bnpFile := filepath.Clean(modDir) + ".bnp"
+	err = os.WriteFile(bnpFile, bnp.Bytes(), 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Println("wrote", bnpFile, bnp.Len(), "bytes")
+
+	return nil
+}
+
+// The game an archive is from, as the BCML and resource size table platform.
+// The archives of the Switch release are little endian.
+func archivePlatform(archive *sarc.Archive) (string, rstb.Platform) {
+	if archive.ByteOrder == binary.LittleEndian {
+		return bcml.PlatformSwitch, rstb.PlatformSwitch
+	}
+	return bcml.PlatformWiiU, rstb.PlatformWiiU
+}
+
+// The font in the archive a bffnt file replaces, the longest archive name
+// without extension the file name starts with.
+func archiveFontName(archive *sarc.Archive, bffntFile string) (string, error) {
+	base := filepath.Base(bffntFile)
+	match := ""
+	for _, name := range archive.Names() {
+		prefix := strings.TrimSuffix(name, filepath.Ext(name))
+		if strings.HasPrefix(base, prefix) && len(name) > len(match) {
+			match = name
+		}
+	}
+	if match == "" {
+		return "", fmt.Errorf("%s does not match any font in the archive: %s", base, strings.Join(archive.Names(), ", "))
+	}
+	return match, nil
+}
+
+// Sets the resource sizes of the changed files in a copy of the game's
+// table, for installing the mod without BCML, which patches its own copy
+// with logs/rstb.json.
+func patchResourceSizeTable(tableFile string, sizes map[string]uint32) ([]byte, error) {
+	tableRaw, err := ioutil.ReadFile(tableFile)
+	if err != nil {
+		return nil, err
+	}
+	table, err := rstb.Decode(tableRaw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", tableFile, err)
+	}
+
+	for name, size := range sizes {
+		old, ok := table.Get(name)
+		if !ok {
+			fmt.Println(name, "is not in", tableFile, "adding it")
+		}
+		fmt.Println("resource size of", name, old, "->", size)
+		table.Set(name, size)
+	}
+
+	return table.EncodeCompressed(), nil
+}
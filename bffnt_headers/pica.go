@@ -0,0 +1,131 @@
+package bffnt_headers
+
+import (
+	"fmt"
+)
+
+// The 3DS GPU (PICA200) reads textures in tiles of 8x8 pixels, stored row by
+// row. Inside a tile the pixels are in Morton (Z) order: the bits of x and y
+// are interleaved, x in the lowest bit. ETC1 and ETC1A4 elements are 4x4
+// blocks, so their tiles are 2x2 blocks in the same order.
+//
+// Source:
+// https://www.3dbrew.org/wiki/GPU/Textures
+
+const picaTileSize = 8
+
+// A single 3DS texture. Width and Height are counted in elements, Pitch and
+// AlignedHeight are padded to whole tiles.
+type picaLayout struct {
+	Width         uint
+	Height        uint
+	Bpp           uint // bits per element
+	TileSize      uint // width and height of a tile in elements
+	Pitch         uint
+	AlignedHeight uint
+}
+
+func newPicaLayout(width int, height int, format sheetFormat) (picaLayout, error) {
+	elementsWide, elementsHigh := format.elementSize(width, height)
+	l := picaLayout{
+		Width:  uint(elementsWide),
+		Height: uint(elementsHigh),
+		Bpp:    format.Bpp,
+	}
+
+	if width <= 0 || height <= 0 {
+		return l, fmt.Errorf("3ds texture can not be %dx%d", width, height)
+	}
+	if format.BlockSize <= 0 || picaTileSize%format.BlockSize != 0 {
+		return l, fmt.Errorf("unsupported 3ds texture block size: %d", format.BlockSize)
+	}
+	if l.Bpp != 4 && l.Bpp%8 != 0 {
+		return l, fmt.Errorf("unsupported 3ds texture bpp: %d", l.Bpp)
+	}
+
+	l.TileSize = uint(picaTileSize / format.BlockSize)
+	l.Pitch = alignUp(l.Width, l.TileSize)
+	l.AlignedHeight = alignUp(l.Height, l.TileSize)
+
+	return l, nil
+}
+
+// Size in bytes of the tiled texture including the tile padding.
+func (l picaLayout) Size() int {
+	return int((l.Pitch*l.AlignedHeight*l.Bpp + 7) / 8)
+}
+
+// Takes the tiled texture and returns the elements in row order with no
+// padding.
+func (l picaLayout) Deswizzle(data []byte) ([]byte, error) {
+	return l.swizzleTexture(data, false)
+}
+
+// Takes the elements in row order with no padding and returns the tiled
+// texture, Size() bytes.
+func (l picaLayout) Swizzle(data []byte) ([]byte, error) {
+	return l.swizzleTexture(data, true)
+}
+
+func (l picaLayout) swizzleTexture(data []byte, swizzle bool) ([]byte, error) {
+	linearSize := int((l.Width*l.Height*l.Bpp + 7) / 8)
+
+	var result []byte
+	if swizzle {
+		if len(data) != linearSize {
+			return nil, fmt.Errorf("swizzle expected %d bytes of image data, got %d", linearSize, len(data))
+		}
+		result = make([]byte, l.Size())
+	} else {
+		if len(data) < l.Size() {
+			return nil, fmt.Errorf("deswizzle expected %d bytes of texture data, got %d", l.Size(), len(data))
+		}
+		result = make([]byte, linearSize)
+	}
+
+	for y := uint(0); y < l.Height; y++ {
+		for x := uint(0); x < l.Width; x++ {
+			tiledIndex := l.elementIndex(x, y)
+			linearIndex := y*l.Width + x
+
+			if swizzle {
+				copyElement(result, tiledIndex, data, linearIndex, l.Bpp)
+			} else {
+				copyElement(result, linearIndex, data, tiledIndex, l.Bpp)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Position of the element at x, y in the tiled texture, counted in elements
+func (l picaLayout) elementIndex(x uint, y uint) uint {
+	tileX, tileY := x/l.TileSize, y/l.TileSize
+	tileIndex := tileY*(l.Pitch/l.TileSize) + tileX
+	return tileIndex*l.TileSize*l.TileSize + mortonIndex(x%l.TileSize, y%l.TileSize, l.TileSize)
+}
+
+// Interleaves the bits of x and y, x in the lowest bit
+func mortonIndex(x uint, y uint, tileSize uint) uint {
+	index := uint(0)
+	for bit := uint(0); 1<<bit < tileSize; bit++ {
+		index |= (x >> bit & 1) << (2 * bit)
+		index |= (y >> bit & 1) << (2*bit + 1)
+	}
+	return index
+}
+
+// Copies the element at srcIndex of src to dstIndex of dst. 4 bit elements
+// are nibbles, the first element of a byte in the low nibble.
+func copyElement(dst []byte, dstIndex uint, src []byte, srcIndex uint, bpp uint) {
+	if bpp == 4 {
+		value := (src[srcIndex/2] >> (4 * (srcIndex % 2))) & 0xF
+		shift := 4 * (dstIndex % 2)
+		dst[dstIndex/2] = dst[dstIndex/2]&^(0xF<<shift) | value<<shift
+		return
+	}
+
+	bytesPerElement := bpp / 8
+	copy(dst[dstIndex*bytesPerElement:(dstIndex+1)*bytesPerElement], src[srcIndex*bytesPerElement:(srcIndex+1)*bytesPerElement])
+}
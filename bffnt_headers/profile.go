@@ -0,0 +1,195 @@
+package bffnt_headers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/image/font"
+)
+
+// Profiles are JSON files with the settings that make the glyphs drawn from
+// a font file look like the original font, one per font. The botw fonts are
+// in ./profiles/botw.
+const defaultProfileDir = "./profiles/botw"
+
+type FontProfile struct {
+	Name          string  `json:"name"`          // font in the archive, Normal for Normal_00.bffnt
+	FontFile      string  `json:"fontFile"`      // ttf or otf to draw the glyphs from, relative to the profile
+	PointSize     float64 `json:"pointSize"`     // at scale 1, multiplied by the scale
+	DPI           float64 `json:"dpi"`           // 144 when not set
+	Hinting       string  `json:"hinting"`       // none, vertical or full (default)
+	Outline       int     `json:"outline"`       // pixels of outline around every glyph, added to the glyph width
+	BaselineNudge int     `json:"baselineNudge"` // pixels added to the baseline after upscaling
+	Comment       string  `json:"comment"`
+
+	// Glyphs that are not at their code in the font file
+	Remap []RemapRule `json:"remap"`
+
+	// Changes to the widths the glyphs get after drawing, by scale and then
+	// by character. Only applied when upscaling by exactly that scale.
+	Widths map[string]map[string]WidthAdjustment `json:"widths"`
+
+	// Limits of fitting the widths to the font file instead
+	Fit WidthFit `json:"fit"`
+
+	profileDir string
+}
+
+// Codes First to Last are drawn with the glyph at code+Shift, or with Glyph
+// when Shift is 0. Last is First when not set.
+type RemapRule struct {
+	First   uint16  `json:"first"`
+	Last    uint16  `json:"last"`
+	Glyph   *uint16 `json:"glyph"`
+	Shift   int     `json:"shift"`
+	Comment string  `json:"comment"`
+}
+
+// Added to the CWDH widths of a glyph
+type WidthAdjustment struct {
+	Left      int `json:"left"`
+	CharWidth int `json:"charWidth"`
+}
+
+var hintings = map[string]font.Hinting{
+	"":         font.HintingFull,
+	"none":     font.HintingNone,
+	"vertical": font.HintingVertical,
+	"full":     font.HintingFull,
+}
+
+// LoadFontProfile reads and checks a profile file.
+func LoadFontProfile(profileFile string) (*FontProfile, error) {
+	raw, err := ioutil.ReadFile(profileFile)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := &FontProfile{profileDir: filepath.Dir(profileFile)}
+	err = json.Unmarshal(raw, profile)
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", profileFile, err)
+	}
+
+	err = profile.validate()
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", profileFile, err)
+	}
+
+	return profile, nil
+}
+
+// Profile of a botw font by name, like Normal
+func loadBotwFontProfile(fontName string) (*FontProfile, error) {
+	return LoadFontProfile(filepath.Join(defaultProfileDir, fontName+".json"))
+}
+
+func (p *FontProfile) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("name is not set")
+	}
+	if p.PointSize <= 0 {
+		return fmt.Errorf("pointSize has to be above 0, got %v", p.PointSize)
+	}
+	if p.DPI < 0 {
+		return fmt.Errorf("dpi can not be negative, got %v", p.DPI)
+	}
+	if _, ok := hintings[p.Hinting]; !ok {
+		return fmt.Errorf("hinting has to be none, vertical or full, got %q", p.Hinting)
+	}
+	if p.Outline < 0 {
+		return fmt.Errorf("outline can not be negative, got %d", p.Outline)
+	}
+
+	if p.Fit.MaxCorrection < 0 || p.Fit.Tolerance < 0 {
+		return fmt.Errorf("fit can not be negative, got %+v", p.Fit)
+	}
+
+	for i, rule := range p.Remap {
+		if rule.Last != 0 && rule.Last < rule.First {
+			return fmt.Errorf("%w: remap %d goes from %d down to %d", ErrInvalidRange, i, rule.First, rule.Last)
+		}
+		if (rule.Glyph == nil) == (rule.Shift == 0) {
+			return fmt.Errorf("remap %d needs either glyph or shift", i)
+		}
+	}
+
+	for scale, glyphs := range p.Widths {
+		_, err := strconv.ParseFloat(scale, 64)
+		if err != nil {
+			return fmt.Errorf("widths scale %q is not a number", scale)
+		}
+		for char := range glyphs {
+			if len([]rune(char)) != 1 {
+				return fmt.Errorf("widths %s: %q is not a single character", scale, char)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Font file path, relative ones are relative to the profile
+func (p *FontProfile) fontFilePath() string {
+	if p.FontFile == "" || filepath.IsAbs(p.FontFile) {
+		return p.FontFile
+	}
+	return filepath.Join(p.profileDir, p.FontFile)
+}
+
+func (p *FontProfile) dpi() float64 {
+	if p.DPI == 0 {
+		return 144
+	}
+	return p.DPI
+}
+
+func (p *FontProfile) hinting() font.Hinting {
+	return hintings[p.Hinting]
+}
+
+// The code of the glyph in the font file to draw for a character code. The
+// last rule that covers the code wins.
+func (p *FontProfile) glyphCode(code uint16) uint16 {
+	glyph := code
+	for _, rule := range p.Remap {
+		last := rule.Last
+		if last == 0 {
+			last = rule.First
+		}
+		if code < rule.First || code > last {
+			continue
+		}
+		if rule.Shift != 0 {
+			glyph = uint16(int(code) + rule.Shift)
+		} else {
+			glyph = *rule.Glyph
+		}
+	}
+	return glyph
+}
+
+// Applies the width adjustments for the scale to the glyphs of the font.
+// Characters the font or its CWDHs do not have are skipped.
+func (p *FontProfile) adjustWidths(b *BFFNT, scale float64) {
+	glyphs, ok := p.Widths[strconv.FormatFloat(scale, 'f', -1, 64)]
+	if !ok {
+		return
+	}
+
+	for char, adjustment := range glyphs {
+		index, ok := b.CWDHIndexMap[[]rune(char)[0]]
+		if !ok {
+			continue
+		}
+		glyph := b.glyphWidths(uint16(index))
+		if glyph == nil {
+			continue
+		}
+		glyph.LeftWidth += int8(adjustment.Left)
+		glyph.CharWidth = uint8(int(glyph.CharWidth) + adjustment.CharWidth)
+	}
+}
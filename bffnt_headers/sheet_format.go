@@ -0,0 +1,422 @@
+package bffnt_headers
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+)
+
+// A sheetFormat converts sheet images to and from the raw bytes of one
+// TGLP.SheetImageFormat. The raw bytes are elements in row order, before
+// swizzling. An element is a single pixel or, for block compressed formats, a
+// BlockSize x BlockSize block of pixels.
+//
+// Font sheets are single channel coverage masks in most fonts. Single
+// channel formats decode into white pixels with the value in the alpha
+// channel, and encode from the alpha channel. Luminance formats decode into
+// gray pixels and encode the luma of the color channels.
+type sheetFormat struct {
+	Name      string
+	Bpp       uint // bits per element
+	BlockSize int  // width and height of an element in pixels
+	Decode    func(data []byte, width int, height int) *image.NRGBA
+	Encode    func(img *image.NRGBA, quality EncodeQuality) []byte
+}
+
+// How hard the lossy block compressed formats try to match the sheet image.
+// Higher qualities take longer to encode. The zero value is QualityMedium.
+type EncodeQuality int
+
+const (
+	QualityLow EncodeQuality = iota - 1
+	QualityMedium
+	QualityHigh
+)
+
+// The qualities by their name in the -quality flag
+var encodeQualities = map[string]EncodeQuality{
+	"low":    QualityLow,
+	"medium": QualityMedium,
+	"high":   QualityHigh,
+}
+
+// Rearranges the unswizzled elements of a sheet into the order the console
+// reads them in, and back. Size is the amount of bytes of a stored sheet.
+type sheetLayout interface {
+	Size() int
+	Swizzle(data []byte) ([]byte, error)
+	Deswizzle(data []byte) ([]byte, error)
+}
+
+// Wii U sheet image formats, stored in the GX2 surface format of the same
+// name. The numbering is not the 3DS one from 3dbrew, on the Wii U 12 is BC4
+// and not ETC1. GX2 has no 24 or 4 bit formats, RGB8 is stored in 32 bits
+// (R8_G8_B8_A8 with opaque alpha) and LA4 and A4 in 8 bits (R4_G4). The
+// channels of GX2 formats start at the lowest bits. Every botw font is either
+// A8 (8) or BC4 (12).
+var cafeSheetFormats = map[uint16]sheetFormat{
+	0:  pixelFormat("RGBA8", 32, decodeRGBA8, encodeRGBA8),
+	1:  pixelFormat("RGB8", 32, decodeRGBX8, encodeRGBX8),
+	2:  pixelFormat("RGB5A1", 16, decodeABGR1555, encodeABGR1555),
+	3:  pixelFormat("RGB565", 16, decodeBGR565, encodeBGR565),
+	4:  pixelFormat("RGBA4", 16, decodeABGR4, encodeABGR4),
+	5:  pixelFormat("LA8", 16, decodeLA8, encodeLA8),
+	6:  pixelFormat("LA4", 8, decodeIA4, encodeIA4),
+	7:  pixelFormat("A4", 8, decodeXA4, encodeXA4),
+	8:  pixelFormat("A8", 8, decodeA8, encodeA8),
+	9:  {"BC1", 64, 4, decodeBC1, encodeBC1},
+	10: {"BC2", 128, 4, decodeBC2, encodeBC2},
+	11: {"BC3", 128, 4, decodeBC3, encodeBC3},
+	12: {"BC4", 64, 4, decodeBC4, encodeBC4},
+	13: {"BC5", 128, 4, decodeBC5, encodeBC5},
+}
+
+// 3DS sheet image formats, numbered like on 3dbrew. Multi byte pixels are
+// little endian.
+var ctrSheetFormats = map[uint16]sheetFormat{
+	0:  pixelFormat("RGBA8", 32, decodeABGR8, encodeABGR8),
+	1:  pixelFormat("RGB8", 24, decodeBGR8, encodeBGR8),
+	2:  pixelFormat("RGBA5551", 16, decodeRGBA5551, encodeRGBA5551),
+	3:  pixelFormat("RGB565", 16, decodeRGB565, encodeRGB565),
+	4:  pixelFormat("RGBA4", 16, decodeRGBA4, encodeRGBA4),
+	5:  pixelFormat("LA8", 16, decodeAL8, encodeAL8),
+	6:  pixelFormat("HILO8", 16, decodeHILO8, encodeHILO8),
+	7:  pixelFormat("L8", 8, decodeL8, encodeL8),
+	8:  pixelFormat("A8", 8, decodeA8, encodeA8),
+	9:  pixelFormat("LA4", 8, decodeLA4, encodeLA4),
+	10: nibbleFormat("L4", decodeL4, encodeL4),
+	11: nibbleFormat("A4", decodeA4, encodeA4),
+	12: {"ETC1", 64, 4, decodeETC1, encodeETC1},
+	13: {"ETC1A4", 128, 4, decodeETC1A4, encodeETC1A4},
+}
+
+// Switch sheet formats, by the format of the BNTX texture holding the sheets.
+// The low byte is the type, 1 for UNORM and 6 for SRGB. Single channel sheets
+// are R8 or BC4 with the red channel read as alpha.
+var nxSheetFormats = map[uint32]sheetFormat{
+	0x0201: pixelFormat("R8", 8, decodeA8, encodeA8),
+	0x0901: pixelFormat("R8G8", 16, decodeLA8, encodeLA8),
+	0x0B01: pixelFormat("RGBA8", 32, decodeRGBA8, encodeRGBA8),
+	0x0B06: pixelFormat("RGBA8 SRGB", 32, decodeRGBA8, encodeRGBA8),
+	0x1D01: {"BC4", 64, 4, decodeBC4, encodeBC4},
+}
+
+// Wii sheet image formats, the GX texture formats. I4 and I8 are intensity,
+// read as alpha like the single channel formats of the other platforms.
+// Multi byte pixels are big endian. The palette formats and CMPR are not
+// supported.
+var rvlSheetFormats = map[uint16]sheetFormat{
+	0: nibbleFormat("I4", decodeA4, encodeA4),
+	1: pixelFormat("I8", 8, decodeA8, encodeA8),
+	2: pixelFormat("IA4", 8, decodeIA4, encodeIA4),
+	3: pixelFormat("IA8", 16, decodeAL8, encodeAL8),
+	4: pixelFormat("RGB565", 16, decodeRGB565BE, encodeRGB565BE),
+	5: pixelFormat("RGB5A3", 16, decodeRGB5A3, encodeRGB5A3),
+	6: pixelFormat("RGBA8", 32, decodeRGBA8, encodeRGBA8),
+}
+
+// Width and height of the sheet in elements
+func (f sheetFormat) elementSize(width int, height int) (int, int) {
+	return (width + f.BlockSize - 1) / f.BlockSize, (height + f.BlockSize - 1) / f.BlockSize
+}
+
+// Amount of bytes of an unswizzled sheet
+func (f sheetFormat) dataSize(width int, height int) int {
+	elementsWide, elementsHigh := f.elementSize(width, height)
+	return (elementsWide*elementsHigh*int(f.Bpp) + 7) / 8
+}
+
+// A format storing every pixel in bpp/8 bytes
+func pixelFormat(name string, bpp uint, decodePixel func(p []byte) color.NRGBA, encodePixel func(c color.NRGBA, p []byte)) sheetFormat {
+	pixelBytes := int(bpp / 8)
+
+	decode := func(data []byte, width int, height int) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, width, height))
+		for i := 0; i < width*height; i++ {
+			c := decodePixel(data[i*pixelBytes : (i+1)*pixelBytes])
+			img.Pix[4*i+0] = c.R
+			img.Pix[4*i+1] = c.G
+			img.Pix[4*i+2] = c.B
+			img.Pix[4*i+3] = c.A
+		}
+		return img
+	}
+
+	encode := func(img *image.NRGBA, quality EncodeQuality) []byte {
+		width, height := img.Rect.Dx(), img.Rect.Dy()
+		data := make([]byte, width*height*pixelBytes)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				i := y*width + x
+				encodePixel(nrgbaAt(img, x, y), data[i*pixelBytes:(i+1)*pixelBytes])
+			}
+		}
+		return data
+	}
+
+	return sheetFormat{name, bpp, 1, decode, encode}
+}
+
+// A format storing two pixels per byte, the first pixel in the low nibble
+func nibbleFormat(name string, decodePixel func(v uint8) color.NRGBA, encodePixel func(c color.NRGBA) uint8) sheetFormat {
+	decode := func(data []byte, width int, height int) *image.NRGBA {
+		img := image.NewNRGBA(image.Rect(0, 0, width, height))
+		for i := 0; i < width*height; i++ {
+			c := decodePixel((data[i/2] >> (4 * uint(i%2))) & 0xF)
+			img.Pix[4*i+0] = c.R
+			img.Pix[4*i+1] = c.G
+			img.Pix[4*i+2] = c.B
+			img.Pix[4*i+3] = c.A
+		}
+		return img
+	}
+
+	encode := func(img *image.NRGBA, quality EncodeQuality) []byte {
+		width, height := img.Rect.Dx(), img.Rect.Dy()
+		data := make([]byte, (width*height+1)/2)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				i := y*width + x
+				data[i/2] |= encodePixel(nrgbaAt(img, x, y)) << (4 * uint(i%2))
+			}
+		}
+		return data
+	}
+
+	return sheetFormat{name, 4, 1, decode, encode}
+}
+
+func nrgbaAt(img *image.NRGBA, x int, y int) color.NRGBA {
+	pos := y*img.Stride + 4*x
+	return color.NRGBA{img.Pix[pos+0], img.Pix[pos+1], img.Pix[pos+2], img.Pix[pos+3]}
+}
+
+// Same weights as color.GrayModel, without premultiplying the alpha
+func luma(c color.NRGBA) uint8 {
+	return uint8((19595*uint32(c.R) + 38470*uint32(c.G) + 7471*uint32(c.B) + 1<<15) >> 16)
+}
+
+// Reduces an 8 bit value to the given amount of bits
+func quantize(v uint8, bits uint) uint8 {
+	maxValue := uint32(1)<<bits - 1
+	return uint8((uint32(v)*maxValue + 127) / 255)
+}
+
+// Expands a value of the given amount of bits to 8 bits
+func expand(v uint8, bits uint) uint8 {
+	maxValue := uint32(1)<<bits - 1
+	return uint8((uint32(v)*255 + maxValue/2) / maxValue)
+}
+
+func decodeRGBA8(p []byte) color.NRGBA { return color.NRGBA{p[0], p[1], p[2], p[3]} }
+func encodeRGBA8(c color.NRGBA, p []byte) {
+	p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
+}
+
+// Alpha is ignored and written opaque
+func decodeRGBX8(p []byte) color.NRGBA { return color.NRGBA{p[0], p[1], p[2], 255} }
+func encodeRGBX8(c color.NRGBA, p []byte) {
+	p[0], p[1], p[2], p[3] = c.R, c.G, c.B, 255
+}
+
+func decodeABGR8(p []byte) color.NRGBA { return color.NRGBA{p[3], p[2], p[1], p[0]} }
+func encodeABGR8(c color.NRGBA, p []byte) {
+	p[0], p[1], p[2], p[3] = c.A, c.B, c.G, c.R
+}
+
+func decodeBGR8(p []byte) color.NRGBA { return color.NRGBA{p[2], p[1], p[0], 255} }
+func encodeBGR8(c color.NRGBA, p []byte) {
+	p[0], p[1], p[2] = c.B, c.G, c.R
+}
+
+func decodeRGBA5551(p []byte) color.NRGBA {
+	v := binary.LittleEndian.Uint16(p)
+	return color.NRGBA{
+		expand(uint8(v>>11&0x1F), 5),
+		expand(uint8(v>>6&0x1F), 5),
+		expand(uint8(v>>1&0x1F), 5),
+		uint8(v&1) * 255,
+	}
+}
+func encodeRGBA5551(c color.NRGBA, p []byte) {
+	v := uint16(quantize(c.R, 5))<<11 | uint16(quantize(c.G, 5))<<6 | uint16(quantize(c.B, 5))<<1 | uint16(c.A>>7)
+	binary.LittleEndian.PutUint16(p, v)
+}
+
+func decodeRGB565(p []byte) color.NRGBA {
+	v := binary.LittleEndian.Uint16(p)
+	return color.NRGBA{
+		expand(uint8(v>>11&0x1F), 5),
+		expand(uint8(v>>5&0x3F), 6),
+		expand(uint8(v&0x1F), 5),
+		255,
+	}
+}
+func encodeRGB565(c color.NRGBA, p []byte) {
+	v := uint16(quantize(c.R, 5))<<11 | uint16(quantize(c.G, 6))<<5 | uint16(quantize(c.B, 5))
+	binary.LittleEndian.PutUint16(p, v)
+}
+
+// Little endian with the red channel in the low bits, the Wii U one
+func decodeBGR565(p []byte) color.NRGBA {
+	v := binary.LittleEndian.Uint16(p)
+	return color.NRGBA{
+		expand(uint8(v&0x1F), 5),
+		expand(uint8(v>>5&0x3F), 6),
+		expand(uint8(v>>11&0x1F), 5),
+		255,
+	}
+}
+func encodeBGR565(c color.NRGBA, p []byte) {
+	v := uint16(quantize(c.B, 5))<<11 | uint16(quantize(c.G, 6))<<5 | uint16(quantize(c.R, 5))
+	binary.LittleEndian.PutUint16(p, v)
+}
+
+// Little endian with the red channel in the low bits and alpha in the top bit
+func decodeABGR1555(p []byte) color.NRGBA {
+	v := binary.LittleEndian.Uint16(p)
+	return color.NRGBA{
+		expand(uint8(v&0x1F), 5),
+		expand(uint8(v>>5&0x1F), 5),
+		expand(uint8(v>>10&0x1F), 5),
+		uint8(v>>15) * 255,
+	}
+}
+func encodeABGR1555(c color.NRGBA, p []byte) {
+	v := uint16(c.A>>7)<<15 | uint16(quantize(c.B, 5))<<10 | uint16(quantize(c.G, 5))<<5 | uint16(quantize(c.R, 5))
+	binary.LittleEndian.PutUint16(p, v)
+}
+
+// Little endian with the red channel in the low nibble
+func decodeABGR4(p []byte) color.NRGBA {
+	v := binary.LittleEndian.Uint16(p)
+	return color.NRGBA{
+		expand(uint8(v&0xF), 4),
+		expand(uint8(v>>4&0xF), 4),
+		expand(uint8(v>>8&0xF), 4),
+		expand(uint8(v>>12&0xF), 4),
+	}
+}
+func encodeABGR4(c color.NRGBA, p []byte) {
+	v := uint16(quantize(c.A, 4))<<12 | uint16(quantize(c.B, 4))<<8 | uint16(quantize(c.G, 4))<<4 | uint16(quantize(c.R, 4))
+	binary.LittleEndian.PutUint16(p, v)
+}
+
+// Big endian, the Wii one
+func decodeRGB565BE(p []byte) color.NRGBA {
+	v := binary.BigEndian.Uint16(p)
+	return color.NRGBA{
+		expand(uint8(v>>11&0x1F), 5),
+		expand(uint8(v>>5&0x3F), 6),
+		expand(uint8(v&0x1F), 5),
+		255,
+	}
+}
+func encodeRGB565BE(c color.NRGBA, p []byte) {
+	v := uint16(quantize(c.R, 5))<<11 | uint16(quantize(c.G, 6))<<5 | uint16(quantize(c.B, 5))
+	binary.BigEndian.PutUint16(p, v)
+}
+
+// Big endian. With the top bit set the pixel is opaque RGB555, otherwise it
+// is ARGB3444.
+func decodeRGB5A3(p []byte) color.NRGBA {
+	v := binary.BigEndian.Uint16(p)
+	if v&0x8000 != 0 {
+		return color.NRGBA{
+			expand(uint8(v>>10&0x1F), 5),
+			expand(uint8(v>>5&0x1F), 5),
+			expand(uint8(v&0x1F), 5),
+			255,
+		}
+	}
+	return color.NRGBA{
+		expand(uint8(v>>8&0xF), 4),
+		expand(uint8(v>>4&0xF), 4),
+		expand(uint8(v&0xF), 4),
+		expand(uint8(v>>12&0x7), 3),
+	}
+}
+func encodeRGB5A3(c color.NRGBA, p []byte) {
+	var v uint16
+	if alpha := quantize(c.A, 3); alpha == 7 {
+		v = 0x8000 | uint16(quantize(c.R, 5))<<10 | uint16(quantize(c.G, 5))<<5 | uint16(quantize(c.B, 5))
+	} else {
+		v = uint16(alpha)<<12 | uint16(quantize(c.R, 4))<<8 | uint16(quantize(c.G, 4))<<4 | uint16(quantize(c.B, 4))
+	}
+	binary.BigEndian.PutUint16(p, v)
+}
+
+func decodeRGBA4(p []byte) color.NRGBA {
+	v := binary.LittleEndian.Uint16(p)
+	return color.NRGBA{
+		expand(uint8(v>>12&0xF), 4),
+		expand(uint8(v>>8&0xF), 4),
+		expand(uint8(v>>4&0xF), 4),
+		expand(uint8(v&0xF), 4),
+	}
+}
+func encodeRGBA4(c color.NRGBA, p []byte) {
+	v := uint16(quantize(c.R, 4))<<12 | uint16(quantize(c.G, 4))<<8 | uint16(quantize(c.B, 4))<<4 | uint16(quantize(c.A, 4))
+	binary.LittleEndian.PutUint16(p, v)
+}
+
+// Luminance first
+func decodeLA8(p []byte) color.NRGBA { return color.NRGBA{p[0], p[0], p[0], p[1]} }
+func encodeLA8(c color.NRGBA, p []byte) {
+	p[0], p[1] = luma(c), c.A
+}
+
+// Alpha first
+func decodeAL8(p []byte) color.NRGBA { return color.NRGBA{p[1], p[1], p[1], p[0]} }
+func encodeAL8(c color.NRGBA, p []byte) {
+	p[0], p[1] = c.A, luma(c)
+}
+
+// Two channels, used for normal maps. HI goes to red and LO to green.
+func decodeHILO8(p []byte) color.NRGBA { return color.NRGBA{p[1], p[0], 0, 255} }
+func encodeHILO8(c color.NRGBA, p []byte) {
+	p[0], p[1] = c.G, c.R
+}
+
+func decodeL8(p []byte) color.NRGBA { return color.NRGBA{p[0], p[0], p[0], 255} }
+func encodeL8(c color.NRGBA, p []byte) {
+	p[0] = luma(c)
+}
+
+func decodeA8(p []byte) color.NRGBA { return color.NRGBA{255, 255, 255, p[0]} }
+func encodeA8(c color.NRGBA, p []byte) {
+	p[0] = c.A
+}
+
+// Luminance in the high nibble, alpha in the low nibble
+func decodeLA4(p []byte) color.NRGBA {
+	l := expand(p[0]>>4, 4)
+	return color.NRGBA{l, l, l, expand(p[0]&0xF, 4)}
+}
+func encodeLA4(c color.NRGBA, p []byte) {
+	p[0] = quantize(luma(c), 4)<<4 | quantize(c.A, 4)
+}
+
+// Alpha in the high nibble, intensity in the low nibble
+func decodeIA4(p []byte) color.NRGBA {
+	i := expand(p[0]&0xF, 4)
+	return color.NRGBA{i, i, i, expand(p[0]>>4, 4)}
+}
+func encodeIA4(c color.NRGBA, p []byte) {
+	p[0] = quantize(c.A, 4)<<4 | quantize(luma(c), 4)
+}
+
+// Alpha in the low nibble, the high nibble is unused
+func decodeXA4(p []byte) color.NRGBA { return color.NRGBA{255, 255, 255, expand(p[0]&0xF, 4)} }
+func encodeXA4(c color.NRGBA, p []byte) {
+	p[0] = quantize(c.A, 4)
+}
+
+func decodeL4(v uint8) color.NRGBA {
+	l := expand(v, 4)
+	return color.NRGBA{l, l, l, 255}
+}
+func encodeL4(c color.NRGBA) uint8 { return quantize(luma(c), 4) }
+
+func decodeA4(v uint8) color.NRGBA { return color.NRGBA{255, 255, 255, expand(v, 4)} }
+func encodeA4(c color.NRGBA) uint8 { return quantize(c.A, 4) }
@@ -0,0 +1,72 @@
+package bffnt_headers
+
+import (
+	"errors"
+	"unicode"
+)
+
+// Removes every glyph but the ones of the kept characters and the alter
+// char. Returns the removed characters, and the kept ones the font does not
+// have. Those are drawn with the alter char in the game.
+func subsetFont(f *Font, keep []rune) (removed []rune, missing []rune, err error) {
+	keepSet := make(map[rune]bool, len(keep))
+	for _, r := range uniqueRunes(keep) {
+		keepSet[r] = true
+		if _, ok := f.Glyph(r); !ok && !unicode.IsControl(r) {
+			missing = append(missing, r)
+		}
+	}
+
+	for _, r := range f.Runes() {
+		if keepSet[r] {
+			continue
+		}
+		err := f.RemoveGlyph(r)
+		if errors.Is(err, ErrAlterChar) {
+			continue
+		}
+		if err != nil {
+			return removed, missing, err
+		}
+		removed = append(removed, r)
+	}
+
+	return removed, missing, nil
+}
+
+// PackSheets makes the sheets smaller when the glyphs fit on fewer rows.
+// Sheets keep a power of two height and the sheets after the first are only
+// dropped by Encode, so this only shrinks fonts that fit on a single sheet.
+func (f *Font) PackSheets() error {
+	tglp := &f.BFFNT.TGLP
+	glyphCount := len(f.order)
+	columnCount := int(tglp.NumOfColumns)
+	realCellHeight := int(tglp.CellHeight) + 1
+	if glyphCount > columnCount*int(tglp.NumOfRows) {
+		return nil
+	}
+
+	height := int(tglp.SheetHeight)
+	rowCount := int(tglp.NumOfRows)
+	for height%2 == 0 {
+		rows := (height / 2) / realCellHeight
+		if rows*columnCount < glyphCount || rows < 1 {
+			break
+		}
+		height /= 2
+		rowCount = rows
+	}
+	if height == int(tglp.SheetHeight) {
+		return nil
+	}
+
+	tglp.SheetHeight = uint16(height)
+	tglp.NumOfRows = uint16(rowCount)
+	surface, _, err := tglp.sheetSurface(0)
+	if err != nil {
+		return err
+	}
+	tglp.SheetSize = uint32(surface.Size())
+
+	return nil
+}
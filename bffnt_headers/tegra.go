@@ -0,0 +1,137 @@
+package bffnt_headers
+
+import (
+	"fmt"
+)
+
+// The Switch GPU (Tegra X1) stores textures "block linear". The smallest
+// unit is a GOB (group of bytes), 64 bytes of 8 rows. GOBs are stacked into
+// blocks that are 1 to 16 GOBs high, and the blocks are stored row by row.
+// Inside a GOB the bytes are in 16 byte x 2 row pieces in a fixed order.
+//
+// Ported from AboodXD's BNTX-Extractor (swizzle.py)
+
+const (
+	gobWidth            = 64 // bytes
+	gobHeight           = 8  // rows
+	gobSize             = gobWidth * gobHeight
+	maxGobsPerBlock     = 16
+	tegraTileModeLinear = 1
+)
+
+// A single block linear texture. Width and Height are counted in elements,
+// Pitch is the width in bytes and AlignedHeight the height in rows after they
+// have been padded to whole blocks.
+type blockLinearLayout struct {
+	Width           uint
+	Height          uint
+	BytesPerElement uint
+	BlockHeight     uint // GOBs per block
+	Pitch           uint
+	AlignedHeight   uint
+}
+
+func newBlockLinearLayout(width int, height int, format sheetFormat, blockHeightLog2 uint) (blockLinearLayout, error) {
+	elementsWide, elementsHigh := format.elementSize(width, height)
+	l := blockLinearLayout{
+		Width:           uint(elementsWide),
+		Height:          uint(elementsHigh),
+		BytesPerElement: format.Bpp / 8,
+		BlockHeight:     1 << blockHeightLog2,
+	}
+
+	if width <= 0 || height <= 0 {
+		return l, fmt.Errorf("switch texture can not be %dx%d", width, height)
+	}
+	if format.Bpp%8 != 0 {
+		return l, fmt.Errorf("unsupported switch texture bpp: %d", format.Bpp)
+	}
+	if l.BlockHeight > maxGobsPerBlock {
+		return l, fmt.Errorf("switch texture blocks can not be %d GOBs high", l.BlockHeight)
+	}
+
+	l.Pitch = alignUp(l.Width*l.BytesPerElement, gobWidth)
+	l.AlignedHeight = alignUp(l.Height, gobHeight*l.BlockHeight)
+
+	return l, nil
+}
+
+// The block height the Switch picks for a texture of height elements, as a
+// power of two
+func blockHeightLog2(height int) uint {
+	gobs := height / gobHeight
+	log2 := uint(0)
+	for 1<<log2 < gobs && 1<<log2 < maxGobsPerBlock {
+		log2++
+	}
+	return log2
+}
+
+// Size in bytes of the swizzled texture including the block padding.
+func (l blockLinearLayout) Size() int {
+	return int(l.Pitch * l.AlignedHeight)
+}
+
+// Takes the swizzled texture and returns the elements in row order with no
+// padding.
+func (l blockLinearLayout) Deswizzle(data []byte) ([]byte, error) {
+	return l.swizzleTexture(data, false)
+}
+
+// Takes the elements in row order with no padding and returns the swizzled
+// texture, Size() bytes.
+func (l blockLinearLayout) Swizzle(data []byte) ([]byte, error) {
+	return l.swizzleTexture(data, true)
+}
+
+func (l blockLinearLayout) swizzleTexture(data []byte, swizzle bool) ([]byte, error) {
+	linearSize := int(l.Width * l.Height * l.BytesPerElement)
+
+	var result []byte
+	if swizzle {
+		if len(data) != linearSize {
+			return nil, fmt.Errorf("swizzle expected %d bytes of image data, got %d", linearSize, len(data))
+		}
+		result = make([]byte, l.Size())
+	} else {
+		if len(data) < l.Size() {
+			return nil, fmt.Errorf("deswizzle expected %d bytes of texture data, got %d", l.Size(), len(data))
+		}
+		result = make([]byte, linearSize)
+	}
+
+	// elements are at most 16 bytes and 16 byte aligned, so they never
+	// straddle the 16 byte pieces of a GOB
+	bytesPerElement := l.BytesPerElement
+	for y := uint(0); y < l.Height; y++ {
+		for x := uint(0); x < l.Width; x++ {
+			swizzledIndex := l.address(x*bytesPerElement, y)
+			linearIndex := (y*l.Width + x) * bytesPerElement
+
+			if swizzle {
+				copy(result[swizzledIndex:swizzledIndex+bytesPerElement], data[linearIndex:linearIndex+bytesPerElement])
+			} else {
+				copy(result[linearIndex:linearIndex+bytesPerElement], data[swizzledIndex:swizzledIndex+bytesPerElement])
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Byte offset of byte x of row y
+func (l blockLinearLayout) address(x uint, y uint) uint {
+	widthInGobs := l.Pitch / gobWidth
+	blockRows := gobHeight * l.BlockHeight
+
+	gobAddress := (y/blockRows)*gobSize*l.BlockHeight*widthInGobs +
+		(x/gobWidth)*gobSize*l.BlockHeight +
+		(y%blockRows/gobHeight)*gobSize
+
+	return gobAddress +
+		(x%64)/32*256 +
+		(y%8)/2*64 +
+		(x%32)/16*32 +
+		(y%2)*16 +
+		x%16
+}
@@ -3,7 +3,6 @@ package bffnt_headers
 import (
 	"bufio"
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"image"
 	"math"
@@ -11,31 +10,6 @@ import (
 	"github.com/disintegration/imaging"
 )
 
-type AddrTileMode uint
-
-const (
-	ADDR_TM_LINEAR_GENERAL AddrTileMode = iota
-	ADDR_TM_LINEAR_ALIGNED
-	ADDR_TM_1D_TILED_THIN1
-	ADDR_TM_1D_TILED_THICK
-	ADDR_TM_2D_TILED_THIN1
-	ADDR_TM_2D_TILED_THIN2
-	ADDR_TM_2D_TILED_THIN4
-	ADDR_TM_2D_TILED_THICK
-	ADDR_TM_2B_TILED_THIN1
-	ADDR_TM_2B_TILED_THIN2
-	ADDR_TM_2B_TILED_THIN4
-	ADDR_TM_2B_TILED_THICK
-	ADDR_TM_3D_TILED_THIN1
-	ADDR_TM_3D_TILED_THICK
-	ADDR_TM_3B_TILED_THIN1
-	ADDR_TM_3B_TILED_THICK
-	ADDR_TM_2D_TILED_XTHICK
-	ADDR_TM_3D_TILED_XTHICK
-	ADDR_TM_POWER_SAVE
-	ADDR_TM_COUNT
-)
-
 type TGLP struct { //    Offset  Size  Description
 	MagicHeader      string        // 0x00    0x04  Magic Header (TGLP)
 	SectionSize      uint32        // 0x04    0x04  Section Size
@@ -45,26 +19,38 @@ type TGLP struct { //    Offset  Size  Description
 	MaxCharWidth     uint8         // 0x0B    0x01  Max Character Width
 	SheetSize        uint32        // 0x0C    0x04  Sheet Size
 	BaselinePosition uint16        // 0x10    0x02  Baseline Position
-	SheetImageFormat uint16        // 0x12    0x02  Sheet Image Format 0-13: (RGBA8, RGB8, RGBA5551, RGB565, RGBA4, LA8, HILO8, L8, A8, LA4, L4, A4, ETC1, ETC1A4)
+	SheetImageFormat uint16        // 0x12    0x02  Sheet Image Format, numbering depends on the platform (cafeSheetFormats, ctrSheetFormats, rvlSheetFormats)
 	NumOfColumns     uint16        // 0x14    0x02  Number of Sheet columns
 	NumOfRows        uint16        // 0x16    0x02  Number of Sheet rows
 	SheetWidth       uint16        // 0x18    0x02  Sheet Width
 	SheetHeight      uint16        // 0x1A    0x02  Sheet Height
 	SheetDataOffset  uint32        // 0x1C    0x04  Sheet Data Offset
 	AllSheetData     []byte        // raw bytes of all data sheets. Used for decoding.
-	SheetData        []image.NRGBA // separated unswizzled images. Used for encoding.
+	SheetData        []image.NRGBA // separated unswizzled images, one per sheet. Filled when decoding, used for encoding.
+	Platform         Platform      // from the file magic header. Decides the sheet format numbering and layout.
+	SheetQuality     EncodeQuality // quality of lossy sheet formats. Used for encoding.
+
+	// Switch sheets are the layers of a texture in a BNTX. It is kept to
+	// encode the sheets into again.
+	bntx *bntxTexture
+
+	sectionOrder
+	layout headerLayout // from the file version. Decides the field order.
+
+	// The 3DS (CFNT version 3) and Wii (RFNT version 1) section has a 1 byte
+	// baseline and 2 bytes for the number of sheets:
+	// BaselinePosition  0x0A    0x01  Baseline Position
+	// MaxCharWidth      0x0B    0x01  Max Character Width
+	// SheetSize         0x0C    0x04  Sheet Size
+	// NumOfSheets       0x10    0x02  Number of Sheets
+	// The fields from SheetImageFormat on are the same.
 }
 
 func (tglp *TGLP) Upscale(scale float64) {
-	tglp.SheetWidth = uint16(math.Ceil(float64(tglp.SheetWidth) * scale))
-	tglp.SheetHeight = uint16(math.Ceil(float64(tglp.SheetHeight*uint16(tglp.NumOfSheets)) * scale))
-	tglp.SheetSize = uint32(tglp.SheetWidth) * uint32(tglp.SheetHeight)
-	// tglp.SheetImageFormat = uint16(12)
-	if tglp.SheetImageFormat == 12 {
-		tglp.SheetSize = uint32(math.Ceil(float64(tglp.SheetSize) / float64(2)))
-	}
+	// the sheets are merged below each other, which can be taller than 16 bits
+	sheetWidth := int(math.Ceil(float64(tglp.SheetWidth) * scale))
+	sheetHeight := int(math.Ceil(float64(int(tglp.SheetHeight)*int(tglp.NumOfSheets)) * scale))
 
-	tglp.SectionSize = TGLP_HEADER_SIZE + uint32(tglp.computePredataPadding()) + tglp.SheetSize
 	tglp.CellWidth = uint8(math.Ceil(float64(tglp.CellWidth) * scale))
 	tglp.CellHeight = uint8(math.Ceil(float64(tglp.CellHeight) * scale))
 	tglp.MaxCharWidth = uint8(math.Ceil(float64(tglp.MaxCharWidth) * scale))
@@ -74,30 +60,142 @@ func (tglp *TGLP) Upscale(scale float64) {
 	// tglp.SheetWidth = uint16(tglp.SheetWidth * scale)
 	// tglp.SheetHeight = uint16(1024 * scale)
 	// tglp.NumOfColumns /= uint16(scale)
-	tglp.NumOfRows = tglp.NumOfRows * uint16(tglp.NumOfSheets)
+	if maxSize := tglp.Platform.maxSheetSize(); maxSize > 0 {
+		tglp.splitSheets(maxSize, sheetWidth, sheetHeight)
+	} else {
+		tglp.SheetWidth = uint16(sheetWidth)
+		tglp.SheetHeight = uint16(sheetHeight)
+		tglp.NumOfRows = tglp.NumOfRows * uint16(tglp.NumOfSheets)
+		tglp.NumOfSheets = uint8(1) // its just easier not to deal with multiple pages
+	}
+
+	// The decoded sheets no longer match the new sheet size. New sheet images
+	// have to be provided before encoding, otherwise blank sheets are written.
+	tglp.SheetData = nil
+
+	// The swizzled surface can be padded to whole tiles. Formats we can not
+	// swizzle fall back to 1 byte per pixel.
+	surface, _, err := tglp.sheetSurface(0)
+	if err == nil {
+		tglp.SheetSize = uint32(surface.Size())
+	} else {
+		tglp.SheetSize = uint32(tglp.SheetWidth) * uint32(tglp.SheetHeight)
+	}
+	tglp.SectionSize = TGLP_HEADER_SIZE + uint32(tglp.computePredataPadding()) + tglp.SheetSize*uint32(tglp.NumOfSheets)
+}
 
-	tglp.NumOfSheets = uint8(1) // its just easier not to deal with multiple pages
+// Lays the cells of all sheets out on sheets of sheetWidth x sheetHeight,
+// spread over as many sheets of maxSize as they need when that is too large.
+// The cells keep their order, only the number of columns and rows per sheet
+// changes. 3DS sheets are rounded up to a power of two, the only sizes the
+// PICA200 can sample.
+func (tglp *TGLP) splitSheets(maxSize, sheetWidth, sheetHeight int) {
+	// every cell is separated by 1 px at the left and top
+	realCellWidth, realCellHeight := int(tglp.CellWidth)+1, int(tglp.CellHeight)+1
+	cellCount := int(tglp.NumOfColumns) * int(tglp.NumOfRows) * int(tglp.NumOfSheets)
+	columnCount := int(tglp.NumOfColumns)
+
+	if sheetWidth > maxSize {
+		sheetWidth = maxSize
+		columnCount = (maxSize - 1) / realCellWidth
+	}
+	rowCount := (cellCount + columnCount - 1) / columnCount
+	if rowCount*realCellHeight+1 > sheetHeight {
+		sheetHeight = rowCount*realCellHeight + 1
+	}
+	sheetCount := 1
+	if sheetHeight > maxSize {
+		sheetHeight = maxSize
+		rowsPerSheet := (maxSize - 1) / realCellHeight
+		sheetCount = (rowCount + rowsPerSheet - 1) / rowsPerSheet
+		rowCount = rowsPerSheet
+	}
+
+	if tglp.Platform == Platform3DS {
+		sheetWidth = nextPowerOfTwo(sheetWidth)
+		sheetHeight = nextPowerOfTwo(sheetHeight)
+	}
+
+	tglp.SheetWidth = uint16(sheetWidth)
+	tglp.SheetHeight = uint16(sheetHeight)
+	tglp.NumOfColumns = uint16(columnCount)
+	tglp.NumOfRows = uint16(rowCount)
+	tglp.NumOfSheets = uint8(sheetCount)
 }
 
-// Version 4 (BFFNT)
+// Smallest power of two that is at least n.
+func nextPowerOfTwo(n int) int {
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+	return size
+}
+
+// Every version of fileVersions. The version in the file header decides the
+// field order.
 // The input for TGLP decode is the entire BFFNT file in the form of a byte
 // array ([]byte).
-func (tglp *TGLP) Decode(raw []byte) {
-	headerStart := FFNT_HEADER_SIZE + FINF_HEADER_SIZE
+func (tglp *TGLP) Decode(raw []byte) error {
+	var err error
+	tglp.byteOrder, tglp.Platform, tglp.layout, err = fileFormat(raw)
+	if err != nil {
+		return err
+	}
+
+	headerStart := tglp.headerStart()
 	headerEnd := headerStart + TGLP_HEADER_SIZE
-	headerRaw := raw[headerStart:headerEnd]
-	assertEqual(TGLP_HEADER_SIZE, len(headerRaw))
-	tglp.DecodeHeader(headerRaw)
+	headerRaw, err := sliceSection(raw, TGLP_MAGIC_HEADER, "header", headerStart, headerEnd)
+	if err != nil {
+		return err
+	}
+
+	err = tglp.DecodeHeader(headerRaw)
+	if err != nil {
+		return err
+	}
+
+	err = checkMagicHeader(TGLP_MAGIC_HEADER, headerStart, tglp.MagicHeader)
+	if err != nil {
+		return err
+	}
+
+	if int(tglp.SheetDataOffset) < headerEnd {
+		return &SectionError{
+			Section:  TGLP_MAGIC_HEADER,
+			Offset:   headerStart + 28,
+			Field:    "SheetDataOffset",
+			Expected: headerEnd,
+			Actual:   int(tglp.SheetDataOffset),
+			Err:      ErrInvalidRange,
+		}
+	}
 
 	totalSheetDataSize := int(tglp.SheetSize) * int(tglp.NumOfSheets)
+	if tglp.Platform == PlatformSwitch {
+		totalSheetDataSize, err = bntxFileSize(raw, int(tglp.SheetDataOffset))
+		if err != nil {
+			return err
+		}
+	}
 	dataStart := int(tglp.SheetDataOffset)
 	dataEnd := dataStart + totalSheetDataSize
-	tglp.AllSheetData = raw[dataStart:dataEnd]
+	tglp.AllSheetData, err = sliceSection(raw, TGLP_MAGIC_HEADER, "sheet data", dataStart, dataEnd)
+	if err != nil {
+		return err
+	}
 
 	calculatedTGLPSectionSize := TGLP_HEADER_SIZE + tglp.computePredataPadding() + len(tglp.AllSheetData)
-	assertEqual(int(tglp.SectionSize), calculatedTGLPSectionSize)
+	err = checkEqual(TGLP_MAGIC_HEADER, headerStart+4, "SectionSize", ErrSizeMismatch, int(tglp.SectionSize), calculatedTGLPSectionSize)
+	if err != nil {
+		return err
+	}
+
+	err = tglp.DecodeSheets()
+	if err != nil {
+		return err
+	}
 
-	// tglp.DecodeSheets()
 	if Debug {
 		tglp.Print()
 		// fmt.Println("MagicHeader     ", tglp.MagicHeader)
@@ -122,6 +220,8 @@ func (tglp *TGLP) Decode(raw []byte) {
 		fmt.Printf("image data  %-8d to  %d\n", dataStart, dataEnd)
 		fmt.Println()
 	}
+
+	return nil
 }
 
 func (tglp *TGLP) Print() {
@@ -142,102 +242,286 @@ func (tglp *TGLP) Print() {
 	fmt.Println()
 }
 
-func (tglp *TGLP) DecodeHeader(raw []byte) {
+// Decodes the header in the byte order and layout of the TGLP, the Wii U
+// ones unless Decode set them from the file.
+func (tglp *TGLP) DecodeHeader(raw []byte) error {
+	if len(raw) < TGLP_HEADER_SIZE {
+		return &SectionError{
+			Section:  TGLP_MAGIC_HEADER,
+			Offset:   tglp.headerStart(),
+			Field:    "header",
+			Expected: TGLP_HEADER_SIZE,
+			Actual:   len(raw),
+			Err:      ErrTruncated,
+		}
+	}
+
+	order := tglp.order()
 	tglp.MagicHeader = string(raw[0:4])
-	tglp.SectionSize = binary.BigEndian.Uint32(raw[4:8])
+	tglp.SectionSize = order.Uint32(raw[4:8])
 	tglp.CellWidth = raw[8] // byte == uint8
 	tglp.CellHeight = raw[9]
-	tglp.NumOfSheets = raw[10]
-	tglp.MaxCharWidth = raw[11]
-	tglp.SheetSize = binary.BigEndian.Uint32(raw[12:16])
-	tglp.BaselinePosition = binary.BigEndian.Uint16(raw[16:18])
-	tglp.SheetImageFormat = binary.BigEndian.Uint16(raw[18:20])
-	tglp.NumOfColumns = binary.BigEndian.Uint16(raw[20:22])
-	tglp.NumOfRows = binary.BigEndian.Uint16(raw[22:24])
-	tglp.SheetWidth = binary.BigEndian.Uint16(raw[24:26])
-	tglp.SheetHeight = binary.BigEndian.Uint16(raw[26:28])
-	tglp.SheetDataOffset = binary.BigEndian.Uint32(raw[28:TGLP_HEADER_SIZE])
+	if tglp.layout.legacyFields() {
+		tglp.BaselinePosition = uint16(raw[10])
+		tglp.MaxCharWidth = raw[11]
+		tglp.SheetSize = order.Uint32(raw[12:16])
+		numOfSheets := order.Uint16(raw[16:18])
+		if numOfSheets > math.MaxUint8 {
+			return &SectionError{
+				Section:  TGLP_MAGIC_HEADER,
+				Offset:   tglp.headerStart() + 16,
+				Field:    "NumOfSheets",
+				Expected: math.MaxUint8,
+				Actual:   int(numOfSheets),
+				Err:      ErrValueOutOfRange,
+			}
+		}
+		tglp.NumOfSheets = uint8(numOfSheets)
+	} else {
+		tglp.NumOfSheets = raw[10]
+		tglp.MaxCharWidth = raw[11]
+		tglp.SheetSize = order.Uint32(raw[12:16])
+		tglp.BaselinePosition = order.Uint16(raw[16:18])
+	}
+	tglp.SheetImageFormat = order.Uint16(raw[18:20])
+	tglp.NumOfColumns = order.Uint16(raw[20:22])
+	tglp.NumOfRows = order.Uint16(raw[22:24])
+	tglp.SheetWidth = order.Uint16(raw[24:26])
+	tglp.SheetHeight = order.Uint16(raw[26:28])
+	tglp.SheetDataOffset = order.Uint32(raw[28:TGLP_HEADER_SIZE])
+
+	// the glyph cells are placed by dividing by the columns and rows
+	if tglp.NumOfColumns == 0 || tglp.NumOfRows == 0 {
+		offset, field := tglp.headerStart()+20, "NumOfColumns"
+		if tglp.NumOfColumns != 0 {
+			offset, field = tglp.headerStart()+22, "NumOfRows"
+		}
+		return &SectionError{
+			Section:  TGLP_MAGIC_HEADER,
+			Offset:   offset,
+			Field:    field,
+			Expected: 1,
+			Actual:   0,
+			Err:      ErrValueOutOfRange,
+		}
+	}
 
 	if Debug {
 		// pprint(tglp)
 	}
+
+	return nil
+}
+
+// The format and layout of a single sheet.
+func (tglp *TGLP) sheetSurface(sheetIndex int) (sheetLayout, sheetFormat, error) {
+	if tglp.Platform == PlatformSwitch {
+		return tglp.bntxSurface()
+	}
+
+	formats := cafeSheetFormats
+	switch tglp.Platform {
+	case Platform3DS:
+		formats = ctrSheetFormats
+	case PlatformWii:
+		formats = rvlSheetFormats
+	}
+
+	format, ok := formats[tglp.SheetImageFormat]
+	if !ok {
+		return nil, format, &SectionError{
+			Section: TGLP_MAGIC_HEADER,
+			Offset:  tglp.headerStart() + 18,
+			Field:   fmt.Sprintf("SheetImageFormat %d", tglp.SheetImageFormat),
+			Err:     ErrUnsupportedFormat,
+		}
+	}
+
+	var layout sheetLayout
+	var err error
+	switch tglp.Platform {
+	case Platform3DS:
+		layout, err = newPicaLayout(int(tglp.SheetWidth), int(tglp.SheetHeight), format)
+	case PlatformWii:
+		layout, err = newGXLayout(int(tglp.SheetWidth), int(tglp.SheetHeight), format)
+	default:
+		width, height := format.elementSize(int(tglp.SheetWidth), int(tglp.SheetHeight))
+		layout, err = newGX2Surface(uint(width), uint(height), format.Bpp, GX2_DEFAULT_TILE_MODE, sheetSwizzle(sheetIndex))
+	}
+	if err != nil {
+		return nil, format, &SectionError{
+			Section: TGLP_MAGIC_HEADER,
+			Offset:  tglp.headerStart(),
+			Field:   "sheet surface",
+			Err:     err,
+		}
+	}
+
+	return layout, format, nil
+}
+
+// Sheet, row and column of the cell of a glyph index. Cells are counted row
+// by row and continue on the next sheet.
+func (tglp *TGLP) cellPosition(index int) (sheet, row, column int) {
+	columnCount := int(tglp.NumOfColumns)
+	cellsPerSheet := columnCount * int(tglp.NumOfRows)
+
+	sheet = index / cellsPerSheet
+	row = (index % cellsPerSheet) / columnCount
+	column = index % columnCount
+	return sheet, row, column
+}
+
+// Pixels of a cell on its sheet. Every cell is separated by 1 px at the left
+// and top.
+func (tglp *TGLP) cellRect(row, column int) image.Rectangle {
+	cellWidth, cellHeight := int(tglp.CellWidth), int(tglp.CellHeight)
+	x := (cellWidth+1)*column + 1
+	y := (cellHeight+1)*row + 1
+	return image.Rect(x, y, x+cellWidth, y+cellHeight)
 }
 
-// TODO: decode multiple sheets
-// TODO: have swizzle take in RGBA
-func (tglp *TGLP) DecodeSheets() {
+// Splits AllSheetData into NumOfSheets sheets of SheetSize bytes and
+// deswizzles every one of them into an image in SheetData.
+func (tglp *TGLP) DecodeSheets() error {
+	if tglp.Platform == PlatformSwitch {
+		return tglp.decodeBNTXSheets()
+	}
+
 	totalSheetBytes := int(tglp.NumOfSheets) * int(tglp.SheetSize)
-	assertEqual(totalSheetBytes, len(tglp.AllSheetData))
-
-	sheetData := tglp.AllSheetData
-	depth := uint(1)
-	sw := uint(tglp.SheetWidth)
-	sh := uint(tglp.SheetHeight)
-	format_ := uint(1)
-	aa := uint(0)
-	use := uint(2)
-	tileMode := uint(4)
-	swizzle_ := uint(0)
-	bpp := uint(8)
-	slice := uint(0)
-	sample := uint(0)
-	deswizzledImage := deswizzle(sw, sh, depth, sh, format_, aa, use, tileMode, swizzle_, sw, bpp, slice, sample, sheetData)
-
-	alphaImg := image.Alpha{
-		Pix:    deswizzledImage,
-		Stride: int(tglp.SheetWidth),
-		Rect:   image.Rect(0, 0, int(tglp.SheetWidth), int(tglp.SheetHeight)),
-	}
-
-	// imaging.FlipV returns an NRGBA image
-	img := imaging.FlipV(alphaImg.SubImage(alphaImg.Rect))
-
-	tglp.SheetData = append(tglp.SheetData, *img)
+	err := checkEqual(TGLP_MAGIC_HEADER, int(tglp.SheetDataOffset), "sheet data", ErrSizeMismatch, totalSheetBytes, len(tglp.AllSheetData))
+	if err != nil {
+		return err
+	}
+
+	tglp.SheetData = make([]image.NRGBA, 0, tglp.NumOfSheets)
+	for i := 0; i < int(tglp.NumOfSheets); i++ {
+		surface, format, err := tglp.sheetSurface(i)
+		if err != nil {
+			return err
+		}
+
+		sheetStart := i * int(tglp.SheetSize)
+		sheetEnd := sheetStart + int(tglp.SheetSize)
+		deswizzledData, err := surface.Deswizzle(tglp.AllSheetData[sheetStart:sheetEnd])
+		if err != nil {
+			return &SectionError{
+				Section: TGLP_MAGIC_HEADER,
+				Offset:  int(tglp.SheetDataOffset) + sheetStart,
+				Field:   fmt.Sprintf("sheet %d", i),
+				Err:     err,
+			}
+		}
+		sheet := format.Decode(deswizzledData, int(tglp.SheetWidth), int(tglp.SheetHeight))
+
+		// Wii U and 3DS store image data upside down, the Wii does not
+		if tglp.Platform != PlatformWii {
+			sheet = imaging.FlipV(sheet)
+		}
+
+		tglp.SheetData = append(tglp.SheetData, *sheet)
+	}
+
+	return nil
 }
 
-func (tglp *TGLP) Encode() []byte {
+func (tglp *TGLP) Encode() ([]byte, error) {
 	var res []byte
 
-	// pprint(tglp)
+	// Without sheet images a template is written, see EncodeBlankSheets
+	var allSheetData []byte
+	var err error
+	if len(tglp.SheetData) > 0 {
+		allSheetData, err = tglp.EncodeSheetData()
+	} else if tglp.Platform == PlatformSwitch {
+		allSheetData, err = tglp.encodeBNTXSheets(tglp.blankSheets())
+	} else {
+		allSheetData = tglp.EncodeBlankSheets()
+	}
+	if err != nil {
+		return nil, err
+	}
+	// fmt.Println("data len:", len(allSheetData))
 
-	header := tglp.EncodeHeader()
+	// the BNTX around Switch sheets has its own size
+	if tglp.Platform == PlatformSwitch {
+		tglp.SectionSize = uint32(TGLP_HEADER_SIZE + tglp.computePredataPadding() + len(allSheetData))
+	}
+
+	header, err := tglp.EncodeHeader()
+	if err != nil {
+		return nil, err
+	}
 	// pprint(tglp)
-	padding := make([]byte, tglp.computePredataPadding())
-	allSheetData := tglp.EncodeBlankSheets()
-	// fmt.Println("data len:", len(allSheetData))
+	paddingSize := tglp.computePredataPadding()
+	if paddingSize < 0 {
+		return nil, &SectionError{
+			Section:  TGLP_MAGIC_HEADER,
+			Offset:   tglp.headerStart() + 28,
+			Field:    "SheetDataOffset",
+			Expected: tglp.headerStart() + TGLP_HEADER_SIZE,
+			Actual:   int(tglp.SheetDataOffset),
+			Err:      ErrInvalidRange,
+		}
+	}
+	padding := make([]byte, paddingSize)
 
 	res = append(res, header...)
 	res = append(res, padding...)
 	res = append(res, allSheetData...)
 	// fmt.Println("tglp size:", len(res))
 
-	assertEqual(int(tglp.SheetDataOffset), FFNT_HEADER_SIZE+FINF_HEADER_SIZE+TGLP_HEADER_SIZE+len(padding))
-	assertEqual(int(tglp.SectionSize), len(res))
-	return res
-}
+	err = checkEqual(TGLP_MAGIC_HEADER, tglp.headerStart(), "SectionSize", ErrSizeMismatch, int(tglp.SectionSize), len(res))
+	if err != nil {
+		return nil, err
+	}
 
-func (tglp *TGLP) EncodeHeader() []byte {
+	return res, nil
+}
 
+func (tglp *TGLP) EncodeHeader() ([]byte, error) {
+	order := tglp.order()
 	var buf bytes.Buffer
 	w := bufio.NewWriter(&buf)
 
 	_, _ = w.Write([]byte(tglp.MagicHeader))
-	binaryWrite(w, tglp.SectionSize)
-	binaryWrite(w, tglp.CellWidth)
-	binaryWrite(w, tglp.CellHeight)
-	binaryWrite(w, tglp.NumOfSheets)
-	binaryWrite(w, tglp.MaxCharWidth)
-	binaryWrite(w, tglp.SheetSize)
-	binaryWrite(w, tglp.BaselinePosition)
-	binaryWrite(w, tglp.SheetImageFormat)
-	binaryWrite(w, tglp.NumOfColumns)
-	binaryWrite(w, tglp.NumOfRows)
-	binaryWrite(w, tglp.SheetWidth)
-	binaryWrite(w, tglp.SheetHeight)
-	binaryWrite(w, tglp.SheetDataOffset)
-
-	assertEqual(TGLP_HEADER_SIZE, len(buf.Bytes()))
-	return buf.Bytes()
+	binaryWrite(w, order, tglp.SectionSize)
+	binaryWrite(w, order, tglp.CellWidth)
+	binaryWrite(w, order, tglp.CellHeight)
+	if tglp.layout.legacyFields() {
+		if tglp.BaselinePosition > math.MaxUint8 {
+			return nil, &SectionError{
+				Section:  TGLP_MAGIC_HEADER,
+				Offset:   tglp.headerStart() + 10,
+				Field:    "BaselinePosition",
+				Expected: math.MaxUint8,
+				Actual:   int(tglp.BaselinePosition),
+				Err:      ErrValueOutOfRange,
+			}
+		}
+		binaryWrite(w, order, uint8(tglp.BaselinePosition))
+		binaryWrite(w, order, tglp.MaxCharWidth)
+		binaryWrite(w, order, tglp.SheetSize)
+		binaryWrite(w, order, uint16(tglp.NumOfSheets))
+	} else {
+		binaryWrite(w, order, tglp.NumOfSheets)
+		binaryWrite(w, order, tglp.MaxCharWidth)
+		binaryWrite(w, order, tglp.SheetSize)
+		binaryWrite(w, order, tglp.BaselinePosition)
+	}
+	binaryWrite(w, order, tglp.SheetImageFormat)
+	binaryWrite(w, order, tglp.NumOfColumns)
+	binaryWrite(w, order, tglp.NumOfRows)
+	binaryWrite(w, order, tglp.SheetWidth)
+	binaryWrite(w, order, tglp.SheetHeight)
+	binaryWrite(w, order, tglp.SheetDataOffset)
+
+	err := checkEqual(TGLP_MAGIC_HEADER, tglp.headerStart(), "encoded header", ErrSizeMismatch, TGLP_HEADER_SIZE, len(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
 }
 
 func (tglp *TGLP) computePredataPadding() int {
@@ -248,397 +532,241 @@ func (tglp *TGLP) computePredataPadding() int {
 	// |      |             |              |                    |
 	// aaaaaa bbbbbbbbbbbbb cccccccccccccc 00000000000000000000 ddddddddddddddddddddddd
 
-	return int(tglp.SheetDataOffset) - FFNT_HEADER_SIZE - FINF_HEADER_SIZE - TGLP_HEADER_SIZE
+	return int(tglp.SheetDataOffset) - tglp.headerStart() - TGLP_HEADER_SIZE
+}
+
+// Offset of the TGLP section in the file, after the file header and FINF
+func (tglp *TGLP) headerStart() int {
+	return tglp.layout.fileHeaderSize() + FINF_HEADER_SIZE
 }
 
-// So since switch toolbox already has the ability to swizzle and replace
-// textures. I'm going to skip this part.  Generate a template BFFNT file with
-// everything but the images, and then open the template bffnt file in switch
-// toolbox and do a texture replace.  Switch toolbox will then swizzle and
-// encode the sheets for me.
+// Sheets filled with zeros. This generates a template BFFNT file with
+// everything but the images, the sheets can then be replaced with a texture
+// replace in switch toolbox.
 func (tglp *TGLP) EncodeBlankSheets() []byte {
 	return make([]byte, int(tglp.SheetSize)*int(tglp.NumOfSheets))
 }
 
-func (tglp *TGLP) EncodeSheetData() []byte {
-	encodedSheetData := make([]byte, 0)
+// Converts every image in SheetData into the sheet image format and swizzles
+// it the way the console reads it.
+func (tglp *TGLP) EncodeSheetData() ([]byte, error) {
+	err := checkEqual(TGLP_MAGIC_HEADER, tglp.headerStart()+10, "NumOfSheets vs sheet images", ErrSizeMismatch, int(tglp.NumOfSheets), len(tglp.SheetData))
+	if err != nil {
+		return nil, err
+	}
+	if tglp.Platform == PlatformSwitch {
+		return tglp.encodeBNTXSheets(tglp.SheetData)
+	}
+
+	encodedSheetData := make([]byte, 0, int(tglp.SheetSize)*len(tglp.SheetData))
 
 	// looping through every sheet to swizzle
 	for i := 0; i < len(tglp.SheetData); i++ {
 		currentSheet := tglp.SheetData[i]
+		sheetOffset := int(tglp.SheetDataOffset) + i*int(tglp.SheetSize)
 
-		// Wii U stores image data upside down
-		img := imaging.FlipV(currentSheet.SubImage(currentSheet.Rect))
+		err = checkEqual(TGLP_MAGIC_HEADER, sheetOffset, fmt.Sprintf("sheet %d width", i), ErrSizeMismatch, int(tglp.SheetWidth), currentSheet.Rect.Dx())
+		if err != nil {
+			return nil, err
+		}
+		err = checkEqual(TGLP_MAGIC_HEADER, sheetOffset, fmt.Sprintf("sheet %d height", i), ErrSizeMismatch, int(tglp.SheetHeight), currentSheet.Rect.Dy())
+		if err != nil {
+			return nil, err
+		}
 
-		sheetData := make([]byte, tglp.SheetSize)
-		switch tglp.SheetImageFormat {
-		case 8:
-			// convert RGBA into alpha only image, discard unused bytes
-			for i := 0; i < len(sheetData); i++ {
-				sheetData[i] = img.Pix[4*i+3]
+		surface, format, err := tglp.sheetSurface(i)
+		if err != nil {
+			return nil, err
+		}
+
+		// Wii U and 3DS store image data upside down, the Wii does not
+		img := imaging.Clone(currentSheet.SubImage(currentSheet.Rect))
+		if tglp.Platform != PlatformWii {
+			img = imaging.FlipV(img)
+		}
+		sheetData := format.Encode(img, tglp.SheetQuality)
+
+		swizzledData, err := surface.Swizzle(sheetData)
+		if err != nil {
+			return nil, &SectionError{
+				Section: TGLP_MAGIC_HEADER,
+				Offset:  sheetOffset,
+				Field:   fmt.Sprintf("sheet %d", i),
+				Err:     err,
 			}
-			break
-		default:
-			panic(fmt.Sprintf("Unsupported image encoding for image format: %d", tglp.SheetImageFormat))
 		}
 
-		// swizzle the image
-		depth := uint(1)
-		sw := uint(tglp.SheetWidth)
-		sh := uint(tglp.SheetHeight)
-		format_ := uint(1)
-		aa := uint(0)
-		use := uint(2)
-		tileMode := uint(4)
-		swizzle_ := uint(0)
-		bpp := uint(8)
-		slice := uint(0)
-		sample := uint(0)
-		swizzledData := swizzle(sw, sh, depth, sh, format_, aa, use, tileMode, swizzle_, sw, bpp, slice, sample, sheetData)
+		err = checkEqual(TGLP_MAGIC_HEADER, sheetOffset, fmt.Sprintf("sheet %d SheetSize", i), ErrSizeMismatch, int(tglp.SheetSize), len(swizzledData))
+		if err != nil {
+			return nil, err
+		}
 
 		// write swizzled sheet
 		encodedSheetData = append(encodedSheetData, swizzledData...)
 	}
 
-	return encodedSheetData
+	return encodedSheetData, nil
 }
 
-func deswizzle(width uint, height uint, depth uint, height_ uint, format uint, aa uint, use uint, tileMode uint, swizzle_ uint, pitch uint, bpp uint, slice uint, sample uint, data []byte) []byte {
-	return swizzleSurface(width, height, depth, format, aa, use, tileMode, swizzle_, pitch, bpp, slice, sample, data, false)
-}
+// The format and layout of the sheets in the BNTX of a Switch font
+func (tglp *TGLP) bntxSurface() (sheetLayout, sheetFormat, error) {
+	if tglp.bntx == nil {
+		return nil, sheetFormat{}, &SectionError{
+			Section: TGLP_MAGIC_HEADER,
+			Offset:  int(tglp.SheetDataOffset),
+			Field:   "switch sheets without the BNTX of a switch font",
+			Err:     ErrUnsupportedFormat,
+		}
+	}
 
-func swizzle(width uint, height uint, depth uint, height_ uint, format uint, aa uint, use uint, tileMode uint, swizzle_ uint, pitch uint, bpp uint, slice uint, sample uint, data []byte) []byte {
-	return swizzleSurface(width, height, depth, format, aa, use, tileMode, swizzle_, pitch, bpp, slice, sample, data, true)
-}
+	format, ok := nxSheetFormats[tglp.bntx.Format]
+	if !ok {
+		return nil, format, &SectionError{
+			Section: BRTI_MAGIC_HEADER,
+			Offset:  int(tglp.SheetDataOffset) + tglp.bntx.brtiStart + 0x1C,
+			Field:   fmt.Sprintf("format 0x%04X", tglp.bntx.Format),
+			Err:     ErrUnsupportedFormat,
+		}
+	}
 
-// Copied from KillzXGaming/Switch-Toolbox
-// KillzXGaming/Switch-Toolbox credits ____________
-func swizzleSurface(width uint, height uint, depth uint, format uint, aa uint, use uint, tileMode uint, swizzle_ uint, pitch uint, bpp uint, slice uint, sample uint, data []byte, swizzle bool) []byte {
-	var bytesPerPixel uint = bpp / 8
-	result := make([]byte, len(data))
-
-	// uint pipeSwizzle, bankSwizzle, pos_;
-	// ulong pos;
-
-	// if (IsFormatBCN((GX2SurfaceFormat)format))
-	// {
-	//     width = (width + 3) / 4;
-	//     height = (height + 3) / 4;
-	// }
-
-	// pipeSwizzle = (swizzle_ >> 8) & 1;
-	// bankSwizzle = (swizzle_ >> 9) & 3;
-
-	// if (depth > 1)
-	// {
-	// //     bankSwizzle = (uint)(slice % 4);
-	// }
-
-	// tileMode = GX2TileModeToAddrTileMode(tileMode);
-
-	// var IsDepth bool = (use & 4) != 0
-	isDepth := false
-	// var numSamples uint = (uint)(1 << (int)(aa))
-
-	var swizzledPixelIndex uint
-
-	for y := uint(0); y < height; y++ {
-		for x := uint(0); x < width; x++ {
-			// if tileMode == 0 || tileMode == 1 {
-			// 	pos = computeSurfaceAddrFromCoordLinear((uint)x, (uint)y, slice, sample, bytesPerPixel, pitch, height, depth);
-			// 	panic("unsupported tile mode")
-			// } else if tileMode == 2 || tileMode == 3 {
-			// 	pos = computeSurfaceAddrFromCoordMicroTiled((uint)x, (uint)y, slice, bpp, pitch, height, (AddrTileMode)tileMode, IsDepth);
-			// 	panic("unsupported tile mode")
-			// } else {
-			// 	pos = computeSurfaceAddrFromCoordMacroTiled((uint)x, (uint)y, slice, sample, bpp, pitch, height, numSamples, (AddrTileMode)tileMode, IsDepth, pipeSwizzle, bankSwizzle);
-			swizzledPixelIndex = computeSwizzledPixelIndex(x, y, bpp, pitch, height, ADDR_TM_2D_TILED_THIN1, isDepth)
-			// }
-			var pixelIndex uint = (y*width + x) * bytesPerPixel
-			dataLen := (uint)(len(data))
-			if pixelIndex+bytesPerPixel <= dataLen && swizzledPixelIndex+bytesPerPixel <= dataLen {
-				if swizzle {
-					// swizzle
-					result[swizzledPixelIndex] = data[pixelIndex]
-				} else {
-					// deswizzle
-					result[pixelIndex] = data[swizzledPixelIndex]
-				}
-			}
+	layout, err := newBlockLinearLayout(int(tglp.SheetWidth), int(tglp.SheetHeight), format, tglp.bntx.BlockHeightLog2)
+	if err != nil {
+		return nil, format, &SectionError{
+			Section: TGLP_MAGIC_HEADER,
+			Offset:  int(tglp.SheetDataOffset),
+			Field:   "sheet surface",
+			Err:     err,
 		}
 	}
 
-	return result
+	return layout, format, nil
 }
 
-// computeSurfaceAddrFromCoordMacroTiled(uint x, uint y, uint slice, uint
-// sample, uint bpp, uint pitch, uint height, uint numSamples, AddrTileMode
-// tileMode, bool IsDepth, uint pipeSwizzle, uint bankSwizzle)
-func computeSwizzledPixelIndex(x uint, y uint, bpp uint, pitch uint, height uint, tileMode AddrTileMode, isDepth bool) uint {
-	var pipeSwizzle uint = 0
-	var bankSwizzle uint = 0
-	var numSamples uint = 1
-	var sample uint = 0
-	var slice uint = 0
-	var microTileThickness uint = computeSurfaceThickness(tileMode)
-
-	var microTileBits uint = numSamples * bpp * (microTileThickness * 64)
-	var microTileBytes uint = (microTileBits + 7) / 8
-
-	var pixelIndex uint = computePixelIndexWithinMicroTile(x, y, slice, bpp, tileMode, isDepth)
-	var bytesPerSample uint = microTileBytes / numSamples
-	var sampleOffset uint = 0
-	var pixelOffset uint = 0
-	var samplesPerSlice uint = 0
-	var numSampleSplits uint = 0
-	var sampleSlice uint = 0
-
-	// if hasDepth {
-	// 	sampleOffset = bpp * sample
-	// 	pixelOffset = numSamples * bpp * pixelIndex
-	// } else {
-	sampleOffset = sample * (microTileBits / numSamples)
-	pixelOffset = bpp * pixelIndex
-	// }
-
-	elemOffset := pixelOffset + sampleOffset
-
-	if numSamples <= 1 || microTileBytes <= 2048 {
-		samplesPerSlice = numSamples
-		numSampleSplits = 1
-		sampleSlice = 0
-	} else {
-		samplesPerSlice = 2048 / bytesPerSample
-		numSampleSplits = numSamples / samplesPerSlice
-		numSamples = samplesPerSlice
-
-		var tileSliceBits uint = microTileBits / numSampleSplits
-		sampleSlice = elemOffset / tileSliceBits
-		elemOffset %= tileSliceBits
+// Decodes the BNTX in AllSheetData and every layer of its texture into an
+// image in SheetData. Switch sheets are not upside down.
+func (tglp *TGLP) decodeBNTXSheets() error {
+	bntx, err := decodeBNTX(tglp.AllSheetData, int(tglp.SheetDataOffset))
+	if err != nil {
+		return err
 	}
+	tglp.bntx = bntx
 
-	elemOffset = (elemOffset + 7) / 8
-
-	var pipe uint = computePipeFromCoordWoRotation(x, y)
-	var bank uint = computeBankFromCoordWoRotation(x, y)
-
-	var swizzle_ uint = pipeSwizzle + 2*bankSwizzle
-	var bankPipe uint = pipe + 2*bank
-	var rotation uint = computeSurfaceRotationFromTileMode(tileMode)
-	var sliceIn uint = slice
-
-	if isThickMacroTiled(tileMode) != 0 {
-		sliceIn >>= 2
+	err = checkEqual(BRTI_MAGIC_HEADER, int(tglp.SheetDataOffset)+bntx.brtiStart+0x30, "array length vs NumOfSheets", ErrSizeMismatch, int(tglp.NumOfSheets), int(bntx.ArrayLength))
+	if err != nil {
+		return err
+	}
+	err = checkEqual(BRTI_MAGIC_HEADER, int(tglp.SheetDataOffset)+bntx.brtiStart+0x24, "texture width vs SheetWidth", ErrSizeMismatch, int(tglp.SheetWidth), int(bntx.Width))
+	if err != nil {
+		return err
+	}
+	err = checkEqual(BRTI_MAGIC_HEADER, int(tglp.SheetDataOffset)+bntx.brtiStart+0x28, "texture height vs SheetHeight", ErrSizeMismatch, int(tglp.SheetHeight), int(bntx.Height))
+	if err != nil {
+		return err
 	}
 
-	bankPipe ^= 2*sampleSlice*3 ^ (swizzle_ + sliceIn*rotation)
-	bankPipe %= 8
-
-	pipe = bankPipe % 2
-	bank = bankPipe / 2
-
-	var sliceBytes uint = (height*pitch*microTileThickness*bpp*numSamples + 7) / 8
-	var sliceOffset uint = sliceBytes * (sampleSlice + numSampleSplits*slice) / microTileThickness
-
-	macroTilePitch, macroTileHeight := computeMacroPitchAndHeight(tileMode)
-
-	var macroTilesPerRow uint = pitch / macroTilePitch
-	var macroTileBytes uint = (numSamples*microTileThickness*bpp*macroTileHeight*macroTilePitch + 7) / 8
-	var macroTileIndexX uint = x / macroTilePitch
-	var macroTileIndexY uint = y / macroTileHeight
-	var macroTileOffset uint = (macroTileIndexX + macroTilesPerRow*macroTileIndexY) * macroTileBytes
-
-	// if isBankSwappedTileMode(tileMode) != 0 {
-	// 	var bankSwapWidth uint = computeSurfaceBankSwappedWidth(tileMode, bpp, 1, pitch)
-	// 	var swapIndex uint = macroTilePitch * macroTileIndexX / bankSwapWidth
-	// 	bank ^= bankSwapOrder[swapIndex&3]
-	// }
+	surface, format, err := tglp.bntxSurface()
+	if err != nil {
+		return err
+	}
+	layerSize := bntx.layerSize(surface.Size())
+
+	tglp.SheetData = make([]image.NRGBA, 0, tglp.NumOfSheets)
+	for i := 0; i < int(tglp.NumOfSheets); i++ {
+		layerOffset := int(tglp.SheetDataOffset) + bntx.dataStart + i*layerSize
+		if i*layerSize+surface.Size() > len(bntx.Data) {
+			return &SectionError{
+				Section:  BRTD_MAGIC_HEADER,
+				Offset:   layerOffset,
+				Field:    fmt.Sprintf("sheet %d", i),
+				Expected: i*layerSize + surface.Size(),
+				Actual:   len(bntx.Data),
+				Err:      ErrTruncated,
+			}
+		}
 
-	var totalOffset uint = elemOffset + ((macroTileOffset + sliceOffset) >> 3)
-	res := bank<<9 | pipe<<8 | totalOffset&255 | (uint)((int)(totalOffset)&-256)<<3
+		deswizzledData, err := surface.Deswizzle(bntx.Data[i*layerSize:])
+		if err != nil {
+			return &SectionError{
+				Section: BRTD_MAGIC_HEADER,
+				Offset:  layerOffset,
+				Field:   fmt.Sprintf("sheet %d", i),
+				Err:     err,
+			}
+		}
+		sheet := format.Decode(deswizzledData, int(tglp.SheetWidth), int(tglp.SheetHeight))
+		tglp.SheetData = append(tglp.SheetData, *sheet)
+	}
 
-	return res
+	return nil
 }
 
-func computePixelIndexWithinMicroTile(x uint, y uint, z uint, bpp uint, tileMode AddrTileMode, isDepth bool) uint {
-	var pixelBit0 uint = 0
-	var pixelBit1 uint = 0
-	var pixelBit2 uint = 0
-	var pixelBit3 uint = 0
-	var pixelBit4 uint = 0
-	var pixelBit5 uint = 0
-	var pixelBit6 uint = 0
-	var pixelBit7 uint = 0
-	var pixelBit8 uint = 0
-	var thickness uint = computeSurfaceThickness(tileMode)
-
-	if isDepth {
-		pixelBit0 = x & 1
-		pixelBit1 = y & 1
-		pixelBit2 = (x & 2) >> 1
-		pixelBit3 = (y & 2) >> 1
-		pixelBit4 = (x & 4) >> 2
-		pixelBit5 = (y & 4) >> 2
-	} else {
-		switch bpp {
-		case 8:
-			pixelBit0 = x & 1
-			pixelBit1 = (x & 2) >> 1
-			pixelBit2 = (x & 4) >> 2
-			pixelBit3 = (y & 2) >> 1
-			pixelBit4 = y & 1
-			pixelBit5 = (y & 4) >> 2
-			break
-		case 0x10:
-			pixelBit0 = x & 1
-			pixelBit1 = (x & 2) >> 1
-			pixelBit2 = (x & 4) >> 2
-			pixelBit3 = y & 1
-			pixelBit4 = (y & 2) >> 1
-			pixelBit5 = (y & 4) >> 2
-			break
-		case 0x20:
-			fallthrough
-		case 0x60:
-			pixelBit0 = x & 1
-			pixelBit1 = (x & 2) >> 1
-			pixelBit2 = y & 1
-			pixelBit3 = (x & 4) >> 2
-			pixelBit4 = (y & 2) >> 1
-			pixelBit5 = (y & 4) >> 2
-			break
-		case 0x40:
-			pixelBit0 = x & 1
-			pixelBit1 = y & 1
-			pixelBit2 = (x & 2) >> 1
-			pixelBit3 = (x & 4) >> 2
-			pixelBit4 = (y & 2) >> 1
-			pixelBit5 = (y & 4) >> 2
-			break
-		case 0x80:
-			pixelBit0 = y & 1
-			pixelBit1 = x & 1
-			pixelBit2 = (x & 2) >> 1
-			pixelBit3 = (x & 4) >> 2
-			pixelBit4 = (y & 2) >> 1
-			pixelBit5 = (y & 4) >> 2
-			break
-		default:
-			pixelBit0 = x & 1
-			pixelBit1 = (x & 2) >> 1
-			pixelBit2 = y & 1
-			pixelBit3 = (x & 4) >> 2
-			pixelBit4 = (y & 2) >> 1
-			pixelBit5 = (y & 4) >> 2
-			break
-		}
+// Swizzles the sheets into the layers of the BNTX texture and encodes the
+// BNTX. The block height is picked again when the sheet height changed.
+func (tglp *TGLP) encodeBNTXSheets(sheets []image.NRGBA) ([]byte, error) {
+	bntx := tglp.bntx
+	if bntx == nil {
+		_, _, err := tglp.bntxSurface()
+		return nil, err
 	}
 
-	if thickness > 1 {
-		pixelBit6 = z & 1
-		pixelBit7 = (z & 2) >> 1
+	if bntx.Height != uint32(tglp.SheetHeight) {
+		format, ok := nxSheetFormats[bntx.Format]
+		if ok {
+			_, elementsHigh := format.elementSize(int(tglp.SheetWidth), int(tglp.SheetHeight))
+			bntx.BlockHeightLog2 = blockHeightLog2(elementsHigh)
+		}
 	}
+	bntx.Width = uint32(tglp.SheetWidth)
+	bntx.Height = uint32(tglp.SheetHeight)
+	bntx.ArrayLength = uint32(len(sheets))
 
-	if thickness == 8 {
-		pixelBit8 = (z & 4) >> 2
+	surface, format, err := tglp.bntxSurface()
+	if err != nil {
+		return nil, err
 	}
+	layerSize := bntx.layerSize(surface.Size())
+	tglp.SheetSize = uint32(layerSize)
+
+	data := make([]byte, 0, layerSize*len(sheets))
+	for i, currentSheet := range sheets {
+		sheetOffset := int(tglp.SheetDataOffset) + bntx.dataStart + i*layerSize
+		err = checkEqual(TGLP_MAGIC_HEADER, sheetOffset, fmt.Sprintf("sheet %d width", i), ErrSizeMismatch, int(tglp.SheetWidth), currentSheet.Rect.Dx())
+		if err != nil {
+			return nil, err
+		}
+		err = checkEqual(TGLP_MAGIC_HEADER, sheetOffset, fmt.Sprintf("sheet %d height", i), ErrSizeMismatch, int(tglp.SheetHeight), currentSheet.Rect.Dy())
+		if err != nil {
+			return nil, err
+		}
 
-	return (pixelBit8 << 8) | (pixelBit7 << 7) | (pixelBit6 << 6) | 32*pixelBit5 | 16*pixelBit4 | 8*pixelBit3 | 4*pixelBit2 | pixelBit0 | 2*pixelBit1
-}
+		sheetData := format.Encode(imaging.Clone(currentSheet.SubImage(currentSheet.Rect)), tglp.SheetQuality)
+		swizzledData, err := surface.Swizzle(sheetData)
+		if err != nil {
+			return nil, &SectionError{
+				Section: BRTD_MAGIC_HEADER,
+				Offset:  sheetOffset,
+				Field:   fmt.Sprintf("sheet %d", i),
+				Err:     err,
+			}
+		}
 
-func computeSurfaceThickness(tileMode AddrTileMode) uint {
-	switch tileMode {
-	case ADDR_TM_1D_TILED_THICK:
-		fallthrough
-	case ADDR_TM_2D_TILED_THICK:
-		fallthrough
-	case ADDR_TM_2B_TILED_THICK:
-		fallthrough
-	case ADDR_TM_3D_TILED_THICK:
-		fallthrough
-	case ADDR_TM_3B_TILED_THICK:
-		return 4
-	case ADDR_TM_2D_TILED_XTHICK:
-		fallthrough
-	case ADDR_TM_3D_TILED_XTHICK:
-		return 8
-	default:
-		return 1
+		data = append(data, swizzledData...)
+		data = append(data, make([]byte, layerSize-len(swizzledData))...)
 	}
-}
-
-func computePipeFromCoordWoRotation(x uint, y uint) uint {
-	return ((y >> 3) ^ (x >> 3)) & 1
-}
-
-func computeBankFromCoordWoRotation(x uint, y uint) uint {
-	return ((y>>5)^(x>>3))&1 | 2*(((y>>4)^(x>>4))&1)
-}
+	bntx.Data = data
 
-func computeSurfaceRotationFromTileMode(tileMode AddrTileMode) uint {
-	switch tileMode {
-	case ADDR_TM_2D_TILED_THIN1:
-		fallthrough
-	case ADDR_TM_2D_TILED_THIN2:
-		fallthrough
-	case ADDR_TM_2D_TILED_THIN4:
-		fallthrough
-	case ADDR_TM_2D_TILED_THICK:
-		fallthrough
-	case ADDR_TM_2B_TILED_THIN1:
-		fallthrough
-	case ADDR_TM_2B_TILED_THIN2:
-		fallthrough
-	case ADDR_TM_2B_TILED_THIN4:
-		fallthrough
-	case ADDR_TM_2B_TILED_THICK:
-		return 2
-	case ADDR_TM_3D_TILED_THIN1:
-		fallthrough
-	case ADDR_TM_3D_TILED_THICK:
-		fallthrough
-	case ADDR_TM_3B_TILED_THIN1:
-		fallthrough
-	case ADDR_TM_3B_TILED_THICK:
-		return 1
-	default:
-		return 0
+	res, err := bntx.Encode()
+	if err != nil {
+		return nil, bntxError(err, int(tglp.SheetDataOffset))
 	}
+	return res, nil
 }
 
-func isThickMacroTiled(tileMode AddrTileMode) uint {
-	switch tileMode {
-	case ADDR_TM_2D_TILED_THICK:
-		fallthrough
-	case ADDR_TM_2B_TILED_THICK:
-		fallthrough
-	case ADDR_TM_3D_TILED_THICK:
-		fallthrough
-	case ADDR_TM_3B_TILED_THICK:
-		return 1
-	default:
-		return 0
+// Transparent sheets for every sheet of the font
+func (tglp *TGLP) blankSheets() []image.NRGBA {
+	sheets := make([]image.NRGBA, tglp.NumOfSheets)
+	for i := range sheets {
+		sheets[i] = *image.NewNRGBA(image.Rect(0, 0, int(tglp.SheetWidth), int(tglp.SheetHeight)))
 	}
-}
-
-func computeMacroPitchAndHeight(tileMode AddrTileMode) (pitch uint, height uint) {
-	var macroTilePitch uint = 32
-	var macroTileHeight uint = 16
-
-	switch tileMode {
-	case ADDR_TM_2D_TILED_THIN2:
-		fallthrough
-	case ADDR_TM_2B_TILED_THIN2:
-		macroTilePitch = 16
-		macroTileHeight = 32
-		break
-	case ADDR_TM_2D_TILED_THIN4:
-		fallthrough
-	case ADDR_TM_2B_TILED_THIN4:
-		macroTilePitch = 8
-		macroTileHeight = 64
-		break
-	}
-
-	return macroTilePitch, macroTileHeight
+	return sheets
 }
@@ -5,6 +5,7 @@ go 1.17
 require (
 	github.com/disintegration/imaging v1.6.2
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d
 )
 
 // require bffnt/bffnt_headers v0.0.0
@@ -27,7 +28,6 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/rogpeppe/godef v1.1.2 // indirect
 	github.com/zmb3/gogetdoc v0.0.0-20190228002656-b37376c5da6a // indirect
-	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d // indirect
 	golang.org/x/mod v0.5.1 // indirect
 	golang.org/x/sys v0.0.0-20211004093028-2c5d950f24ef // indirect
 	golang.org/x/text v0.3.6 // indirect
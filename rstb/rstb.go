@@ -0,0 +1,201 @@
+// Package rstb reads and patches the ResourceSizeTable of Breath of the Wild
+// and calculates the resource size of the files in it.
+//
+// The game reserves the size in the table for every file it loads. If a mod
+// makes a file bigger than its entry, loading it fails and the game crashes,
+// so every changed file needs an entry that is at least its resource size.
+//
+// The table is a header, entries of a CRC32 of the file path and the size,
+// sorted by CRC32, and then entries of a 128 byte path and the size for the
+// paths whose CRC32 collides with another path:
+//
+//	0x00 0x04 Magic Header (RSTB)
+//	0x04 0x04 Amount of CRC32 entries
+//	0x08 0x04 Amount of name entries
+//
+// It is big endian on the Wii U, little endian on the Switch and Yaz0
+// compressed in the game files as System/Resource/ResourceSizeTable.product.srsizetable.
+//
+// Sources:
+// https://zeldamods.org/wiki/ResourceSizeTable.product.rsizetable
+// https://github.com/zeldamods/rstb
+package rstb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+
+	"bffnt/yaz0"
+)
+
+const (
+	MagicHeader = "RSTB"
+	HeaderSize  = 0xC
+
+	crcEntrySize  = 0x8
+	nameSize      = 0x80
+	nameEntrySize = nameSize + 0x4
+
+	// Where the table is in the game files
+	TablePath = "System/Resource/ResourceSizeTable.product.srsizetable"
+)
+
+var (
+	ErrMagicHeader = errors.New("rstb: unexpected magic header")
+	ErrTruncated   = errors.New("rstb: unexpected end of data")
+	ErrNameTooLong = errors.New("rstb: name does not fit in a name entry")
+)
+
+type Table struct {
+	ByteOrder binary.ByteOrder
+	CRCs      map[uint32]uint32 // size by CRC32 of the path
+	Names     map[string]uint32 // size by path, for paths with colliding CRC32s
+}
+
+// New returns an empty table for the given byte order.
+func New(byteOrder binary.ByteOrder) *Table {
+	return &Table{
+		ByteOrder: byteOrder,
+		CRCs:      map[uint32]uint32{},
+		Names:     map[string]uint32{},
+	}
+}
+
+// Decode reads a table, Yaz0 compressed or not. The byte order is the one
+// the entry counts in the header match the size of the data with.
+func Decode(raw []byte) (*Table, error) {
+	if yaz0.IsCompressed(raw) {
+		var err error
+		raw, err = yaz0.Decompress(raw)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(raw) < HeaderSize {
+		return nil, fmt.Errorf("%w: header needs %d bytes, got %d", ErrTruncated, HeaderSize, len(raw))
+	}
+	if string(raw[0:4]) != MagicHeader {
+		return nil, fmt.Errorf("%w: %q", ErrMagicHeader, raw[0:4])
+	}
+
+	var order binary.ByteOrder
+	for _, o := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		crcCount := uint64(o.Uint32(raw[4:8]))
+		nameCount := uint64(o.Uint32(raw[8:12]))
+		if HeaderSize+crcCount*crcEntrySize+nameCount*nameEntrySize == uint64(len(raw)) {
+			order = o
+			break
+		}
+	}
+	if order == nil {
+		return nil, fmt.Errorf("%w: entry counts do not match %d bytes of data", ErrTruncated, len(raw))
+	}
+
+	t := New(order)
+	crcCount := int(order.Uint32(raw[4:8]))
+	nameCount := int(order.Uint32(raw[8:12]))
+
+	pos := HeaderSize
+	for i := 0; i < crcCount; i++ {
+		t.CRCs[order.Uint32(raw[pos:pos+4])] = order.Uint32(raw[pos+4 : pos+8])
+		pos += crcEntrySize
+	}
+	for i := 0; i < nameCount; i++ {
+		name := raw[pos : pos+nameSize]
+		end := 0
+		for end < nameSize && name[end] != 0 {
+			end++
+		}
+		t.Names[string(name[:end])] = order.Uint32(raw[pos+nameSize : pos+nameEntrySize])
+		pos += nameEntrySize
+	}
+
+	return t, nil
+}
+
+// Encode writes the uncompressed table.
+func (t *Table) Encode() []byte {
+	order := t.ByteOrder
+	if order == nil {
+		order = binary.BigEndian
+	}
+
+	crcs := make([]uint32, 0, len(t.CRCs))
+	for crc := range t.CRCs {
+		crcs = append(crcs, crc)
+	}
+	sort.Slice(crcs, func(i, j int) bool { return crcs[i] < crcs[j] })
+
+	names := make([]string, 0, len(t.Names))
+	for name := range t.Names {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	raw := make([]byte, HeaderSize+len(crcs)*crcEntrySize+len(names)*nameEntrySize)
+	copy(raw[0:4], MagicHeader)
+	order.PutUint32(raw[4:8], uint32(len(crcs)))
+	order.PutUint32(raw[8:12], uint32(len(names)))
+
+	pos := HeaderSize
+	for _, crc := range crcs {
+		order.PutUint32(raw[pos:pos+4], crc)
+		order.PutUint32(raw[pos+4:pos+8], t.CRCs[crc])
+		pos += crcEntrySize
+	}
+	for _, name := range names {
+		copy(raw[pos:pos+nameSize], name)
+		order.PutUint32(raw[pos+nameSize:pos+nameEntrySize], t.Names[name])
+		pos += nameEntrySize
+	}
+
+	return raw
+}
+
+// EncodeCompressed writes the table Yaz0 compressed, the way the game
+// stores it.
+func (t *Table) EncodeCompressed() []byte {
+	return yaz0.Compress(t.Encode(), yaz0.DefaultCompression)
+}
+
+// Get returns the size for a canonical path like Font/Font_EU.bfarc. Name
+// entries take precedence over CRC32 entries.
+func (t *Table) Get(name string) (uint32, bool) {
+	if size, ok := t.Names[name]; ok {
+		return size, true
+	}
+	size, ok := t.CRCs[crc32.ChecksumIEEE([]byte(name))]
+	return size, ok
+}
+
+// Set changes the size for a canonical path, or adds it as a CRC32 entry.
+func (t *Table) Set(name string, size uint32) {
+	if _, ok := t.Names[name]; ok {
+		t.Names[name] = size
+		return
+	}
+	t.CRCs[crc32.ChecksumIEEE([]byte(name))] = size
+}
+
+// SetName adds a name entry, for a path whose CRC32 collides with another
+// path in the table. The name has to leave room for its null terminator.
+func (t *Table) SetName(name string, size uint32) error {
+	if len(name) >= nameSize {
+		return fmt.Errorf("%w: %s is %d bytes, at most %d fit", ErrNameTooLong, name, len(name), nameSize-1)
+	}
+	t.Names[name] = size
+	return nil
+}
+
+// Delete removes the entry of a canonical path, so the game does not check
+// the size of the file anymore.
+func (t *Table) Delete(name string) {
+	if _, ok := t.Names[name]; ok {
+		delete(t.Names, name)
+		return
+	}
+	delete(t.CRCs, crc32.ChecksumIEEE([]byte(name)))
+}
@@ -0,0 +1,119 @@
+package rstb
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"bffnt/sarc"
+	"bffnt/yaz0"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableRoundTrip(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		table := New(order)
+		table.Set("Font/Font_EU.bfarc", 10715748)
+		table.Set("Font/Font_US.bfarc", 10715748)
+		assert.NoError(t, table.SetName("Actor/Pack/Collides.sbactorpack", 0x2000))
+
+		raw := table.Encode()
+		assert.Equal(t, HeaderSize+2*crcEntrySize+nameEntrySize, len(raw))
+		assert.Equal(t, uint32(2), order.Uint32(raw[4:8]), order.String()+" crc entries")
+
+		for _, encoded := range [][]byte{raw, table.EncodeCompressed()} {
+			decoded, err := Decode(encoded)
+			assert.NoError(t, err)
+			assert.Equal(t, table, decoded, order.String())
+		}
+	}
+}
+
+func TestTablePatch(t *testing.T) {
+	table := New(binary.BigEndian)
+	table.Set("Font/Font_EU.bfarc", 100)
+	assert.NoError(t, table.SetName("Font/Font_US.bfarc", 100))
+
+	table.Set("Font/Font_EU.bfarc", 200)
+	table.Set("Font/Font_US.bfarc", 300)
+	size, ok := table.Get("Font/Font_EU.bfarc")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(200), size)
+	size, ok = table.Get("Font/Font_US.bfarc")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(300), size, "name entries should be patched in place")
+	assert.Equal(t, 1, len(table.CRCs))
+
+	table.Delete("Font/Font_EU.bfarc")
+	table.Delete("Font/Font_US.bfarc")
+	_, ok = table.Get("Font/Font_EU.bfarc")
+	assert.False(t, ok)
+	assert.Empty(t, table.CRCs)
+	assert.Empty(t, table.Names)
+
+	err := table.SetName(string(make([]byte, nameSize)), 0)
+	assert.True(t, errors.Is(err, ErrNameTooLong))
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	raw := New(binary.BigEndian).Encode()
+
+	_, err := Decode(raw[:HeaderSize-1])
+	assert.True(t, errors.Is(err, ErrTruncated))
+
+	_, err = Decode(append([]byte("BSTR"), raw[4:]...))
+	assert.True(t, errors.Is(err, ErrMagicHeader))
+
+	_, err = Decode(append(raw, 0))
+	assert.True(t, errors.Is(err, ErrTruncated))
+}
+
+// The resource size of an archive follows the decompressed archive, so an
+// upscaled font has to grow it.
+func TestResourceSize(t *testing.T) {
+	archiveRaw, err := ioutil.ReadFile("../WiiU_fonts/botw/Font_EU.sbfarc")
+	assert.NoError(t, err)
+	sarcRaw, err := yaz0.Decompress(archiveRaw)
+	assert.NoError(t, err)
+
+	size, err := ResourceSize("Font/Font_EU.bfarc", archiveRaw, PlatformWiiU)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32((len(sarcRaw)+31)&^31+wiiuOverhead+0x20), size)
+
+	uncompressedSize, err := ResourceSize("Font/Font_EU.bfarc", sarcRaw, PlatformWiiU)
+	assert.NoError(t, err)
+	assert.Equal(t, size, uncompressedSize, "compressed and decompressed data should give the same size")
+
+	switchSize, err := ResourceSize("Font/Font_EU.bfarc", sarcRaw, PlatformSwitch)
+	assert.NoError(t, err)
+	assert.Equal(t, size-wiiuOverhead-0x20+switchOverhead+0x40, switchSize)
+
+	archive, err := sarc.Decode(sarcRaw)
+	assert.NoError(t, err)
+	upscaled, err := ioutil.ReadFile("../bffnt_results/Caption_00_2x.bffnt")
+	assert.NoError(t, err)
+	assert.NoError(t, archive.Replace("Caption_00.bffnt", upscaled))
+	upscaledRaw, err := archive.Encode()
+	assert.NoError(t, err)
+	upscaledSize, err := ResourceSize("Font/Font_EU.bfarc", upscaledRaw, PlatformWiiU)
+	assert.NoError(t, err)
+	assert.Greater(t, upscaledSize, size)
+	assert.GreaterOrEqual(t, upscaledSize, uint32(len(upscaledRaw)))
+
+	_, err = ResourceSize("Actor/ActorInfo.product.byml", sarcRaw, PlatformWiiU)
+	assert.Error(t, err)
+}
+
+// BCML sized this archive at 4506212 bytes in the ResourceSizeTable it
+// wrote, logs/rstb.json of bffnt_results/HD_Fonts_AC.bnp. Its archive
+// decompresses to 4505944 bytes.
+func TestResourceSizeMatchesTable(t *testing.T) {
+	archiveRaw, err := ioutil.ReadFile("../HD_Fonts/content/Font/Font_US.sbfarc")
+	assert.NoError(t, err)
+
+	size, err := ResourceSize("Font/Font_US.bfarc", archiveRaw, PlatformWiiU)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(4506212), size)
+}
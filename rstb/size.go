@@ -0,0 +1,70 @@
+package rstb
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"bffnt/yaz0"
+)
+
+type Platform int
+
+const (
+	PlatformWiiU Platform = iota
+	PlatformSwitch
+)
+
+// Every resource costs the size of its resource class plus what the resource
+// manager needs to track it, on top of the file itself.
+const (
+	wiiuOverhead   = 0xE4
+	switchOverhead = 0x168
+)
+
+// The size of the resource class of the files that are used as they are
+// loaded, on the Wii U and on the Switch. Archives, fonts and layout images
+// are sead direct resources. Files the game parses into other structures,
+// like actor parameters, also need a parse buffer that can not be calculated
+// from the file size and are not supported.
+//
+// The Wii U size matches the table BCML writes for a font archive, it
+// calculates sizes with https://github.com/zeldamods/rstb. The Switch classes
+// are the same with 64 bit pointers.
+var resourceClassSizes = map[string]struct {
+	WiiU   uint32
+	Switch uint32
+}{
+	"bfarc": {0x20, 0x40},
+	"sarc":  {0x20, 0x40},
+	"pack":  {0x20, 0x40},
+	"bffnt": {0x20, 0x40},
+	"bflim": {0x20, 0x40},
+}
+
+// ResourceSize returns the size the table needs for a file, by its canonical
+// path like Font/Font_EU.bfarc. Yaz0 compressed data is measured
+// decompressed.
+func ResourceSize(name string, data []byte, platform Platform) (uint32, error) {
+	ext := strings.TrimPrefix(path.Ext(name), ".")
+	classSize, ok := resourceClassSizes[ext]
+	if !ok {
+		return 0, fmt.Errorf("rstb: can not calculate the resource size of .%s files", ext)
+	}
+
+	size := len(data)
+	if yaz0.IsCompressed(data) {
+		z, err := yaz0.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return 0, err
+		}
+		size = z.Size()
+	}
+
+	size = (size + 31) &^ 31
+	if platform == PlatformSwitch {
+		return uint32(size) + switchOverhead + classSize.Switch, nil
+	}
+	return uint32(size) + wiiuOverhead + classSize.WiiU, nil
+}
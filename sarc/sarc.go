@@ -0,0 +1,321 @@
+// Package sarc reads and writes SARC archives, the uncompressed archive
+// inside .sbfarc, .pack and the other Yaz0 compressed archives.
+//
+// A SARC is a header, the SFAT file table, the SFNT file names and then the
+// file data. SFAT nodes are sorted by the hash of the file name and point to
+// the name in SFNT and to the file data.
+//
+// Sources:
+// https://zeldamods.org/wiki/SARC
+// http://wiki.tockdom.com/wiki/SARC_(File_Format)
+package sarc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+)
+
+const (
+	SARC_MAGIC_HEADER = "SARC"
+	SFAT_MAGIC_HEADER = "SFAT"
+	SFNT_MAGIC_HEADER = "SFNT"
+
+	SARC_HEADER_SIZE = 0x14
+	SFAT_HEADER_SIZE = 0xC
+	SFAT_NODE_SIZE   = 0x10
+	SFNT_HEADER_SIZE = 0x8
+
+	DefaultHashKey = 0x65
+	defaultVersion = 0x0100
+)
+
+var (
+	ErrMagicHeader = errors.New("sarc: unexpected magic header")
+	ErrTruncated   = errors.New("sarc: unexpected end of data")
+	ErrNotFound    = errors.New("sarc: file not found")
+	ErrExists      = errors.New("sarc: file already exists")
+)
+
+// Alignment of the data of new files by extension. Wii U textures have to
+// start on a 0x2000 byte boundary, everything else uses DefaultAlignment.
+var ExtensionAlignment = map[string]int{
+	".bffnt": 0x2000,
+	".bflim": 0x2000,
+	".bntx":  0x1000,
+}
+
+const DefaultAlignment = 4
+
+type File struct {
+	Name      string
+	Data      []byte
+	Alignment int // the file data starts on a multiple of this, in the archive
+}
+
+type Archive struct {
+	ByteOrder binary.ByteOrder // big endian on the Wii U, little endian on the Switch
+	HashKey   uint32
+	Version   uint16
+	Files     []File // sorted by name hash when encoded
+}
+
+// New returns an empty archive for the given byte order.
+func New(byteOrder binary.ByteOrder) *Archive {
+	return &Archive{
+		ByteOrder: byteOrder,
+		HashKey:   DefaultHashKey,
+		Version:   defaultVersion,
+	}
+}
+
+// NameHash is the SFAT hash of a file name.
+func NameHash(name string, key uint32) uint32 {
+	var hash uint32
+	for _, c := range []byte(name) {
+		// the games hash signed chars
+		hash = hash*key + uint32(int32(int8(c)))
+	}
+	return hash
+}
+
+// Decode reads a whole SARC archive. The alignment of every file is taken
+// from where its data starts so that encoding it again keeps it the same.
+func Decode(raw []byte) (*Archive, error) {
+	if len(raw) < SARC_HEADER_SIZE {
+		return nil, fmt.Errorf("%w: header needs %d bytes, got %d", ErrTruncated, SARC_HEADER_SIZE, len(raw))
+	}
+	if string(raw[0:4]) != SARC_MAGIC_HEADER {
+		return nil, fmt.Errorf("%w: %q at offset 0", ErrMagicHeader, raw[0:4])
+	}
+
+	a := &Archive{ByteOrder: binary.BigEndian}
+	if raw[6] == 0xFF && raw[7] == 0xFE {
+		a.ByteOrder = binary.LittleEndian
+	}
+	order := a.ByteOrder
+
+	headerSize := int(order.Uint16(raw[4:6]))
+	fileSize := int(order.Uint32(raw[8:12]))
+	dataOffset := int(order.Uint32(raw[12:16]))
+	a.Version = order.Uint16(raw[16:18])
+	if fileSize > len(raw) {
+		return nil, fmt.Errorf("%w: header says %d bytes, got %d", ErrTruncated, fileSize, len(raw))
+	}
+
+	sfatStart := headerSize
+	if sfatStart+SFAT_HEADER_SIZE > len(raw) {
+		return nil, fmt.Errorf("%w: SFAT header at offset %d", ErrTruncated, sfatStart)
+	}
+	if string(raw[sfatStart:sfatStart+4]) != SFAT_MAGIC_HEADER {
+		return nil, fmt.Errorf("%w: %q at offset %d", ErrMagicHeader, raw[sfatStart:sfatStart+4], sfatStart)
+	}
+	sfatHeaderSize := int(order.Uint16(raw[sfatStart+4 : sfatStart+6]))
+	nodeCount := int(order.Uint16(raw[sfatStart+6 : sfatStart+8]))
+	a.HashKey = order.Uint32(raw[sfatStart+8 : sfatStart+12])
+
+	nodesStart := sfatStart + sfatHeaderSize
+	sfntStart := nodesStart + nodeCount*SFAT_NODE_SIZE
+	if sfntStart+SFNT_HEADER_SIZE > len(raw) {
+		return nil, fmt.Errorf("%w: SFNT header at offset %d", ErrTruncated, sfntStart)
+	}
+	if string(raw[sfntStart:sfntStart+4]) != SFNT_MAGIC_HEADER {
+		return nil, fmt.Errorf("%w: %q at offset %d", ErrMagicHeader, raw[sfntStart:sfntStart+4], sfntStart)
+	}
+	namesStart := sfntStart + int(order.Uint16(raw[sfntStart+4:sfntStart+6]))
+
+	for i := 0; i < nodeCount; i++ {
+		node := raw[nodesStart+i*SFAT_NODE_SIZE : nodesStart+(i+1)*SFAT_NODE_SIZE]
+		attributes := order.Uint32(node[4:8])
+		dataStart := dataOffset + int(order.Uint32(node[8:12]))
+		dataEnd := dataOffset + int(order.Uint32(node[12:16]))
+		if dataStart > dataEnd || dataEnd > len(raw) {
+			return nil, fmt.Errorf("%w: file %d data from %d to %d", ErrTruncated, i, dataStart, dataEnd)
+		}
+
+		// the high byte counts the files with the same hash from 1, 0 for
+		// files without a name
+		if attributes>>24 == 0 {
+			return nil, fmt.Errorf("sarc: file %d has no name, only files with names are supported", i)
+		}
+		nameStart := namesStart + int(attributes&0xFFFF)*4
+		nameEnd := nameStart
+		for nameEnd < len(raw) && raw[nameEnd] != 0 {
+			nameEnd++
+		}
+		if nameEnd >= len(raw) {
+			return nil, fmt.Errorf("%w: name of file %d at offset %d", ErrTruncated, i, nameStart)
+		}
+		name := string(raw[nameStart:nameEnd])
+
+		a.Files = append(a.Files, File{
+			Name:      name,
+			Data:      raw[dataStart:dataEnd],
+			Alignment: offsetAlignment(dataStart, dataOffset),
+		})
+	}
+
+	return a, nil
+}
+
+// The biggest power of two alignment the offset is on, up to the alignment
+// of the whole data section.
+func offsetAlignment(offset int, dataOffset int) int {
+	alignment := DefaultAlignment
+	for alignment*2 <= dataOffset && offset%(alignment*2) == 0 {
+		alignment *= 2
+	}
+	return alignment
+}
+
+// Names lists the files in the archive.
+func (a *Archive) Names() []string {
+	names := make([]string, len(a.Files))
+	for i, file := range a.Files {
+		names[i] = file.Name
+	}
+	return names
+}
+
+func (a *Archive) index(name string) int {
+	for i, file := range a.Files {
+		if file.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// File returns the data of a file in the archive.
+func (a *Archive) File(name string) ([]byte, error) {
+	i := a.index(name)
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return a.Files[i].Data, nil
+}
+
+// Replace changes the data of a file in the archive. The file keeps its
+// alignment.
+func (a *Archive) Replace(name string, data []byte) error {
+	i := a.index(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	a.Files[i].Data = data
+	return nil
+}
+
+// Add puts a new file in the archive, aligned by ExtensionAlignment.
+func (a *Archive) Add(name string, data []byte) error {
+	if a.index(name) >= 0 {
+		return fmt.Errorf("%w: %s", ErrExists, name)
+	}
+
+	alignment, ok := ExtensionAlignment[path.Ext(name)]
+	if !ok {
+		alignment = DefaultAlignment
+	}
+	a.Files = append(a.Files, File{Name: name, Data: data, Alignment: alignment})
+	return nil
+}
+
+// Encode writes the archive. Files are sorted by their name hash, which is
+// the order the games look them up in.
+func (a *Archive) Encode() ([]byte, error) {
+	order := a.ByteOrder
+	if order == nil {
+		order = binary.BigEndian
+	}
+
+	files := make([]File, len(a.Files))
+	copy(files, a.Files)
+	sort.SliceStable(files, func(i, j int) bool {
+		return NameHash(files[i].Name, a.HashKey) < NameHash(files[j].Name, a.HashKey)
+	})
+
+	// names are null terminated and padded to 4 bytes
+	var names []byte
+	nameOffsets := make([]int, len(files))
+	for i, file := range files {
+		nameOffsets[i] = len(names)
+		names = append(names, file.Name...)
+		names = append(names, 0)
+		for len(names)%4 != 0 {
+			names = append(names, 0)
+		}
+	}
+
+	// the data section is aligned to the biggest file alignment
+	dataAlignment := DefaultAlignment
+	for _, file := range files {
+		if file.Alignment > dataAlignment {
+			dataAlignment = file.Alignment
+		}
+	}
+	namesEnd := SARC_HEADER_SIZE + SFAT_HEADER_SIZE + len(files)*SFAT_NODE_SIZE + SFNT_HEADER_SIZE + len(names)
+	dataOffset := alignUp(namesEnd, dataAlignment)
+
+	dataStarts := make([]int, len(files))
+	dataEnd := dataOffset
+	for i, file := range files {
+		alignment := file.Alignment
+		if alignment <= 0 {
+			alignment = DefaultAlignment
+		}
+		dataStarts[i] = alignUp(dataEnd, alignment)
+		dataEnd = dataStarts[i] + len(file.Data)
+	}
+
+	if len(files) > 0xFFFF || len(names)/4 > 0xFFFF {
+		return nil, fmt.Errorf("sarc: too many files (%d) or names too long (%d bytes)", len(files), len(names))
+	}
+
+	raw := make([]byte, dataEnd)
+	copy(raw[0:4], SARC_MAGIC_HEADER)
+	order.PutUint16(raw[4:6], SARC_HEADER_SIZE)
+	order.PutUint16(raw[6:8], 0xFEFF)
+	order.PutUint32(raw[8:12], uint32(dataEnd))
+	order.PutUint32(raw[12:16], uint32(dataOffset))
+	order.PutUint16(raw[16:18], a.Version)
+
+	sfatStart := SARC_HEADER_SIZE
+	copy(raw[sfatStart:sfatStart+4], SFAT_MAGIC_HEADER)
+	order.PutUint16(raw[sfatStart+4:sfatStart+6], SFAT_HEADER_SIZE)
+	order.PutUint16(raw[sfatStart+6:sfatStart+8], uint16(len(files)))
+	order.PutUint32(raw[sfatStart+8:sfatStart+12], a.HashKey)
+
+	nodesStart := sfatStart + SFAT_HEADER_SIZE
+	for i, file := range files {
+		hash := NameHash(file.Name, a.HashKey)
+
+		// the high byte counts files with the same hash, starting at 1
+		collisions := 1
+		for j := i - 1; j >= 0 && NameHash(files[j].Name, a.HashKey) == hash; j-- {
+			collisions++
+		}
+
+		node := raw[nodesStart+i*SFAT_NODE_SIZE : nodesStart+(i+1)*SFAT_NODE_SIZE]
+		order.PutUint32(node[0:4], hash)
+		order.PutUint32(node[4:8], uint32(collisions)<<24|uint32(nameOffsets[i]/4))
+		order.PutUint32(node[8:12], uint32(dataStarts[i]-dataOffset))
+		order.PutUint32(node[12:16], uint32(dataStarts[i]+len(file.Data)-dataOffset))
+	}
+
+	sfntStart := nodesStart + len(files)*SFAT_NODE_SIZE
+	copy(raw[sfntStart:sfntStart+4], SFNT_MAGIC_HEADER)
+	order.PutUint16(raw[sfntStart+4:sfntStart+6], SFNT_HEADER_SIZE)
+	copy(raw[sfntStart+SFNT_HEADER_SIZE:], names)
+
+	for i, file := range files {
+		copy(raw[dataStarts[i]:], file.Data)
+	}
+
+	return raw, nil
+}
+
+func alignUp(value int, alignment int) int {
+	return (value + alignment - 1) / alignment * alignment
+}
@@ -0,0 +1,141 @@
+package sarc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"bffnt/yaz0"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var fontNames = []string{
+	"Ancient_00.bffnt",
+	"Special_00.bffnt",
+	"Caption_00.bffnt",
+	"Normal_00.bffnt",
+	"NormalS_00.bffnt",
+	"External_00.bffnt",
+}
+
+func readArchive(t *testing.T, filename string) []byte {
+	raw, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	if yaz0.IsCompressed(raw) {
+		raw, err = yaz0.Decompress(raw)
+		assert.NoError(t, err)
+	}
+	return raw
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	for _, filename := range []string{
+		"../WiiU_fonts/botw/Font_EU.sbfarc",
+		"../WiiU_fonts/comicfont/Font_EU.sbfarc",
+		"../HD_Fonts/content/Font/Font_US.sbfarc",
+	} {
+		raw := readArchive(t, filename)
+		archive, err := Decode(raw)
+		assert.NoError(t, err, filename)
+		assert.ElementsMatch(t, fontNames, archive.Names(), filename+" file names")
+
+		for _, file := range archive.Files {
+			assert.Equal(t, "FFNT", string(file.Data[0:4]), filename+" "+file.Name+" should be a bffnt")
+			assert.Equal(t, 0x2000, file.Alignment, filename+" "+file.Name+" should be aligned for GX2")
+		}
+
+		encoded, err := archive.Encode()
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(raw, encoded), filename+" did not encode to the same bytes")
+	}
+}
+
+func TestArchiveEdit(t *testing.T) {
+	archive, err := Decode(readArchive(t, "../WiiU_fonts/botw/Font_EU.sbfarc"))
+	assert.NoError(t, err)
+
+	normal, err := archive.File("Normal_00.bffnt")
+	assert.NoError(t, err)
+	bigger := append(append([]byte{}, normal...), make([]byte, 12345)...)
+	assert.NoError(t, archive.Replace("Normal_00.bffnt", bigger))
+	assert.NoError(t, archive.Add("Extra/Font.txt", []byte("hello")))
+	assert.True(t, errors.Is(archive.Add("Extra/Font.txt", nil), ErrExists))
+	assert.True(t, errors.Is(archive.Replace("Missing.bffnt", nil), ErrNotFound))
+	_, err = archive.File("Missing.bffnt")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	encoded, err := archive.Encode()
+	assert.NoError(t, err)
+	decoded, err := Decode(encoded)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, append(fontNames, "Extra/Font.txt"), decoded.Names())
+
+	data, err := decoded.File("Normal_00.bffnt")
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(bigger, data), "replaced file changed")
+	data, err = decoded.File("Extra/Font.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+
+	// nodes sorted by hash, every bffnt still on a 0x2000 boundary
+	dataOffset := int(binary.BigEndian.Uint32(encoded[12:16]))
+	lastHash := uint32(0)
+	for i := range decoded.Files {
+		node := encoded[SARC_HEADER_SIZE+SFAT_HEADER_SIZE+i*SFAT_NODE_SIZE:]
+		hash := binary.BigEndian.Uint32(node[0:4])
+		assert.LessOrEqual(t, lastHash, hash, "SFAT nodes should be sorted by hash")
+		lastHash = hash
+		assert.Equal(t, NameHash(decoded.Files[i].Name, DefaultHashKey), hash)
+		if decoded.Files[i].Name != "Extra/Font.txt" {
+			start := dataOffset + int(binary.BigEndian.Uint32(node[8:12]))
+			assert.Equal(t, 0, start%0x2000, decoded.Files[i].Name+" is not aligned")
+		}
+	}
+}
+
+// Files whose names share a hash are told apart by the collision counter in
+// the high byte of the attributes, 1 for the first and 2 for the second.
+func TestHashCollision(t *testing.T) {
+	first, second := "aaseqa.txt", "bxaaac.txt"
+	assert.Equal(t, NameHash(first, DefaultHashKey), NameHash(second, DefaultHashKey), "the names should share a hash")
+
+	archive := New(binary.BigEndian)
+	assert.NoError(t, archive.Add(first, []byte("first")))
+	assert.NoError(t, archive.Add(second, []byte("second")))
+	encoded, err := archive.Encode()
+	assert.NoError(t, err)
+
+	nodesStart := SARC_HEADER_SIZE + SFAT_HEADER_SIZE
+	for i, collisions := range []uint32{1, 2} {
+		attributes := binary.BigEndian.Uint32(encoded[nodesStart+i*SFAT_NODE_SIZE+4:])
+		assert.Equal(t, collisions, attributes>>24, "collision counter of node %d", i)
+	}
+
+	decoded, err := Decode(encoded)
+	assert.NoError(t, err)
+	for name, expected := range map[string]string{first: "first", second: "second"} {
+		data, err := decoded.File(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(data))
+	}
+	reencoded, err := decoded.Encode()
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(encoded, reencoded), "archive with a hash collision did not encode to the same bytes")
+}
+
+func TestMalformed(t *testing.T) {
+	raw := readArchive(t, "../WiiU_fonts/botw/Font_EU.sbfarc")
+
+	_, err := Decode(raw[:10])
+	assert.True(t, errors.Is(err, ErrTruncated))
+
+	_, err = Decode(raw[:len(raw)-1])
+	assert.True(t, errors.Is(err, ErrTruncated))
+
+	badMagic := append([]byte("CRAS"), raw[4:]...)
+	_, err = Decode(badMagic)
+	assert.True(t, errors.Is(err, ErrMagicHeader))
+}
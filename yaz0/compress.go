@@ -0,0 +1,175 @@
+package yaz0
+
+import (
+	"encoding/binary"
+)
+
+// Compression levels. Higher levels look further back for matches and check
+// if starting a match one byte later gives a longer one, which is slower but
+// gives smaller files. NoCompression only writes copied bytes, which every
+// Yaz0 reader accepts.
+const (
+	NoCompression      = 0
+	BestSpeed          = 1
+	BestCompression    = 9
+	DefaultCompression = 6
+)
+
+const (
+	hashBits = 15
+	hashSize = 1 << hashBits
+)
+
+// Compress returns data as a Yaz0 file. level is between NoCompression and
+// BestCompression, anything outside of it is clamped.
+func Compress(data []byte, level int) []byte {
+	return CompressWithAlignment(data, level, 0)
+}
+
+// CompressWithAlignment is Compress with the alignment field of the header
+// set.
+func CompressWithAlignment(data []byte, level int, alignment uint32) []byte {
+	if level < NoCompression {
+		level = NoCompression
+	}
+	if level > BestCompression {
+		level = BestCompression
+	}
+
+	out := make([]byte, HeaderSize, HeaderSize+len(data)+len(data)/8+1)
+	copy(out, MagicHeader)
+	binary.BigEndian.PutUint32(out[4:8], uint32(len(data)))
+	binary.BigEndian.PutUint32(out[8:12], alignment)
+
+	m := newMatcher(data, level)
+	codePos := 0
+	bit := 8 // start a new group right away
+	nextGroup := func() {
+		if bit == 8 {
+			codePos = len(out)
+			out = append(out, 0)
+			bit = 0
+		}
+	}
+
+	pos := 0
+	for pos < len(data) {
+		length, distance := m.find(pos)
+		if length >= minMatch && m.lazy && pos+1 < len(data) {
+			// a longer match one byte later is worth the extra copied byte
+			m.insert(pos)
+			nextLength, _ := m.find(pos + 1)
+			if nextLength > length+1 {
+				nextGroup()
+				out[codePos] |= 0x80 >> uint(bit)
+				out = append(out, data[pos])
+				bit++
+				pos++
+				continue
+			}
+		} else {
+			m.insert(pos)
+		}
+
+		nextGroup()
+		if length < minMatch {
+			out[codePos] |= 0x80 >> uint(bit)
+			out = append(out, data[pos])
+			bit++
+			pos++
+			continue
+		}
+
+		r := distance - 1
+		if length <= maxShort {
+			out = append(out, byte((length-2)<<4|r>>8), byte(r))
+		} else {
+			out = append(out, byte(r>>8), byte(r), byte(length-0x12))
+		}
+		bit++
+
+		for i := pos + 1; i < pos+length; i++ {
+			m.insert(i)
+		}
+		pos += length
+	}
+
+	return out
+}
+
+// Finds back references with hash chains over every 3 byte sequence.
+type matcher struct {
+	data     []byte
+	head     []int // last position of every hash, -1 when there is none
+	prev     []int // previous position with the same hash as a position
+	inserted int   // every position below this is in the chains
+	maxChain int   // how many candidates find looks at
+	lazy     bool
+}
+
+func newMatcher(data []byte, level int) *matcher {
+	m := &matcher{
+		data:     data,
+		head:     make([]int, hashSize),
+		prev:     make([]int, len(data)),
+		maxChain: 0,
+		lazy:     level >= 5,
+	}
+	for i := range m.head {
+		m.head[i] = -1
+	}
+
+	if level > NoCompression {
+		// 4, 8, 16, ... 1024 candidates
+		m.maxChain = 1 << uint(level+1)
+	}
+
+	return m
+}
+
+func (m *matcher) hash(pos int) int {
+	v := uint32(m.data[pos])<<16 | uint32(m.data[pos+1])<<8 | uint32(m.data[pos+2])
+	return int((v * 2654435761) >> (32 - hashBits))
+}
+
+// Adds pos to the hash chains. Positions are only added once and in order.
+func (m *matcher) insert(pos int) {
+	if pos < m.inserted || pos+minMatch > len(m.data) {
+		return
+	}
+	m.inserted = pos + 1
+
+	h := m.hash(pos)
+	m.prev[pos] = m.head[h]
+	m.head[h] = pos
+}
+
+// Longest match for the bytes at pos within the window, and how far back it
+// starts. Positions from pos on must not be inserted yet.
+func (m *matcher) find(pos int) (length int, distance int) {
+	if m.maxChain == 0 || pos+minMatch > len(m.data) {
+		return 0, 0
+	}
+
+	limit := len(m.data) - pos
+	if limit > maxMatch {
+		limit = maxMatch
+	}
+
+	candidate := m.head[m.hash(pos)]
+	for chain := 0; candidate >= 0 && pos-candidate <= windowSize && chain < m.maxChain; chain++ {
+		n := 0
+		for n < limit && m.data[candidate+n] == m.data[pos+n] {
+			n++
+		}
+		if n > length {
+			length, distance = n, pos-candidate
+			if n == limit {
+				break
+			}
+		}
+		candidate = m.prev[candidate]
+	}
+
+	return length, distance
+}
@@ -0,0 +1,195 @@
+// Package yaz0 compresses and decompresses Yaz0, the LZ77 variant Nintendo
+// uses for .sbfarc, .szs and the other s-prefixed files.
+//
+// A Yaz0 file is a 16 byte header followed by groups. Every group starts
+// with a code byte, read from the most significant bit, where a 1 bit copies
+// one byte and a 0 bit is a back reference of 2 or 3 bytes:
+//
+//	NR RR         copy N+2 bytes (N = 1-15) from R+1 bytes back
+//	0R RR NN      copy NN+0x12 bytes from R+1 bytes back
+//
+// Sources:
+// http://wiki.tockdom.com/wiki/YAZ0_(File_Format)
+// https://zeldamods.org/wiki/Yaz0
+package yaz0
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	MagicHeader = "Yaz0"
+	HeaderSize  = 16
+
+	windowSize = 0x1000         // farthest a back reference can reach
+	minMatch   = 3              // shortest back reference
+	maxMatch   = 0xFF + 0x12    // longest back reference
+	maxShort   = 0xF + 2        // longest back reference stored in 2 bytes
+	maxGroup   = 8 * (maxMatch) // most bytes a single group can decode to
+)
+
+var (
+	ErrMagicHeader = errors.New("yaz0: unexpected magic header")
+	ErrTruncated   = errors.New("yaz0: unexpected end of data")
+	ErrCorrupt     = errors.New("yaz0: back reference before the start of the data")
+)
+
+// Header of a Yaz0 file. Alignment is 0 in most files, some Wii U and
+// Switch files use it to say how the decompressed data should be aligned in
+// memory.
+type Header struct {
+	MagicHeader      string // 0x00 0x04 Magic Header (Yaz0)
+	DecompressedSize uint32 // 0x04 0x04 Size of the data after decompressing
+	Alignment        uint32 // 0x08 0x04 Alignment of the decompressed data
+	Reserved         uint32 // 0x0C 0x04 Always 0
+}
+
+// IsCompressed reports whether data starts with the Yaz0 magic header.
+func IsCompressed(data []byte) bool {
+	return len(data) >= HeaderSize && string(data[0:4]) == MagicHeader
+}
+
+// Reader decompresses a Yaz0 stream. Only the last 4096 decompressed bytes
+// are kept in memory.
+type Reader struct {
+	Header Header
+
+	r       *bufio.Reader
+	out     []byte // decompressed bytes, the history of back references
+	readPos int    // next byte of out to hand to Read
+	total   int    // amount of bytes decompressed so far
+	err     error
+}
+
+// NewReader reads the Yaz0 header from r. The decompressed data is then read
+// from the returned Reader.
+func NewReader(r io.Reader) (*Reader, error) {
+	z := &Reader{r: bufio.NewReader(r)}
+
+	var raw [HeaderSize]byte
+	_, err := io.ReadFull(z.r, raw[:])
+	if err != nil {
+		return nil, ErrTruncated
+	}
+
+	z.Header = Header{
+		MagicHeader:      string(raw[0:4]),
+		DecompressedSize: binary.BigEndian.Uint32(raw[4:8]),
+		Alignment:        binary.BigEndian.Uint32(raw[8:12]),
+		Reserved:         binary.BigEndian.Uint32(raw[12:16]),
+	}
+	if z.Header.MagicHeader != MagicHeader {
+		return nil, fmt.Errorf("%w: %q", ErrMagicHeader, z.Header.MagicHeader)
+	}
+
+	z.out = make([]byte, 0, 2*windowSize+maxGroup)
+	return z, nil
+}
+
+// Size of the data once it is decompressed.
+func (z *Reader) Size() int {
+	return int(z.Header.DecompressedSize)
+}
+
+func (z *Reader) Read(p []byte) (int, error) {
+	for z.readPos == len(z.out) {
+		if z.total == z.Size() {
+			return 0, io.EOF
+		}
+		if z.err != nil {
+			return 0, z.err
+		}
+
+		// drop everything that is read and too far back to be referenced
+		if len(z.out) > windowSize && z.readPos >= len(z.out)-windowSize {
+			drop := len(z.out) - windowSize
+			z.out = append(z.out[:0], z.out[drop:]...)
+			z.readPos -= drop
+		}
+
+		z.err = z.decodeGroup()
+	}
+
+	n := copy(p, z.out[z.readPos:])
+	z.readPos += n
+	return n, nil
+}
+
+// Decodes a code byte and up to 8 chunks after it into z.out
+func (z *Reader) decodeGroup() error {
+	code, err := z.r.ReadByte()
+	if err != nil {
+		return ErrTruncated
+	}
+
+	for bit := 0; bit < 8 && z.total < z.Size(); bit++ {
+		if code&(0x80>>uint(bit)) != 0 {
+			b, err := z.r.ReadByte()
+			if err != nil {
+				return ErrTruncated
+			}
+			z.out = append(z.out, b)
+			z.total++
+			continue
+		}
+
+		b1, err := z.r.ReadByte()
+		if err != nil {
+			return ErrTruncated
+		}
+		b2, err := z.r.ReadByte()
+		if err != nil {
+			return ErrTruncated
+		}
+
+		distance := (int(b1&0xF)<<8 | int(b2)) + 1
+		length := int(b1>>4) + 2
+		if b1>>4 == 0 {
+			b3, err := z.r.ReadByte()
+			if err != nil {
+				return ErrTruncated
+			}
+			length = int(b3) + 0x12
+		}
+
+		if distance > len(z.out) {
+			return ErrCorrupt
+		}
+		if z.total+length > z.Size() {
+			length = z.Size() - z.total
+		}
+
+		// byte by byte, a reference can overlap the bytes it writes
+		start := len(z.out) - distance
+		for i := 0; i < length; i++ {
+			z.out = append(z.out, z.out[start+i])
+		}
+		z.total += length
+	}
+
+	return nil
+}
+
+// Decompress returns the decompressed contents of a whole Yaz0 file.
+func Decompress(data []byte) ([]byte, error) {
+	z, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, z.Size())
+	_, err = io.ReadFull(z, out)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = ErrTruncated
+		}
+		return nil, err
+	}
+
+	return out, nil
+}
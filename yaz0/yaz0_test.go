@@ -0,0 +1,106 @@
+package yaz0
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var archives = []string{
+	"../WiiU_fonts/botw/Font_EU.sbfarc",
+	"../WiiU_fonts/popjoy_font/Font_EU.sbfarc",
+	"../WiiU_fonts/turbofont/Font_EU.sbfarc",
+	"../HD_Fonts/content/Font/Font_US.sbfarc",
+}
+
+func TestDecompress(t *testing.T) {
+	for _, filename := range archives {
+		compressed, err := ioutil.ReadFile(filename)
+		assert.NoError(t, err)
+		assert.True(t, IsCompressed(compressed), filename+" should be yaz0 compressed")
+
+		data, err := Decompress(compressed)
+		assert.NoError(t, err)
+		assert.Equal(t, int(binary32(compressed[4:8])), len(data), filename+" decompressed to the wrong size")
+		assert.Equal(t, "SARC", string(data[0:4]), filename+" should contain a SARC archive")
+
+		// reading a byte at a time has to give the same result
+		z, err := NewReader(iotest.OneByteReader(bytes.NewReader(compressed)))
+		assert.NoError(t, err)
+		streamed, err := ioutil.ReadAll(z)
+		assert.NoError(t, err)
+		assert.Equal(t, data, streamed, filename+" streaming decompression differs")
+	}
+}
+
+func TestCompress(t *testing.T) {
+	compressed, err := ioutil.ReadFile(archives[0])
+	assert.NoError(t, err)
+	data, err := Decompress(compressed)
+	assert.NoError(t, err)
+
+	inputs := [][]byte{
+		data,
+		{},
+		[]byte("a"),
+		bytes.Repeat([]byte("ab"), 1000),
+	}
+
+	for i, input := range inputs {
+		lastSize := -1
+		for _, level := range []int{NoCompression, BestSpeed, DefaultCompression, BestCompression} {
+			name := fmt.Sprintf("input %d at level %d", i, level)
+			out := Compress(input, level)
+
+			roundTrip, err := Decompress(out)
+			assert.NoError(t, err, name)
+			assert.Equal(t, len(input), len(roundTrip), name+" changed size")
+			assert.True(t, bytes.Equal(input, roundTrip), name+" did not decompress to the input")
+
+			if lastSize >= 0 {
+				assert.LessOrEqual(t, len(out), lastSize, name+" should not be bigger than the level below it")
+			}
+			lastSize = len(out)
+		}
+	}
+
+	// the default level has to do about as well as the compressor the game
+	// files were made with
+	out := Compress(data, DefaultCompression)
+	assert.Less(t, len(out), len(compressed)*11/10, "default compression is more than 10% worse than the original")
+}
+
+func TestMalformed(t *testing.T) {
+	compressed := Compress(bytes.Repeat([]byte("yaz0 "), 100), DefaultCompression)
+
+	_, err := Decompress(compressed[:10])
+	assert.True(t, errors.Is(err, ErrTruncated), "short header should be ErrTruncated")
+
+	_, err = Decompress(compressed[:len(compressed)-1])
+	assert.True(t, errors.Is(err, ErrTruncated), "missing data should be ErrTruncated")
+
+	badMagic := append([]byte("Yaz1"), compressed[4:]...)
+	_, err = Decompress(badMagic)
+	assert.True(t, errors.Is(err, ErrMagicHeader), "wrong magic should be ErrMagicHeader")
+
+	// back reference 1 byte back with nothing decompressed yet
+	badReference := append(append([]byte{}, compressed[:HeaderSize]...), 0x00, 0x10, 0x00)
+	_, err = Decompress(badReference)
+	assert.True(t, errors.Is(err, ErrCorrupt), "reference before the data should be ErrCorrupt")
+
+	z, err := NewReader(bytes.NewReader(compressed))
+	assert.NoError(t, err)
+	n, err := io.Copy(ioutil.Discard, z)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(500), n)
+}
+
+func binary32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}